@@ -0,0 +1,214 @@
+// Package cms converts images between the color spaces described by ICC
+// color profiles, such as the profiles embedded in image files or the
+// built-in ones in the metadata/icc package.
+//
+// It handles matrix/TRC profiles for RGB and gray color spaces, which
+// describe a color space with a tone response curve for each channel
+// and, for RGB, the XYZ values of its primaries. It also handles
+// profiles with lookup tables (lut8Type, lut16Type, lutAToBType and
+// lutBToAType tags), such as the CMYK profiles of print-oriented JPEG
+// images, whose color lookup tables are interpolated tetrahedrally.
+package cms
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// Intent is a rendering intent, which says how colors are mapped from
+// one color space to another. The values match the rendering intent
+// field of ICC profile headers.
+type Intent int
+
+const (
+	// Perceptual maps colors to preserve the overall appearance of
+	// the image.
+	Perceptual Intent = 0
+	// RelativeColorimetric keeps colors that are in both color spaces
+	// the same, relative to each color space's white.
+	RelativeColorimetric Intent = 1
+	// AbsoluteColorimetric keeps colors that are in both color spaces
+	// the same, without adapting them to the destination's white. The
+	// white of a D65 color space comes out bluish in a D50 one, for
+	// example.
+	AbsoluteColorimetric Intent = 3
+)
+
+// Transform converts colors from one color space to another.
+//
+// Matrix/TRC profiles describe a single mapping for the perceptual and
+// relative colorimetric intents, so those give the same results.
+// Colors that the destination color space can't hold are clipped.
+type Transform struct {
+	// srcChannels and dstChannels hold the number of channels of each
+	// color space: 1 for gray, 3 for RGB and 4 for CMYK.
+	srcChannels, dstChannels int
+	srcGray, dstGray         bool
+
+	// shaper says whether both profiles are matrix/TRC profiles. Then
+	// in holds the source's tone response curves, m the matrix taking
+	// linear source values to linear destination ones, and out the
+	// destination's tone response curves, whose inverses are used.
+	shaper bool
+	in     [3]curve
+	m      mat3
+	out    [3]curve
+
+	// Otherwise colors go through the profile connection space, with
+	// pcs adapting them between the profiles' whites.
+	src, dst *side
+	pcs      mat3
+
+	// The tables for 8 bit samples, which are built when they're
+	// first needed. in8 takes samples to linear values, and out8
+	// takes linear values, scaled to 16 bits, to samples.
+	once sync.Once
+	in8  [3][256]float64
+	out8 [3][]uint8
+}
+
+// NewTransform returns a transform converting colors from the color
+// space of the profile src to that of the profile dst, with the given
+// rendering intent.
+func NewTransform(src, dst *metadata.ICC, intent Intent) (*Transform, error) {
+	switch intent {
+	case Perceptual, RelativeColorimetric, AbsoluteColorimetric:
+	default:
+		return nil, errors.New("cms: unsupported rendering intent")
+	}
+	s, err := newSide(src, intent, true)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newSide(dst, intent, false)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transform{
+		srcChannels: s.channels,
+		dstChannels: d.channels,
+		srcGray:     s.channels == 1,
+		dstGray:     d.channels == 1,
+		src:         s,
+		dst:         d,
+		pcs:         identityMatrix,
+	}
+	if intent == AbsoluteColorimetric {
+		// Undo the source's adaptation to D50, then adapt to the
+		// destination's white in its place.
+		t.pcs = d.adapt.mul(s.adapt.invert())
+	}
+	if s.model == nil || d.model == nil {
+		return t, nil
+	}
+
+	// Gray destinations take the luminance, whatever the source's
+	// white.
+	toDst := mat3{{0, 1, 0}, {0, 1, 0}, {0, 1, 0}}
+	if !d.model.gray {
+		toDst = d.model.inverse
+	}
+	t.shaper = true
+	t.in = s.model.curves
+	t.m = toDst.mul(t.pcs).mul(s.model.matrix)
+	t.out = d.model.curves
+	return t, nil
+}
+
+// Convert returns a copy of m with its colors converted from the color
+// space of the profile src to that of the profile dst. See
+// Transform.Convert.
+func Convert(m image.Image, src, dst *metadata.ICC, intent Intent) (image.Image, error) {
+	t, err := NewTransform(src, dst, intent)
+	if err != nil {
+		return nil, err
+	}
+	return t.Convert(m), nil
+}
+
+// rgb converts a color with samples in [0, 1] between color spaces
+// with one or three channels.
+func (t *Transform) rgb(v [3]float64) [3]float64 {
+	if t.srcGray {
+		y := luma(v[0], v[1], v[2])
+		v = [3]float64{y, y, y}
+	}
+	if !t.shaper {
+		var o [3]float64
+		t.color(v[:t.srcChannels], o[:t.dstChannels])
+		if t.dstGray {
+			o[1], o[2] = o[0], o[0]
+		}
+		return o
+	}
+	for i := range v {
+		v[i] = t.in[i].f(v[i])
+	}
+	v = t.m.apply(v)
+	for i := range v {
+		v[i] = t.out[i].inv(clamp(v[i]))
+	}
+	return v
+}
+
+// color converts the color in, with a sample in [0, 1] for each source
+// channel, through the profile connection space, setting out to the
+// destination color.
+func (t *Transform) color(in, out []float64) {
+	t.dst.fromPCS(t.pcs.apply(t.src.toPCS(in)), out)
+}
+
+// luma returns the gray value of a color, as color.GrayModel works it
+// out.
+func luma(r, g, b float64) float64 {
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+// tables builds the tables for 8 bit samples.
+func (t *Transform) tables() {
+	t.once.Do(func() {
+		if !t.shaper {
+			return
+		}
+		for c := range t.in {
+			for i := range t.in8[c] {
+				t.in8[c][i] = t.in[c].f(float64(i) / 0xff)
+			}
+			if c > 0 && t.dstGray {
+				t.out8[c] = t.out8[0]
+				continue
+			}
+			t.out8[c] = make([]uint8, 1<<16)
+			for i := range t.out8[c] {
+				t.out8[c][i] = to8(t.out[c].inv(float64(i) / 0xffff))
+			}
+		}
+	})
+}
+
+// rgb8 converts an opaque color with 8 bit samples. The tables have to
+// have been built.
+func (t *Transform) rgb8(r, g, b uint8) (uint8, uint8, uint8) {
+	if !t.shaper {
+		v := t.rgb([3]float64{float64(r) / 0xff, float64(g) / 0xff, float64(b) / 0xff})
+		return to8(v[0]), to8(v[1]), to8(v[2])
+	}
+	if t.srcGray {
+		y := uint8((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+		r, g, b = y, y, y
+	}
+	v := t.m.apply([3]float64{t.in8[0][r], t.in8[1][g], t.in8[2][b]})
+	return t.out8[0][to16(v[0])], t.out8[1][to16(v[1])], t.out8[2][to16(v[2])]
+}
+
+// to8 and to16 convert a value in [0, 1] to an 8 or 16 bit sample.
+func to8(v float64) uint8 {
+	return uint8(clamp(v)*0xff + 0.5)
+}
+
+func to16(v float64) uint16 {
+	return uint16(clamp(v)*0xffff + 0.5)
+}
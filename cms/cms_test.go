@@ -0,0 +1,307 @@
+package cms
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/metadata/icc"
+)
+
+// d50Profile returns an RGB profile with the sRGB primaries and a D50
+// white, like a print proofing space.
+func d50Profile() *metadata.ICC {
+	x := icc.SRGB()
+	x.Tag(metadata.ICCTagChromaticAdaptation).Value = metadata.ICCS15Fixed16Array{{Integer: 1}, {}, {}, {}, {Integer: 1}, {}, {}, {}, {Integer: 1}}
+	return x
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dst  *metadata.ICC
+	}{
+		{"sRGB", icc.SRGB()},
+		{"Display P3", icc.DisplayP3()},
+		{"Adobe RGB", icc.AdobeRGB()},
+		{"Rec. 2020", icc.Rec2020()},
+	} {
+		for _, intent := range []Intent{Perceptual, RelativeColorimetric, AbsoluteColorimetric} {
+			there, err := NewTransform(icc.SRGB(), tc.dst, intent)
+			if err != nil {
+				t.Fatalf("%s: NewTransform: %v", tc.name, err)
+			}
+			back, err := NewTransform(tc.dst, icc.SRGB(), intent)
+			if err != nil {
+				t.Fatalf("%s: NewTransform: %v", tc.name, err)
+			}
+			// sRGB colors fit in the wider gamuts, so they come back
+			// as they were, give or take rounding.
+			m := image.NewNRGBA64(image.Rect(0, 0, 16, 16))
+			for i := range m.Pix {
+				m.Pix[i] = uint8(i * 37)
+			}
+			got := back.Convert(there.Convert(m)).(*image.NRGBA64)
+			for i := 0; i < len(m.Pix); i += 2 {
+				g, w := int(got.Pix[i])<<8|int(got.Pix[i+1]), int(m.Pix[i])<<8|int(m.Pix[i+1])
+				if d := g - w; d < -4 || d > 4 {
+					t.Errorf("%s, intent %d: sample %d: got %#x, want %#x", tc.name, intent, i/2, g, w)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDisplayP3ToSRGB(t *testing.T) {
+	tr, err := NewTransform(icc.DisplayP3(), icc.SRGB(), RelativeColorimetric)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	m := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	copy(m.Pix, []uint8{
+		0xff, 0xff, 0xff, 0xff,
+		0, 0, 0, 0xff,
+		0xff, 0, 0, 0xff,
+		0xbf, 0xbf, 0xbf, 0xbf,
+	})
+	got := tr.Convert(m).(*image.RGBA)
+	want := []uint8{
+		0xff, 0xff, 0xff, 0xff,
+		0, 0, 0, 0xff,
+		// P3 red is outside sRGB, so it's clipped.
+		0xff, 0, 0, 0xff,
+		// Gray stays gray, premultiplied or not.
+		0xbf, 0xbf, 0xbf, 0xbf,
+	}
+	for i := range want {
+		if d := int(got.Pix[i]) - int(want[i]); d < -1 || d > 1 {
+			t.Errorf("got %v, want %v", got.Pix, want)
+			break
+		}
+	}
+
+	// P3 green fits in Rec. 2020, so it survives a round trip through
+	// it.
+	m64 := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	m64.SetRGBA64(0, 0, color.RGBA64{G: 0xffff, A: 0xffff})
+	tr, err = NewTransform(icc.DisplayP3(), icc.Rec2020(), RelativeColorimetric)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	back, err := NewTransform(icc.Rec2020(), icc.DisplayP3(), RelativeColorimetric)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	c := back.Convert(tr.Convert(m64)).(*image.RGBA64).RGBA64At(0, 0)
+	if c.R > 0x20 || c.G < 0xffdf || c.B > 0x20 || c.A != 0xffff {
+		t.Errorf("P3 green through Rec. 2020: got %v", c)
+	}
+}
+
+func TestAbsoluteColorimetric(t *testing.T) {
+	white := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	white.Set(0, 0, color.White)
+	for _, tc := range []struct {
+		intent Intent
+		bluish bool
+	}{
+		{RelativeColorimetric, false},
+		{AbsoluteColorimetric, true},
+	} {
+		tr, err := NewTransform(icc.SRGB(), d50Profile(), tc.intent)
+		if err != nil {
+			t.Fatalf("NewTransform: %v", err)
+		}
+		c := tr.Convert(white).(*image.NRGBA64).NRGBA64At(0, 0)
+		if bluish := int(c.B) > int(c.R)+0x400; bluish != tc.bluish {
+			t.Errorf("intent %d: got white %v", tc.intent, c)
+		}
+	}
+}
+
+func TestGray(t *testing.T) {
+	tr, err := NewTransform(icc.GrayGamma22(), icc.SRGB(), Perceptual)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	g := image.NewGray(image.Rect(0, 0, 3, 1))
+	copy(g.Pix, []uint8{0, 0x80, 0xff})
+	got := tr.Convert(g).(*image.RGBA)
+	// Mid gray with a gamma of 2.2 is 0.2195 linear, which is 0x81 in
+	// sRGB.
+	want := []uint8{0, 0, 0, 0xff, 0x81, 0x81, 0x81, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for i := range want {
+		if d := int(got.Pix[i]) - int(want[i]); d < -1 || d > 1 {
+			t.Errorf("got %v, want %v", got.Pix, want)
+			break
+		}
+	}
+
+	// Converting to gray keeps the image gray, and RGB images get the
+	// same value for each channel.
+	tr, err = NewTransform(icc.SRGB(), icc.GrayGamma22(), Perceptual)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	if _, ok := tr.Convert(image.NewGray16(image.Rect(0, 0, 1, 1))).(*image.Gray16); !ok {
+		t.Error("converting a gray image didn't give a gray image")
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	m.Set(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+	c := tr.Convert(m).(*image.NRGBA).NRGBAAt(0, 0)
+	if c.R != c.G || c.G != c.B || c.R == 0 || c.R == 0xff {
+		t.Errorf("red in gray: got %v", c)
+	}
+}
+
+func TestConvertTypes(t *testing.T) {
+	tr, err := NewTransform(icc.AdobeRGB(), icc.SRGB(), Perceptual)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	r := image.Rect(1, 2, 5, 6)
+	c := color.NRGBA{R: 0x40, G: 0x80, B: 0xc0, A: 0xff}
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = yy
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = cb, cr
+	}
+	var want color.Color
+	for _, tc := range []struct {
+		m    image.Image
+		want image.Image
+	}{
+		{image.NewNRGBA(r), &image.NRGBA{}},
+		{image.NewRGBA(r), &image.RGBA{}},
+		{image.NewRGBA64(r), &image.RGBA64{}},
+		{image.NewNRGBA64(r), &image.NRGBA64{}},
+		{image.NewCMYK(r), &image.NRGBA64{}},
+		{image.NewPaletted(r, color.Palette{c}), &image.Paletted{}},
+		{ycbcr, &image.RGBA{}},
+	} {
+		if m, ok := tc.m.(interface{ Set(int, int, color.Color) }); ok {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					m.Set(x, y, c)
+				}
+			}
+		}
+		got := tr.Convert(tc.m)
+		if got.Bounds() != r {
+			t.Errorf("%T: got bounds %v, want %v", tc.m, got.Bounds(), r)
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(tc.want) {
+			t.Errorf("%T: got %T, want %T", tc.m, got, tc.want)
+			continue
+		}
+		g := color.NRGBAModel.Convert(got.At(4, 5)).(color.NRGBA)
+		if want == nil {
+			want = g
+		}
+		w := want.(color.NRGBA)
+		// The CMYK and YCbCr colors are only close to c.
+		if math.Abs(float64(g.R)-float64(w.R)) > 3 || math.Abs(float64(g.G)-float64(w.G)) > 3 || math.Abs(float64(g.B)-float64(w.B)) > 3 {
+			t.Errorf("%T: got %v, want %v", tc.m, g, w)
+		}
+	}
+	// Adobe RGB is wider than sRGB, so the colors get more saturated.
+	if w := want.(color.NRGBA); w.R >= c.R || w.B <= c.B {
+		t.Errorf("got %v from Adobe RGB %v", w, c)
+	}
+}
+
+func TestNewProfile(t *testing.T) {
+	linear, err := NewProfile(1, nil, false)
+	if err != nil {
+		t.Fatalf("NewProfile: %v", err)
+	}
+	tr, err := NewTransform(linear, icc.SRGB(), Perceptual)
+	if err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	// Linear 0x80 is 0xbc in sRGB.
+	m := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	m.Pix = []uint8{0x80, 0x80, 0x80, 0xff}
+	if c := tr.Convert(m).(*image.NRGBA).NRGBAAt(0, 0); c != (color.NRGBA{R: 0xbc, G: 0xbc, B: 0xbc, A: 0xff}) {
+		t.Errorf("got %v, want 0xbc", c)
+	}
+
+	// With no gamma and no chromaticities the profile is sRGB.
+	srgb, err := NewProfile(0, nil, false)
+	if err != nil {
+		t.Fatalf("NewProfile: %v", err)
+	}
+	if tr, err = NewTransform(srgb, icc.SRGB(), Perceptual); err != nil {
+		t.Fatalf("NewTransform: %v", err)
+	}
+	m.Pix = []uint8{0x40, 0x80, 0xc0, 0xff}
+	if c := tr.Convert(m).(*image.NRGBA).NRGBAAt(0, 0); c != (color.NRGBA{R: 0x40, G: 0x80, B: 0xc0, A: 0xff}) {
+		t.Errorf("sRGB: got %v, want unchanged", c)
+	}
+
+	for _, gamma := range []float64{-1, math.Inf(1)} {
+		if _, err := NewProfile(gamma, nil, false); err == nil {
+			t.Errorf("gamma %v: got nil error", gamma)
+		}
+	}
+	if _, err := NewProfile(0, &Chromaticities{}, false); err == nil {
+		t.Error("zero chromaticities: got nil error")
+	}
+}
+
+func TestNewTransformErrors(t *testing.T) {
+	noTRC := icc.SRGB()
+	noTRC.Tags = noTRC.Tags[:len(noTRC.Tags)-1]
+	lab := icc.SRGB()
+	lab.ProfileConnectionSpace = metadata.ICCSpaceLab
+	cmyk := icc.SRGB()
+	cmyk.ColorSpace = metadata.ICCSpaceCMYK
+	for _, tc := range []struct {
+		name     string
+		src, dst *metadata.ICC
+		intent   Intent
+	}{
+		{"nil", nil, icc.SRGB(), Perceptual},
+		{"intent", icc.SRGB(), icc.SRGB(), 7},
+		{"no TRC", noTRC, icc.SRGB(), Perceptual},
+		{"Lab", icc.SRGB(), lab, Perceptual},
+		{"CMYK", cmyk, icc.SRGB(), Perceptual},
+	} {
+		if _, err := NewTransform(tc.src, tc.dst, tc.intent); err == nil {
+			t.Errorf("%s: got nil error", tc.name)
+		}
+	}
+}
+
+func TestCurveInverse(t *testing.T) {
+	for _, c := range []metadata.ICCToneCurve{
+		metadata.ICCCurve{},
+		metadata.ICCCurve{0x0233},
+		metadata.ICCCurve{0, 0x1000, 0x1000, 0x8000, 0xffff},
+		icc.SRGB().Tag(metadata.ICCTagRedTRC).Value.(metadata.ICCToneCurve),
+		icc.Rec2020().Tag(metadata.ICCTagRedTRC).Value.(metadata.ICCToneCurve),
+		parametric(1, 2.2, 1.1, -0.1),
+		parametric(2, 2.2, 1.1, -0.1, 0.05),
+		parametric(4, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045, 0.01, 0.01),
+	} {
+		cv, err := newCurve(c)
+		if err != nil {
+			t.Errorf("%v: %v", c, err)
+			continue
+		}
+		for x := 0.0; x <= 1; x += 1.0 / 64 {
+			y := cv.f(x)
+			if got := cv.f(cv.inv(y)); math.Abs(got-y) > 1e-6 {
+				t.Errorf("%v: f(inv(%v)) = %v", c, y, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package cms
+
+import (
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/internal/imageutil"
+)
+
+// Convert returns a copy of m with its colors converted.
+//
+// *image.RGBA, *image.NRGBA, *image.RGBA64, *image.NRGBA64 and
+// *image.Paletted images are converted to images of the same type, and
+// *image.YCbCr images to *image.RGBA images. *image.Gray and *image.Gray16 images stay gray if
+// the destination is gray, and are converted to *image.RGBA and
+// *image.RGBA64 images otherwise. Other images are converted to
+// *image.NRGBA64 images.
+//
+// With a gray source profile, the gray value of colors in RGB images
+// is used, as color.GrayModel works it out. With a gray destination
+// profile, images with RGB pixels get the same value for each channel.
+//
+// With a CMYK destination profile, images are converted to *image.CMYK
+// images, and with a CMYK source profile to *image.RGBA or *image.Gray
+// images. The colors of images that don't match the source profile's
+// channels, such as RGB images with a CMYK source profile, are first
+// converted with the color package's models, and lose their alpha when
+// either profile is CMYK.
+func (t *Transform) Convert(m image.Image) image.Image {
+	if t.srcChannels == 4 || t.dstChannels == 4 {
+		return t.convertCMYK(m)
+	}
+	b := m.Bounds()
+	switch src := m.(type) {
+	case *image.RGBA:
+		dst := image.NewRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			t.convert8(dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):], b.Dx(), true)
+		}
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			t.convert8(dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):], b.Dx(), false)
+		}
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			t.convert16(dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):], b.Dx(), true)
+		}
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			t.convert16(dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):], b.Dx(), false)
+		}
+		return dst
+	case *image.YCbCr:
+		dst := image.NewRGBA(b)
+		if !imageutil.DrawYCbCr(dst, b, src, b.Min) {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					dst.Set(x, y, src.At(x, y))
+				}
+			}
+		}
+		// The pixels are opaque, so they're converted in place.
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			t.convert8(row, row, b.Dx(), false)
+		}
+		return dst
+	case *image.Paletted:
+		return t.convertPaletted(src)
+	case *image.Gray:
+		return t.convertGray(src)
+	case *image.Gray16:
+		return t.convertGray16(src)
+	}
+
+	dst := image.NewNRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x, y, m.At(x, y))
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		t.convert16(row, row, b.Dx(), false)
+	}
+	return dst
+}
+
+// Converts reports whether images with the color model m hold samples
+// for the source profile's channels, so that Convert takes them as they
+// are rather than going through the color package's models first. Gray
+// and CMYK images only match gray and CMYK profiles, while images with
+// RGB pixels match RGB profiles, and gray profiles too, whose gray value
+// Convert takes from them.
+func (t *Transform) Converts(m color.Model) bool {
+	switch m {
+	case color.GrayModel, color.Gray16Model:
+		return t.srcChannels == 1
+	case color.CMYKModel:
+		return t.srcChannels == 4
+	case color.AlphaModel, color.Alpha16Model:
+		return false
+	case color.YCbCrModel:
+		return t.srcChannels == 3
+	}
+	return t.srcChannels != 4
+}
+
+// convert8 converts n 8 bit RGBA pixels from src to dst, which may be
+// the same. The pixels may be alpha-premultiplied.
+func (t *Transform) convert8(dst, src []uint8, n int, premul bool) {
+	t.tables()
+	for i := 0; i < 4*n; i += 4 {
+		r, g, b, a := src[i], src[i+1], src[i+2], src[i+3]
+		switch {
+		case a == 0 && premul:
+			r, g, b = 0, 0, 0
+		case a == 0xff || !premul:
+			r, g, b = t.rgb8(r, g, b)
+		default:
+			s := float64(a) / 0xff
+			v := t.rgb([3]float64{float64(r) / 0xff / s, float64(g) / 0xff / s, float64(b) / 0xff / s})
+			r, g, b = to8(v[0]*s), to8(v[1]*s), to8(v[2]*s)
+		}
+		dst[i], dst[i+1], dst[i+2], dst[i+3] = r, g, b, a
+	}
+}
+
+// convert16 converts n 16 bit big-endian RGBA pixels from src to dst,
+// which may be the same. The pixels may be alpha-premultiplied.
+func (t *Transform) convert16(dst, src []uint8, n int, premul bool) {
+	get := func(b []uint8) float64 { return float64(uint16(b[0])<<8|uint16(b[1])) / 0xffff }
+	put := func(b []uint8, v float64) {
+		u := to16(v)
+		b[0], b[1] = uint8(u>>8), uint8(u)
+	}
+	for i := 0; i < 8*n; i += 8 {
+		a := get(src[i+6:])
+		dst[i+6], dst[i+7] = src[i+6], src[i+7]
+		if a == 0 && premul {
+			for j := i; j < i+6; j++ {
+				dst[j] = 0
+			}
+			continue
+		}
+		s := 1.0
+		if premul {
+			s = a
+		}
+		v := t.rgb([3]float64{get(src[i:]) / s, get(src[i+2:]) / s, get(src[i+4:]) / s})
+		put(dst[i:], v[0]*s)
+		put(dst[i+2:], v[1]*s)
+		put(dst[i+4:], v[2]*s)
+	}
+}
+
+// convertPaletted converts a paletted image, which only needs its
+// palette converted.
+func (t *Transform) convertPaletted(src *image.Paletted) image.Image {
+	pix := make([]uint8, 4*len(src.Palette))
+	for i, c := range src.Palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		pix[4*i], pix[4*i+1], pix[4*i+2], pix[4*i+3] = n.R, n.G, n.B, n.A
+	}
+	t.convert8(pix, pix, len(src.Palette), false)
+	p := make(color.Palette, len(src.Palette))
+	for i := range p {
+		p[i] = color.NRGBA{R: pix[4*i], G: pix[4*i+1], B: pix[4*i+2], A: pix[4*i+3]}
+	}
+	dst := image.NewPaletted(src.Bounds(), p)
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)])
+	}
+	return dst
+}
+
+// convertGray converts an 8 bit gray image.
+func (t *Transform) convertGray(src *image.Gray) image.Image {
+	t.tables()
+	var lut [256][3]uint8
+	for i := range lut {
+		v := uint8(i)
+		lut[i][0], lut[i][1], lut[i][2] = t.rgb8(v, v, v)
+	}
+	b := src.Bounds()
+	if t.dstGray {
+		dst := image.NewGray(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			d, s := dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				d[x] = lut[s[x]][0]
+			}
+		}
+		return dst
+	}
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		d, s := dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			c := lut[s[x]]
+			d[4*x], d[4*x+1], d[4*x+2], d[4*x+3] = c[0], c[1], c[2], 0xff
+		}
+	}
+	return dst
+}
+
+// convertGray16 converts a 16 bit gray image.
+func (t *Transform) convertGray16(src *image.Gray16) image.Image {
+	b := src.Bounds()
+	gray := func(s []uint8, x int) [3]float64 {
+		g := float64(uint16(s[2*x])<<8|uint16(s[2*x+1])) / 0xffff
+		return t.rgb([3]float64{g, g, g})
+	}
+	if t.dstGray {
+		dst := image.NewGray16(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			d, s := dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				u := to16(gray(s, x)[0])
+				d[2*x], d[2*x+1] = uint8(u>>8), uint8(u)
+			}
+		}
+		return dst
+	}
+	dst := image.NewRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		d, s := dst.Pix[dst.PixOffset(b.Min.X, y):], src.Pix[src.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			v := gray(s, x)
+			for c := range v {
+				u := to16(v[c])
+				d[8*x+2*c], d[8*x+2*c+1] = uint8(u>>8), uint8(u)
+			}
+			d[8*x+6], d[8*x+7] = 0xff, 0xff
+		}
+	}
+	return dst
+}
+
+// convertCMYK converts an image when either profile is CMYK.
+func (t *Transform) convertCMYK(m image.Image) image.Image {
+	b := m.Bounds()
+	var set func(x, y int, v []float64)
+	var dst image.Image
+	switch t.dstChannels {
+	case 4:
+		d := image.NewCMYK(b)
+		set = func(x, y int, v []float64) {
+			p := d.Pix[d.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = to8(v[0]), to8(v[1]), to8(v[2]), to8(v[3])
+		}
+		dst = d
+	case 3:
+		d := image.NewRGBA(b)
+		set = func(x, y int, v []float64) {
+			p := d.Pix[d.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = to8(v[0]), to8(v[1]), to8(v[2]), 0xff
+		}
+		dst = d
+	default:
+		d := image.NewGray(b)
+		set = func(x, y int, v []float64) {
+			d.Pix[d.PixOffset(x, y)] = to8(v[0])
+		}
+		dst = d
+	}
+
+	get := t.sampler(m)
+	// Images often have runs of the same color, so the last color
+	// converted is kept.
+	var in, last, out [4]float64
+	first := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			get(x, y, in[:t.srcChannels])
+			if first || in != last {
+				t.color(in[:t.srcChannels], out[:t.dstChannels])
+				last, first = in, false
+			}
+			set(x, y, out[:t.dstChannels])
+		}
+	}
+	return dst
+}
+
+// sampler returns a function setting v to the samples of the pixel of m
+// at (x, y), in [0, 1], for the source profile's channels.
+func (t *Transform) sampler(m image.Image) func(x, y int, v []float64) {
+	switch t.srcChannels {
+	case 4:
+		if src, ok := m.(*image.CMYK); ok {
+			return func(x, y int, v []float64) {
+				p := src.Pix[src.PixOffset(x, y):]
+				v[0], v[1], v[2], v[3] = float64(p[0])/0xff, float64(p[1])/0xff, float64(p[2])/0xff, float64(p[3])/0xff
+			}
+		}
+		return func(x, y int, v []float64) {
+			c := color.CMYKModel.Convert(m.At(x, y)).(color.CMYK)
+			v[0], v[1], v[2], v[3] = float64(c.C)/0xff, float64(c.M)/0xff, float64(c.Y)/0xff, float64(c.K)/0xff
+		}
+	case 3:
+		return func(x, y int, v []float64) {
+			c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+			v[0], v[1], v[2] = float64(c.R)/0xffff, float64(c.G)/0xffff, float64(c.B)/0xffff
+		}
+	}
+	return func(x, y int, v []float64) {
+		c := color.Gray16Model.Convert(m.At(x, y)).(color.Gray16)
+		v[0] = float64(c.Y) / 0xffff
+	}
+}
@@ -0,0 +1,145 @@
+package cms
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// curve holds a tone response curve and its inverse, both taking
+// values in [0, 1] to values in [0, 1].
+type curve struct {
+	f, inv func(float64) float64
+}
+
+// identity is the identity curve.
+var identity = curve{f: clamp, inv: clamp}
+
+// clamp returns v clamped to [0, 1].
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// newCurve returns the curve for c, which is assumed to be
+// non-decreasing.
+func newCurve(c metadata.ICCToneCurve) (curve, error) {
+	switch c := c.(type) {
+	case nil:
+		return curve{}, errors.New("cms: missing curve")
+	case metadata.ICCCurve:
+		switch len(c) {
+		case 0:
+			return identity, nil
+		case 1:
+			if c[0] == 0 {
+				return curve{}, errors.New("cms: curve with a gamma of zero")
+			}
+			return gamma(float64(c[0]) / 256), nil
+		}
+		if c[0] > c[len(c)-1] {
+			return curve{}, errors.New("cms: decreasing curves aren't supported")
+		}
+		return curve{f: c.Eval, inv: tableInverse(c)}, nil
+	case metadata.ICCParametricCurve:
+		if c.ParamCount() == 0 || len(c.Params) < c.ParamCount() {
+			return curve{}, fmt.Errorf("cms: parametric curve function type %d with %d parameters", c.Function, len(c.Params))
+		}
+		if inv := parametricInverse(c); inv != nil {
+			return curve{f: c.Eval, inv: inv}, nil
+		}
+	}
+	return curve{f: c.Eval, inv: bisect(c.Eval)}, nil
+}
+
+// gamma returns the curve raising values to the power g.
+func gamma(g float64) curve {
+	return curve{
+		f:   func(x float64) float64 { return math.Pow(clamp(x), g) },
+		inv: func(y float64) float64 { return math.Pow(clamp(y), 1/g) },
+	}
+}
+
+// tableInverse returns the inverse of the curve with the table t,
+// interpolating between its entries as ICCCurve.Eval does.
+func tableInverse(t metadata.ICCCurve) func(float64) float64 {
+	return func(y float64) float64 {
+		v := clamp(y) * 0xffff
+		n := len(t)
+		if v <= float64(t[0]) {
+			return 0
+		}
+		if v >= float64(t[n-1]) {
+			return 1
+		}
+		// The first entry at or above v, which follows one below it.
+		j := sort.Search(n, func(i int) bool { return float64(t[i]) >= v })
+		a, b := float64(t[j-1]), float64(t[j])
+		return (float64(j-1) + (v-a)/(b-a)) / float64(n-1)
+	}
+}
+
+// parametricInverse returns the inverse of the parametric curve c, or
+// nil if its parameters don't allow working it out directly.
+func parametricInverse(c metadata.ICCParametricCurve) func(float64) float64 {
+	var p [7]float64
+	for i := 0; i < c.ParamCount(); i++ {
+		p[i] = c.Params[i].Float()
+	}
+	g, a, b, cc, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	if g == 0 || a == 0 && c.Function != 0 {
+		return nil
+	}
+	// root inverts the power function segment, which takes x to
+	// (ax+b)^g+offset.
+	root := func(y, offset float64) float64 {
+		if y <= offset {
+			return clamp(-b / a)
+		}
+		return clamp((math.Pow(y-offset, 1/g) - b) / a)
+	}
+	switch c.Function {
+	case 0:
+		return gamma(g).inv
+	case 1:
+		return func(y float64) float64 { return root(clamp(y), 0) }
+	case 2:
+		return func(y float64) float64 { return root(clamp(y), cc) }
+	case 3:
+		return func(y float64) float64 {
+			y = clamp(y)
+			if cc > 0 && y < cc*d {
+				return clamp(y / cc)
+			}
+			return root(y, 0)
+		}
+	case 4:
+		return func(y float64) float64 {
+			y = clamp(y)
+			if cc > 0 && y < cc*d+f {
+				return clamp((y - f) / cc)
+			}
+			return root(y, e)
+		}
+	}
+	return nil
+}
+
+// bisect returns the inverse of the non-decreasing function f, found
+// by bisection.
+func bisect(f func(float64) float64) func(float64) float64 {
+	return func(y float64) float64 {
+		lo, hi := 0.0, 1.0
+		for i := 0; i < 40; i++ {
+			mid := (lo + hi) / 2
+			if f(mid) < y {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return (lo + hi) / 2
+	}
+}
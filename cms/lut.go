@@ -0,0 +1,367 @@
+package cms
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// maxChannels is the most channels a lookup table can have.
+const maxChannels = 15
+
+// pipeline evaluates a profile's lookup table tag, taking values in
+// [0, 1] to values in [0, 1].
+type pipeline interface {
+	inputs() int
+	outputs() int
+	eval(in, out []float64)
+}
+
+// clut is a color lookup table, with its entries scaled to [0, 1].
+type clut struct {
+	grid []int
+	// strides holds the distance in data between neighboring grid
+	// points along each dimension.
+	strides []int
+	outputs int
+	data    []float64
+}
+
+// newCLUT returns the color lookup table with the given grid points
+// along each dimension and entries.
+func newCLUT(grid []int, outputs int, entries []uint16) (*clut, error) {
+	c := &clut{grid: grid, strides: make([]int, len(grid)), outputs: outputs}
+	n := outputs
+	for i := len(grid) - 1; i >= 0; i-- {
+		if grid[i] < 2 {
+			return nil, errors.New("cms: color lookup table with fewer than two grid points")
+		}
+		c.strides[i] = n
+		n *= grid[i]
+	}
+	if len(entries) != n {
+		return nil, fmt.Errorf("cms: color lookup table with %d entries, want %d", len(entries), n)
+	}
+	c.data = make([]float64, n)
+	for i, v := range entries {
+		c.data[i] = float64(v) / 0xffff
+	}
+	return c, nil
+}
+
+// eval looks up the input values in, interpolating between grid
+// points: tetrahedrally across three dimensions, and linearly across
+// any others. The first input channels vary slowest, so a CMYK table
+// is interpolated linearly in cyan between two tetrahedral lookups.
+func (c *clut) eval(in, out []float64) {
+	var pos [maxChannels]int
+	var frac [maxChannels]float64
+	for i, v := range in {
+		p := clamp(v) * float64(c.grid[i]-1)
+		j := int(p)
+		if j >= c.grid[i]-1 {
+			j = c.grid[i] - 2
+		}
+		pos[i], frac[i] = j, p-float64(j)
+	}
+	c.interpolate(0, 0, pos[:len(in)], frac[:len(in)], out)
+}
+
+// interpolate interpolates across the dimensions from dim on, around
+// the grid point at offset base in the data.
+func (c *clut) interpolate(dim, base int, pos []int, frac []float64, out []float64) {
+	switch len(pos) - dim {
+	case 0:
+		copy(out, c.data[base:base+c.outputs])
+		return
+	case 3:
+		c.tetrahedral(base+pos[dim]*c.strides[dim]+pos[dim+1]*c.strides[dim+1]+pos[dim+2]*c.strides[dim+2],
+			c.strides[dim], c.strides[dim+1], c.strides[dim+2], frac[dim], frac[dim+1], frac[dim+2], out)
+		return
+	}
+	var hi [maxChannels]float64
+	base += pos[dim] * c.strides[dim]
+	c.interpolate(dim+1, base, pos, frac, out)
+	c.interpolate(dim+1, base+c.strides[dim], pos, frac, hi[:c.outputs])
+	f := frac[dim]
+	for i := 0; i < c.outputs; i++ {
+		out[i] += (hi[i] - out[i]) * f
+	}
+}
+
+// tetrahedral interpolates within the cube with the corner at offset
+// base in the data, and the given strides along its x, y and z edges.
+// It splits the cube into six tetrahedra along its diagonal, and
+// interpolates between the corners of the one holding the point.
+func (c *clut) tetrahedral(base, sx, sy, sz int, rx, ry, rz float64, out []float64) {
+	d := c.data
+	// The corners along the path from the first corner to the
+	// opposite one, and the fractions to step along each edge.
+	var c1, c2 int
+	var f1, f2, f3 float64
+	switch {
+	case rx >= ry && ry >= rz:
+		c1, c2, f1, f2, f3 = sx, sx+sy, rx, ry, rz
+	case rx >= rz && rz >= ry:
+		c1, c2, f1, f2, f3 = sx, sx+sz, rx, rz, ry
+	case rz >= rx && rx >= ry:
+		c1, c2, f1, f2, f3 = sz, sx+sz, rz, rx, ry
+	case ry >= rx && rx >= rz:
+		c1, c2, f1, f2, f3 = sy, sx+sy, ry, rx, rz
+	case ry >= rz && rz >= rx:
+		c1, c2, f1, f2, f3 = sy, sy+sz, ry, rz, rx
+	default:
+		c1, c2, f1, f2, f3 = sz, sy+sz, rz, ry, rx
+	}
+	c3 := sx + sy + sz
+	for i := 0; i < c.outputs; i++ {
+		p := base + i
+		v0, v1, v2, v3 := d[p], d[p+c1], d[p+c2], d[p+c3]
+		out[i] = v0 + (v1-v0)*f1 + (v2-v1)*f2 + (v3-v2)*f3
+	}
+}
+
+// lut evaluates a lut8Type or lut16Type tag.
+type lut struct {
+	// matrix is only used when the input is XYZ.
+	matrix    *mat3
+	in, out   []metadata.ICCCurve
+	clut      *clut
+	nIn, nOut int
+}
+
+// newLut returns the pipeline for the lut l. xyzInput says whether
+// its input is PCS XYZ, in which case its matrix is used.
+func newLut(l *metadata.ICCLut, xyzInput bool) (*lut, error) {
+	if l.InputChannels < 1 || l.InputChannels > maxChannels || l.OutputChannels < 1 || l.OutputChannels > maxChannels ||
+		len(l.InputTables) != l.InputChannels || len(l.OutputTables) != l.OutputChannels {
+		return nil, errors.New("cms: invalid lut")
+	}
+	grid := make([]int, l.InputChannels)
+	for i := range grid {
+		grid[i] = l.GridPoints
+	}
+	c, err := newCLUT(grid, l.OutputChannels, l.CLUT)
+	if err != nil {
+		return nil, err
+	}
+	p := &lut{clut: c, nIn: l.InputChannels, nOut: l.OutputChannels}
+	for _, t := range l.InputTables {
+		if len(t) < 2 {
+			return nil, errors.New("cms: lut table with fewer than two entries")
+		}
+		p.in = append(p.in, metadata.ICCCurve(t))
+	}
+	for _, t := range l.OutputTables {
+		if len(t) < 2 {
+			return nil, errors.New("cms: lut table with fewer than two entries")
+		}
+		p.out = append(p.out, metadata.ICCCurve(t))
+	}
+	if xyzInput && l.InputChannels == 3 {
+		var m mat3
+		for i, v := range l.Matrix {
+			m[i/3][i%3] = v.Float()
+		}
+		if m != identityMatrix {
+			p.matrix = &m
+		}
+	}
+	return p, nil
+}
+
+func (l *lut) inputs() int  { return l.nIn }
+func (l *lut) outputs() int { return l.nOut }
+
+func (l *lut) eval(in, out []float64) {
+	var v [maxChannels]float64
+	copy(v[:], in)
+	if l.matrix != nil {
+		x := l.matrix.apply([3]float64{v[0], v[1], v[2]})
+		v[0], v[1], v[2] = clamp(x[0]), clamp(x[1]), clamp(x[2])
+	}
+	for i, c := range l.in {
+		v[i] = c.Eval(v[i])
+	}
+	l.clut.eval(v[:l.nIn], out)
+	for i, c := range l.out {
+		out[i] = c.Eval(out[i])
+	}
+}
+
+// lutAB evaluates a lutAToBType or lutBToAType tag.
+type lutAB struct {
+	aToB      bool
+	a, m, b   []metadata.ICCToneCurve
+	matrix    *[12]float64
+	clut      *clut
+	nIn, nOut int
+}
+
+// newLutAB returns the pipeline for the lut l.
+func newLutAB(l *metadata.ICCLutAB) (*lutAB, error) {
+	p := &lutAB{aToB: l.AToB, a: l.A, m: l.M, b: l.B, nIn: l.InputChannels, nOut: l.OutputChannels}
+	if p.nIn < 1 || p.nIn > maxChannels || p.nOut < 1 || p.nOut > maxChannels {
+		return nil, errors.New("cms: invalid lut")
+	}
+	aChannels, bChannels := p.nIn, p.nOut
+	if !p.aToB {
+		aChannels, bChannels = bChannels, aChannels
+	}
+	// Without a color lookup table, both sides have the same number
+	// of channels.
+	if l.CLUT == nil && aChannels != bChannels {
+		return nil, errors.New("cms: lut without a color lookup table changes the number of channels")
+	}
+	if (l.Matrix != nil || l.M != nil) && bChannels != 3 {
+		return nil, errors.New("cms: lut with a matrix on a side without three channels")
+	}
+	for _, c := range [][2]int{{len(l.A), aChannels}, {len(l.M), bChannels}, {len(l.B), bChannels}} {
+		if c[0] != 0 && c[0] != c[1] {
+			return nil, errors.New("cms: lut with the wrong number of curves")
+		}
+	}
+	if l.Matrix != nil {
+		var m [12]float64
+		for i, v := range l.Matrix {
+			m[i] = v.Float()
+		}
+		p.matrix = &m
+	}
+	if l.CLUT != nil {
+		// The color lookup table takes the input side to the output
+		// side either way.
+		if len(l.CLUT.GridPoints) != p.nIn {
+			return nil, errors.New("cms: color lookup table with the wrong number of dimensions")
+		}
+		grid := make([]int, p.nIn)
+		for i, g := range l.CLUT.GridPoints {
+			grid[i] = int(g)
+		}
+		c, err := newCLUT(grid, p.nOut, l.CLUT.Data)
+		if err != nil {
+			return nil, err
+		}
+		p.clut = c
+	}
+	return p, nil
+}
+
+func (l *lutAB) inputs() int  { return l.nIn }
+func (l *lutAB) outputs() int { return l.nOut }
+
+func (l *lutAB) eval(in, out []float64) {
+	var v [maxChannels]float64
+	copy(v[:], in)
+	n := l.nIn
+	curves := func(c []metadata.ICCToneCurve) {
+		for i, c := range c {
+			v[i] = c.Eval(v[i])
+		}
+	}
+	lookup := func() {
+		if l.clut != nil {
+			var o [maxChannels]float64
+			l.clut.eval(v[:n], o[:l.clut.outputs])
+			v, n = o, l.clut.outputs
+		}
+	}
+	if l.aToB {
+		curves(l.a)
+		lookup()
+		curves(l.m)
+		l.applyMatrix(&v)
+		curves(l.b)
+	} else {
+		curves(l.b)
+		l.applyMatrix(&v)
+		curves(l.m)
+		lookup()
+		curves(l.a)
+	}
+	copy(out, v[:l.nOut])
+}
+
+// applyMatrix applies the lut's matrix, if it has one, to the first
+// three values of v.
+func (l *lutAB) applyMatrix(v *[maxChannels]float64) {
+	if l.matrix == nil {
+		return
+	}
+	m := l.matrix
+	x, y, z := v[0], v[1], v[2]
+	for i := 0; i < 3; i++ {
+		v[i] = clamp(m[3*i]*x + m[3*i+1]*y + m[3*i+2]*z + m[9+i])
+	}
+}
+
+// The profile connection space encodings used by lookup tables, which
+// take PCS values to values in [0, 1]. XYZ values are encoded as
+// u1Fixed15Numbers, so 1.0 is 0x8000. Lab values are encoded with L*
+// going from 0 to 100 and a* and b* from -128 to 127, except in
+// lut16Type tags, which use the version 2 encoding where 100 is 0xff00
+// and 127 is 0xffff.
+const xyzScale = 0xffff / 32768.0
+
+// pcsEncoding says how a lookup table encodes the profile connection
+// space.
+type pcsEncoding struct {
+	lab, legacy bool
+}
+
+// decode returns the PCS XYZ value of the encoded value v.
+func (e pcsEncoding) decode(v []float64) [3]float64 {
+	if !e.lab {
+		return [3]float64{v[0] * xyzScale, v[1] * xyzScale, v[2] * xyzScale}
+	}
+	l, a, b := v[0]*100, v[1]*255-128, v[2]*255-128
+	if e.legacy {
+		l, a, b = v[0]*0xffff/652.8, v[1]*0xffff/256-128, v[2]*0xffff/256-128
+	}
+	return labToXYZ(l, a, b)
+}
+
+// encode returns the encoding of the PCS XYZ value xyz.
+func (e pcsEncoding) encode(xyz [3]float64, v []float64) {
+	if !e.lab {
+		for i := range xyz {
+			v[i] = clamp(xyz[i] / xyzScale)
+		}
+		return
+	}
+	l, a, b := xyzToLab(xyz)
+	if e.legacy {
+		v[0], v[1], v[2] = clamp(l*652.8/0xffff), clamp((a+128)*256/0xffff), clamp((b+128)*256/0xffff)
+		return
+	}
+	v[0], v[1], v[2] = clamp(l/100), clamp((a+128)/255), clamp((b+128)/255)
+}
+
+// labToXYZ returns the XYZ value of the CIELAB color with the D50 PCS
+// white.
+func labToXYZ(l, a, b float64) [3]float64 {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	f := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	return [3]float64{d50[0] * f(fx), d50[1] * f(fy), d50[2] * f(fz)}
+}
+
+// xyzToLab returns the CIELAB color of the XYZ value with the D50 PCS
+// white.
+func xyzToLab(xyz [3]float64) (l, a, b float64) {
+	f := func(t float64) float64 {
+		if t > (6.0/29)*(6.0/29)*(6.0/29) {
+			return math.Cbrt(t)
+		}
+		return t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+	}
+	fx, fy, fz := f(xyz[0]/d50[0]), f(xyz[1]/d50[1]), f(xyz[2]/d50[2])
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
@@ -0,0 +1,297 @@
+package cms
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/metadata/icc"
+)
+
+// cmykProfile returns a CMYK profile whose colors are those that
+// color.CMYKModel gives in sRGB, with a paper white of white. Its
+// perceptual tags are lut16Type tags and its colorimetric ones
+// lutAToBType and lutBToAType tags.
+func cmykProfile(t *testing.T, lab bool, white [3]float64) *metadata.ICC {
+	srgb, err := newSide(icc.SRGB(), Perceptual, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &metadata.ICC{
+		ProfileVersion:         metadata.ProfileVersion{Major: 4, Minor: 0x30},
+		ProfileClassSignature:  metadata.ICCClassOutput,
+		ColorSpace:             metadata.ICCSpaceCMYK,
+		ProfileConnectionSpace: metadata.ICCSpaceXYZ,
+	}
+	if lab {
+		x.ProfileConnectionSpace = metadata.ICCSpaceLab
+	}
+	wtpt := metadata.ICCXYZ{{X: metadata.NewS15Fixed16(white[0]), Y: metadata.NewS15Fixed16(white[1]), Z: metadata.NewS15Fixed16(white[2])}}
+	x.Tags = append(x.Tags, &metadata.ICCTag{Signature: metadata.ICCTagMediaWhitePoint, Value: wtpt})
+
+	const aGrid, bGrid = 9, 17
+	// toCLUT builds a table with the given grid points along each of
+	// n input dimensions from f.
+	toCLUT := func(n, grid int, f func(in []float64, out []float64) int) []uint16 {
+		var data []uint16
+		in := make([]float64, n)
+		total := 1
+		for i := 0; i < n; i++ {
+			total *= grid
+		}
+		for i := 0; i < total; i++ {
+			for j, k := n-1, i; j >= 0; j, k = j-1, k/grid {
+				in[j] = float64(k%grid) / float64(grid-1)
+			}
+			var out [4]float64
+			m := f(in, out[:])
+			for _, v := range out[:m] {
+				data = append(data, to16(v))
+			}
+		}
+		return data
+	}
+	a2b := func(legacy bool) []uint16 {
+		return toCLUT(4, aGrid, func(in, out []float64) int {
+			c := color.CMYK{C: to8(in[0]), M: to8(in[1]), Y: to8(in[2]), K: to8(in[3])}
+			r, g, b, _ := c.RGBA()
+			xyz := srgb.toPCS([]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff})
+			pcsEncoding{lab, legacy}.encode(xyz, out)
+			return 3
+		})
+	}
+	b2a := func(legacy bool) []uint16 {
+		return toCLUT(3, bGrid, func(in, out []float64) int {
+			var rgb [3]float64
+			srgb.fromPCS(pcsEncoding{lab, legacy}.decode(in), rgb[:])
+			c, m, y, k := color.RGBToCMYK(to8(rgb[0]), to8(rgb[1]), to8(rgb[2]))
+			out[0], out[1], out[2], out[3] = float64(c)/0xff, float64(m)/0xff, float64(y)/0xff, float64(k)/0xff
+			return 4
+		})
+	}
+	identity := func(n int) [][]uint16 {
+		t := make([][]uint16, n)
+		for i := range t {
+			t[i] = []uint16{0, 0xffff}
+		}
+		return t
+	}
+	curves := func(n int) []metadata.ICCToneCurve {
+		c := make([]metadata.ICCToneCurve, n)
+		for i := range c {
+			c[i] = metadata.ICCCurve{}
+		}
+		return c
+	}
+	x.Tags = append(x.Tags,
+		&metadata.ICCTag{Signature: metadata.ICCTagAToB0, Value: &metadata.ICCLut{
+			Precision: 2, InputChannels: 4, OutputChannels: 3, GridPoints: aGrid,
+			InputTables: identity(4), OutputTables: identity(3), CLUT: a2b(lab),
+		}},
+		&metadata.ICCTag{Signature: metadata.ICCTagBToA0, Value: &metadata.ICCLut{
+			Precision: 2, InputChannels: 3, OutputChannels: 4, GridPoints: bGrid,
+			Matrix:      [9]metadata.S15Fixed16{{Integer: 1}, {}, {}, {}, {Integer: 1}, {}, {}, {}, {Integer: 1}},
+			InputTables: identity(3), OutputTables: identity(4), CLUT: b2a(lab),
+		}},
+		&metadata.ICCTag{Signature: metadata.ICCTagAToB1, Value: &metadata.ICCLutAB{
+			AToB: true, InputChannels: 4, OutputChannels: 3, A: curves(4), B: curves(3),
+			CLUT: &metadata.ICCCLUT{GridPoints: []uint8{aGrid, aGrid, aGrid, aGrid}, Precision: 2, Data: a2b(false)},
+		}},
+		&metadata.ICCTag{Signature: metadata.ICCTagBToA1, Value: &metadata.ICCLutAB{
+			InputChannels: 3, OutputChannels: 4, A: curves(4), B: curves(3),
+			CLUT: &metadata.ICCCLUT{GridPoints: []uint8{bGrid, bGrid, bGrid}, Precision: 2, Data: b2a(false)},
+		}},
+	)
+	return x
+}
+
+func TestCMYKToSRGB(t *testing.T) {
+	m := image.NewCMYK(image.Rect(0, 0, 16, 16))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 53)
+	}
+	for _, lab := range []bool{false, true} {
+		for _, intent := range []Intent{Perceptual, RelativeColorimetric} {
+			tr, err := NewTransform(cmykProfile(t, lab, d50), icc.SRGB(), intent)
+			if err != nil {
+				t.Fatalf("NewTransform: %v", err)
+			}
+			got := tr.Convert(m).(*image.RGBA)
+			for i := 0; i < len(got.Pix); i++ {
+				if i%4 == 3 {
+					continue
+				}
+				// The profile's lookup table is coarse, so the colors are
+				// only close to what color.CMYKModel gives.
+				r, g, b, _ := m.At((i/4)%16, i/64).RGBA()
+				w := []uint32{r >> 8, g >> 8, b >> 8}[i%4]
+				if d := int(got.Pix[i]) - int(w); d < -8 || d > 8 {
+					t.Errorf("Lab %t, intent %d: pixel %d: got %v, want %v", lab, intent, i/4, got.Pix[i&^3:i&^3+4], m.At((i/4)%16, i/64))
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestSRGBToCMYK(t *testing.T) {
+	cmyk := cmykProfile(t, true, d50)
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i*37) | 0x0f
+	}
+	for _, intent := range []Intent{Perceptual, RelativeColorimetric} {
+		there, err := NewTransform(icc.SRGB(), cmyk, intent)
+		if err != nil {
+			t.Fatalf("NewTransform: %v", err)
+		}
+		back, err := NewTransform(cmyk, icc.SRGB(), intent)
+		if err != nil {
+			t.Fatalf("NewTransform: %v", err)
+		}
+		got := back.Convert(there.Convert(m).(*image.CMYK)).(*image.RGBA)
+		for i := 0; i < len(got.Pix); i++ {
+			if i%4 == 3 {
+				if got.Pix[i] != 0xff {
+					t.Fatalf("intent %d: pixel %d: got alpha %#x", intent, i/4, got.Pix[i])
+				}
+				continue
+			}
+			// Both lookup tables are coarse, and saturated colors
+			// suffer most from that.
+			if d := int(got.Pix[i]) - int(m.Pix[i]); d < -16 || d > 16 {
+				t.Errorf("intent %d: pixel %d: got %v, want %v", intent, i/4, got.Pix[i&^3:i&^3+3], m.Pix[i&^3:i&^3+3])
+				break
+			}
+		}
+	}
+}
+
+func TestCMYKAbsoluteColorimetric(t *testing.T) {
+	// The paper is darker and yellower than D50, which shows with the
+	// absolute colorimetric intent.
+	paper := [3]float64{0.85, 0.88, 0.68}
+	m := image.NewCMYK(image.Rect(0, 0, 1, 1))
+	for _, tc := range []struct {
+		intent Intent
+		white  bool
+	}{
+		{RelativeColorimetric, true},
+		{AbsoluteColorimetric, false},
+	} {
+		tr, err := NewTransform(cmykProfile(t, true, paper), icc.SRGB(), tc.intent)
+		if err != nil {
+			t.Fatalf("NewTransform: %v", err)
+		}
+		c := tr.Convert(m).(*image.RGBA).RGBAAt(0, 0)
+		if white := c.R > 0xfc && c.G > 0xfc && c.B > 0xfc; white != tc.white {
+			t.Errorf("intent %d: got paper %v", tc.intent, c)
+		}
+		if !tc.white && (c.B >= c.R || c.G > 0xf8) {
+			t.Errorf("intent %d: got paper %v, want darker and yellower", tc.intent, c)
+		}
+	}
+}
+
+func TestCLUT(t *testing.T) {
+	// Interpolation is exact for linear functions, and at the grid
+	// points.
+	f := func(in []float64) [2]float64 {
+		return [2]float64{0.1 + 0.2*in[0] + 0.3*in[1] + 0.1*in[2] + 0.25*in[3], 0.9 - 0.2*in[1] - 0.6*in[3]}
+	}
+	for _, n := range []int{1, 2, 3, 4} {
+		const grid = 5
+		var data []uint16
+		total := 1
+		for i := 0; i < n; i++ {
+			total *= grid
+		}
+		in := make([]float64, 4)
+		for i := 0; i < total; i++ {
+			for j, k := n-1, i; j >= 0; j, k = j-1, k/grid {
+				in[j] = float64(k%grid) / (grid - 1)
+			}
+			v := f(in)
+			data = append(data, uint16(v[0]*0xffff+0.5), uint16(v[1]*0xffff+0.5))
+		}
+		g := make([]int, n)
+		for i := range g {
+			g[i] = grid
+		}
+		c, err := newCLUT(g, 2, data)
+		if err != nil {
+			t.Fatalf("%d inputs: %v", n, err)
+		}
+		for i := 0; i < 200; i++ {
+			for j := range in {
+				in[j] = 0
+				if j < n {
+					in[j] = math.Mod(float64(i*(j+3))*0.1379, 1)
+				}
+			}
+			var got [2]float64
+			c.eval(in[:n], got[:])
+			want := f(in)
+			if math.Abs(got[0]-want[0]) > 1e-4 || math.Abs(got[1]-want[1]) > 1e-4 {
+				t.Errorf("%d inputs: eval(%v) = %v, want %v", n, in[:n], got, want)
+				break
+			}
+		}
+	}
+
+	if _, err := newCLUT([]int{2, 2}, 3, make([]uint16, 11)); err == nil {
+		t.Error("newCLUT with too few entries: got nil error")
+	}
+	if _, err := newCLUT([]int{1, 2}, 3, make([]uint16, 6)); err == nil {
+		t.Error("newCLUT with one grid point: got nil error")
+	}
+}
+
+func TestPCSEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		e    pcsEncoding
+		xyz  [3]float64
+		want [3]uint16
+	}{
+		{pcsEncoding{}, [3]float64{1, 0.5, 0}, [3]uint16{0x8000, 0x4000, 0}},
+		{pcsEncoding{lab: true}, d50, [3]uint16{0xffff, 0x8080, 0x8080}},
+		{pcsEncoding{lab: true, legacy: true}, d50, [3]uint16{0xff00, 0x8000, 0x8000}},
+		{pcsEncoding{lab: true}, [3]float64{}, [3]uint16{0, 0x8080, 0x8080}},
+	} {
+		var v [3]float64
+		tc.e.encode(tc.xyz, v[:])
+		got := [3]uint16{to16(v[0]), to16(v[1]), to16(v[2])}
+		if got != tc.want {
+			t.Errorf("%+v: encode(%v) = %#x, want %#x", tc.e, tc.xyz, got, tc.want)
+		}
+		back := tc.e.decode(v[:])
+		for i := range back {
+			if math.Abs(back[i]-tc.xyz[i]) > 1e-4 {
+				t.Errorf("%+v: decode(encode(%v)) = %v", tc.e, tc.xyz, back)
+				break
+			}
+		}
+	}
+}
+
+func TestLutErrors(t *testing.T) {
+	wrongType := cmykProfile(t, false, d50)
+	wrongType.Tag(metadata.ICCTagAToB1).Value.(*metadata.ICCLutAB).AToB = false
+	badCLUT := cmykProfile(t, false, d50)
+	l := badCLUT.Tag(metadata.ICCTagAToB0).Value.(*metadata.ICCLut)
+	l.CLUT = l.CLUT[1:]
+	for _, tc := range []struct {
+		name   string
+		x      *metadata.ICC
+		intent Intent
+	}{
+		{"wrong type", wrongType, RelativeColorimetric},
+		{"bad CLUT", badCLUT, Perceptual},
+	} {
+		if _, err := NewTransform(tc.x, icc.SRGB(), tc.intent); err == nil {
+			t.Errorf("%s: got nil error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package cms
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/rmamba/image/internal/iccutil"
+	"github.com/rmamba/image/metadata"
+)
+
+// d50 holds the XYZ value of the D50 profile connection space white.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// side holds what a transform needs to know about one of its profiles:
+// how to get between its color space and XYZ values in the profile
+// connection space, either with its matrix/TRC model or with one of its
+// lookup tables.
+type side struct {
+	channels int
+	model    *model
+	pipe     pipeline
+	pcs      pcsEncoding
+	// adapt takes XYZ values relative to the profile's own media white
+	// to the profile connection space values relative to D50.
+	adapt mat3
+}
+
+// newSide returns the side of the profile x used for converting colors
+// from its color space if input is true, and to it otherwise. The
+// lookup table tags for the intent take precedence over the matrix/TRC
+// model, as the ICC specification says.
+func newSide(x *metadata.ICC, intent Intent, input bool) (*side, error) {
+	if x == nil {
+		return nil, errors.New("cms: nil profile")
+	}
+	adapt, err := adaptation(x)
+	if err != nil {
+		return nil, err
+	}
+	s := &side{adapt: adapt}
+	l, err := lutTag(x, intent, input)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		if s.model, err = newModel(x); err != nil {
+			return nil, err
+		}
+		s.channels = 3
+		if s.model.gray {
+			s.channels = 1
+		}
+		return s, nil
+	}
+
+	switch x.ProfileConnectionSpace {
+	case metadata.ICCSpaceXYZ:
+	case metadata.ICCSpaceLab:
+		s.pcs.lab = true
+	default:
+		return nil, errors.New("cms: profile connection space isn't XYZ or Lab")
+	}
+	switch l := l.Value.(type) {
+	case *metadata.ICCLut:
+		s.pcs.legacy = s.pcs.lab && l.Precision == 16
+		s.pipe, err = newLut(l, !input && !s.pcs.lab)
+	case *metadata.ICCLutAB:
+		if l.AToB != input {
+			return nil, errors.New("cms: lookup table tag of the wrong type")
+		}
+		s.pipe, err = newLutAB(l)
+	default:
+		return nil, errors.New("cms: invalid lookup table tag")
+	}
+	if err != nil {
+		return nil, err
+	}
+	pcs, device := s.pipe.outputs(), s.pipe.inputs()
+	if !input {
+		pcs, device = device, pcs
+	}
+	if pcs != 3 {
+		return nil, errors.New("cms: lookup table without three profile connection space channels")
+	}
+	switch device {
+	case 1, 3, 4:
+	default:
+		return nil, errors.New("cms: only gray, three channel and CMYK color spaces are supported")
+	}
+	s.channels = device
+	return s, nil
+}
+
+// lutTag returns the profile's lookup table tag for the intent, taking
+// colors to the profile connection space if input is true and from it
+// otherwise, or nil if it has none. The colorimetric intents use the
+// relative colorimetric tag, and profiles without the tag for an intent
+// fall back to the perceptual one.
+func lutTag(x *metadata.ICC, intent Intent, input bool) (*metadata.ICCTag, error) {
+	sigs := [3]uint32{metadata.ICCTagBToA0, metadata.ICCTagBToA1, metadata.ICCTagBToA2}
+	if input {
+		sigs = [3]uint32{metadata.ICCTagAToB0, metadata.ICCTagAToB1, metadata.ICCTagAToB2}
+	}
+	sig := sigs[0]
+	if intent != Perceptual {
+		sig = sigs[1]
+	}
+	t := x.Tag(sig)
+	if t == nil {
+		t = x.Tag(sigs[0])
+	}
+	if t != nil && t.Value == nil {
+		return nil, errors.New("cms: invalid lookup table tag")
+	}
+	return t, nil
+}
+
+// toPCS returns the profile connection space XYZ value of the color v,
+// with samples in [0, 1].
+func (s *side) toPCS(v []float64) [3]float64 {
+	if s.pipe != nil {
+		var o [3]float64
+		s.pipe.eval(v, o[:])
+		return s.pcs.decode(o[:])
+	}
+	if s.model.gray {
+		y := s.model.curves[0].f(v[0])
+		return [3]float64{d50[0] * y, d50[1] * y, d50[2] * y}
+	}
+	return s.model.matrix.apply([3]float64{s.model.curves[0].f(v[0]), s.model.curves[1].f(v[1]), s.model.curves[2].f(v[2])})
+}
+
+// fromPCS sets v to the color with the profile connection space XYZ
+// value xyz, clipping it to the color space.
+func (s *side) fromPCS(xyz [3]float64, v []float64) {
+	if s.pipe != nil {
+		var e [3]float64
+		s.pcs.encode(xyz, e[:])
+		s.pipe.eval(e[:], v)
+		for i := range v {
+			v[i] = clamp(v[i])
+		}
+		return
+	}
+	if s.model.gray {
+		// Gray takes the luminance, whatever the color's white.
+		v[0] = s.model.curves[0].inv(clamp(xyz[1] / d50[1]))
+		return
+	}
+	l := s.model.inverse.apply(xyz)
+	for i := range l {
+		v[i] = s.model.curves[i].inv(clamp(l[i]))
+	}
+}
+
+// model holds the matrix/TRC model of a profile. Its tone response
+// curves take samples to linear values, and its matrix takes those to
+// XYZ values in the profile connection space.
+type model struct {
+	gray    bool
+	curves  [3]curve
+	matrix  mat3
+	inverse mat3
+}
+
+// newModel returns the matrix/TRC model of the profile x.
+func newModel(x *metadata.ICC) (*model, error) {
+	if x.ProfileConnectionSpace != metadata.ICCSpaceXYZ {
+		return nil, errors.New("cms: matrix/TRC profiles need an XYZ profile connection space")
+	}
+	m := &model{}
+	switch x.ColorSpace {
+	case metadata.ICCSpaceGray:
+		m.gray = true
+		c, err := tagCurve(x, metadata.ICCTagGrayTRC)
+		if err != nil {
+			return nil, err
+		}
+		m.curves = [3]curve{c, c, c}
+		// Gray values are luminances, with the color of the profile
+		// connection space white.
+		for i := range d50 {
+			m.matrix[i][0] = d50[i]
+		}
+	case metadata.ICCSpaceRGB:
+		for i, sig := range [][2]uint32{
+			{metadata.ICCTagRedColorant, metadata.ICCTagRedTRC},
+			{metadata.ICCTagGreenColorant, metadata.ICCTagGreenTRC},
+			{metadata.ICCTagBlueColorant, metadata.ICCTagBlueTRC},
+		} {
+			v, ok := tagXYZ(x, sig[0])
+			if !ok {
+				return nil, errors.New("cms: RGB profile without colorant tags")
+			}
+			for j := range v {
+				m.matrix[j][i] = v[j]
+			}
+			c, err := tagCurve(x, sig[1])
+			if err != nil {
+				return nil, err
+			}
+			m.curves[i] = c
+		}
+		if m.matrix.det() == 0 {
+			return nil, errors.New("cms: RGB profile with a singular matrix")
+		}
+		m.inverse = m.matrix.invert()
+	default:
+		return nil, errors.New("cms: profile without lookup tables isn't an RGB or gray matrix/TRC profile")
+	}
+	return m, nil
+}
+
+// adaptation returns the matrix taking XYZ values relative to the
+// profile's media white to those in its profile connection space.
+//
+// Version 4 profiles, and some version 2 ones, say how they were
+// adapted to D50. Otherwise the media white point is adapted with the
+// Bradford transform. Version 4 profiles record the media white in the
+// profile connection space, so a print profile's paper white, which is
+// darker than D50, is scaled to it as well; version 2 profiles without
+// an adaptation tag record the actual media white.
+func adaptation(x *metadata.ICC) (mat3, error) {
+	a := identityMatrix
+	w, white := tagXYZ(x, metadata.ICCTagMediaWhitePoint)
+	white = white && w[0] > 0 && w[1] > 0 && w[2] > 0
+	if t := x.Tag(metadata.ICCTagChromaticAdaptation); t != nil {
+		v, ok := t.Value.(metadata.ICCS15Fixed16Array)
+		if !ok || len(v) != 9 {
+			return a, errors.New("cms: invalid chromatic adaptation tag")
+		}
+		for i := range v {
+			a[i/3][i%3] = v[i].Float()
+		}
+		if a.det() == 0 {
+			return a, errors.New("cms: singular chromatic adaptation matrix")
+		}
+	} else if white && x.ProfileVersion.Major < 4 {
+		sum := w[0] + w[1] + w[2]
+		a = iccutil.Adaptation([2]float64{w[0] / sum, w[1] / sum})
+		for i := range a {
+			for j := range a[i] {
+				a[i][j] /= w[1]
+			}
+		}
+		return a, nil
+	}
+	if white && x.ProfileVersion.Major >= 4 {
+		var s mat3
+		for i := range s {
+			s[i][i] = d50[i] / w[i]
+		}
+		a = s.mul(a)
+	}
+	return a, nil
+}
+
+// tagCurve returns the profile's tone response curve with the
+// signature sig.
+func tagCurve(x *metadata.ICC, sig uint32) (curve, error) {
+	t := x.Tag(sig)
+	if t == nil {
+		return curve{}, errors.New("cms: profile without tone response curve tags")
+	}
+	c, ok := t.Value.(metadata.ICCToneCurve)
+	if !ok {
+		return curve{}, errors.New("cms: invalid tone response curve tag")
+	}
+	return newCurve(c)
+}
+
+// tagXYZ returns the first XYZ value in the profile's XYZ tag with the
+// signature sig.
+func tagXYZ(x *metadata.ICC, sig uint32) ([3]float64, bool) {
+	t := x.Tag(sig)
+	if t == nil {
+		return [3]float64{}, false
+	}
+	v, ok := t.Value.(metadata.ICCXYZ)
+	if !ok || len(v) == 0 {
+		return [3]float64{}, false
+	}
+	return v[0].Floats(), true
+}
+
+// Chromaticities holds the CIE 1931 xy chromaticities of the white
+// point and primaries of an RGB color space.
+type Chromaticities struct {
+	White, Red, Green, Blue [2]float64
+}
+
+// srgbChromaticities holds the white point and primaries of sRGB.
+var srgbChromaticities = Chromaticities{
+	White: [2]float64{0.3127, 0.3290},
+	Red:   [2]float64{0.64, 0.33},
+	Green: [2]float64{0.30, 0.60},
+	Blue:  [2]float64{0.15, 0.06},
+}
+
+// NewProfile returns a matrix/TRC profile for samples encoded with a
+// pure power law, so that a sample is the linear value raised to the
+// power gamma, and with the primaries in chroma, as the gAMA and cHRM
+// chunks of PNG images describe them. If gamma is zero the sRGB
+// transfer function is used, and if chroma is nil the sRGB primaries
+// are. Gray profiles ignore chroma.
+func NewProfile(gamma float64, chroma *Chromaticities, gray bool) (*metadata.ICC, error) {
+	var trc metadata.ICCParametricCurve
+	switch {
+	case gamma == 0:
+		trc = parametric(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	case gamma > 0 && !math.IsInf(gamma, 0):
+		trc = parametric(0, 1/gamma)
+	default:
+		return nil, fmt.Errorf("cms: invalid gamma %g", gamma)
+	}
+
+	white := metadata.XYZNumber{X: metadata.NewS15Fixed16(d50[0]), Y: metadata.NewS15Fixed16(d50[1]), Z: metadata.NewS15Fixed16(d50[2])}
+	x := &metadata.ICC{
+		ProfileVersion:                   metadata.ProfileVersion{Major: 4, Minor: 0x30},
+		ProfileClassSignature:            metadata.ICCClassDisplay,
+		ColorSpace:                       metadata.ICCSpaceRGB,
+		ProfileConnectionSpace:           metadata.ICCSpaceXYZ,
+		ProfileConnectionSpaceIlluminant: white,
+		Tags: []*metadata.ICCTag{
+			{Signature: metadata.ICCTagMediaWhitePoint, Value: metadata.ICCXYZ{white}},
+		},
+	}
+	if gray {
+		x.ColorSpace = metadata.ICCSpaceGray
+		x.Tags = append(x.Tags, &metadata.ICCTag{Signature: metadata.ICCTagGrayTRC, Value: trc})
+		return x, nil
+	}
+
+	if chroma == nil {
+		chroma = &srgbChromaticities
+	}
+	c := iccutil.Chromaticities(*chroma)
+	m, err := c.Matrix()
+	if err != nil {
+		return nil, errors.New("cms: invalid chromaticities")
+	}
+	for i, sig := range [][2]uint32{
+		{metadata.ICCTagRedColorant, metadata.ICCTagRedTRC},
+		{metadata.ICCTagGreenColorant, metadata.ICCTagGreenTRC},
+		{metadata.ICCTagBlueColorant, metadata.ICCTagBlueTRC},
+	} {
+		v := metadata.XYZNumber{X: metadata.NewS15Fixed16(m[0][i]), Y: metadata.NewS15Fixed16(m[1][i]), Z: metadata.NewS15Fixed16(m[2][i])}
+		x.Tags = append(x.Tags,
+			&metadata.ICCTag{Signature: sig[0], Value: metadata.ICCXYZ{v}},
+			&metadata.ICCTag{Signature: sig[1], Value: trc})
+	}
+	return x, nil
+}
+
+// parametric returns a parametric curve with the given function type
+// and parameters.
+func parametric(function uint16, params ...float64) metadata.ICCParametricCurve {
+	c := metadata.ICCParametricCurve{Function: function}
+	for _, p := range params {
+		c.Params = append(c.Params, metadata.NewS15Fixed16(p))
+	}
+	return c
+}
+
+// mat3 is a 3x3 matrix.
+type mat3 [3][3]float64
+
+var identityMatrix = mat3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// mul returns the matrix product m×n.
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// apply returns the product of m and the vector v.
+func (m mat3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// det returns the determinant of m.
+func (m mat3) det() float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// invert returns the inverse of m, which mustn't be singular.
+func (m mat3) invert() mat3 {
+	det := m.det()
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// The cofactor of m[j][i], using cyclic indices so the
+			// signs come out right.
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return r
+}
@@ -0,0 +1,78 @@
+package image_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+)
+
+func TestFormatLookup(t *testing.T) {
+	extTests := []struct {
+		ext, want string
+	}{
+		{".png", "png"},
+		{"PNG", "png"},
+		{".jpg", "jpeg"},
+		{".JPEG", "jpeg"},
+		{"gif", "gif"},
+		{".tiff", ""},
+	}
+	for _, tc := range extTests {
+		got, ok := image.FormatForExtension(tc.ext)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("FormatForExtension(%q) = %q, %v, want %q", tc.ext, got, ok, tc.want)
+		}
+	}
+
+	mimeTests := []struct {
+		mimeType, want string
+	}{
+		{"image/png", "png"},
+		{"Image/JPEG", "jpeg"},
+		{"image/gif; charset=binary", "gif"},
+		{"text/plain", ""},
+	}
+	for _, tc := range mimeTests {
+		got, ok := image.FormatForMIMEType(tc.mimeType)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("FormatForMIMEType(%q) = %q, %v, want %q", tc.mimeType, got, ok, tc.want)
+		}
+	}
+}
+
+func TestEncodeWithOptions(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			m.SetRGBA(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 32), B: 0x80, A: 0xff})
+		}
+	}
+
+	for _, name := range []string{"png", "jpeg", "gif"} {
+		var buf bytes.Buffer
+		if err := image.EncodeWithOptions(context.Background(), &buf, name, m); err != nil {
+			t.Errorf("%s: EncodeWithOptions: %v", name, err)
+			continue
+		}
+		got, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Errorf("%s: Decode: %v", name, err)
+			continue
+		}
+		if format != name {
+			t.Errorf("%s: decoded format %q", name, format)
+		}
+		if got.Bounds() != m.Bounds() {
+			t.Errorf("%s: got bounds %v, want %v", name, got.Bounds(), m.Bounds())
+		}
+	}
+
+	err := image.EncodeWithOptions(context.Background(), &bytes.Buffer{}, "tiff", m)
+	if !errors.Is(err, image.ErrFormat) {
+		t.Errorf("unregistered format: got error %v, want ErrFormat", err)
+	}
+}
@@ -17,7 +17,7 @@ import (
 func main() {
 	ctx := context.Background()
 
-	fh, err := os.Open(os.Args[0])
+	fh, err := os.Open(os.Args[1])
 	if err != nil {
 		log.Fatalf("can't open file %v", err)
 	}
@@ -29,21 +29,26 @@ func main() {
 		return
 	}
 
-	fmt.Printf("%v read with error %v", err, os.Args[0])
+	fmt.Printf("%v read with error %v\n", os.Args[1], initialErr)
 	_, err = fh.Seek(0, 0)
 	if err != nil {
 		log.Fatalf("Can't seek back to beginning, %v", err)
 	}
-	_, _, t, err = image.DecodeWithOptions(ctx, fh,
+	// Decoders hand back the part of the image they could read along
+	// with an error saying it's only partial.
+	img, _, t, err := image.DecodeWithOptions(ctx, fh,
 		image.DamageHandlingOptions{
 			AllowTrailingData:   true,
 			SkipDamagedData:     true,
 			AllowMisorderedData: true,
 		})
 
-	if err != nil {
+	if img == nil {
 		log.Fatalf("Unable to read file, %v", err)
 	}
-	fmt.Printf("Partially ecoverable, image type %v\n", t)
+	if err != nil {
+		fmt.Printf("Partial image recovered with error %v\n", err)
+	}
+	fmt.Printf("Partially recoverable, image type %v\n", t)
 
 }
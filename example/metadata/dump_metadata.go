@@ -12,6 +12,11 @@ import (
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/gif"
 	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	_ "github.com/rmamba/image/metadata/exif"
+	_ "github.com/rmamba/image/metadata/icc"
+	_ "github.com/rmamba/image/metadata/iptc"
+	_ "github.com/rmamba/image/metadata/xmp"
 	"github.com/rmamba/image/png"
 )
 
@@ -106,4 +111,79 @@ func main() {
 	default:
 		log.Fatalf("unknown image type %v", t)
 	}
+	dumpEmbedded(ctx, m)
+}
+
+// dumpEmbedded prints the EXIF, XMP, ICC and IPTC metadata embedded in the
+// image, for whichever of them the image format can carry.
+func dumpEmbedded(ctx context.Context, m image.Metadata) {
+	if c, ok := m.(metadata.EXIFCarrier); ok {
+		x, err := c.EXIF(ctx)
+		switch {
+		case err != nil:
+			fmt.Printf("EXIF: unable to decode: %v\n", err)
+		case x != nil:
+			fmt.Printf("EXIF:\n")
+			fmt.Printf("  Make: %q\n", x.Make)
+			fmt.Printf("  Model: %q\n", x.Model)
+			fmt.Printf("  Software: %q\n", x.Software)
+			fmt.Printf("  Orientation: %v\n", x.Orientation)
+			if x.DateTime != nil {
+				fmt.Printf("  Date/time: %v\n", *x.DateTime)
+			}
+		}
+	}
+
+	if c, ok := m.(metadata.XMPCarrier); ok {
+		x, err := c.XMP(ctx)
+		switch {
+		case err != nil:
+			fmt.Printf("XMP: unable to decode: %v\n", err)
+		case x != nil:
+			fmt.Printf("XMP:\n")
+			if x.Properties != nil {
+				fmt.Printf("  Creator tool: %q\n", x.Properties.CreatorTool)
+			}
+			if x.CoreProperties != nil {
+				fmt.Printf("  Creator: %q\n", x.CoreProperties.Creator)
+			}
+		}
+	}
+
+	if c, ok := m.(metadata.ICCCarrier); ok {
+		p, err := c.ICC(ctx)
+		switch {
+		case err != nil:
+			fmt.Printf("ICC profile: unable to decode: %v\n", err)
+		case p != nil:
+			fmt.Printf("ICC profile:\n")
+			fmt.Printf("  Description: %q\n", p.Description())
+			fmt.Printf("  Version: %d.%x\n", p.ProfileVersion.Major, p.ProfileVersion.Minor)
+			fmt.Printf("  Color space: %q\n", signature(p.ColorSpace))
+			fmt.Printf("  Connection space: %q\n", signature(p.ProfileConnectionSpace))
+			fmt.Printf("  Wide gamut: %v\n", p.WideGamut())
+		}
+	}
+
+	if c, ok := m.(metadata.IPTCCarrier); ok {
+		x, err := c.IPTC(ctx)
+		switch {
+		case err != nil:
+			fmt.Printf("IPTC: unable to decode: %v\n", err)
+		case x != nil:
+			fmt.Printf("IPTC:\n")
+			fmt.Printf("  Headline: %q\n", x.Headline)
+			fmt.Printf("  Caption: %q\n", x.Caption)
+			fmt.Printf("  Byline: %q\n", x.Byline)
+			fmt.Printf("  Keywords: %q\n", x.Keywords)
+			if x.DateCreated != nil {
+				fmt.Printf("  Date created: %v\n", *x.DateCreated)
+			}
+		}
+	}
+}
+
+// signature returns the four character ICC signature s as a string.
+func signature(s uint32) string {
+	return string([]byte{byte(s >> 24), byte(s >> 16), byte(s >> 8), byte(s)})
 }
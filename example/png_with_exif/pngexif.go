@@ -9,8 +9,9 @@ import (
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/png"
 
-	// Make sure the exif metadata decoder is loaded
+	// Make sure the exif and xmp metadata decoders are loaded
 	_ "github.com/rmamba/image/metadata/exif"
+	_ "github.com/rmamba/image/metadata/xmp"
 )
 
 func main() {
@@ -8,7 +8,9 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -45,17 +47,11 @@ func RegisterFormat(name, magic string, decode func(io.Reader) (Image, error), d
 
 	// Create a function suitable for RegisterFormatExtended.
 	f := func(_ context.Context, r io.Reader, o ...ReadOption) (Image, Metadata, error) {
-		var ro *DataDecodeOptions
-		// Run through the passed in options and pull out the ones that we understand
-		for _, op := range o {
-			iro, ok := op.(DataDecodeOptions)
-			if ok {
-				if ro != nil {
-					return nil, nil, ErrOption
-				}
-				ro = &iro
-			}
+		s, err := ResolveReadOptions(o...)
+		if err != nil {
+			return nil, nil, err
 		}
+		ro := s.Data
 
 		// Images currently can't be deferred.
 		if ro.DecodeImage == DeferData {
@@ -68,9 +64,8 @@ func RegisterFormat(name, magic string, decode func(io.Reader) (Image, error), d
 		}
 
 		var id Image
-		var err error
 		// Decode the image, if the options specify that.
-		if ro.DecodeImage == DefaultDecodeOption || ro.DecodeImage == DecodeData {
+		if ro.DecodeImage == DecodeData {
 			id, err = decode(r)
 			if err != nil {
 				return nil, nil, err
@@ -105,6 +100,110 @@ func RegisterFormatExtended(name, magic string, decode func(context.Context, io.
 	formatsMu.Unlock()
 }
 
+// An encoder holds an image format's name, the MIME types and file
+// extensions it goes by, and how to encode it.
+type encoder struct {
+	name                  string
+	mimeTypes, extensions []string
+	encode                func(context.Context, io.Writer, Image, ...WriteOption) error
+}
+
+// Encoders is the list of registered encoders.
+var (
+	encodersMu     sync.Mutex
+	atomicEncoders atomic.Value
+)
+
+// RegisterEncoder registers an image format for use by
+// EncodeWithOptions. Name is the name of the format, like "jpeg" or
+// "png". MimeTypes are the MIME types the format goes by, like
+// "image/jpeg", and extensions are the file extensions it uses, like
+// ".jpg" or ".jpeg". Encode is the function that encodes an image, and
+// any metadata passed to it as a write option.
+//
+// If more than one encoder is registered under the same name, MIME
+// type or extension, the first one registered is used.
+func RegisterEncoder(name string, mimeTypes, extensions []string, encode func(context.Context, io.Writer, Image, ...WriteOption) error) {
+	e := encoder{name: name, encode: encode}
+	for _, t := range mimeTypes {
+		e.mimeTypes = append(e.mimeTypes, normalizeMIMEType(t))
+	}
+	for _, x := range extensions {
+		e.extensions = append(e.extensions, normalizeExtension(x))
+	}
+	encodersMu.Lock()
+	encoders, _ := atomicEncoders.Load().([]encoder)
+	atomicEncoders.Store(append(encoders, e))
+	encodersMu.Unlock()
+}
+
+// normalizeMIMEType returns the MIME type t in lower case, without any
+// parameters.
+func normalizeMIMEType(t string) string {
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// normalizeExtension returns the file extension x in lower case, with
+// a leading dot.
+func normalizeExtension(x string) string {
+	x = strings.ToLower(strings.TrimSpace(x))
+	if !strings.HasPrefix(x, ".") {
+		x = "." + x
+	}
+	return x
+}
+
+// findEncoder returns the first registered encoder that match accepts.
+func findEncoder(match func(e *encoder) bool) *encoder {
+	encoders, _ := atomicEncoders.Load().([]encoder)
+	for i := range encoders {
+		if match(&encoders[i]) {
+			return &encoders[i]
+		}
+	}
+	return nil
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatForExtension returns the name of the registered encoder that
+// uses the file extension ext, like ".png" or "JPG". The match ignores
+// case and the leading dot is optional, so the result of
+// filepath.Ext can be passed directly. The boolean reports whether an
+// encoder was found.
+func FormatForExtension(ext string) (string, bool) {
+	ext = normalizeExtension(ext)
+	e := findEncoder(func(e *encoder) bool { return contains(e.extensions, ext) })
+	if e == nil {
+		return "", false
+	}
+	return e.name, true
+}
+
+// FormatForMIMEType returns the name of the registered encoder for the
+// MIME type mimeType, like "image/png". The match ignores case and any
+// parameters following the type. The boolean reports whether an
+// encoder was found.
+func FormatForMIMEType(mimeType string) (string, bool) {
+	mimeType = normalizeMIMEType(mimeType)
+	e := findEncoder(func(e *encoder) bool { return contains(e.mimeTypes, mimeType) })
+	if e == nil {
+		return "", false
+	}
+	return e.name, true
+}
+
 // A reader is an io.Reader that can also peek ahead.
 type reader interface {
 	io.Reader
@@ -210,6 +309,53 @@ type LimitOptions struct {
 func (_ LimitOptions) IsImageReadOption() {
 }
 
+// CheckImageSize returns a *LimitError if an image that decodes to
+// size bytes would go over MaxImageSize. A MaxImageSize of zero means
+// there is no limit.
+func (l LimitOptions) CheckImageSize(size int64) error {
+	if l.MaxImageSize > 0 && size > int64(l.MaxImageSize) {
+		return &LimitError{Kind: "image", Size: size, Limit: l.MaxImageSize}
+	}
+	return nil
+}
+
+// CheckMetadataSize returns a *LimitError if size bytes of metadata
+// would go over MaxMetadataSize. A MaxMetadataSize of zero means there
+// is no limit.
+func (l LimitOptions) CheckMetadataSize(size int64) error {
+	if l.MaxMetadataSize > 0 && size > int64(l.MaxMetadataSize) {
+		return &LimitError{Kind: "metadata", Size: size, Limit: l.MaxMetadataSize}
+	}
+	return nil
+}
+
+// ErrLimit indicates that decoding was stopped because the image or
+// its metadata was larger than the limits in the LimitOptions
+// provided. Decoders return a *LimitError, which matches ErrLimit
+// with errors.Is.
+var ErrLimit = errors.New("image: size limit exceeded")
+
+// LimitError reports that an image or its metadata was larger than
+// the limits set in LimitOptions.
+type LimitError struct {
+	// Kind is the kind of data that went over its limit, either
+	// "image" or "metadata".
+	Kind string
+	// Size is the number of bytes the data would have needed.
+	Size int64
+	// Limit is the limit that was exceeded.
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("image: %s size %d exceeds limit of %d bytes", e.Kind, e.Size, e.Limit)
+}
+
+// Is lets errors.Is match a *LimitError against ErrLimit.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimit
+}
+
 // IsImaegWriteOption allows a LimitOptions struct get passed when
 // writing out an image to allow size limits to be placed on the
 // output file.
@@ -279,14 +425,34 @@ type ImageTransformOptions struct {
 	// RotationTransform controls how the image transform metadata
 	// should be applied when reading an image. By defaul the image has
 	// no rotation transformations applied.
+	//
+	// The rotation is taken from the exif Orientation tag, so the
+	// metadata/exif package must be imported for this to work. The
+	// image config returned by the metadata reports the dimensions of
+	// the transformed image.
 	RotationTransform TransformOption
 	// ColorTransform controls how the color metadata should be applied
 	// when reading an image. By default the returned image has no color
 	// transformations applied.
+	//
+	// The forward transform converts the image from the color space of
+	// its embedded ICC profile to sRGB, and removes the profile from
+	// the metadata. The reverse transform converts sRGB pixels to the
+	// profile's color space. The conversion is done by the cms package,
+	// and the profile has to be decoded first, so the metadata/icc
+	// package must be imported for this to work. Images with profiles
+	// the cms package can't convert with, or without one, are returned
+	// unchanged.
 	ColorTransform TransformOption
 	// GammaTransform controls how the gamma metadata should be applied
 	// when reading an image. By default the returned image has no gamma
 	// transformations applied.
+	//
+	// The forward transform converts the image from the gamma and
+	// primaries noted in the metadata to sRGB, while the reverse
+	// transform converts sRGB pixels to them. Color profiles take
+	// precedence over gamma metadata, so images with one are returned
+	// unchanged.
 	GammaTransform TransformOption
 }
 
@@ -302,6 +468,19 @@ type WriteOption interface {
 	IsImageWriteOption()
 }
 
+// EncodeWithOptions encodes the image m to w in the registered format
+// named formatName, such as "png", passing opts on to the format's
+// encoder. Encoder registration is typically done by an init function
+// in the codec-specific package. If no encoder has been registered
+// under formatName the error returned wraps ErrFormat.
+func EncodeWithOptions(ctx context.Context, w io.Writer, formatName string, m Image, opts ...WriteOption) error {
+	e := findEncoder(func(e *encoder) bool { return e.name == formatName })
+	if e == nil {
+		return fmt.Errorf("%w: no encoder registered for %q", ErrFormat, formatName)
+	}
+	return e.encode(ctx, w, m, opts...)
+}
+
 // Decode decodes an image that has been encoded in a registered format.
 // The string returned is the format name used during format registration.
 // Format registration is typically done by an init function in the codec-
@@ -1,6 +1,7 @@
 package gif
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
@@ -35,12 +36,42 @@ type Metadata struct {
 	ColorModel color.Model
 }
 
+// The application identifier and authentication code of the
+// application extension holding XMP data.
+const (
+	xmpAppID    = "XMP Data"
+	xmpAuthCode = "XMP"
+)
+
+// xmpTrailerLen is the length of the "magic trailer" that follows the
+// XMP data, not counting the block terminator.
+const xmpTrailerLen = 257
+
+// xmpTrailer returns the magic trailer that follows the XMP data in an
+// XMP application extension. XMP data isn't split into sub-blocks;
+// instead the trailer makes a reader that skips through it as if it
+// were sub-blocks land on the block terminator, wherever it starts.
+func xmpTrailer() []byte {
+	t := make([]byte, xmpTrailerLen+1)
+	t[0] = 0x01
+	for i := 1; i <= 256; i++ {
+		t[i] = byte(256 - i)
+	}
+	return t
+}
+
 // Extension holds the contents of an extension.
 type Extension struct {
 	AuthCode string
 	Body     []byte
 }
 
+// Metadata implements the carrier interfaces for the kinds of
+// embedded metadata a gif file can hold.
+var (
+	_ metadata.XMPCarrier = (*Metadata)(nil)
+)
+
 // ImageMetadataFormat returns the image type for this metadata.
 func (m *Metadata) ImageMetadataFormat() string {
 	return "gif"
@@ -88,6 +119,28 @@ func (m *Metadata) SetXMP(x *metadata.XMP) {
 	m.rawXmp = nil
 }
 
+// RawXMP returns the undecoded XMP packet read from the image. It
+// returns the empty string if the image had no XMP data or the data
+// has been decoded or replaced.
+func (m *Metadata) RawXMP() string {
+	if m.rawXmp == nil {
+		return ""
+	}
+	return *m.rawXmp
+}
+
+// SetRawXMP replaces the XMP information associated with the metadata
+// object with the undecoded packet x, which is written out as it is.
+// An empty packet removes the XMP information.
+func (m *Metadata) SetRawXMP(x string) {
+	m.xmp = nil
+	m.xmpDecodeErr = nil
+	m.rawXmp = nil
+	if x != "" {
+		m.rawXmp = &x
+	}
+}
+
 // readComment reads a comment from the image and saves it.
 func (d *decoder) readComment(ctx context.Context) error {
 	c := []byte{}
@@ -97,7 +150,13 @@ func (d *decoder) readComment(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-
+		// Read the blocks until we get an end-of-block block
+		if n == 0 {
+			break
+		}
+		if err := d.countMetadata(n); err != nil {
+			return err
+		}
 		c = append(c, d.tmp[:n]...)
 	}
 
@@ -124,6 +183,10 @@ func (d *decoder) readApplication(ctx context.Context) error {
 	// apparently sometimes is less because standards are for chumps.
 	authCode := string(d.tmp[8:b])
 
+	// XMP data isn't really split into sub-blocks, so the bytes read
+	// as block sizes are part of the data and have to be kept.
+	isXMP := appId == xmpAppID && authCode == xmpAuthCode
+
 	// Read in all the sub-block data
 	c := []byte{}
 	for {
@@ -135,9 +198,24 @@ func (d *decoder) readApplication(ctx context.Context) error {
 		if n == 0 {
 			break
 		}
+		if err := d.countMetadata(n); err != nil {
+			return err
+		}
+		if isXMP {
+			c = append(c, byte(n))
+		}
 		c = append(c, d.tmp[:n]...)
 	}
 
+	if isXMP {
+		if len(c) >= xmpTrailerLen && bytes.Equal(c[len(c)-xmpTrailerLen:], xmpTrailer()[:xmpTrailerLen]) {
+			c = c[:len(c)-xmpTrailerLen]
+		}
+		x := string(c)
+		d.metadata.rawXmp = &x
+		return nil
+	}
+
 	switch appId {
 	case "NETSCAPE":
 		// I have no idea what we should do if this has a different auth code.
@@ -25,6 +25,12 @@ var (
 	errBadPixel  = errors.New("gif: invalid pixel value")
 )
 
+// ErrPartialImage is returned by DecodeExtended, along with the first frame,
+// when a frame's image data is cut short or damaged, or the file ends before
+// the trailer, and DamageHandlingOptions.SkipDamagedData is set. The pixels
+// of a damaged frame that couldn't be decoded have color index zero.
+var ErrPartialImage = errors.New("gif: image data ends before trailer")
+
 // If the io.Reader does not also have ReadByte, then decode will introduce its own buffering.
 type reader interface {
 	io.Reader
@@ -128,6 +134,24 @@ type decoder struct {
 
 	// Metadata
 	metadata *Metadata
+
+	// settings holds the resolved read options for this decode.
+	settings image.ReadSettings
+	// imageSize holds the number of bytes of frame data kept so far.
+	imageSize int64
+	// metadataSize holds the number of bytes of metadata read so far.
+	metadataSize int64
+	// damaged is set once a frame with damaged image data has been
+	// read under SkipDamagedData, which ends the decode, or if the file
+	// ends before the trailer.
+	damaged bool
+}
+
+// countMetadata adds n bytes to the amount of metadata read so far
+// and checks the total against the metadata size limit.
+func (d *decoder) countMetadata(n int) error {
+	d.metadataSize += int64(n)
+	return d.settings.Limits.CheckMetadataSize(d.metadataSize)
 }
 
 // blockReader parses the block structure of GIF image data, which comprises
@@ -264,6 +288,12 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, configOnly, keepAllFr
 	for {
 		c, err := readByte(d.r)
 		if err != nil {
+			// A file that stops without a trailer still has usable
+			// frames if we're skipping damaged data.
+			if d.settings.Damage.SkipDamagedData && len(d.image) > 0 {
+				d.damaged = true
+				return nil
+			}
 			return fmt.Errorf("gif: reading frames: %v", err)
 		}
 		switch c {
@@ -276,6 +306,12 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, configOnly, keepAllFr
 			if err = d.readImageDescriptor(ctx, keepAllFrames); err != nil {
 				return err
 			}
+			if d.damaged {
+				if len(d.image) == 0 {
+					return fmt.Errorf("gif: missing image data")
+				}
+				return nil
+			}
 
 		case sTrailer:
 			if len(d.image) == 0 {
@@ -300,6 +336,11 @@ func (d *decoder) readHeaderAndScreenDescriptor(ctx context.Context) error {
 	}
 	d.width = int(d.tmp[6]) + int(d.tmp[7])<<8
 	d.height = int(d.tmp[8]) + int(d.tmp[9])<<8
+	// Every frame is a paletted image no bigger than the logical
+	// screen, so a single frame takes at most width*height bytes.
+	if err := d.settings.Limits.CheckImageSize(int64(d.width) * int64(d.height)); err != nil {
+		return err
+	}
 	if fields := d.tmp[10]; fields&fColorTable != 0 {
 		d.backgroundIndex = d.tmp[11]
 		// readColorTable overwrites the contents of d.tmp, but that's OK.
@@ -386,6 +427,12 @@ func (d *decoder) readImageDescriptor(ctx context.Context, keepAllFrames bool) e
 	if err != nil {
 		return err
 	}
+	keep := keepAllFrames || len(d.image) == 0
+	if keep {
+		if err := d.settings.Limits.CheckImageSize(d.imageSize + int64(len(m.Pix))); err != nil {
+			return err
+		}
+	}
 	useLocalColorTable := d.imageFields&fColorTable != 0
 	if useLocalColorTable {
 		m.Palette, err = d.readColorTable(ctx, d.imageFields)
@@ -425,11 +472,57 @@ func (d *decoder) readImageDescriptor(ctx context.Context, keepAllFrames bool) e
 	if litWidth < 2 || litWidth > 8 {
 		return fmt.Errorf("gif: pixel size in decode out of range: %d", litWidth)
 	}
+	if err := d.readImageData(ctx, m, litWidth); err != nil {
+		// With SkipDamagedData set a frame whose image data is cut off
+		// or corrupt keeps the pixels decoded before the damage. We
+		// can't tell where the next block starts after that, so this
+		// is the last frame we read.
+		if !d.settings.Damage.SkipDamagedData || ctx.Err() != nil {
+			return err
+		}
+		d.damaged = true
+	}
+
+	// Check that the color indexes are inside the palette. Pixels in a
+	// damaged frame may be garbage, so those get the first palette
+	// entry instead.
+	if len(m.Palette) < 256 {
+		for i, pixel := range m.Pix {
+			if int(pixel) >= len(m.Palette) {
+				if !d.damaged {
+					return errBadPixel
+				}
+				m.Pix[i] = 0
+			}
+		}
+	}
+
+	// Undo the interlacing if necessary.
+	if d.imageFields&fInterlace != 0 {
+		uninterlace(m)
+	}
+
+	if keep {
+		d.imageSize += int64(len(m.Pix))
+		d.image = append(d.image, m)
+		d.delay = append(d.delay, d.delayTime)
+		d.disposal = append(d.disposal, d.disposalMethod)
+	}
+	// The GIF89a spec, Section 23 (Graphic Control Extension) says:
+	// "The scope of this extension is the first graphic rendering block
+	// to follow." We therefore reset the GCE fields to zero.
+	d.delayTime = 0
+	d.hasTransparentIndex = false
+	return nil
+}
+
+// readImageData reads the LZW-compressed pixels of a frame into m.
+func (d *decoder) readImageData(ctx context.Context, m *image.Paletted, litWidth byte) error {
 	// A wonderfully Go-like piece of magic.
 	br := &blockReader{d: d}
 	lzwr := lzw.NewReader(br, lzw.LSB, int(litWidth))
 	defer lzwr.Close()
-	if err = readFull(ctx, lzwr, m.Pix); err != nil {
+	if err := readFull(ctx, lzwr, m.Pix); err != nil {
 		if err != io.ErrUnexpectedEOF {
 			return fmt.Errorf("gif: reading image data: %v", err)
 		}
@@ -460,31 +553,6 @@ func (d *decoder) readImageDescriptor(ctx context.Context, keepAllFrames bool) e
 	} else if err != nil {
 		return fmt.Errorf("gif: reading image data: %v", err)
 	}
-
-	// Check that the color indexes are inside the palette.
-	if len(m.Palette) < 256 {
-		for _, pixel := range m.Pix {
-			if int(pixel) >= len(m.Palette) {
-				return errBadPixel
-			}
-		}
-	}
-
-	// Undo the interlacing if necessary.
-	if d.imageFields&fInterlace != 0 {
-		uninterlace(m)
-	}
-
-	if keepAllFrames || len(d.image) == 0 {
-		d.image = append(d.image, m)
-		d.delay = append(d.delay, d.delayTime)
-		d.disposal = append(d.disposal, d.disposalMethod)
-	}
-	// The GIF89a spec, Section 23 (Graphic Control Extension) says:
-	// "The scope of this extension is the first graphic rendering block
-	// to follow." We therefore reset the GCE fields to zero.
-	d.delayTime = 0
-	d.hasTransparentIndex = false
 	return nil
 }
 
@@ -569,17 +637,11 @@ type ReadOption struct {
 }
 
 func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption) (image.Image, image.Metadata, error) {
-	if len(opts) > 1 {
-		return nil, nil, errors.New("Too many read options provided")
-	}
-	opt := image.DataDecodeOptions{}
-	if len(opts) == 1 {
-		o, ok := opts[0].(image.DataDecodeOptions)
-		if !ok {
-			return nil, nil, errors.New("Unknown read option type provided")
-		}
-		opt = o
+	s, err := image.ResolveReadOptions(opts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	opt := s.Data
 
 	// If they ask for nothing then return nothing. This is currently
 	// not an error.
@@ -591,13 +653,6 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		return nil, nil, errors.New("Image parsing may not be deferred")
 	}
 
-	if opt.DecodeImage == image.DefaultDecodeOption {
-		opt.DecodeImage = image.DecodeData
-	}
-	if opt.DecodeMetadata == image.DefaultDecodeOption {
-		opt.DecodeMetadata = image.DeferData
-	}
-
 	parseImage := false
 	parseMetadata := true
 	if opt.DecodeImage == image.DecodeData {
@@ -609,6 +664,7 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 
 	var d decoder
 	d.metadata = &Metadata{}
+	d.settings = *s
 
 	if err := d.decode(ctx, r, false, false, parseImage, parseMetadata); err != nil {
 		return nil, nil, err
@@ -627,6 +683,9 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		}
 	}
 
+	if d.damaged {
+		return d.image[0], d.metadata, ErrPartialImage
+	}
 	return d.image[0], d.metadata, nil
 }
 
@@ -675,6 +734,7 @@ type GIF struct {
 // and timing information.
 func DecodeAll(r io.Reader) (*GIF, error) {
 	var d decoder
+	d.metadata = &Metadata{}
 	if err := d.decode(context.TODO(), r, false, true, true, false); err != nil {
 		return nil, err
 	}
@@ -709,4 +769,5 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 
 func init() {
 	image.RegisterFormatExtended("gif", "GIF8?a", DecodeExtended)
+	image.RegisterEncoder("gif", []string{"image/gif"}, []string{".gif"}, EncodeExtended)
 }
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"compress/lzw"
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"reflect"
@@ -406,6 +407,55 @@ func TestUnexpectedEOF(t *testing.T) {
 	}
 }
 
+func TestSkipDamagedData(t *testing.T) {
+	ctx := context.TODO()
+	skip := image.DamageHandlingOptions{SkipDamagedData: true}
+
+	// frame returns a 2x1 frame with both pixels set to 1, with its
+	// LZW data cut down to n bytes.
+	frame := func(n int) []byte {
+		enc := lzwEncode([]byte{0x01, 0x01})
+		b := []byte("\x2c\x00\x00\x00\x00\x02\x00\x01\x00\x00\x02")
+		b = append(b, byte(len(enc)))
+		b = append(b, enc[:n]...)
+		if n == len(enc) {
+			b = append(b, 0x00)
+		}
+		return b
+	}
+	full := len(lzwEncode([]byte{0x01, 0x01}))
+
+	testCases := []struct {
+		desc string
+		data string
+		want color.Color
+	}{
+		{"missing trailer", headerStr + paletteStr + string(frame(full)), color.RGBA{R: 0x40, G: 0x50, B: 0x60, A: 0xff}},
+		{"truncated image data", headerStr + paletteStr + string(frame(1)), color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}},
+	}
+	for _, tc := range testCases {
+		if _, _, err := DecodeExtended(ctx, strings.NewReader(tc.data), image.OptionDecodeImage); err == nil {
+			t.Errorf("%s: got nil error without SkipDamagedData", tc.desc)
+		}
+		m, _, err := DecodeExtended(ctx, strings.NewReader(tc.data), image.OptionDecodeImage, skip)
+		if err != ErrPartialImage {
+			t.Errorf("%s: got error %v, want ErrPartialImage", tc.desc, err)
+			continue
+		}
+		if got := m.Bounds(); got != image.Rect(0, 0, 2, 1) {
+			t.Errorf("%s: got bounds %v, want 2x1", tc.desc, got)
+		}
+		if got := m.At(1, 0); got != tc.want {
+			t.Errorf("%s: got pixel %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+
+	// Without any frames there's nothing to return.
+	if _, _, err := DecodeExtended(ctx, strings.NewReader(headerStr+paletteStr), image.OptionDecodeImage, skip); err == nil {
+		t.Error("got nil error for a file with no frames")
+	}
+}
+
 // See golang.org/issue/22237
 func TestDecodeMemoryConsumption(t *testing.T) {
 	const frames = 3000
@@ -437,6 +487,44 @@ func TestDecodeMemoryConsumption(t *testing.T) {
 	}
 }
 
+func TestImageSizeLimit(t *testing.T) {
+	ctx := context.TODO()
+	// A 65535x65535 logical screen should be rejected before any frame
+	// data is read.
+	b := append([]byte{}, testGIF...)
+	b[6], b[7], b[8], b[9] = 0xff, 0xff, 0xff, 0xff
+	_, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.LimitOptions{MaxImageSize: 1 << 20})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+	_, _, err = DecodeExtended(ctx, bytes.NewReader(testGIF), image.LimitOptions{MaxImageSize: 1})
+	if err != nil {
+		t.Fatalf("decode under the limit failed: %v", err)
+	}
+}
+
+func TestMetadataSizeLimit(t *testing.T) {
+	ctx := context.TODO()
+	comment := []byte{sExtension, eComment, 100}
+	comment = append(comment, bytes.Repeat([]byte{'x'}, 100)...)
+	comment = append(comment, 0)
+	b := append([]byte{}, testGIF[:19]...)
+	b = append(b, comment...)
+	b = append(b, testGIF[19:]...)
+
+	_, m, err := DecodeExtended(ctx, bytes.NewReader(b), image.LimitOptions{MaxMetadataSize: 100})
+	if err != nil {
+		t.Fatalf("decode under the limit failed: %v", err)
+	}
+	if c := m.(*Metadata).Comments; len(c) != 1 || len(c[0]) != 100 {
+		t.Errorf("got comments %q, want one 100 byte comment", c)
+	}
+	_, _, err = DecodeExtended(ctx, bytes.NewReader(b), image.LimitOptions{MaxMetadataSize: 99})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+}
+
 func BenchmarkDecode(b *testing.B) {
 	data, err := ioutil.ReadFile("../testdata/video-001.gif")
 	if err != nil {
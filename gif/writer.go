@@ -8,13 +8,16 @@ import (
 	"bufio"
 	"bytes"
 	"compress/lzw"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
 	"github.com/rmamba/image/color/palette"
 	"github.com/rmamba/image/draw"
+	"github.com/rmamba/image/metadata"
 )
 
 // Graphic control extension fields.
@@ -53,8 +56,15 @@ type encoder struct {
 	// writing. All attempted writes after the first error become no-ops.
 	w   writer
 	err error
+	// ctx is the context for encoding any decoded metadata, and
+	// metadataOpts holds the write options for it.
+	ctx          context.Context
+	metadataOpts []image.WriteOption
 	// g is a reference to the data that is being encoded.
 	g GIF
+	// metadata holds the comments and XMP data to write after the
+	// header. It may be nil.
+	metadata *Metadata
 	// globalCT is the size in bytes of the global color table.
 	globalCT int
 	// buf is a scratch buffer. It must be at least 256 for the blockWriter.
@@ -195,6 +205,56 @@ func (e *encoder) writeHeader() {
 		e.buf[4] = 0x00 // Block Terminator.
 		e.write(e.buf[:5])
 	}
+
+	if e.metadata != nil {
+		for _, c := range e.metadata.Comments {
+			e.writeComment(c)
+		}
+		e.writeXMP()
+	}
+}
+
+// writeXMP writes out the metadata's XMP data, if it has any, as an
+// application extension. Decoded XMP data is encoded first.
+func (e *encoder) writeXMP() {
+	m := e.metadata
+	if e.err != nil || (m.rawXmp == nil && m.xmp == nil) {
+		return
+	}
+	var xmp string
+	if m.rawXmp != nil {
+		xmp = *m.rawXmp
+	}
+	if m.xmp != nil {
+		xmp, e.err = m.xmp.Encode(e.ctx, e.metadataOpts...)
+		if e.err != nil {
+			return
+		}
+	}
+	e.buf[0] = sExtension
+	e.buf[1] = eApplication
+	e.buf[2] = 0x0b // Block Size.
+	e.write(e.buf[:3])
+	e.write([]byte(xmpAppID + xmpAuthCode))
+	e.write([]byte(xmp))
+	e.write(xmpTrailer())
+}
+
+// writeComment writes c out as a comment extension.
+func (e *encoder) writeComment(c string) {
+	e.buf[0] = sExtension
+	e.buf[1] = eComment
+	e.write(e.buf[:2])
+	for len(c) > 0 {
+		n := len(c)
+		if n > 255 {
+			n = 255
+		}
+		e.writeByte(uint8(n))
+		e.write([]byte(c[:n]))
+		c = c[n:]
+	}
+	e.writeByte(0x00) // Block Terminator.
 }
 
 func encodeColorTable(dst []byte, p color.Palette, size int) (int, error) {
@@ -371,6 +431,13 @@ type Options struct {
 // EncodeAll writes the images in g to w in GIF format with the
 // given loop count and delay between frames.
 func EncodeAll(w io.Writer, g *GIF) error {
+	return encodeAll(context.TODO(), w, g, nil, nil)
+}
+
+// encodeAll writes the images in g to w in GIF format, along with the
+// comments and XMP data held in metadata, which may be nil. The XMP is
+// encoded with metadataOpts.
+func encodeAll(ctx context.Context, w io.Writer, g *GIF, metadata *Metadata, metadataOpts []image.WriteOption) error {
 	if len(g.Image) == 0 {
 		return errors.New("gif: must provide at least one image")
 	}
@@ -379,7 +446,7 @@ func EncodeAll(w io.Writer, g *GIF) error {
 		return errors.New("gif: mismatched image and delay lengths")
 	}
 
-	e := encoder{g: *g}
+	e := encoder{g: *g, ctx: ctx, metadataOpts: metadataOpts, metadata: metadata}
 	// The GIF.Disposal, GIF.Config and GIF.BackgroundIndex fields were added
 	// in Go 1.5. Valid Go 1.4 code, such as when the Disposal field is omitted
 	// in a GIF struct literal, should still produce valid GIFs.
@@ -421,6 +488,43 @@ func (_ *Options) IsImageWriteOption() {
 
 // Encode writes the Image m to w in GIF format.
 func Encode(w io.Writer, m image.Image, o *Options) error {
+	return encode(context.TODO(), w, m, o, nil, nil)
+}
+
+// EncodeExtended writes the Image m to w in GIF format. The options
+// may include a *Options, which is used as it is by Encode, a
+// *Metadata, whose comments and XMP are written out with the image, and
+// metadata.XMPOptions for encoding the XMP. image.LimitOptions are
+// ignored, as they only apply when decoding.
+func EncodeExtended(ctx context.Context, w io.Writer, m image.Image, opts ...image.WriteOption) error {
+	var o *Options
+	var md *Metadata
+	var metadataOpts []image.WriteOption
+	for _, opt := range opts {
+		switch do := opt.(type) {
+		case *Options:
+			if o != nil {
+				return errors.New("gif: multiple options specified")
+			}
+			o = do
+		case *Metadata:
+			if md != nil {
+				return errors.New("gif: multiple metadata specified")
+			}
+			md = do
+		case metadata.XMPOptions:
+			metadataOpts = append(metadataOpts, do)
+		case image.LimitOptions:
+			// Limits only apply when decoding.
+		default:
+			return fmt.Errorf("gif: unknown write option of type %T", opt)
+		}
+	}
+	return encode(ctx, w, m, o, md, metadataOpts)
+}
+
+// encode does the work for Encode and EncodeExtended.
+func encode(ctx context.Context, w io.Writer, m image.Image, o *Options, metadata *Metadata, metadataOpts []image.WriteOption) error {
 	// Check for bounds and size restrictions.
 	b := m.Bounds()
 	if b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
@@ -470,7 +574,7 @@ func Encode(w io.Writer, m image.Image, o *Options) error {
 		pm = &dup
 	}
 
-	return EncodeAll(w, &GIF{
+	return encodeAll(ctx, w, &GIF{
 		Image: []*image.Paletted{pm},
 		Delay: []int{0},
 		Config: image.Config{
@@ -478,5 +582,5 @@ func Encode(w io.Writer, m image.Image, o *Options) error {
 			Width:      b.Dx(),
 			Height:     b.Dy(),
 		},
-	})
+	}, metadata, metadataOpts)
 }
@@ -11,6 +11,7 @@ import (
 	"math/rand"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/rmamba/image"
@@ -279,6 +280,49 @@ func TestEncodeAllGo1Dot4(t *testing.T)                 { testEncodeAll(t, false
 func TestEncodeAllGo1Dot5(t *testing.T)                 { testEncodeAll(t, true, false) }
 func TestEncodeAllGo1Dot5GlobalColorModel(t *testing.T) { testEncodeAll(t, true, true) }
 
+func TestEncodeExtendedComments(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	comments := []string{"hello", strings.Repeat("x", 300)}
+	var buf bytes.Buffer
+	if err := EncodeExtended(context.Background(), &buf, m, &Options{NumColors: 16}, &Metadata{Comments: comments}); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	_, md, err := DecodeExtended(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	got := md.(*Metadata).Comments
+	if !reflect.DeepEqual(got, comments) {
+		t.Errorf("got comments %q, want %q", got, comments)
+	}
+
+	// Core options that don't apply to encoding are ignored.
+	if err := EncodeExtended(context.Background(), &buf, m, image.LimitOptions{}); err != nil {
+		t.Errorf("EncodeExtended with LimitOptions: %v", err)
+	}
+}
+
+func TestXMPRoundTrip(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	// Make sure the packet's length doesn't land on a block boundary,
+	// and that it has bytes that look like sub-block sizes.
+	xmp := `<?xpacket begin="\ufeff" id="W5M0MpCehiHzreSzNTczkc9d"?>` + strings.Repeat("\x00\xff x", 100) + `<?xpacket end="w"?>`
+	md := &Metadata{}
+	md.SetRawXMP(xmp)
+
+	var buf bytes.Buffer
+	if err := EncodeExtended(context.Background(), &buf, m, md); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	_, md2, err := DecodeExtended(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	if got := md2.(*Metadata).RawXMP(); got != xmp {
+		t.Errorf("XMP packet changed in round trip: got %q, want %q", got, xmp)
+	}
+}
+
 func TestEncodeMismatchDelay(t *testing.T) {
 	images := make([]*image.Paletted, 2)
 	for i := range images {
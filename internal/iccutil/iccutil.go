@@ -0,0 +1,124 @@
+// Package iccutil holds the chromaticity math shared by the built-in
+// profiles in the metadata/icc package and the profiles the cms
+// package builds from gamma and chromaticity metadata.
+package iccutil
+
+import (
+	"errors"
+)
+
+// Chromaticities holds the CIE 1931 xy chromaticities of the white
+// point and primaries of an RGB color space.
+type Chromaticities struct {
+	White, Red, Green, Blue [2]float64
+}
+
+// bradford is the Bradford cone response matrix used for chromatic
+// adaptation.
+var bradford = [3][3]float64{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// d50 holds the XYZ value of the D50 profile connection space white.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// xyz returns the XYZ value with a luminance of one for the
+// chromaticity c.
+func xyz(c [2]float64) [3]float64 {
+	return [3]float64{c[0] / c[1], 1, (1 - c[0] - c[1]) / c[1]}
+}
+
+// Matrix returns the matrix taking linear RGB values to XYZ values in
+// the D50 profile connection space, as the colorant tags of a profile
+// for the color space hold it.
+func (c *Chromaticities) Matrix() ([3][3]float64, error) {
+	for _, v := range [][2]float64{c.White, c.Red, c.Green, c.Blue} {
+		if v[1] <= 0 {
+			return [3][3]float64{}, errors.New("icc: invalid chromaticities")
+		}
+	}
+
+	// Build the matrix taking linear RGB values to XYZ values relative
+	// to the white point, scaling the primaries so that they sum to
+	// the white point.
+	var prim [3][3]float64
+	for i, v := range [][2]float64{c.Red, c.Green, c.Blue} {
+		v := xyz(v)
+		for j := range v {
+			prim[j][i] = v[j]
+		}
+	}
+	if det(prim) == 0 {
+		return [3][3]float64{}, errors.New("icc: invalid chromaticities")
+	}
+	scale := apply(invert(prim), xyz(c.White))
+	for i := range prim {
+		for j := range prim[i] {
+			prim[i][j] *= scale[j]
+		}
+	}
+
+	// Then adapt from the white point to the D50 profile connection
+	// space white.
+	return mul(Adaptation(c.White), prim), nil
+}
+
+// Adaptation returns the Bradford chromatic adaptation matrix taking
+// XYZ values relative to the white point with chromaticity white to
+// ones relative to D50, as a profile's chromatic adaptation tag holds
+// it.
+func Adaptation(white [2]float64) [3][3]float64 {
+	src, dst := apply(bradford, xyz(white)), apply(bradford, d50)
+	var cone [3][3]float64
+	for i := range cone {
+		cone[i][i] = dst[i] / src[i]
+	}
+	return mul(invert(bradford), mul(cone, bradford))
+}
+
+// mul returns the matrix product a×b.
+func mul(a, b [3][3]float64) [3][3]float64 {
+	var r [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// apply returns the product of the matrix m and the vector v.
+func apply(m [3][3]float64, v [3]float64) [3]float64 {
+	var r [3]float64
+	for i := range r {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+// det returns the determinant of m.
+func det(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// invert returns the inverse of m, which mustn't be singular.
+func invert(m [3][3]float64) [3][3]float64 {
+	det := det(m)
+	var r [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// The cofactor of m[j][i], using cyclic indices so the
+			// signs come out right.
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return r
+}
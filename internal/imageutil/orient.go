@@ -0,0 +1,145 @@
+package imageutil
+
+import (
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+)
+
+// OrientedSize returns the dimensions of a w by h image once the EXIF
+// orientation o has been applied to it. Orientations 5 through 8
+// involve a quarter turn, so they swap the width and height.
+func OrientedSize(w, h int, o uint16) (int, int) {
+	if o >= 5 && o <= 8 {
+		return h, w
+	}
+	return w, h
+}
+
+// Orient returns m transformed as the EXIF orientation o describes,
+// so that an image stored with that orientation comes back upright.
+// If reverse is true the inverse transformation is applied instead,
+// which turns an upright image back into one stored with orientation
+// o. Orientation values other than 2 through 8 return m unchanged.
+//
+// The returned image has the same type as m, except that *image.YCbCr
+// images and image types this package doesn't know about are returned
+// as *image.RGBA and *image.RGBA64 respectively. The returned image's
+// bounds start at the origin.
+func Orient(m image.Image, o uint16, reverse bool) image.Image {
+	if o < 2 || o > 8 {
+		return m
+	}
+	if reverse {
+		// Orientations 6 and 8 are quarter turns in opposite
+		// directions. All the others are their own inverse.
+		switch o {
+		case 6:
+			o = 8
+		case 8:
+			o = 6
+		}
+	}
+
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	ow, oh := OrientedSize(w, h, o)
+	r := image.Rect(0, 0, ow, oh)
+
+	switch src := m.(type) {
+	case *image.RGBA:
+		dst := image.NewRGBA(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, w, h, o)
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, w, h, o)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 8, w, h, o)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 8, w, h, o)
+		return dst
+	case *image.Gray:
+		dst := image.NewGray(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, w, h, o)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 2, w, h, o)
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, w, h, o)
+		return dst
+	case *image.Alpha16:
+		dst := image.NewAlpha16(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 2, w, h, o)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, w, h, o)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(r, src.Palette)
+		orientPix(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, w, h, o)
+		return dst
+	case *image.YCbCr:
+		// The chroma planes may be subsampled, which doesn't survive a
+		// quarter turn, so convert to RGBA first.
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		if !DrawYCbCr(rgba, rgba.Bounds(), src, b.Min) {
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					rgba.Set(x, y, src.At(b.Min.X+x, b.Min.Y+y))
+				}
+			}
+		}
+		return Orient(rgba, o, false)
+	}
+
+	dst := image.NewRGBA64(r)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := orientPoint(x, y, w, h, o)
+			dst.Set(dx, dy, color.RGBA64Model.Convert(m.At(b.Min.X+x, b.Min.Y+y)))
+		}
+	}
+	return dst
+}
+
+// orientPix copies the w by h pixels of src into dst, moving each to
+// the position given by the orientation o. Each pixel is bpp bytes.
+func orientPix(dst, src []byte, dstStride, srcStride, bpp, w, h int, o uint16) {
+	for y := 0; y < h; y++ {
+		row := src[y*srcStride:]
+		for x := 0; x < w; x++ {
+			dx, dy := orientPoint(x, y, w, h, o)
+			copy(dst[dy*dstStride+dx*bpp:dy*dstStride+dx*bpp+bpp], row[x*bpp:x*bpp+bpp])
+		}
+	}
+}
+
+// orientPoint returns where the pixel at (x, y) in a w by h image
+// ends up once the orientation o has been applied.
+func orientPoint(x, y, w, h int, o uint16) (int, int) {
+	switch o {
+	case 2: // Mirrored horizontally.
+		return w - 1 - x, y
+	case 3: // Rotated 180 degrees.
+		return w - 1 - x, h - 1 - y
+	case 4: // Mirrored vertically.
+		return x, h - 1 - y
+	case 5: // Mirrored about the top-left to bottom-right diagonal.
+		return y, x
+	case 6: // Rotated 90 degrees clockwise.
+		return h - 1 - y, x
+	case 7: // Mirrored about the top-right to bottom-left diagonal.
+		return h - 1 - y, w - 1 - x
+	case 8: // Rotated 90 degrees counter-clockwise.
+		return y, w - 1 - x
+	}
+	return x, y
+}
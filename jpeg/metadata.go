@@ -76,6 +76,26 @@ type Metadata struct {
 	// seen so far, so we can validate whether we've read everything or
 	// not.
 	iccSegmentsSeen int
+	// iptc holds the cached decoded IPTC-IIM data. This will be set
+	// when the image is read, if the metadata decode option was set to
+	// DecodeData, or on first access if the metadata decode option was
+	// set to DeferData.
+	//
+	// Note the IPTC data is saved in a Photoshop image resource in the
+	// APP13 segment.
+	iptc *metadata.IPTC
+	// iptcDecodeErr holds the cached IPTC decode error, if decoding
+	// failed.
+	iptcDecodeErr error
+	// rawIptc holds the undecoded IPTC-IIM data read from the image.
+	// Decoding the IPTC data will discard this cache.
+	rawIptc []byte
+
+	// PhotoshopResources holds the Photoshop image resource blocks
+	// from the APP13 segments, other than the IPTC-IIM data and its
+	// digest, which are written from the IPTC data. They're written
+	// back out as they are.
+	PhotoshopResources []PhotoshopResource
 
 	// Width holds the image width, in pixels
 	Width int
@@ -100,6 +120,9 @@ type Metadata struct {
 	// YThumbnail is the y dimension of the thumbnail image
 	YThumbnail uint8
 
+	// Comments holds the contents of any COM segments.
+	Comments []string
+
 	// appX holds all the unknown chunks of data in APPx segments.
 	appX map[uint8][][]byte
 }
@@ -125,6 +148,15 @@ func (v Version) String() string {
 	return fmt.Sprintf("%d.%02d", v>>8, v&0xff)
 }
 
+// Metadata implements the carrier interfaces for the kinds of
+// embedded metadata a jpeg file can hold.
+var (
+	_ metadata.EXIFCarrier = (*Metadata)(nil)
+	_ metadata.XMPCarrier  = (*Metadata)(nil)
+	_ metadata.ICCCarrier  = (*Metadata)(nil)
+	_ metadata.IPTCCarrier = (*Metadata)(nil)
+)
+
 func (m *Metadata) ImageMetadataFormat() string {
 	return "jpeg"
 }
@@ -152,7 +184,22 @@ func (m *Metadata) EXIF(ctx context.Context, opt ...image.ReadOption) (*metadata
 		return nil, m.exifDecodeErr
 	}
 	if m.rawExif != nil {
-		x, err := metadata.DecodeEXIF(ctx, m.rawExif, true, opt...)
+		// Some writers leave the jpeg "Exif\x00\x00" header in front
+		// of the TIFF data; the decoder skips it.
+		tiff := bytes.TrimPrefix(m.rawExif, []byte("Exif\x00\x00"))
+		if len(tiff) < 4 {
+			return nil, FormatError("exif data too short")
+		}
+		var isBigEndian bool
+		switch string(tiff[0:4]) {
+		case "II*\x00":
+			isBigEndian = false
+		case "MM\x00*":
+			isBigEndian = true
+		default:
+			return nil, fmt.Errorf("Invalid exif prefix %v", tiff[0:4])
+		}
+		x, err := metadata.DecodeEXIF(ctx, m.rawExif, isBigEndian, opt...)
 		if err != nil {
 			m.exifDecodeErr = err
 			return nil, err
@@ -204,6 +251,12 @@ func (m *Metadata) SetXMP(x *metadata.XMP) {
 	m.rawXmp = nil
 }
 
+// ICC returns the ICC color profile associated with the metadata
+// object. If there is no profile then it will return nil. The returned
+// profile will still be associated with its parent metadata object,
+// and changes to it will be persistent.
+//
+// Note that the profile may be decoded lazily.
 func (m *Metadata) ICC(ctx context.Context, opt ...image.ReadOption) (*metadata.ICC, error) {
 	if m.icc != nil {
 		return m.icc, nil
@@ -224,12 +277,125 @@ func (m *Metadata) ICC(ctx context.Context, opt ...image.ReadOption) (*metadata.
 	return nil, nil
 }
 
-func (m *Metadata) SetIcc(i *metadata.ICC) {
+// SetICC replaces the ICC color profile associated with the metadata
+// object.
+func (m *Metadata) SetICC(i *metadata.ICC) {
 	m.icc = i
 	m.iccDecodeErr = nil
 	m.rawIcc = nil
 }
 
+// SetIcc replaces the ICC color profile associated with the metadata
+// object.
+//
+// Deprecated: use SetICC.
+func (m *Metadata) SetIcc(i *metadata.ICC) {
+	m.SetICC(i)
+}
+
+// IPTC returns the IPTC-IIM data associated with the metadata object.
+// If there is no IPTC data then it will return nil. The returned
+// structure will still be associated with its parent metadata object,
+// and changes to it will be persistent.
+//
+// Note that the IPTC data may be decoded lazily.
+func (m *Metadata) IPTC(ctx context.Context, opt ...image.ReadOption) (*metadata.IPTC, error) {
+	if m.iptc != nil {
+		return m.iptc, nil
+	}
+	if m.iptcDecodeErr != nil {
+		return nil, m.iptcDecodeErr
+	}
+	if m.rawIptc != nil {
+		x, err := metadata.DecodeIPTC(ctx, m.rawIptc, opt...)
+		if err != nil {
+			m.iptcDecodeErr = err
+			return nil, err
+		}
+		m.iptc = x
+		m.rawIptc = nil
+		return x, nil
+	}
+	return nil, nil
+}
+
+// SetIPTC replaces the IPTC-IIM data associated with the metadata
+// object. The other Photoshop image resources are kept.
+func (m *Metadata) SetIPTC(x *metadata.IPTC) {
+	m.iptc = x
+	m.iptcDecodeErr = nil
+	m.rawIptc = nil
+}
+
+// RawEXIF returns the undecoded EXIF data read from the image, a TIFF
+// structure starting with its byte order mark. It returns nil if the
+// image had no EXIF data or the data has been decoded or replaced.
+func (m *Metadata) RawEXIF() []byte {
+	return m.rawExif
+}
+
+// SetRawEXIF replaces the EXIF information associated with the
+// metadata object with the undecoded data b, which is written out as
+// it is.
+func (m *Metadata) SetRawEXIF(b []byte) {
+	m.exif = nil
+	m.exifDecodeErr = nil
+	m.rawExif = b
+}
+
+// RawXMP returns the undecoded XMP packet read from the image. It
+// returns the empty string if the image had no XMP data or the data
+// has been decoded or replaced.
+func (m *Metadata) RawXMP() string {
+	if m.rawXmp == nil {
+		return ""
+	}
+	return *m.rawXmp
+}
+
+// SetRawXMP replaces the XMP information associated with the metadata
+// object with the undecoded packet x, which is written out as it is.
+// An empty packet removes the XMP information.
+func (m *Metadata) SetRawXMP(x string) {
+	m.xmp = nil
+	m.xmpDecodeErr = nil
+	m.rawXmp = nil
+	if x != "" {
+		m.rawXmp = &x
+	}
+}
+
+// RawICC returns the undecoded ICC profile read from the image. It
+// returns nil if the image had no profile or the profile has been
+// decoded or replaced.
+func (m *Metadata) RawICC() []byte {
+	return m.rawIcc
+}
+
+// RawIPTC returns the undecoded IPTC-IIM data read from the image. It
+// returns nil if the image had no IPTC data or the data has been
+// decoded or replaced.
+func (m *Metadata) RawIPTC() []byte {
+	return m.rawIptc
+}
+
+// SetRawIPTC replaces the IPTC-IIM data associated with the metadata
+// object with the undecoded data b, which is written out as it is.
+func (m *Metadata) SetRawIPTC(b []byte) {
+	m.iptc = nil
+	m.iptcDecodeErr = nil
+	m.rawIptc = b
+}
+
+// SetRawICC replaces the ICC color profile associated with the
+// metadata object with the undecoded profile b, which is written out
+// as it is.
+func (m *Metadata) SetRawICC(b []byte) {
+	m.icc = nil
+	m.iccDecodeErr = nil
+	m.rawIcc = b
+}
+
 func (d *decoder) processApp0(ctx context.Context, n int, opts ...image.ReadOption) error {
 	buf := make([]byte, n)
 	err := d.readFull(ctx, buf)
@@ -250,7 +416,7 @@ func (d *decoder) processApp0(ctx context.Context, n int, opts ...image.ReadOpti
 		if len(buf) <= 7 {
 			return nil
 		}
-		d.metadata.Units = Units(d.tmp[7])
+		d.metadata.Units = Units(buf[7])
 		if len(buf) <= 8 {
 			return nil
 		}
@@ -289,9 +455,31 @@ func (d *decoder) processApp1(ctx context.Context, n int, opts ...image.ReadOpti
 	}
 
 	off := bytes.IndexByte(buf, 0)
+	if off == -1 {
+		// No tag, so we can't tell what this is.
+		return d.saveAppN(ctx, app1Marker, buf, opts...)
+	}
 	tag := string(buf[:off])
 
 	switch tag {
+	case exifMetadata:
+		// The "Exif" tag is followed by two nulls, then the exif data
+		// itself as a TIFF file.
+		if len(buf) < off+2 {
+			return FormatError("short exif segment")
+		}
+		d.metadata.rawExif = buf[off+2:]
+	case xmpMetadata:
+		// The tag is followed by the XMP packet, which some writers
+		// terminate with a null. Only the first one counts; any others
+		// are kept as they are.
+		if d.metadata.rawXmp != nil {
+			return d.saveAppN(ctx, app1Marker, buf, opts...)
+		}
+		xmp := string(bytes.TrimRight(buf[off+1:], "\x00"))
+		d.metadata.rawXmp = &xmp
+	case extendedXmpMetadata:
+		return d.addExtendedXMP(buf[off+1:])
 	default:
 		// An app1 segment we don't understand, so just save it for later
 		d.saveAppN(ctx, app1Marker, buf, opts...)
@@ -348,6 +536,42 @@ func (d *decoder) processApp2(ctx context.Context, n int, opts ...image.ReadOpti
 	return nil
 }
 
+// processApp13 handles the APP13 block, which holds Photoshop image
+// resources. They may be split across several segments, so they're
+// collected and split into resource blocks once the image is read.
+func (d *decoder) processApp13(ctx context.Context, n int, opts ...image.ReadOption) error {
+	buf := make([]byte, n)
+	if err := d.readFull(ctx, buf); err != nil {
+		return err
+	}
+	off := bytes.IndexByte(buf, 0)
+	if off == -1 || string(buf[:off]) != photoshopMetadata {
+		// An app13 segment we don't understand, so just save it.
+		return d.saveAppN(ctx, app13Marker, buf, opts...)
+	}
+	d.photoshop = append(d.photoshop, buf[off+1:]...)
+	return nil
+}
+
+// processPhotoshop splits the Photoshop image resources collected from
+// the APP13 segments into the IPTC-IIM data and the other resources.
+// The resources read before any error are kept.
+func (d *decoder) processPhotoshop() error {
+	res, err := decodePhotoshopResources(d.photoshop)
+	for _, r := range res {
+		switch r.ID {
+		case iptcResource:
+			d.metadata.rawIptc = r.Data
+		case iptcDigestResource:
+			// The digest is worked out afresh when the IPTC data is
+			// written.
+		default:
+			d.metadata.PhotoshopResources = append(d.metadata.PhotoshopResources, r)
+		}
+	}
+	return err
+}
+
 func (d *decoder) processApp14(ctx context.Context, n int) error {
 	buf := make([]byte, n)
 	err := d.readFull(ctx, buf)
@@ -375,6 +599,27 @@ func (d *decoder) processApp14(ctx context.Context, n int) error {
 	return nil
 }
 
+// UnknownSegments returns the contents of the APPn segments read from
+// the image that weren't understood, keyed by marker. Each segment's
+// contents start after its length. They're written back out as they
+// are when the metadata is passed to EncodeExtended.
+func (m *Metadata) UnknownSegments() map[uint8][][]byte {
+	return m.appX
+}
+
+// processCOM reads a COM segment and saves its contents as a comment.
+func (d *decoder) processCOM(ctx context.Context, n int) error {
+	if err := d.countMetadata(n); err != nil {
+		return err
+	}
+	buf := make([]byte, n)
+	if err := d.readFull(ctx, buf); err != nil {
+		return err
+	}
+	d.metadata.Comments = append(d.metadata.Comments, string(buf))
+	return nil
+}
+
 func (d *decoder) saveAppN(ctx context.Context, n byte, buf []byte, opts ...image.ReadOption) error {
 	if d.metadata.appX == nil {
 		d.metadata.appX = make(map[byte][][]byte)
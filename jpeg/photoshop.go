@@ -0,0 +1,114 @@
+package jpeg
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// PhotoshopResource is a Photoshop image resource block, as held in
+// APP13 segments.
+type PhotoshopResource struct {
+	// ID identifies the kind of resource, such as 0x0404 for IPTC-IIM
+	// data.
+	ID uint16
+	// Name is usually empty.
+	Name string
+	Data []byte
+}
+
+// The image resource IDs with their own handling.
+const (
+	// iptcResource holds the IPTC-IIM data.
+	iptcResource = 0x0404
+	// iptcDigestResource holds the MD5 checksum of the IPTC-IIM data,
+	// which Photoshop uses to tell whether it was changed by software
+	// that doesn't update the matching XMP.
+	iptcDigestResource = 0x0425
+)
+
+// resourceSignature starts every image resource block.
+const resourceSignature = "8BIM"
+
+// decodePhotoshopResources splits b, the contents of the APP13
+// segments after their tags, into image resource blocks. The resources
+// read before any error are returned along with it.
+func decodePhotoshopResources(b []byte) ([]PhotoshopResource, error) {
+	var res []PhotoshopResource
+	for len(b) > 0 {
+		if len(b) < 7 || string(b[:4]) != resourceSignature {
+			// Some writers pad the data out with zeros.
+			if allZero(b) {
+				break
+			}
+			return res, FormatError("invalid photoshop resource block")
+		}
+		r := PhotoshopResource{ID: binary.BigEndian.Uint16(b[4:])}
+		// The name is a Pascal string, padded to an even size.
+		n := int(b[6])
+		size := (1 + n + 1) &^ 1
+		if len(b) < 6+size+4 {
+			return res, FormatError("short photoshop resource block")
+		}
+		r.Name = string(b[7 : 7+n])
+		b = b[6+size:]
+		l := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(l) > uint64(len(b)) {
+			return res, FormatError(fmt.Sprintf("photoshop resource %#04x is truncated", r.ID))
+		}
+		r.Data = b[:l:l]
+		// The data is padded to an even size too.
+		b = b[l:]
+		if l%2 == 1 && len(b) > 0 {
+			b = b[1:]
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// allZero reports whether b holds nothing but zeros.
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePhotoshopResources returns the image resource blocks for res.
+func encodePhotoshopResources(res []PhotoshopResource) []byte {
+	var b []byte
+	for _, r := range res {
+		name := r.Name
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		b = append(b, resourceSignature...)
+		b = append(b, byte(r.ID>>8), byte(r.ID), byte(len(name)))
+		b = append(b, name...)
+		if len(name)%2 == 0 {
+			b = append(b, 0)
+		}
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(r.Data)))
+		b = append(b, l[:]...)
+		b = append(b, r.Data...)
+		if len(r.Data)%2 == 1 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// iptcResources returns the image resource blocks holding the IPTC-IIM
+// data iptc and its digest.
+func iptcResources(iptc []byte) []PhotoshopResource {
+	sum := md5.Sum(iptc)
+	return []PhotoshopResource{
+		{ID: iptcResource, Data: iptc},
+		{ID: iptcDigestResource, Data: sum[:]},
+	}
+}
@@ -0,0 +1,125 @@
+package jpeg
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/rmamba/image"
+	_ "github.com/rmamba/image/metadata/iptc"
+)
+
+func TestPhotoshopResources(t *testing.T) {
+	f, err := ioutil.ReadFile("../testdata/kauaii_1.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	m, md, err := DecodeExtended(ctx, bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	jm := md.(*Metadata)
+	// The image only has IPTC data and its digest.
+	if jm.RawIPTC() == nil || len(jm.PhotoshopResources) != 0 {
+		t.Fatalf("got IPTC data %q and resources %v", jm.RawIPTC(), jm.PhotoshopResources)
+	}
+	x, err := jm.IPTC(ctx)
+	if err != nil {
+		t.Fatalf("IPTC: %v", err)
+	}
+
+	// Updating the IPTC data keeps the other resources, even ones too
+	// big for a single segment.
+	x.Headline = "Kauaʻi"
+	x.Keywords = []string{"Hawaii", "coast"}
+	big := make([]byte, 100001)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	jm.PhotoshopResources = []PhotoshopResource{
+		{ID: 0x03ed, Data: []byte{0, 0x48, 0, 0, 0, 1, 0, 1, 0, 0x48, 0, 0, 0, 1, 0, 1}},
+		{ID: 0x0bb7, Name: "big", Data: big},
+	}
+	var buf bytes.Buffer
+	if err := EncodeExtended(ctx, &buf, m, jm); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	_, md, err = DecodeExtended(ctx, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	got := md.(*Metadata)
+	if !reflect.DeepEqual(got.PhotoshopResources, jm.PhotoshopResources) {
+		t.Errorf("resources didn't survive a round trip")
+	}
+	raw := got.RawIPTC()
+	gx, err := got.IPTC(ctx)
+	if err != nil {
+		t.Fatalf("IPTC: %v", err)
+	}
+	if gx.Headline != x.Headline || !reflect.DeepEqual(gx.Keywords, x.Keywords) || !gx.DateCreated.Equal(*x.DateCreated) {
+		t.Errorf("got %+v, want %+v", gx, x)
+	}
+
+	// The digest matches the new IPTC data.
+	i := bytes.Index(buf.Bytes(), []byte("8BIM\x04\x25\x00\x00\x00\x00\x00\x10"))
+	if i < 0 {
+		t.Fatal("no IPTC digest")
+	}
+	if sum := md5.Sum(raw); !bytes.Equal(buf.Bytes()[i+12:i+28], sum[:]) {
+		t.Errorf("got digest %x, want %x", buf.Bytes()[i+12:i+28], sum)
+	}
+
+	// Removing the IPTC data removes the digest too.
+	jm.SetIPTC(nil)
+	jm.PhotoshopResources = jm.PhotoshopResources[:1]
+	buf.Reset()
+	if err := EncodeExtended(ctx, &buf, m, jm); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("8BIM\x04\x04")) || bytes.Contains(buf.Bytes(), []byte("8BIM\x04\x25")) {
+		t.Error("IPTC resources written without IPTC data")
+	}
+}
+
+func TestDecodePhotoshopResources(t *testing.T) {
+	res := []PhotoshopResource{
+		{ID: 0x0404, Data: []byte{0x1c, 2, 5, 0, 1, 'x'}},
+		{ID: 0x0408, Name: "odd", Data: []byte{1, 2, 3}},
+	}
+	b := encodePhotoshopResources(res)
+	got, err := decodePhotoshopResources(append(b, 0, 0))
+	if err != nil {
+		t.Fatalf("decodePhotoshopResources: %v", err)
+	}
+	if !reflect.DeepEqual(got, res) {
+		t.Errorf("got %+v, want %+v", got, res)
+	}
+
+	// The resources before the damage are returned with the error.
+	got, err = decodePhotoshopResources(b[:len(b)-2])
+	if err == nil || len(got) != 1 {
+		t.Errorf("truncated: got %+v, %v", got, err)
+	}
+
+	// Damaged resources are an error unless damaged data is skipped.
+	f, err := ioutil.ReadFile("../testdata/kauaii_1.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(f, []byte("8BIM\x04\x04"))
+	f[i+11] = 0xff
+	if _, _, err := DecodeExtended(context.Background(), bytes.NewReader(f)); err == nil {
+		t.Error("damaged resources: got nil error")
+	}
+	_, md, err := DecodeExtended(context.Background(), bytes.NewReader(f), image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Errorf("damaged resources, skipping damaged data: %v", err)
+	} else if raw := md.(*Metadata).RawIPTC(); raw != nil {
+		t.Errorf("damaged resources, skipping damaged data: got IPTC data %q", raw)
+	}
+}
@@ -25,6 +25,12 @@ type FormatError string
 
 func (e FormatError) Error() string { return "invalid JPEG format: " + string(e) }
 
+// ErrPartialImage is returned by DecodeExtended, along with the part of the
+// image that was decoded, when the image data ends before the End Of Image
+// marker and DamageHandlingOptions.SkipDamagedData is set. The parts of the
+// image that couldn't be decoded are neutral gray.
+var ErrPartialImage = FormatError("image data ends before EOI marker")
+
 // An UnsupportedError reports that the input uses a valid but unimplemented JPEG feature.
 type UnsupportedError string
 
@@ -168,6 +174,23 @@ type decoder struct {
 	tmp        [2 * blockSize]byte
 
 	metadata *Metadata
+	// photoshop collects the contents of the Photoshop APP13 segments.
+	photoshop []byte
+	// extendedXmp collects the chunks of extended XMP from the APP1
+	// segments, by GUID.
+	extendedXmp map[string]*extendedXMP
+
+	// settings holds the resolved read options for this decode.
+	settings image.ReadSettings
+	// metadataSize holds the number of bytes of metadata read so far.
+	metadataSize int64
+}
+
+// countMetadata adds n bytes to the amount of metadata read so far
+// and checks the total against the metadata size limit.
+func (d *decoder) countMetadata(n int) error {
+	d.metadataSize += int64(n)
+	return d.settings.Limits.CheckMetadataSize(d.metadataSize)
 }
 
 // fill fills up the d.bytes.buf buffer from the underlying io.Reader. It
@@ -450,7 +473,35 @@ func (d *decoder) processSOF(ctx context.Context, n int) error {
 		d.comp[i].h = h
 		d.comp[i].v = v
 	}
-	return nil
+	return d.settings.Limits.CheckImageSize(d.imageSize())
+}
+
+// imageSize returns the number of bytes the buffers for the decoded
+// image will take, based on the SOF segment. This mirrors the
+// allocations made by makeImg, plus the interleaved CMYK image that
+// 4-component images are converted to, and the coefficients kept for
+// each block of a progressive image.
+func (d *decoder) imageSize() int64 {
+	h0, v0 := int64(d.comp[0].h), int64(d.comp[0].v)
+	mxx := (int64(d.width) + 8*h0 - 1) / (8 * h0)
+	myy := (int64(d.height) + 8*v0 - 1) / (8 * v0)
+	var size int64
+	if d.progressive {
+		for i := 0; i < d.nComp; i++ {
+			size += blockSize * 4 * int64(d.comp[i].h) * int64(d.comp[i].v) * mxx * myy
+		}
+	}
+	if d.nComp == 1 {
+		return size + 64*mxx*myy
+	}
+	ySize := 64 * h0 * v0 * mxx * myy
+	cSize := 64 * int64(d.comp[1].h) * int64(d.comp[1].v) * mxx * myy
+	size += ySize + 2*cSize
+	if d.nComp == 4 {
+		size += 64 * int64(d.comp[3].h) * int64(d.comp[3].v) * mxx * myy
+		size += 4 * int64(d.width) * int64(d.height)
+	}
+	return size
 }
 
 // Specified in section B.2.4.1.
@@ -523,11 +574,52 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 		return nil, FormatError("missing SOI marker")
 	}
 
-	// Process the remaining segments until the End Of Image marker.
+	// Process the remaining segments until the End Of Image marker. A file
+	// that's cut off part way through still has the part of the image that
+	// was decoded, if we're skipping damaged data.
+	partial := false
+	if err := d.readSegments(ctx); err != nil {
+		if !d.settings.Damage.SkipDamagedData || (d.img1 == nil && d.img3 == nil) {
+			return nil, err
+		}
+		if err != ErrPartialImage && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		partial = true
+	}
+
+	if d.progressive {
+		if err := d.reconstructProgressiveImage(); err != nil {
+			return nil, err
+		}
+	}
+	var img image.Image
+	var err error
+	switch {
+	case d.img1 != nil:
+		img = d.img1
+	case d.img3 != nil && d.blackPix != nil:
+		img, err = d.applyBlack()
+	case d.img3 != nil && d.isRGB():
+		img, err = d.convertToRGB()
+	case d.img3 != nil:
+		img = d.img3
+	default:
+		return nil, FormatError("missing SOS marker")
+	}
+	if err == nil && partial {
+		err = ErrPartialImage
+	}
+	return img, err
+}
+
+// readSegments processes the segments that follow the Start Of Image marker,
+// up to and including the End Of Image marker.
+func (d *decoder) readSegments(ctx context.Context) error {
 	for {
 		err := d.readFull(ctx, d.tmp[:2])
 		if err != nil {
-			return nil, err
+			return err
 		}
 		for d.tmp[0] != 0xff {
 			// Strictly speaking, this is a format error. However, libjpeg is
@@ -553,7 +645,7 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 			d.tmp[0] = d.tmp[1]
 			d.tmp[1], err = d.readByte()
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		marker := d.tmp[1]
@@ -566,11 +658,11 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 			// number of fill bytes, which are bytes assigned code X'FF'".
 			marker, err = d.readByte()
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if marker == eoiMarker { // End Of Image.
-			break
+			return nil
 		}
 		if rst0Marker <= marker && marker <= rst7Marker {
 			// Figures B.2 and B.16 of the specification suggest that restart markers should
@@ -585,11 +677,19 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 		// Read the 16-bit length of the segment. The value includes the 2 bytes for the
 		// length itself, so we subtract 2 to get the number of remaining bytes.
 		if err = d.readFull(ctx, d.tmp[:2]); err != nil {
-			return nil, err
+			return err
 		}
 		n := int(d.tmp[0])<<8 + int(d.tmp[1]) - 2
 		if n < 0 {
-			return nil, FormatError("short segment length")
+			return FormatError("short segment length")
+		}
+
+		// APPn segments hold the image metadata, so they count against
+		// the metadata size limit.
+		if marker >= app0Marker && marker <= app15Marker {
+			if err := d.countMetadata(n); err != nil {
+				return err
+			}
 		}
 
 		switch marker {
@@ -598,7 +698,7 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 			d.progressive = marker == sof2Marker
 			err = d.processSOF(ctx, n)
 			//			if configOnly && d.jfif {
-			//			return nil, err
+			//			return err
 			//	}
 		case dhtMarker:
 			// if configOnly {
@@ -629,6 +729,8 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 			err = d.processApp1(ctx, n)
 		case app2Marker:
 			err = d.processApp2(ctx, n)
+		case app13Marker:
+			err = d.processApp13(ctx, n)
 		case app14Marker:
 			err = d.processApp14(ctx, n)
 		default:
@@ -636,7 +738,7 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 				// Got an APPx segment we dont understand, so just save it.
 				d.processUnknownApp(ctx, marker, n)
 			} else if marker == comMarker {
-				err = d.ignore(ctx, n)
+				err = d.processCOM(ctx, n)
 			} else if marker < 0xc0 { // See Table B.1 "Marker code assignments".
 				err = FormatError("unknown marker")
 			} else {
@@ -644,27 +746,9 @@ func (d *decoder) decode(ctx context.Context, r io.Reader, decodeImage, decodeMe
 			}
 		}
 		if err != nil {
-			return nil, err
-		}
-	}
-
-	if d.progressive {
-		if err := d.reconstructProgressiveImage(); err != nil {
-			return nil, err
-		}
-	}
-	if d.img1 != nil {
-		return d.img1, nil
-	}
-	if d.img3 != nil {
-		if d.blackPix != nil {
-			return d.applyBlack()
-		} else if d.isRGB() {
-			return d.convertToRGB()
+			return err
 		}
-		return d.img3, nil
 	}
-	return nil, FormatError("missing SOS marker")
 }
 
 // applyBlack combines d.img3 and d.blackPix into a CMYK image. The formula
@@ -770,17 +854,11 @@ func (d *decoder) convertToRGB() (image.Image, error) {
 }
 
 func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption) (image.Image, image.Metadata, error) {
-	if len(opts) > 1 {
-		return nil, nil, errors.New("Too many read options provided")
-	}
-	opt := image.DataDecodeOptions{}
-	if len(opts) == 1 {
-		o, ok := opts[0].(image.DataDecodeOptions)
-		if !ok {
-			return nil, nil, errors.New("Unknown read option type provided")
-		}
-		opt = o
+	s, err := image.ResolveReadOptions(opts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	opt := s.Data
 
 	// If they ask for nothing then return nothing. This is currently
 	// not an error.
@@ -792,13 +870,6 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		return nil, nil, errors.New("Image parsing may not be deferred")
 	}
 
-	if opt.DecodeImage == image.DefaultDecodeOption {
-		opt.DecodeImage = image.DecodeData
-	}
-	if opt.DecodeMetadata == image.DefaultDecodeOption {
-		opt.DecodeMetadata = image.DeferData
-	}
-
 	parseImage := false
 	parseMetadata := true
 	if opt.DecodeImage == image.DecodeData {
@@ -810,10 +881,24 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 
 	var d decoder
 	d.metadata = &Metadata{}
+	d.settings = *s
 
-	img, err := d.decode(ctx, r, parseImage, parseMetadata)
-	if err != nil {
-		return nil, nil, err
+	// A partial image is still returned, along with the error, so it goes
+	// through the same steps as a complete one.
+	img, partialErr := d.decode(ctx, r, parseImage, parseMetadata)
+	if partialErr != nil && partialErr != ErrPartialImage {
+		return nil, nil, partialErr
+	}
+
+	if d.photoshop != nil {
+		if err := d.processPhotoshop(); err != nil && !s.Damage.SkipDamagedData {
+			return nil, nil, err
+		}
+	}
+	if d.extendedXmp != nil {
+		if err := d.processExtendedXMP(); err != nil && !s.Damage.SkipDamagedData {
+			return nil, nil, err
+		}
 	}
 
 	d.metadata.Width = d.width
@@ -830,6 +915,19 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		d.metadata.ColorModel = color.CMYKModel
 	}
 
+	if s.Transform.ColorTransform != image.NoImageTransform {
+		img, err = d.convertColor(ctx, img, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if s.Transform.RotationTransform != image.NoImageTransform {
+		img, err = d.orient(ctx, img, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	if opt.DecodeMetadata == image.DecodeData {
 		_, err := d.metadata.EXIF(ctx, opts...)
 		if err != nil {
@@ -843,8 +941,12 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		if err != nil {
 			return nil, nil, err
 		}
+		_, err = d.metadata.IPTC(ctx, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	return img, d.metadata, nil
+	return img, d.metadata, partialErr
 }
 
 // Decode reads a jpeg image from r.
@@ -873,4 +975,5 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 
 func init() {
 	image.RegisterFormatExtended("jpeg", "\xff\xd8", DecodeExtended)
+	image.RegisterEncoder("jpeg", []string{"image/jpeg"}, []string{".jpg", ".jpeg", ".jpe", ".jfif"}, EncodeExtended)
 }
@@ -7,6 +7,8 @@ package jpeg
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,6 +20,8 @@ import (
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	_ "github.com/rmamba/image/metadata/exif"
+	_ "github.com/rmamba/image/metadata/icc"
 )
 
 // TestDecodeProgressive tests that decoding the baseline and progressive
@@ -343,6 +347,309 @@ func TestExtraneousData(t *testing.T) {
 	}
 }
 
+func TestImageSizeLimit(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b), image.LimitOptions{MaxImageSize: 1024})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b), image.LimitOptions{MaxImageSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("decode under the limit failed: %v", err)
+	}
+	// A progressive image keeps the coefficients of every block as well,
+	// so the same image needs more room.
+	b, err = ioutil.ReadFile("../testdata/video-001.progressive.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b), image.LimitOptions{MaxImageSize: 64 << 10})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("progressive: got error %v, want a limit error", err)
+	}
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b), image.LimitOptions{MaxImageSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("progressive: decode under the limit failed: %v", err)
+	}
+}
+
+func TestMetadataSizeLimit(t *testing.T) {
+	m := &Metadata{appX: map[uint8][][]byte{
+		app4Marker: {bytes.Repeat([]byte{'x'}, 4096)},
+	}}
+	var b bytes.Buffer
+	if err := EncodeExtended(context.TODO(), &b, image.NewGray(image.Rect(0, 0, 8, 8)), m); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), image.LimitOptions{MaxMetadataSize: 1024})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+}
+
+func TestRotationTransform(t *testing.T) {
+	exif := []byte{
+		'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 42, 0, 8, 0, 0, 0, // TIFF header
+		1, 0, // one IFD0 entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, 8, 0, 0, 0, // Orientation 8
+		0, 0, 0, 0, // no IFD1
+	}
+	m := &Metadata{appX: map[uint8][][]byte{app1Marker: {exif}}}
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	// Make the left half white.
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetGray(x, y, color.Gray{Y: 0xff})
+		}
+	}
+	var b bytes.Buffer
+	if err := EncodeExtended(context.TODO(), &b, src, m); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := image.ImageTransformOptions{RotationTransform: image.ForwardImageTransform}
+	img, md, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 8, 16); got != want {
+		t.Fatalf("got bounds %v, want %v", got, want)
+	}
+	if c := md.GetConfig(); c.Width != 8 || c.Height != 16 {
+		t.Fatalf("got config size %dx%d, want 8x16", c.Width, c.Height)
+	}
+	// Rotating counter-clockwise moves the white left half to the bottom.
+	if g := color.GrayModel.Convert(img.At(4, 12)).(color.Gray); g.Y < 0x80 {
+		t.Fatalf("got bottom pixel %v, want white", g)
+	}
+	if g := color.GrayModel.Convert(img.At(4, 4)).(color.Gray); g.Y >= 0x80 {
+		t.Fatalf("got top pixel %v, want black", g)
+	}
+
+	// The config should be rotated even if the image isn't decoded.
+	_, md, err = DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt, image.DataDecodeOptions{
+		DecodeImage:    image.DiscardData,
+		DecodeMetadata: image.DecodeData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := md.GetConfig(); c.Width != 8 || c.Height != 16 {
+		t.Fatalf("metadata only: got config size %dx%d, want 8x16", c.Width, c.Height)
+	}
+
+	// Exif data that can't be decoded fails the decode, unless damaged
+	// data is being skipped, when the image isn't rotated.
+	exif[6], exif[7] = 'N', 'N'
+	b.Reset()
+	if err := EncodeExtended(context.TODO(), &b, src, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt); err == nil {
+		t.Error("bad exif: got nil error")
+	}
+	img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("bad exif, skipping damaged data: %v", err)
+	}
+	if got, want := img.Bounds(), src.Bounds(); got != want {
+		t.Fatalf("bad exif, skipping damaged data: got bounds %v, want %v", got, want)
+	}
+}
+
+// testProfile returns an RGB ICC profile with sRGB primaries and
+// curves with a gamma of 1.0.
+func testProfile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], uint32(int32(v*65536)))
+		}
+		return b
+	}
+	curv := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00")
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"rXYZ", xyz(0.4360747, 0.2225045, 0.0139322)},
+		{"gXYZ", xyz(0.3850649, 0.7168786, 0.0971045)},
+		{"bXYZ", xyz(0.1430804, 0.0606169, 0.7141733)},
+		{"rTRC", curv}, {"gTRC", curv}, {"bTRC", curv},
+	}
+
+	b := make([]byte, 132+12*len(tags))
+	copy(b[16:], "RGB XYZ ")
+	copy(b[36:], "acsp")
+	binary.BigEndian.PutUint32(b[128:], uint32(len(tags)))
+	for i, t := range tags {
+		e := b[132+i*12:]
+		copy(e, t.sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(b)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(t.data)))
+		b = append(b, t.data...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// hasICC reports whether the metadata m still holds a color profile.
+func hasICC(m image.Metadata) bool {
+	x, err := m.(*Metadata).ICC(context.TODO())
+	return x != nil || err != nil
+}
+
+func TestColorTransform(t *testing.T) {
+	icc := append([]byte("ICC_PROFILE\x00\x01\x01"), testProfile()...)
+	m := &Metadata{appX: map[uint8][][]byte{app2Marker: {icc}}}
+	src := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range src.Pix {
+		src.Pix[i] = 0x80
+	}
+	var b bytes.Buffer
+	if err := EncodeExtended(context.TODO(), &b, src, m, &Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := image.ImageTransformOptions{ColorTransform: image.ForwardImageTransform}
+	img, md, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Linear 0x80 is 0xbc in sRGB.
+	if r, _, _, _ := img.At(8, 8).RGBA(); r>>8 < 0xba || r>>8 > 0xbe {
+		t.Fatalf("got red %#x, want 0xbc", r>>8)
+	}
+	if hasICC(md) {
+		t.Fatalf("profile kept after conversion to sRGB")
+	}
+
+	// An RGB profile doesn't apply to a gray image, so the image is
+	// left alone and the profile is kept.
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range gray.Pix {
+		gray.Pix[i] = 0x80
+	}
+	b.Reset()
+	if err := EncodeExtended(context.TODO(), &b, gray, m, &Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	img, md, err = DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(8, 8).RGBA(); r>>8 < 0x7f || r>>8 > 0x81 {
+		t.Errorf("gray: got %#x, want 0x80", r>>8)
+	}
+	if !hasICC(md) {
+		t.Error("gray: profile dropped without a conversion")
+	}
+	// A profile missing one of its segments can't be used. That fails
+	// the decode unless damaged data is being skipped, in which case
+	// the image is left alone.
+	icc = append([]byte("ICC_PROFILE\x00\x01\x02"), testProfile()...)
+	m = &Metadata{appX: map[uint8][][]byte{app2Marker: {icc}}}
+	b.Reset()
+	if err := EncodeExtended(context.TODO(), &b, src, m, &Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt); err == nil {
+		t.Error("missing segment: got nil error without SkipDamagedData")
+	}
+	img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), opt, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("missing segment: %v", err)
+	}
+	if r, _, _, _ := img.At(8, 8).RGBA(); r>>8 < 0x7f || r>>8 > 0x81 {
+		t.Errorf("missing segment: got red %#x, want 0x80", r>>8)
+	}
+}
+
+func TestSkipDamagedData(t *testing.T) {
+	ctx := context.TODO()
+	skip := image.DamageHandlingOptions{SkipDamagedData: true}
+	gray := color.YCbCr{Y: 0x80, Cb: 0x80, Cr: 0x80}
+
+	// This 640x480 4:4:4 image has a restart interval of 80 MCUs, which is
+	// one row of MCUs.
+	src, err := ioutil.ReadFile("../testdata/kauaii_1.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := DecodeExtended(ctx, bytes.NewReader(src), image.OptionDecodeImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rst []int
+	for i := bytes.LastIndex(src, []byte{0xff, sosMarker}); i < len(src)-1; i++ {
+		if src[i] == 0xff && src[i+1] >= rst0Marker && src[i+1] <= rst7Marker {
+			rst = append(rst, i)
+		}
+	}
+	if len(rst) != 59 {
+		t.Fatalf("found %d RST markers, want 59", len(rst))
+	}
+
+	// Cut out the second half of the restart interval after the eleventh
+	// RST marker. That interval covers rows 88 to 95, and decoding picks up
+	// again after it.
+	cut := rst[10] + (rst[11]-rst[10])/2
+	b := append(append([]byte{}, src[:cut]...), src[rst[11]:]...)
+	if _, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage); err == nil {
+		t.Error("damaged interval: got nil error without SkipDamagedData")
+	}
+	got, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage, skip)
+	if err != nil {
+		t.Fatalf("damaged interval: %v", err)
+	}
+	for _, p := range []image.Point{{X: 0, Y: 0}, {X: 639, Y: 87}, {X: 0, Y: 96}, {X: 639, Y: 479}} {
+		if got.At(p.X, p.Y) != want.At(p.X, p.Y) {
+			t.Errorf("damaged interval: at %v got %v, want %v", p, got.At(p.X, p.Y), want.At(p.X, p.Y))
+		}
+	}
+	if c := got.At(639, 95); c != gray {
+		t.Errorf("damaged interval: at (639, 95) got %v, want %v", c, gray)
+	}
+
+	// A truncated file gives back what could be decoded.
+	b = src[:rst[29]]
+	if _, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage); err == nil {
+		t.Error("truncated: got nil error without SkipDamagedData")
+	}
+	got, _, err = DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage, skip)
+	if err != ErrPartialImage {
+		t.Fatalf("truncated: got error %v, want %v", err, ErrPartialImage)
+	}
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("truncated: got bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	if got.At(0, 0) != want.At(0, 0) {
+		t.Errorf("truncated: at (0, 0) got %v, want %v", got.At(0, 0), want.At(0, 0))
+	}
+	if c := got.At(639, 479); c != gray {
+		t.Errorf("truncated: at (639, 479) got %v, want %v", c, gray)
+	}
+
+	// The same goes for a progressive image cut off between scans.
+	src, err = ioutil.ReadFile("../testdata/video-001.progressive.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = src[:bytes.LastIndex(src, []byte{0xff, sosMarker})]
+	got, _, err = DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage, skip)
+	if err != ErrPartialImage {
+		t.Fatalf("truncated progressive: got error %v, want %v", err, ErrPartialImage)
+	}
+	if got.Bounds().Empty() {
+		t.Errorf("truncated progressive: got empty image")
+	}
+}
+
 func benchmarkDecode(b *testing.B, filename string) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -6,6 +6,7 @@ package jpeg
 
 import (
 	"context"
+	"io"
 
 	"github.com/rmamba/image"
 )
@@ -15,6 +16,9 @@ func (d *decoder) makeImg(mxx, myy int) {
 	if d.nComp == 1 {
 		m := image.NewGray(image.Rect(0, 0, 8*mxx, 8*myy))
 		d.img1 = m.SubImage(image.Rect(0, 0, d.width, d.height)).(*image.Gray)
+		if d.settings.Damage.SkipDamagedData {
+			fillGray(m.Pix)
+		}
 		return
 	}
 
@@ -47,6 +51,21 @@ func (d *decoder) makeImg(mxx, myy int) {
 		d.blackPix = make([]byte, 8*h3*mxx*8*v3*myy)
 		d.blackStride = 8 * h3 * mxx
 	}
+	if d.settings.Damage.SkipDamagedData {
+		fillGray(m.Y)
+		fillGray(m.Cb)
+		fillGray(m.Cr)
+		fillGray(d.blackPix)
+	}
+}
+
+// fillGray sets every sample in pix to the neutral value 0x80. When damaged
+// data is being skipped the image starts out this way, so that any blocks we
+// can't decode come out gray.
+func fillGray(pix []byte) {
+	for i := range pix {
+		pix[i] = 0x80
+	}
 }
 
 // Specified in section B.2.3.
@@ -162,6 +181,10 @@ func (d *decoder) processSOS(ctx context.Context, n int) error {
 
 	d.bits = bits{}
 	mcu, expectedRST := 0, uint8(rst0Marker)
+	// resume is the MCU where decoding picks up again after damaged data has
+	// been skipped, and resynced is set if the RST marker in front of it has
+	// already been read. The MCUs in between are left as they are.
+	resume, resynced := 0, false
 	var (
 		// b is the decoded coefficients, in natural (not zig-zag) order.
 		b  block
@@ -215,6 +238,9 @@ func (d *decoder) processSOS(ctx context.Context, n int) error {
 							continue
 						}
 					}
+					if mcu < resume {
+						continue
+					}
 
 					// Load the previous partially decoded coefficients, if applicable.
 					if d.progressive {
@@ -223,69 +249,18 @@ func (d *decoder) processSOS(ctx context.Context, n int) error {
 						b = block{}
 					}
 
-					if ah != 0 {
-						if err := d.refine(&b, &d.huff[acTable][scan[i].ta], zigStart, zigEnd, 1<<al); err != nil {
+					if err := d.decodeBlock(&b, &dc[compIndex], scan[i].td, scan[i].ta, zigStart, zigEnd, ah, al); err != nil {
+						if !d.settings.Damage.SkipDamagedData {
 							return err
 						}
-					} else {
-						zig := zigStart
-						if zig == 0 {
-							zig++
-							// Decode the DC coefficient, as specified in section F.2.2.1.
-							value, err := d.decodeHuffman(&d.huff[dcTable][scan[i].td])
-							if err != nil {
-								return err
-							}
-							if value > 16 {
-								return UnsupportedError("excessive DC component")
-							}
-							dcDelta, err := d.receiveExtend(value)
-							if err != nil {
-								return err
-							}
-							dc[compIndex] += dcDelta
-							b[0] = dc[compIndex] << al
-						}
-
-						if zig <= zigEnd && d.eobRun > 0 {
-							d.eobRun--
-						} else {
-							// Decode the AC coefficients, as specified in section F.2.2.2.
-							huff := &d.huff[acTable][scan[i].ta]
-							for ; zig <= zigEnd; zig++ {
-								value, err := d.decodeHuffman(huff)
-								if err != nil {
-									return err
-								}
-								val0 := value >> 4
-								val1 := value & 0x0f
-								if val1 != 0 {
-									zig += int32(val0)
-									if zig > zigEnd {
-										break
-									}
-									ac, err := d.receiveExtend(val1)
-									if err != nil {
-										return err
-									}
-									b[unzig[zig]] = ac << al
-								} else {
-									if val0 != 0x0f {
-										d.eobRun = uint16(1 << val0)
-										if val0 != 0 {
-											bits, err := d.decodeBits(int32(val0))
-											if err != nil {
-												return err
-											}
-											d.eobRun |= uint16(bits)
-										}
-										d.eobRun--
-										break
-									}
-									zig += 0x0f
-								}
-							}
+						// Skip the damaged data. The blocks we don't
+						// decode keep their coefficients from any earlier
+						// scans, or stay neutral gray.
+						if resume, err = d.resync(ctx, mcu, mxx*myy); err != nil {
+							return err
 						}
+						resynced = true
+						continue
 					}
 
 					if d.progressive {
@@ -307,18 +282,34 @@ func (d *decoder) processSOS(ctx context.Context, n int) error {
 			} // for i
 			mcu++
 			if d.ri > 0 && mcu%d.ri == 0 && mcu < mxx*myy {
-				// A more sophisticated decoder could use RST[0-7] markers to resynchronize from corrupt input,
-				// but this one assumes well-formed input, and hence the restart marker follows immediately.
-				if err := d.readFull(ctx, d.tmp[:2]); err != nil {
-					return err
-				}
-				if d.tmp[0] != 0xff || d.tmp[1] != expectedRST {
-					return FormatError("bad RST marker")
+				if mcu < resume {
+					// We're still skipping damaged data.
+					continue
 				}
-				expectedRST++
-				if expectedRST == rst7Marker+1 {
-					expectedRST = rst0Marker
+				if resynced {
+					resynced = false
+				} else if d.settings.Damage.SkipDamagedData {
+					// Use the RST markers to resynchronize from corrupt
+					// input, skipping anything in front of the marker.
+					var err error
+					if resume, err = d.resync(ctx, mcu-1, mxx*myy); err != nil {
+						return err
+					}
+					if mcu < resume {
+						resynced = true
+						continue
+					}
+				} else {
+					// Without SkipDamagedData we assume well-formed input, and
+					// hence the restart marker follows immediately.
+					if err := d.readFull(ctx, d.tmp[:2]); err != nil {
+						return err
+					}
+					if d.tmp[0] != 0xff || d.tmp[1] != expectedRST {
+						return FormatError("bad RST marker")
+					}
 				}
+				expectedRST = rst0Marker + uint8(mcu/d.ri%8)
 				// Reset the Huffman decoder.
 				d.bits = bits{}
 				// Reset the DC components, as per section F.2.1.3.1.
@@ -332,6 +323,146 @@ func (d *decoder) processSOS(ctx context.Context, n int) error {
 	return nil
 }
 
+// resync skips over damaged entropy-coded data, for the SkipDamagedData
+// option, and returns the MCU where decoding can carry on. The damage was
+// found while decoding the given MCU, and nMCU is the number of MCUs in the
+// scan.
+//
+// Everything up to the next marker is discarded. If that's a RST marker
+// then decoding carries on after it, at the start of the restart interval
+// following the first one from mcu's onwards that the marker could end.
+// Any other marker ends the scan, and is left for the decode loop to read.
+func (d *decoder) resync(ctx context.Context, mcu, nMCU int) (int, error) {
+	// Back up to the start of any marker the Huffman decoder ran into.
+	d.bytes.i -= d.bytes.nUnreadable
+	d.bytes.nUnreadable = 0
+	d.bits = bits{}
+	for {
+		marker, err := d.nextMarker(ctx)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, ErrPartialImage
+			}
+			return 0, err
+		}
+		if marker < rst0Marker || marker > rst7Marker {
+			d.bytes.i -= 2
+			return nMCU, nil
+		}
+		// RST markers are meaningless without a restart interval, so in
+		// that case keep looking.
+		if d.ri > 0 {
+			interval := mcu / d.ri
+			interval += (int(marker-rst0Marker) - interval%8 + 8) % 8
+			if resume := (interval + 1) * d.ri; resume < nMCU {
+				return resume, nil
+			}
+			return nMCU, nil
+		}
+	}
+}
+
+// nextMarker discards bytes up to and including the next marker, and returns
+// the marker. Byte-stuffed 0xff bytes and fill bytes are skipped.
+func (d *decoder) nextMarker(ctx context.Context) (byte, error) {
+	for {
+		if d.bytes.i == d.bytes.j {
+			// Check and see if our context was cancelled or expired.
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			default:
+			}
+		}
+		x, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if x != 0xff {
+			continue
+		}
+		for x == 0xff {
+			if x, err = d.readByte(); err != nil {
+				return 0, err
+			}
+		}
+		if x != 0x00 {
+			return x, nil
+		}
+	}
+}
+
+// decodeBlock decodes the coefficients of a single block in a scan into b,
+// updating the DC predictor dc. The Huffman table selectors td and ta, the
+// spectral selection bounds and the successive approximation values have
+// the same meaning as in the processSOS method.
+func (d *decoder) decodeBlock(b *block, dc *int32, td, ta uint8, zigStart, zigEnd int32, ah, al uint32) error {
+	if ah != 0 {
+		return d.refine(b, &d.huff[acTable][ta], zigStart, zigEnd, 1<<al)
+	}
+
+	zig := zigStart
+	if zig == 0 {
+		zig++
+		// Decode the DC coefficient, as specified in section F.2.2.1.
+		value, err := d.decodeHuffman(&d.huff[dcTable][td])
+		if err != nil {
+			return err
+		}
+		if value > 16 {
+			return UnsupportedError("excessive DC component")
+		}
+		dcDelta, err := d.receiveExtend(value)
+		if err != nil {
+			return err
+		}
+		*dc += dcDelta
+		b[0] = *dc << al
+	}
+
+	if zig <= zigEnd && d.eobRun > 0 {
+		d.eobRun--
+	} else {
+		// Decode the AC coefficients, as specified in section F.2.2.2.
+		huff := &d.huff[acTable][ta]
+		for ; zig <= zigEnd; zig++ {
+			value, err := d.decodeHuffman(huff)
+			if err != nil {
+				return err
+			}
+			val0 := value >> 4
+			val1 := value & 0x0f
+			if val1 != 0 {
+				zig += int32(val0)
+				if zig > zigEnd {
+					break
+				}
+				ac, err := d.receiveExtend(val1)
+				if err != nil {
+					return err
+				}
+				b[unzig[zig]] = ac << al
+			} else {
+				if val0 != 0x0f {
+					d.eobRun = uint16(1 << val0)
+					if val0 != 0 {
+						bits, err := d.decodeBits(int32(val0))
+						if err != nil {
+							return err
+						}
+						d.eobRun |= uint16(bits)
+					}
+					d.eobRun--
+					break
+				}
+				zig += 0x0f
+			}
+		}
+	}
+
+	return nil
+}
+
 // refine decodes a successive approximation refinement block, as specified in
 // section G.1.2.
 func (d *decoder) refine(b *block, h *huffman, zigStart, zigEnd, delta int32) error {
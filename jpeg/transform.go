@@ -0,0 +1,95 @@
+package jpeg
+
+import (
+	"context"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/cms"
+	"github.com/rmamba/image/internal/imageutil"
+	"github.com/rmamba/image/metadata"
+)
+
+// orient applies the exif orientation to the decoded image and to the
+// dimensions in the metadata, as requested by the RotationTransform
+// read option. Images without exif data, or without an orientation,
+// are returned unchanged, as are images whose exif data can't be
+// decoded if damaged data is being skipped.
+func (d *decoder) orient(ctx context.Context, img image.Image, opts ...image.ReadOption) (image.Image, error) {
+	x, err := d.metadata.EXIF(ctx, opts...)
+	if err != nil {
+		if d.settings.Damage.SkipDamagedData && ctx.Err() == nil {
+			return img, nil
+		}
+		return nil, err
+	}
+	if x == nil {
+		return img, nil
+	}
+
+	o := x.Orientation
+	if d.settings.Transform.RotationTransform == image.ForwardImageTransform && o >= 2 && o <= 8 {
+		// The returned image is upright, so the exif data should say so.
+		x.Orientation = 1
+	}
+	d.metadata.Width, d.metadata.Height = imageutil.OrientedSize(d.metadata.Width, d.metadata.Height, o)
+	if img == nil {
+		return nil, nil
+	}
+	img = imageutil.Orient(img, o, d.settings.Transform.RotationTransform == image.ReverseImageTransform)
+	d.metadata.ColorModel = img.ColorModel()
+	return img, nil
+}
+
+// convertColor converts the decoded image from the color space of the
+// embedded ICC profile to sRGB, or from sRGB to the profile's color
+// space, as requested by the ColorTransform read option. Images
+// without a profile, with a profile the cms package can't convert
+// with, or with pixels the profile doesn't apply to, such as gray
+// pixels with an RGB profile, are returned unchanged, as are images
+// with a damaged profile if damaged data is being skipped.
+//
+// Once an image has been converted to sRGB the profile no longer
+// describes it, so the profile is removed from the metadata.
+func (d *decoder) convertColor(ctx context.Context, img image.Image, opts ...image.ReadOption) (image.Image, error) {
+	if d.metadata.rawIcc == nil {
+		return img, nil
+	}
+	if d.metadata.iccSegmentsSeen != int(d.metadata.iccSegmentCount) {
+		if d.settings.Damage.SkipDamagedData {
+			return img, nil
+		}
+		return nil, FormatError("missing icc segments")
+	}
+	x, err := d.metadata.ICC(ctx, opts...)
+	if err != nil {
+		if d.settings.Damage.SkipDamagedData && ctx.Err() == nil {
+			return img, nil
+		}
+		return nil, err
+	}
+
+	srgb, err := cms.NewProfile(0, nil, x.ColorSpace == metadata.ICCSpaceGray)
+	if err != nil {
+		return nil, err
+	}
+	forward := d.settings.Transform.ColorTransform == image.ForwardImageTransform
+	src, dst := srgb, x
+	if forward {
+		src, dst = x, srgb
+	}
+	t, err := cms.NewTransform(src, dst, cms.Perceptual)
+	if err != nil || !t.Converts(d.metadata.ColorModel) {
+		return img, nil
+	}
+	if forward {
+		d.metadata.SetICC(nil)
+		d.metadata.iccSegmentCount = 0
+		d.metadata.iccSegmentsSeen = 0
+	}
+	if img == nil {
+		return nil, nil
+	}
+	img = t.Convert(img)
+	d.metadata.ColorModel = img.ColorModel()
+	return img, nil
+}
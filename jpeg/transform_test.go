@@ -0,0 +1,55 @@
+package jpeg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
+)
+
+func TestOrient(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	// Make the left half white.
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetGray(x, y, color.Gray{Y: 0xff})
+		}
+	}
+	d := &decoder{metadata: &Metadata{Width: 16, Height: 8}}
+	d.metadata.SetEXIF(&metadata.EXIF{Orientation: 8})
+	d.settings.Transform.RotationTransform = image.ForwardImageTransform
+	img, err := d.orient(context.TODO(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 8, 16); got != want {
+		t.Fatalf("got bounds %v, want %v", got, want)
+	}
+	if c := d.metadata.GetConfig(); c.Width != 8 || c.Height != 16 {
+		t.Fatalf("got config size %dx%d, want 8x16", c.Width, c.Height)
+	}
+	// Rotating counter-clockwise moves the white left half to the bottom.
+	if g := color.GrayModel.Convert(img.At(4, 12)).(color.Gray); g.Y != 0xff {
+		t.Fatalf("got bottom pixel %v, want white", g)
+	}
+	if g := color.GrayModel.Convert(img.At(4, 4)).(color.Gray); g.Y != 0 {
+		t.Fatalf("got top pixel %v, want black", g)
+	}
+	// The returned image is upright, so the exif data should say so.
+	if x, _ := d.metadata.EXIF(context.TODO()); x.Orientation != 1 {
+		t.Errorf("got orientation %d, want 1", x.Orientation)
+	}
+
+	// The config is rotated even if the image isn't decoded.
+	d = &decoder{metadata: &Metadata{Width: 16, Height: 8}}
+	d.metadata.SetEXIF(&metadata.EXIF{Orientation: 8})
+	d.settings.Transform.RotationTransform = image.ForwardImageTransform
+	if _, err := d.orient(context.TODO(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := d.metadata.GetConfig(); c.Width != 8 || c.Height != 16 {
+		t.Fatalf("metadata only: got config size %dx%d, want 8x16", c.Width, c.Height)
+	}
+}
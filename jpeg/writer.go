@@ -7,6 +7,7 @@ package jpeg
 import (
 	"bufio"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
 )
 
 // min returns the minimum of two integers.
@@ -580,7 +582,8 @@ func (e *encoder) writeUnknownApp(ctx context.Context, m *Metadata) {
 		}
 
 		for _, i := range v {
-			e.writeMarkerHeader(k, len(i))
+			// The segment length includes the two length bytes.
+			e.writeMarkerHeader(k, len(i)+2)
 			if e.err != nil {
 				return
 			}
@@ -592,6 +595,192 @@ func (e *encoder) writeUnknownApp(ctx context.Context, m *Metadata) {
 	}
 }
 
+// writeJFIF writes out the JFIF version and pixel density as an APP0
+// segment, if the metadata has any. Thumbnails aren't written.
+func (e *encoder) writeJFIF(m *Metadata) {
+	if e.err != nil || (m.Version == 0 && m.Units == 0 && m.XDensity == 0 && m.YDensity == 0) {
+		return
+	}
+	version, xDensity, yDensity := m.Version, m.XDensity, m.YDensity
+	if version == 0 {
+		version = 0x0102
+	}
+	// A density of zero isn't allowed, so fall back to a 1:1 aspect
+	// ratio.
+	if xDensity == 0 || yDensity == 0 {
+		xDensity, yDensity = 1, 1
+	}
+	e.writeMarkerHeader(app0Marker, 16)
+	e.write([]byte(jfifMetadata + "\x00"))
+	e.buf[0] = uint8(version >> 8)
+	e.buf[1] = uint8(version)
+	e.buf[2] = uint8(m.Units)
+	e.buf[3] = uint8(xDensity >> 8)
+	e.buf[4] = uint8(xDensity)
+	e.buf[5] = uint8(yDensity >> 8)
+	e.buf[6] = uint8(yDensity)
+	e.buf[7] = 0 // Thumbnail width.
+	e.buf[8] = 0 // Thumbnail height.
+	e.write(e.buf[:9])
+}
+
+// writeEXIF writes out the exif data, if we have any, as an APP1
+// segment. Decoded exif data is encoded first.
+func (e *encoder) writeEXIF(ctx context.Context, m *Metadata, opts ...image.WriteOption) {
+	if e.err != nil || (m.rawExif == nil && m.exif == nil) {
+		return
+	}
+	exif := m.rawExif
+	if m.exif != nil {
+		exif, e.err = m.exif.Encode(ctx, !m.exif.LittleEndian, opts...)
+		if e.err != nil {
+			return
+		}
+	}
+	if len(exif)+len(exifMetadata)+2 > maxSegmentSize {
+		e.err = fmt.Errorf("exif data is %v bytes, larger than %v maximum", len(exif), maxSegmentSize-len(exifMetadata)-2)
+		return
+	}
+	e.writeMarkerHeader(app1Marker, len(exif)+len(exifMetadata)+4)
+	e.write([]byte(exifMetadata + "\x00\x00"))
+	e.write(exif)
+}
+
+// writeXMP writes out the xmp packet, if we have one, as an APP1
+// segment. Decoded xmp data is encoded first. A packet too big for a
+// single segment is written as extended XMP, in as many segments as
+// it needs, after a main packet that names it.
+func (e *encoder) writeXMP(ctx context.Context, m *Metadata, opts ...image.WriteOption) {
+	if e.err != nil || (m.rawXmp == nil && m.xmp == nil) {
+		return
+	}
+	var xmp string
+	if m.rawXmp != nil {
+		xmp = *m.rawXmp
+	}
+	if m.xmp != nil {
+		xmp, e.err = m.xmp.Encode(ctx, opts...)
+		if e.err != nil {
+			return
+		}
+	}
+	var (
+		guid string
+		ext  []byte
+	)
+	if len(xmp)+len(xmpMetadata)+1 > maxSegmentSize {
+		xmp, guid, ext = splitXMP(xmp)
+	}
+	e.writeMarkerHeader(app1Marker, len(xmp)+len(xmpMetadata)+3)
+	e.write([]byte(xmpMetadata + "\x00"))
+	e.write([]byte(xmp))
+
+	// Each extended XMP segment starts with the GUID from the main
+	// packet, the full length and the offset of its chunk.
+	const header = len(extendedXmpMetadata) + 1 + extendedXMPHeader
+	for off := 0; off < len(ext); off += maxSegmentSize - header {
+		chunk := ext[off:]
+		if len(chunk) > maxSegmentSize-header {
+			chunk = chunk[:maxSegmentSize-header]
+		}
+		var h [8]byte
+		binary.BigEndian.PutUint32(h[:], uint32(len(ext)))
+		binary.BigEndian.PutUint32(h[4:], uint32(off))
+		e.writeMarkerHeader(app1Marker, len(chunk)+header+2)
+		e.write([]byte(extendedXmpMetadata + "\x00" + guid))
+		e.write(h[:])
+		e.write(chunk)
+	}
+}
+
+// writeICC writes out the icc profile, if we have one, as a series of
+// APP2 segments. Decoded profiles are encoded first.
+func (e *encoder) writeICC(ctx context.Context, m *Metadata, opts ...image.WriteOption) {
+	if e.err != nil || (m.rawIcc == nil && m.icc == nil) {
+		return
+	}
+	icc := m.rawIcc
+	if m.icc != nil {
+		icc, e.err = m.icc.Encode(ctx, opts...)
+		if e.err != nil {
+			return
+		}
+	}
+	// Each segment holds the tag, a null, the 1-based segment index
+	// and the segment count ahead of its share of the profile.
+	const header = len(iccMetadata) + 3
+	const chunkSize = maxSegmentSize - header
+	count := (len(icc) + chunkSize - 1) / chunkSize
+	if count > 255 {
+		e.err = fmt.Errorf("icc profile is %v bytes, larger than %v maximum", len(icc), 255*chunkSize)
+		return
+	}
+	for i := 0; i < count; i++ {
+		chunk := icc[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		e.writeMarkerHeader(app2Marker, len(chunk)+header+2)
+		e.write([]byte(iccMetadata + "\x00"))
+		e.buf[0] = uint8(i + 1)
+		e.buf[1] = uint8(count)
+		e.write(e.buf[:2])
+		e.write(chunk)
+	}
+}
+
+// writePhotoshop writes out the Photoshop image resources, along with
+// the IPTC-IIM data and its digest if we have any, as a series of APP13
+// segments. Decoded IPTC data is encoded first.
+func (e *encoder) writePhotoshop(ctx context.Context, m *Metadata, opts ...image.WriteOption) {
+	if e.err != nil || (m.rawIptc == nil && m.iptc == nil && len(m.PhotoshopResources) == 0) {
+		return
+	}
+	var res []PhotoshopResource
+	iptc := m.rawIptc
+	if m.iptc != nil {
+		iptc, e.err = m.iptc.Encode(ctx, opts...)
+		if e.err != nil {
+			return
+		}
+	}
+	if iptc != nil {
+		res = iptcResources(iptc)
+	}
+	res = append(res, m.PhotoshopResources...)
+	data := encodePhotoshopResources(res)
+	// Each segment holds the tag and a null ahead of its share of the
+	// resources.
+	const header = len(photoshopMetadata) + 1
+	const chunkSize = maxSegmentSize - header
+	for len(data) > 0 && e.err == nil {
+		chunk := data
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		e.writeMarkerHeader(app13Marker, len(chunk)+header+2)
+		e.write([]byte(photoshopMetadata + "\x00"))
+		e.write(chunk)
+		data = data[len(chunk):]
+	}
+}
+
+// writeComments writes out each of the comments as a COM segment,
+// splitting any that are too long for a single segment.
+func (e *encoder) writeComments(m *Metadata) {
+	for _, c := range m.Comments {
+		for len(c) > 0 && e.err == nil {
+			n := len(c)
+			if n > maxSegmentSize {
+				n = maxSegmentSize
+			}
+			e.writeMarkerHeader(comMarker, n+2)
+			e.write([]byte(c[:n]))
+			c = c[n:]
+		}
+	}
+}
+
 // DefaultQuality is the default quality encoding parameter.
 const DefaultQuality = 75
 
@@ -612,7 +801,7 @@ func Encode(w io.Writer, m image.Image, o *Options) error {
 
 // EncodeExtended writes the image m to w in JPEG 4:2:0 baseline format with the given options. Default parameters are used in no options are passed.
 func EncodeExtended(ctx context.Context, w io.Writer, m image.Image, opts ...image.WriteOption) error {
-	var metadata *Metadata
+	var md *Metadata
 	var o *Options
 
 	for _, opt := range opts {
@@ -624,13 +813,17 @@ func EncodeExtended(ctx context.Context, w io.Writer, m image.Image, opts ...ima
 			}
 			o = do
 		case *Metadata:
-			if metadata != nil {
-				return fmt.Errorf("Multiple metadata specified")
+			if md != nil {
+				return fmt.Errorf("Multiple md specified")
 			}
-			metadata = do
-			if err := metadata.validate(); err != nil {
+			md = do
+			if err := md.validate(); err != nil {
 				return err
 			}
+		case metadata.XMPOptions:
+			// These are passed on when the XMP is encoded.
+		case image.LimitOptions:
+			// Limits only apply when decoding.
 		default:
 			log.Printf("Unknown write type %T passed", opt)
 		}
@@ -687,8 +880,16 @@ func EncodeExtended(ctx context.Context, w io.Writer, m image.Image, opts ...ima
 	e.buf[0] = 0xff
 	e.buf[1] = 0xd8
 	e.write(e.buf[:2])
-	if metadata != nil && metadata.appX != nil {
-		e.writeUnknownApp(ctx, metadata)
+	if md != nil {
+		e.writeJFIF(md)
+		e.writeEXIF(ctx, md, opts...)
+		e.writeXMP(ctx, md, opts...)
+		e.writeICC(ctx, md, opts...)
+		e.writePhotoshop(ctx, md, opts...)
+		e.writeComments(md)
+	}
+	if md != nil && md.appX != nil {
+		e.writeUnknownApp(ctx, md)
 	}
 	// Write the quantization tables.
 	e.writeDQT()
@@ -15,6 +15,7 @@ import (
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
 	"github.com/rmamba/image/png"
 )
 
@@ -248,6 +249,51 @@ func TestEncodeYCbCr(t *testing.T) {
 	}
 }
 
+func TestEXIFByteOrder(t *testing.T) {
+	ctx := context.Background()
+	src := &metadata.EXIF{Artist: "someone"}
+	for _, bigEndian := range []bool{true, false} {
+		raw, err := src.Encode(ctx, bigEndian)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		// Decoded EXIF data is written in the byte order it was read
+		// in.
+		m := &Metadata{}
+		m.SetRawEXIF(raw)
+		if _, err := m.EXIF(ctx); err != nil {
+			t.Fatalf("EXIF: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := EncodeExtended(ctx, &buf, image.NewGray(image.Rect(0, 0, 8, 8)), m); err != nil {
+			t.Fatalf("EncodeExtended: %v", err)
+		}
+		_, md, err := DecodeExtended(ctx, &buf)
+		if err != nil {
+			t.Fatalf("DecodeExtended: %v", err)
+		}
+		if got := md.(*Metadata).RawEXIF(); len(got) < 4 || string(got[:4]) != string(raw[:4]) {
+			t.Errorf("big endian %v: got EXIF data starting %q, want %q", bigEndian, got, raw[:4])
+		}
+	}
+}
+
+func TestWriteUnknownApp(t *testing.T) {
+	want := []byte("unknown segment")
+	m := &Metadata{appX: map[uint8][][]byte{app11Marker: {want}}}
+	var buf bytes.Buffer
+	if err := EncodeExtended(context.TODO(), &buf, image.NewGray(image.Rect(0, 0, 8, 8)), m); err != nil {
+		t.Fatal(err)
+	}
+	_, md, err := DecodeExtended(context.TODO(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md.(*Metadata).appX[app11Marker]; len(got) != 1 || !bytes.Equal(got[0], want) {
+		t.Errorf("got APP11 segments %q, want [%q]", got, want)
+	}
+}
+
 func BenchmarkEncodeRGBA(b *testing.B) {
 	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
 	bo := img.Bounds()
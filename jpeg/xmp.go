@@ -0,0 +1,317 @@
+package jpeg
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Extended XMP holds the parts of an XMP packet too big for a single
+// APP1 segment. The main packet names it with its xmpNote:HasExtendedXMP
+// property, which holds the GUID that heads each of its segments: the
+// MD5 checksum of the whole extended packet, in upper case hex.
+const (
+	nsRDF     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXMPNote = "http://ns.adobe.com/xmp/note/"
+
+	// guidSize is the length of the GUID.
+	guidSize = 32
+	// extendedXMPHeader is the size of the GUID, full length and
+	// offset that start the data of each extended XMP segment.
+	extendedXMPHeader = guidSize + 4 + 4
+)
+
+// mainXMPPacket is the main packet written in place of an XMP packet
+// too big for a single segment. The whole packet goes in the extended
+// XMP, with its GUID filled in here.
+const mainXMPPacket = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>" +
+	"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"><rdf:RDF xmlns:rdf=\"" + nsRDF + "\">" +
+	"<rdf:Description rdf:about=\"\" xmlns:xmpNote=\"" + nsXMPNote + "\" xmpNote:HasExtendedXMP=\"%s\"/>" +
+	"</rdf:RDF></x:xmpmeta><?xpacket end=\"w\"?>"
+
+// hasExtendedXMP matches the xmpNote:HasExtendedXMP property, either as
+// an attribute or as an element, and its GUID.
+var hasExtendedXMP = regexp.MustCompile(`\s+[\w.-]+:HasExtendedXMP\s*=\s*["']([0-9A-Fa-f]{32})["']` +
+	`|\s*<(?:[\w.-]+:)?HasExtendedXMP>\s*([0-9A-Fa-f]{32})\s*</(?:[\w.-]+:)?HasExtendedXMP>`)
+
+// xmpChunk is the part of an extended XMP packet held in one segment.
+type xmpChunk struct {
+	offset uint32
+	data   []byte
+}
+
+// extendedXMP collects the chunks of an extended XMP packet.
+type extendedXMP struct {
+	// length is the size of the whole packet.
+	length uint32
+	// received is the number of bytes in the chunks seen so far.
+	received uint64
+	chunks   []xmpChunk
+}
+
+// addExtendedXMP saves the extended XMP chunk in b, the contents of an
+// APP1 segment after its tag. The chunks are put together once the
+// image is read, as they may come in any order.
+func (d *decoder) addExtendedXMP(b []byte) error {
+	if len(b) < extendedXMPHeader {
+		return FormatError("short extended xmp segment")
+	}
+	guid := strings.ToUpper(string(b[:guidSize]))
+	length := binary.BigEndian.Uint32(b[guidSize:])
+	c := xmpChunk{offset: binary.BigEndian.Uint32(b[guidSize+4:]), data: b[extendedXMPHeader:]}
+	if d.extendedXmp == nil {
+		d.extendedXmp = make(map[string]*extendedXMP)
+	}
+	x := d.extendedXmp[guid]
+	if x == nil {
+		// The whole packet is put together in memory, so its length
+		// counts against the metadata limit before anything is
+		// allocated for it.
+		if err := d.settings.Limits.CheckMetadataSize(d.metadataSize + int64(length)); err != nil {
+			return err
+		}
+		x = &extendedXMP{length: length}
+		d.extendedXmp[guid] = x
+	}
+	if x.length != length {
+		return FormatError("inconsistent extended xmp lengths")
+	}
+	x.received += uint64(len(c.data))
+	if x.received > uint64(x.length) {
+		return FormatError("extended xmp is longer than its length")
+	}
+	x.chunks = append(x.chunks, c)
+	return nil
+}
+
+// processExtendedXMP merges the extended XMP named by the main packet
+// into it. Extended XMP that the main packet doesn't name is ignored,
+// as the XMP specification requires, and the main packet is kept on
+// its own if the extended XMP it names is missing. The main packet is
+// left as it is if there's an error.
+func (d *decoder) processExtendedXMP() error {
+	if d.metadata.rawXmp == nil {
+		return nil
+	}
+	main := *d.metadata.rawXmp
+	loc := hasExtendedXMP.FindStringSubmatchIndex(main)
+	if loc == nil {
+		return nil
+	}
+	var guid string
+	if loc[2] >= 0 {
+		guid = strings.ToUpper(main[loc[2]:loc[3]])
+	} else {
+		guid = strings.ToUpper(main[loc[4]:loc[5]])
+	}
+	x := d.extendedXmp[guid]
+	if x == nil {
+		return nil
+	}
+	b, err := x.assemble()
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(b)
+	if strings.ToUpper(hex.EncodeToString(sum[:])) != guid {
+		return FormatError("extended xmp checksum mismatch")
+	}
+	// The property only makes sense in the file; the merged packet
+	// stands on its own.
+	merged, err := mergeXMP(main[:loc[0]]+main[loc[1]:], string(b))
+	if err != nil {
+		return err
+	}
+	d.metadata.rawXmp = &merged
+	return nil
+}
+
+// assemble puts the chunks of x together, checking that they cover the
+// whole packet.
+func (x *extendedXMP) assemble() ([]byte, error) {
+	// The length comes from the file, so it's only trusted once the
+	// chunks are known to add up to it.
+	var received uint64
+	for _, c := range x.chunks {
+		received += uint64(len(c.data))
+	}
+	if received < uint64(x.length) {
+		return nil, FormatError("extended xmp is truncated")
+	}
+	if received > uint64(x.length) {
+		return nil, FormatError("extended xmp is longer than its length")
+	}
+	sort.SliceStable(x.chunks, func(i, j int) bool {
+		return x.chunks[i].offset < x.chunks[j].offset
+	})
+	b := make([]byte, 0, x.length)
+	for _, c := range x.chunks {
+		if c.offset != uint32(len(b)) {
+			return nil, FormatError("extended xmp chunks overlap or leave gaps")
+		}
+		b = append(b, c.data...)
+	}
+	return b, nil
+}
+
+// rdfElement describes the rdf:RDF element of an XML document.
+type rdfElement struct {
+	// start and end are the offsets of the end of its start tag and
+	// the start of its end tag.
+	start, end int
+	// ns holds the namespaces declared on it and the elements around
+	// it, by prefix, with the empty prefix for the default namespace.
+	ns map[string]string
+	// children holds the offsets of the ends of the names in the start
+	// tags of its child elements.
+	children []int
+	// declared holds the prefixes each child declares itself.
+	declared []map[string]bool
+}
+
+// findRDFElement finds the rdf:RDF element in the XML document s.
+func findRDFElement(s string) (*rdfElement, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	var (
+		r     *rdfElement
+		depth int
+		// rdfDepth is the depth of the rdf:RDF element's children.
+		rdfDepth int
+		// scopes holds the namespaces declared by each open element.
+		scopes []map[string]string
+	)
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xmp: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			decls := namespaceDecls(t.Attr)
+			depth++
+			switch {
+			case r == nil && t.Name.Space == nsRDF && t.Name.Local == "RDF":
+				if strings.HasSuffix(s[:d.InputOffset()], "/>") {
+					return nil, FormatError("empty rdf:RDF element in xmp")
+				}
+				r = &rdfElement{start: int(d.InputOffset()), end: -1, ns: make(map[string]string)}
+				for _, sc := range append(scopes, decls) {
+					for p, uri := range sc {
+						r.ns[p] = uri
+					}
+				}
+				rdfDepth = depth + 1
+			case r != nil && r.end < 0 && depth == rdfDepth:
+				name := s[off+1:]
+				if i := strings.IndexAny(name, " \t\r\n/>"); i >= 0 {
+					name = name[:i]
+				}
+				r.children = append(r.children, off+1+len(name))
+				declared := make(map[string]bool)
+				for p := range decls {
+					declared[p] = true
+				}
+				r.declared = append(r.declared, declared)
+			}
+			scopes = append(scopes, decls)
+		case xml.EndElement:
+			if r != nil && r.end < 0 && depth == rdfDepth-1 {
+				r.end = off
+			}
+			depth--
+			scopes = scopes[:len(scopes)-1]
+		}
+	}
+	if r == nil || r.end < 0 {
+		return nil, FormatError("no rdf:RDF element in xmp")
+	}
+	return r, nil
+}
+
+// namespaceDecls returns the namespaces declared by the attributes
+// attrs, by prefix.
+func namespaceDecls(attrs []xml.Attr) map[string]string {
+	decls := make(map[string]string)
+	for _, a := range attrs {
+		switch {
+		case a.Name.Space == "xmlns":
+			decls[a.Name.Local] = a.Value
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			decls[""] = a.Value
+		}
+	}
+	return decls
+}
+
+// mergeXMP adds the contents of the rdf:RDF element of the extended
+// XMP ext to that of the main packet main. The namespaces the extended
+// XMP declares outside its rdf:Description elements are declared on
+// them, so they keep their meaning in the main packet.
+func mergeXMP(main, ext string) (string, error) {
+	m, err := findRDFElement(main)
+	if err != nil {
+		return "", err
+	}
+	e, err := findRDFElement(ext)
+	if err != nil {
+		return "", err
+	}
+	prefixes := make([]string, 0, len(e.ns))
+	for p := range e.ns {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	var b strings.Builder
+	b.WriteString(main[:m.end])
+	last := e.start
+	for i, c := range e.children {
+		b.WriteString(ext[last:c])
+		for _, p := range prefixes {
+			if e.declared[i][p] {
+				continue
+			}
+			name := "xmlns"
+			if p != "" {
+				name += ":" + p
+			}
+			b.WriteString(" " + name + "=\"")
+			xml.EscapeText(&b, []byte(e.ns[p]))
+			b.WriteString("\"")
+		}
+		last = c
+	}
+	b.WriteString(ext[last:e.end])
+	b.WriteString(main[m.end:])
+	return b.String(), nil
+}
+
+// splitXMP returns the main packet, GUID and extended XMP for a
+// packet too big for a single segment. The extended XMP is the whole
+// packet, without its xpacket wrapper, and the main packet just names
+// it.
+func splitXMP(xmp string) (string, string, []byte) {
+	ext := strings.TrimSpace(xmp)
+	if strings.HasPrefix(ext, "<?xpacket") {
+		if i := strings.Index(ext, "?>"); i >= 0 {
+			ext = ext[i+2:]
+		}
+	}
+	if i := strings.LastIndex(ext, "<?xpacket"); i >= 0 {
+		ext = ext[:i]
+	}
+	b := []byte(strings.TrimSpace(ext))
+	sum := md5.Sum(b)
+	guid := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return fmt.Sprintf(mainXMPPacket, guid), guid, b
+}
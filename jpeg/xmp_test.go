@@ -0,0 +1,178 @@
+package jpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rmamba/image"
+	_ "github.com/rmamba/image/metadata/xmp"
+)
+
+func TestXMPFromImage(t *testing.T) {
+	f, err := ioutil.ReadFile("../testdata/kauaii_1.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, md, err := DecodeExtended(context.Background(), bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	m := md.(*Metadata)
+	if !strings.HasPrefix(m.RawXMP(), "<?xpacket") {
+		t.Errorf("got XMP %.40q, want an XMP packet", m.RawXMP())
+	}
+	if len(m.RawEXIF()) == 0 {
+		t.Error("got no EXIF data")
+	}
+	if n := len(m.UnknownSegments()[app1Marker]); n != 0 {
+		t.Errorf("got %d unknown APP1 segments, want none", n)
+	}
+	x, err := m.XMP(context.Background())
+	if err != nil {
+		t.Fatalf("XMP: %v", err)
+	}
+	if x == nil {
+		t.Fatal("got nil XMP")
+	}
+}
+
+// bigXMP returns an XMP packet with n subjects, which declares the
+// Dublin Core namespace on its rdf:RDF element.
+func bigXMP(n int) string {
+	var b strings.Builder
+	b.WriteString(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<rdf:Description rdf:about=""><dc:subject><rdf:Bag>`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "<rdf:li>subject %d</rdf:li>", i)
+	}
+	b.WriteString(`</rdf:Bag></dc:subject></rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+	return b.String()
+}
+
+func TestExtendedXMP(t *testing.T) {
+	const n = 8000
+	src := &Metadata{}
+	src.SetRawXMP(bigXMP(n))
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	if err := EncodeExtended(context.Background(), &buf, img, src); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	f := buf.Bytes()
+	if c := bytes.Count(f, []byte(extendedXmpMetadata)); c < 3 {
+		t.Errorf("got %d extended XMP segments, want at least 3", c)
+	}
+
+	_, md, err := DecodeExtended(context.Background(), bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("DecodeExtended: %v", err)
+	}
+	m := md.(*Metadata)
+	if strings.Contains(m.RawXMP(), "HasExtendedXMP") {
+		t.Error("merged XMP still names the extended XMP")
+	}
+	x, err := m.XMP(context.Background())
+	if err != nil {
+		t.Fatalf("XMP: %v", err)
+	}
+	if x.CoreProperties == nil || len(x.CoreProperties.Subject) != n || x.CoreProperties.Subject[n-1] != fmt.Sprintf("subject %d", n-1) {
+		t.Errorf("got core properties %.200v, want %d subjects", x.CoreProperties, n)
+	}
+
+	// Damaged extended XMP is an error unless damaged data is skipped,
+	// when the main packet is kept as it is.
+	i := bytes.LastIndex(f, []byte("subject"))
+	f[i] = 'S'
+	if _, _, err := DecodeExtended(context.Background(), bytes.NewReader(f)); err == nil {
+		t.Error("damaged extended XMP: got nil error")
+	}
+	_, md, err = DecodeExtended(context.Background(), bytes.NewReader(f), image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("damaged extended XMP, skipping damaged data: %v", err)
+	}
+	if raw := md.(*Metadata).RawXMP(); !strings.Contains(raw, "HasExtendedXMP") || strings.Contains(raw, "subject") {
+		t.Errorf("damaged extended XMP, skipping damaged data: got XMP %q", raw)
+	}
+}
+
+// withAPP1 returns the jpeg file f with an APP1 segment holding b
+// added after its SOI marker.
+func withAPP1(f, b []byte) []byte {
+	seg := []byte{0xff, app1Marker, byte((len(b) + 2) >> 8), byte(len(b) + 2)}
+	return append(append(append([]byte{}, f[:2]...), append(seg, b...)...), f[2:]...)
+}
+
+func TestExtendedXMPDamage(t *testing.T) {
+	main, guid, _ := splitXMP(bigXMP(8000))
+
+	// Without the extended XMP it names, the main packet is kept on its
+	// own.
+	var plain bytes.Buffer
+	if err := EncodeExtended(context.Background(), &plain, image.NewGray(image.Rect(0, 0, 8, 8)), &Metadata{}); err != nil {
+		t.Fatalf("EncodeExtended: %v", err)
+	}
+	f := withAPP1(plain.Bytes(), []byte(xmpMetadata+"\x00"+main))
+	_, md, err := DecodeExtended(context.Background(), bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("missing extended XMP: %v", err)
+	}
+	if raw := md.(*Metadata).RawXMP(); raw != main {
+		t.Errorf("missing extended XMP: got XMP %q, want %q", raw, main)
+	}
+
+	// A huge length in an extended XMP segment goes against the metadata
+	// limit before anything is allocated for it, and is damage if it
+	// doesn't match the chunks.
+	ext := []byte(extendedXmpMetadata + "\x00" + guid + "\xff\xff\xff\xf0\x00\x00\x00\x00chunk")
+	f = withAPP1(f, ext)
+	_, _, err = DecodeExtended(context.Background(), bytes.NewReader(f), image.LimitOptions{MaxMetadataSize: 1 << 20})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Errorf("huge extended XMP: got %v, want a limit error", err)
+	}
+	if _, _, err := DecodeExtended(context.Background(), bytes.NewReader(f)); err == nil {
+		t.Error("huge extended XMP: got nil error")
+	}
+	_, md, err = DecodeExtended(context.Background(), bytes.NewReader(f), image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("huge extended XMP, skipping damaged data: %v", err)
+	}
+	if raw := md.(*Metadata).RawXMP(); raw != main {
+		t.Errorf("huge extended XMP, skipping damaged data: got XMP %q, want %q", raw, main)
+	}
+}
+
+func TestAssembleExtendedXMP(t *testing.T) {
+	chunk := func(off uint32, s string) xmpChunk {
+		return xmpChunk{offset: off, data: []byte(s)}
+	}
+	for _, tc := range []struct {
+		name   string
+		chunks []xmpChunk
+		want   string
+	}{
+		{"in order", []xmpChunk{chunk(0, "abc"), chunk(3, "def"), chunk(6, "gh")}, "abcdefgh"},
+		{"out of order", []xmpChunk{chunk(6, "gh"), chunk(0, "abc"), chunk(3, "def")}, "abcdefgh"},
+		{"gap", []xmpChunk{chunk(0, "abc"), chunk(4, "efgh")}, ""},
+		{"overlap", []xmpChunk{chunk(0, "abcd"), chunk(3, "defgh")}, ""},
+		{"truncated", []xmpChunk{chunk(0, "abc"), chunk(3, "def")}, ""},
+		{"too long", []xmpChunk{chunk(0, "abcdefghi")}, ""},
+	} {
+		x := &extendedXMP{length: 8, chunks: tc.chunks}
+		got, err := x.assemble()
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != tc.want {
+			t.Errorf("%s: got %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
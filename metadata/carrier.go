@@ -0,0 +1,54 @@
+package metadata
+
+import (
+	"context"
+
+	"github.com/rmamba/image"
+)
+
+// The carrier interfaces let code read and replace the metadata
+// embedded in an image without knowing the image's format. An image
+// format's Metadata type implements each of them that the format can
+// hold, so callers can check for support with a type assertion:
+//
+//	if c, ok := m.(metadata.EXIFCarrier); ok {
+//		x, err := c.EXIF(ctx)
+//		...
+//	}
+//
+// The getters return nil, with no error, if the image has no metadata
+// of that kind. The returned structures stay associated with their
+// parent metadata object, so changes to them persist. A setter
+// replaces the metadata of its kind; passing nil removes it.
+
+// EXIFCarrier is implemented by image metadata that can hold EXIF
+// data.
+type EXIFCarrier interface {
+	image.Metadata
+	EXIF(ctx context.Context, opt ...image.ReadOption) (*EXIF, error)
+	SetEXIF(e *EXIF)
+}
+
+// XMPCarrier is implemented by image metadata that can hold XMP
+// data.
+type XMPCarrier interface {
+	image.Metadata
+	XMP(ctx context.Context, opt ...image.ReadOption) (*XMP, error)
+	SetXMP(x *XMP)
+}
+
+// ICCCarrier is implemented by image metadata that can hold an ICC
+// color profile.
+type ICCCarrier interface {
+	image.Metadata
+	ICC(ctx context.Context, opt ...image.ReadOption) (*ICC, error)
+	SetICC(i *ICC)
+}
+
+// IPTCCarrier is implemented by image metadata that can hold IPTC-IIM
+// data.
+type IPTCCarrier interface {
+	image.Metadata
+	IPTC(ctx context.Context, opt ...image.ReadOption) (*IPTC, error)
+	SetIPTC(x *IPTC)
+}
@@ -25,6 +25,19 @@ type Rational struct {
 	Denomenator uint32
 }
 
+// MakerNote holds a camera maker's own data, in a format that depends
+// on the maker. Many makers' formats hold offsets from the start of the
+// TIFF data the maker note was found in, so the encoder needs to know
+// where that was to keep them working when the maker note moves.
+type MakerNote struct {
+	Data []byte
+	// Offset is the offset Data was found at in the TIFF data it was
+	// decoded from, or zero if it wasn't decoded.
+	Offset uint32
+	// BigEndian is set if that TIFF data was big endian.
+	BigEndian bool
+}
+
 type EXIF struct {
 	ImageWidth                uint32         // 256
 	ImageHeight               uint32         // 257
@@ -44,14 +57,35 @@ type EXIF struct {
 	PrimaryChromaticities     [6]Rational    // 319
 	YCbCrCoefficient          [3]Rational    // 529
 	ReferenceBlackWhite       [6]Rational    // 532
-	DateTime                  *time.Time     // 306
+	DateTime                  *time.Time     // 306, 36880, 37520
 	ImageDescription          string         // 270
 	Make                      string         // 271
 	Model                     string         // 272
 	Software                  string         // 305
 	Artist                    string         // 315
 	Copyright                 string         // 33432
-
+	// Thumbnail holds the jpeg compressed thumbnail image described by
+	// IFD1, if there is one.
+	Thumbnail []byte // 513, 514
+	// DateTimeOriginal and DateTimeDigitized give when the image was
+	// taken and when it was stored digitally. Like DateTime, they're
+	// in a fixed time zone if the EXIF data gives their offset from
+	// UTC, and in UTC if it doesn't.
+	DateTimeOriginal  *time.Time // 36867, 36881, 37521
+	DateTimeDigitized *time.Time // 36868, 36882, 37522
+	// GPS holds the location from the GPS IFD, or nil if there's no
+	// GPS IFD.
+	GPS *GPS // 34853
+	// MakerNote holds the camera maker's own data from the Exif IFD,
+	// or nil if there isn't any.
+	MakerNote *MakerNote // 37500
+	// Tags holds the tags from every IFD that don't have a field of
+	// their own, such as the exposure information in the Exif IFD.
+	Tags Tags
+	// LittleEndian is set if the TIFF data x was decoded from was
+	// little endian. Image encoders write EXIF data in the same byte
+	// order, or big endian if it's not set.
+	LittleEndian bool
 }
 
 func DecodeEXIF(ctx context.Context, b []byte, isBigEndian bool, opt ...image.ReadOption) (*EXIF, error) {
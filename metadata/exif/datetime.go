@@ -0,0 +1,177 @@
+package exif
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// dateTimeLayout is the layout of EXIF date and time strings.
+const dateTimeLayout = "2006:01:02 15:04:05"
+
+// EXIF dates and times don't give their time zone or fractional
+// seconds. Those are given by separate offset and subsecond tags in
+// the Exif IFD.
+const (
+	tagDateTimeOriginal    = 36867
+	tagDateTimeDigitized   = 36868
+	tagOffsetTime          = 36880
+	tagOffsetTimeOriginal  = 36881
+	tagOffsetTimeDigitized = 36882
+	tagSubSecTime          = 37520
+	tagSubSecTimeOriginal  = 37521
+	tagSubSecTimeDigitized = 37522
+)
+
+// dateTimes lists the tags that together give each of the dates and
+// times in metadata.EXIF.
+var dateTimes = []struct {
+	// ifd is the IFD of the date and time tag. The offset and
+	// subsecond tags are always in the Exif IFD.
+	ifd                      metadata.IFD
+	dateTime, offset, subSec uint16
+	field                    func(x *metadata.EXIF) **time.Time
+}{
+	{metadata.IFD0, tagDateTime, tagOffsetTime, tagSubSecTime,
+		func(x *metadata.EXIF) **time.Time { return &x.DateTime }},
+	{metadata.ExifIFD, tagDateTimeOriginal, tagOffsetTimeOriginal, tagSubSecTimeOriginal,
+		func(x *metadata.EXIF) **time.Time { return &x.DateTimeOriginal }},
+	{metadata.ExifIFD, tagDateTimeDigitized, tagOffsetTimeDigitized, tagSubSecTimeDigitized,
+		func(x *metadata.EXIF) **time.Time { return &x.DateTimeDigitized }},
+}
+
+// parseDateTime returns the time given by the EXIF date and time
+// string s, the offset string offset, and the subsecond string subSec.
+// The offset and subseconds may be empty. The boolean reports whether s
+// is a valid date and time; an invalid offset or subsecond string is
+// ignored.
+func parseDateTime(s, offset, subSec string) (time.Time, bool) {
+	// Unknown dates are often left blank or zeroed, which don't
+	// parse.
+	t, err := time.Parse(dateTimeLayout, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, false
+	}
+	if loc, ok := parseOffset(offset); ok {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+	}
+	// Subseconds are the digits after the decimal point, and may be
+	// padded with spaces.
+	subSec = strings.TrimSpace(subSec)
+	if len(subSec) > 9 {
+		subSec = subSec[:9]
+	}
+	if n, err := strconv.ParseUint(subSec, 10, 32); err == nil {
+		for i := len(subSec); i < 9; i++ {
+			n *= 10
+		}
+		t = t.Add(time.Duration(n))
+	}
+	return t, true
+}
+
+// parseOffset returns a location for the EXIF offset string s, which
+// has the form "+HH:MM" or "-HH:MM". The boolean reports whether s is
+// a valid offset.
+func parseOffset(s string) (*time.Location, bool) {
+	if len(s) != 6 || (s[0] != '+' && s[0] != '-') || s[3] != ':' {
+		return nil, false
+	}
+	h, err1 := strconv.ParseUint(s[1:3], 10, 8)
+	m, err2 := strconv.ParseUint(s[4:6], 10, 8)
+	if err1 != nil || err2 != nil || h > 23 || m > 59 {
+		return nil, false
+	}
+	off := int(h)*3600 + int(m)*60
+	if s[0] == '-' {
+		off = -off
+	}
+	return time.FixedZone(s, off), true
+}
+
+// formatDateTime returns the EXIF date and time, offset and subsecond
+// strings for t. The subseconds are empty if t is a whole number of
+// seconds. The offset is empty only if t's location is time.UTC, which
+// is what parseDateTime gives a time without an offset. The location
+// is compared rather than the offset so that a time in another zero
+// offset location, such as one decoded with a "+00:00" offset, keeps
+// its offset.
+func formatDateTime(t time.Time) (s, offset, subSec string) {
+	s = t.Format(dateTimeLayout)
+	if t.Location() != time.UTC {
+		offset = t.Format("-07:00")
+	}
+	if ns := t.Nanosecond(); ns != 0 {
+		subSec = strings.TrimRight(fmt.Sprintf("%09d", ns), "0")
+	}
+	return s, offset, subSec
+}
+
+// tagKey identifies a tag within its IFD.
+type tagKey struct {
+	ifd metadata.IFD
+	tag uint16
+}
+
+// setDateTimes sets the dates and times in x from the IFD fields in r.
+// It returns the tags it used.
+func setDateTimes(x *metadata.EXIF, r *ifds) map[tagKey]bool {
+	used := make(map[tagKey]bool)
+	find := func(ifd metadata.IFD, tag uint16) (string, bool) {
+		fields := r.exif
+		if ifd == metadata.IFD0 {
+			fields = r.ifd0
+		}
+		for i := range fields {
+			if fields[i].tag == tag {
+				return fields[i].string()
+			}
+		}
+		return "", false
+	}
+
+	for _, dt := range dateTimes {
+		s, ok := find(dt.ifd, dt.dateTime)
+		if !ok {
+			continue
+		}
+		offset, hasOffset := find(metadata.ExifIFD, dt.offset)
+		subSec, hasSubSec := find(metadata.ExifIFD, dt.subSec)
+		t, ok := parseDateTime(s, offset, subSec)
+		if !ok {
+			continue
+		}
+		*dt.field(x) = &t
+		used[tagKey{dt.ifd, dt.dateTime}] = true
+		// The offset and subseconds are kept as tags if they couldn't
+		// be used.
+		if _, ok := parseOffset(offset); ok && hasOffset {
+			used[tagKey{metadata.ExifIFD, dt.offset}] = true
+		}
+		if _, err := strconv.ParseUint(strings.TrimSpace(subSec), 10, 64); err == nil && hasSubSec {
+			used[tagKey{metadata.ExifIFD, dt.subSec}] = true
+		}
+	}
+	return used
+}
+
+// addDateTimes adds the dates and times in x to IFD0 and the Exif IFD.
+func addDateTimes(ifd0, exifIFD *ifd, x *metadata.EXIF) {
+	for _, dt := range dateTimes {
+		t := *dt.field(x)
+		if t == nil {
+			continue
+		}
+		s, offset, subSec := formatDateTime(*t)
+		d := exifIFD
+		if dt.ifd == metadata.IFD0 {
+			d = ifd0
+		}
+		addString(d, dt.dateTime, s)
+		maybeAddString(exifIFD, dt.offset, offset)
+		maybeAddString(exifIFD, dt.subSec, subSec)
+	}
+}
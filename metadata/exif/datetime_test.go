@@ -0,0 +1,162 @@
+package exif
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+func TestParseDateTime(t *testing.T) {
+	tests := []struct {
+		s, offset, subSec string
+		want              time.Time
+		zone              string
+	}{
+		{"2020:01:02 03:04:05", "", "", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "UTC"},
+		{"2020:01:02 03:04:05", "+01:00", "", time.Date(2020, 1, 2, 2, 4, 5, 0, time.UTC), "+01:00"},
+		{"2020:01:02 03:04:05", "-05:30", "5", time.Date(2020, 1, 2, 8, 34, 5, 5e8, time.UTC), "-05:30"},
+		{"2020:01:02 03:04:05", "+00:00", "012 ", time.Date(2020, 1, 2, 3, 4, 5, 12e6, time.UTC), "+00:00"},
+		{" 2020:01:02 03:04:05 ", "  :  ", "1234567891", time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC), "UTC"},
+		{"2020:01:02 03:04:05", "+24:00", "x", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "UTC"},
+	}
+	for _, tc := range tests {
+		got, ok := parseDateTime(tc.s, tc.offset, tc.subSec)
+		if !ok || !got.Equal(tc.want) {
+			t.Errorf("%q %q %q: got %v, %v, want %v", tc.s, tc.offset, tc.subSec, got, ok, tc.want)
+		}
+		if zone, _ := got.Zone(); zone != tc.zone {
+			t.Errorf("%q %q %q: got zone %q, want %q", tc.s, tc.offset, tc.subSec, zone, tc.zone)
+		}
+	}
+
+	for _, s := range []string{"", "    :  :     :  :  ", "0000:00:00 00:00:00", "2020-01-02 03:04:05"} {
+		if got, ok := parseDateTime(s, "+01:00", ""); ok {
+			t.Errorf("%q: got %v", s, got)
+		}
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	// Only times in time.UTC leave out the offset; a zero offset in any
+	// other location is kept.
+	tests := []struct {
+		t      time.Time
+		offset string
+	}{
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), ""},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("+00:00", 0)), "+00:00"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("", -9000)), "-02:30"},
+	}
+	for _, tc := range tests {
+		s, offset, _ := formatDateTime(tc.t)
+		if s != "2020:01:02 03:04:05" || offset != tc.offset {
+			t.Errorf("%v: got %q, %q, want %q, %q", tc.t, s, offset, "2020:01:02 03:04:05", tc.offset)
+		}
+		got, ok := parseDateTime(s, offset, "")
+		if !ok || !got.Equal(tc.t) {
+			t.Errorf("%v: parsing gave %v, %v", tc.t, got, ok)
+		}
+	}
+}
+
+func TestDecodeDateTimes(t *testing.T) {
+	o := binary.BigEndian
+	exifIFD := &testIFD{entries: []testEntry{
+		ascii(tagDateTimeOriginal, "2021:06:01 12:00:00"),
+		ascii(tagDateTimeDigitized, "2021:06:01 12:00:01"),
+		ascii(tagOffsetTime, "+02:00"),
+		ascii(tagOffsetTimeOriginal, "-04:00"),
+		ascii(tagOffsetTimeDigitized, "bad"),
+		ascii(tagSubSecTime, "25"),
+		ascii(tagSubSecTimeOriginal, "5"),
+	}}
+	b := buildTIFF(o, &testIFD{entries: []testEntry{
+		ascii(tagDateTime, "2021:06:01 18:00:00"),
+		{tag: tagExifIFD, typ: typeLong, count: 1, sub: exifIFD},
+	}})
+	x, err := Decode(context.Background(), b, true)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		got  *time.Time
+		want time.Time
+	}{
+		{"DateTime", x.DateTime, time.Date(2021, 6, 1, 16, 0, 0, 25e7, time.UTC)},
+		{"DateTimeOriginal", x.DateTimeOriginal, time.Date(2021, 6, 1, 16, 0, 0, 5e8, time.UTC)},
+		{"DateTimeDigitized", x.DateTimeDigitized, time.Date(2021, 6, 1, 12, 0, 1, 0, time.UTC)},
+	} {
+		if tc.got == nil || !tc.got.Equal(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	// Only the offset that couldn't be used is kept as a tag.
+	if x.Tags.Len() != 1 {
+		t.Errorf("got %d tags, want 1", x.Tags.Len())
+	}
+	if tag, ok := x.Tags.Get(metadata.ExifIFD, metadata.TagOffsetTimeDigitized); !ok {
+		t.Error("no OffsetTimeDigitized tag")
+	} else if s, _ := tag.Text(); s != "bad" {
+		t.Errorf("got OffsetTimeDigitized %q, want bad", s)
+	}
+}
+
+func TestEncodeDateTimes(t *testing.T) {
+	times := []time.Time{
+		time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.FixedZone("", 5*3600+1800)),
+		time.Date(2021, 6, 1, 12, 0, 0, 5e8, time.FixedZone("", -3600)),
+	}
+	for _, want := range times {
+		x := &metadata.EXIF{DateTime: &want, DateTimeOriginal: &want, DateTimeDigitized: &want}
+		b, err := Encode(context.Background(), x, false)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		got, err := Decode(context.Background(), b, false)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		_, wantOffset := want.Zone()
+		for _, dt := range []*time.Time{got.DateTime, got.DateTimeOriginal, got.DateTimeDigitized} {
+			if dt == nil || !dt.Equal(want) {
+				t.Errorf("got %v, want %v", dt, want)
+				continue
+			}
+			if _, offset := dt.Zone(); offset != wantOffset || (dt.Location() == time.UTC) != (want.Location() == time.UTC) {
+				t.Errorf("got %v in %v, want %v in %v", dt, dt.Location(), want, want.Location())
+			}
+		}
+		// Times in UTC are written without an offset.
+		_, hasOffset := got.Tags.Get(metadata.ExifIFD, metadata.TagOffsetTime)
+		if got.Tags.Len() != 1 || hasOffset {
+			t.Errorf("%v: got %d tags, want just the EXIF version", want, got.Tags.Len())
+		}
+	}
+
+	// Photos from cameras in different time zones sort by when they
+	// were taken.
+	a := time.Date(2021, 6, 1, 9, 0, 0, 0, time.FixedZone("", -4*3600))
+	c := time.Date(2021, 6, 1, 14, 30, 0, 0, time.FixedZone("", 2*3600))
+	var decoded []time.Time
+	for _, tm := range []time.Time{a, c} {
+		b, err := Encode(context.Background(), &metadata.EXIF{DateTimeOriginal: &tm}, true)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		x, err := Decode(context.Background(), b, true)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		decoded = append(decoded, *x.DateTimeOriginal)
+	}
+	if !decoded[1].Before(decoded[0]) {
+		t.Errorf("got %v not before %v", decoded[1], decoded[0])
+	}
+}
@@ -4,11 +4,6 @@
 package exif
 
 import (
-	"context"
-	"encoding/binary"
-	"log"
-
-	"github.com/rmamba/image"
 	"github.com/rmamba/image/metadata"
 )
 
@@ -17,45 +12,97 @@ func init() {
 	metadata.RegisterEXIFEncoder(Encode)
 }
 
-func readUint16(ibe bool, b []byte) uint16 {
-	if ibe {
-		return binary.BigEndian.Uint16(b)
-	}
-	return binary.LittleEndian.Uint16(b)
+// header is the header that comes before the TIFF data in a jpeg APP1
+// segment. Some writers leave it in front of the data elsewhere too.
+const header = "Exif\x00\x00"
+
+// TIFF field types.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+)
+
+// typeSizes holds the size in bytes of a single value of each field
+// type. Unknown types have a size of zero.
+var typeSizes = [...]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeSByte:     1,
+	typeUndefined: 1,
+	typeSShort:    2,
+	typeSLong:     4,
+	typeSRational: 8,
+	typeFloat:     4,
+	typeDouble:    8,
 }
 
-func readUint32(ibe bool, b []byte) uint32 {
-	if ibe {
-		return binary.BigEndian.Uint32(b)
+// typeSize returns the size in bytes of a single value of field type
+// t, or zero if t isn't a known type.
+func typeSize(t uint16) uint32 {
+	if int(t) >= len(typeSizes) {
+		return 0
 	}
-	return binary.LittleEndian.Uint32(b)
+	return typeSizes[t]
 }
 
-// Decode decodes EXIF color profiles.
-func Decode(ctx context.Context, b []byte, isBigEndian bool, opt ...image.ReadOption) (*metadata.EXIF, error) {
-	ex := &metadata.EXIF{}
-	count := int(binary.BigEndian.Uint16(b))
-	for i := 0; i < count; i++ {
-		base := i*12 + 2
-		tag := readUint16(isBigEndian, b[base:])
-		tagType := readUint16(isBigEndian, b[base+2:])
-		valCount := readUint16(isBigEndian, b[base+4:])
-		valOffset := readUint32(isBigEndian, b[base+6:])
-		log.Printf("tag %v type %v val count %v val offset %v", tag, tagType, valCount, valOffset)
-		// Run through all the potential tags. This would be easier with
-		// some kind of reflection on the exif struct.
-		switch tag {
-		case 256:
-			ex.ImageWidth = readUint32(isBigEndian, b[valOffset:])
-		case 257:
-			ex.ImageHeight = readUint32(isBigEndian, b[valOffset:])
-		}
+// Tags for the IFD0 fields held in metadata.EXIF, and for the pointers
+// to the other IFDs.
+const (
+	tagImageWidth                = 256
+	tagImageHeight               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagImageDescription          = 270
+	tagMake                      = 271
+	tagModel                     = 272
+	tagOrientation               = 274
+	tagSamplesPerPixel           = 277
+	tagXResolution               = 282
+	tagYResolution               = 283
+	tagPlanarConfiguration       = 284
+	tagResolutionUnit            = 296
+	tagTransferFunction          = 301
+	tagSoftware                  = 305
+	tagDateTime                  = 306
+	tagArtist                    = 315
+	tagWhitePoint                = 318
+	tagPrimaryChromaticities     = 319
+	tagYCbCrCoefficients         = 529
+	tagYCbCrSubSampling          = 530
+	tagYCbCrPositioning          = 531
+	tagReferenceBlackWhite       = 532
+	tagCopyright                 = 33432
 
-	}
-	panic("Not implemented")
-}
+	// IFD1 points to the thumbnail with these.
+	tagJPEGInterchangeFormat       = 513
+	tagJPEGInterchangeFormatLength = 514
 
-// Encode encodes EXIF color profiles.
-func Encode(ctx context.Context, e *metadata.EXIF, isBigEndian bool, opt ...image.WriteOption) ([]byte, error) {
-	panic("Not implemented")
-}
+	// Tiled TIFF images point to their image data with these.
+	tagTileOffsets    = 324
+	tagTileByteCounts = 325
+
+	tagExifIFD    = 34665
+	tagGPSIFD     = 34853
+	tagInteropIFD = 40965
+
+	// The Exif IFD holds the image dimensions for compressed images,
+	// which often don't have them in IFD0.
+	tagPixelXDimension = 40962
+	tagPixelYDimension = 40963
+	tagExifVersion     = 36864
+	tagMakerNote       = 37500
+)
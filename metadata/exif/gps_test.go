@@ -0,0 +1,148 @@
+package exif
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+func TestGPSCoordinates(t *testing.T) {
+	for _, c := range [][2]float64{
+		{0, 0},
+		{51.5007, -0.1246},
+		{-33.8568, 151.2153},
+		{-90, 180},
+		{89.99999999, -179.99999999},
+	} {
+		g := &metadata.GPS{}
+		if err := g.SetCoordinates(c[0], c[1]); err != nil {
+			t.Errorf("%v: SetCoordinates: %v", c, err)
+			continue
+		}
+		lat, lon, ok := g.Coordinates()
+		// Seconds are kept to 1/10000, which is about 3mm.
+		if !ok || math.Abs(lat-c[0]) > 1e-7 || math.Abs(lon-c[1]) > 1e-7 {
+			t.Errorf("%v: got %v, %v, %v", c, lat, lon, ok)
+		}
+		for _, dms := range [][3]metadata.Rational{g.Latitude, g.Longitude} {
+			if dms[1].Numerator >= 60 || dms[2].Numerator >= 60*dms[2].Denomenator {
+				t.Errorf("%v: got out of range minutes or seconds %v", c, dms)
+			}
+		}
+	}
+
+	g := &metadata.GPS{}
+	if _, _, ok := g.Coordinates(); ok {
+		t.Error("empty GPS: got coordinates")
+	}
+	for _, c := range [][2]float64{{91, 0}, {0, -181}, {math.NaN(), 0}, {0, math.Inf(1)}} {
+		if err := g.SetCoordinates(c[0], c[1]); err == nil {
+			t.Errorf("%v: got nil error", c)
+		}
+	}
+
+	g.SetCoordinates(10, 20)
+	g.LatitudeRef = "X"
+	if _, _, ok := g.Coordinates(); ok {
+		t.Error("bad latitude ref: got coordinates")
+	}
+}
+
+func TestGPSAltitude(t *testing.T) {
+	g := &metadata.GPS{}
+	if _, ok := g.AltitudeMeters(); ok {
+		t.Error("empty GPS: got altitude")
+	}
+	for _, m := range []float64{0, 8848.86, -430.5} {
+		if err := g.SetAltitude(m); err != nil {
+			t.Fatalf("%v: SetAltitude: %v", m, err)
+		}
+		if got, ok := g.AltitudeMeters(); !ok || got != m {
+			t.Errorf("got altitude %v, %v, want %v", got, ok, m)
+		}
+	}
+	if g.AltitudeRef != 1 {
+		t.Errorf("got altitude ref %d below sea level, want 1", g.AltitudeRef)
+	}
+	if err := g.SetAltitude(math.Inf(1)); err == nil {
+		t.Error("infinite altitude: got nil error")
+	}
+}
+
+func TestGPSTime(t *testing.T) {
+	g := &metadata.GPS{}
+	if _, ok := g.Time(); ok {
+		t.Error("empty GPS: got time")
+	}
+	want := time.Date(2020, 2, 29, 23, 59, 58, 250e6, time.UTC)
+	g.SetTime(want.In(time.FixedZone("CET", 3600)))
+	if g.DateStamp != "2020:02:29" {
+		t.Errorf("got date stamp %q, want 2020:02:29", g.DateStamp)
+	}
+	if got, ok := g.Time(); !ok || !got.Equal(want) {
+		t.Errorf("got time %v, %v, want %v", got, ok, want)
+	}
+}
+
+func TestGPSRoundTrip(t *testing.T) {
+	g := &metadata.GPS{
+		VersionID:       [4]uint8{2, 2, 0, 0},
+		SpeedRef:        "K",
+		Speed:           metadata.Rational{Numerator: 50, Denomenator: 1},
+		ImgDirectionRef: "T",
+		ImgDirection:    metadata.Rational{Numerator: 2705, Denomenator: 10},
+		MapDatum:        "WGS-84",
+	}
+	g.SetCoordinates(-33.8568, 151.2153)
+	g.SetAltitude(-12.5)
+	g.SetTime(time.Date(2019, 12, 31, 12, 0, 1, 0, time.UTC))
+
+	for _, want := range []*metadata.GPS{g, {}} {
+		x := &metadata.EXIF{GPS: want}
+		b, err := Encode(context.Background(), x, true)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		got, err := Decode(context.Background(), b, true)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		// The GPS version is always written.
+		if want.VersionID == ([4]uint8{}) {
+			want.VersionID = gpsVersion
+		}
+		if !reflect.DeepEqual(got.GPS, want) {
+			t.Errorf("got %+v, want %+v", got.GPS, want)
+		}
+		if _, ok := got.Tags.Get(metadata.GPSIFD, metadata.TagGPSLatitude); ok {
+			t.Error("got GPS latitude tag as well as field")
+		}
+	}
+}
+
+func TestStripGPS(t *testing.T) {
+	x := &metadata.EXIF{Make: "Make", GPS: &metadata.GPS{}}
+	x.GPS.SetCoordinates(1, 2)
+	x.Tags.Set(metadata.ASCIITag(metadata.GPSIFD, metadata.TagGPSSatellites, "5"))
+	x.Tags.Set(metadata.ASCIITag(metadata.ExifIFD, metadata.TagLensModel, "Lens"))
+	x.StripGPS()
+
+	b, err := Encode(context.Background(), x, false)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(context.Background(), b, false)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.GPS != nil || got.Tags.Len() != 2 {
+		t.Errorf("got GPS %v and %d tags, want no GPS and the lens model and EXIF version", got.GPS, got.Tags.Len())
+	}
+	if got.Make != "Make" {
+		t.Errorf("got make %q, want Make", got.Make)
+	}
+}
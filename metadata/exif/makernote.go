@@ -0,0 +1,164 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// makerNoteLayout describes how a camera maker lays out its maker
+// notes.
+type makerNoteLayout struct {
+	// ifd is the offset of the maker note's IFD from its start.
+	ifd int
+	// absolute is set if the IFD's offsets are from the start of the
+	// TIFF data, so they have to be rebased when the maker note moves.
+	// Other layouts' offsets are from somewhere in the maker note, so
+	// they keep working wherever it's put.
+	absolute bool
+}
+
+// makerNoteLayouts holds the layouts of maker notes that start with a
+// header, keyed by the header.
+var makerNoteLayouts = []struct {
+	header string
+	layout makerNoteLayout
+}{
+	// Nikon type 3 maker notes have their own TIFF header after the
+	// maker header, which gives their byte order and which their
+	// offsets are from.
+	{"Nikon\x00\x02", makerNoteLayout{}},
+	// Fujifilm's offsets are from the start of the maker note, and
+	// are always little endian.
+	{"FUJIFILM", makerNoteLayout{}},
+	// Newer Olympus and OM System maker notes give their own byte
+	// order, and their offsets are from the start of the maker note.
+	{"OLYMPUS\x00", makerNoteLayout{}},
+	{"OM SYSTEM\x00", makerNoteLayout{}},
+	{"Nikon\x00\x01", makerNoteLayout{ifd: 8, absolute: true}},
+	{"OLYMP\x00", makerNoteLayout{ifd: 8, absolute: true}},
+	{"SONY DSC \x00\x00\x00", makerNoteLayout{ifd: 12, absolute: true}},
+	{"SONY CAM \x00\x00\x00", makerNoteLayout{ifd: 12, absolute: true}},
+	{"Panasonic\x00\x00\x00", makerNoteLayout{ifd: 12, absolute: true}},
+}
+
+// detectMakerNote returns the layout of the maker note b, from a camera
+// whose maker is cameraMake. The boolean reports whether the layout is
+// known.
+func detectMakerNote(b []byte, cameraMake string) (makerNoteLayout, bool) {
+	for _, l := range makerNoteLayouts {
+		if bytes.HasPrefix(b, []byte(l.header)) {
+			return l.layout, true
+		}
+	}
+	// Canon maker notes have no header, just an IFD.
+	if strings.HasPrefix(cameraMake, "Canon") {
+		return makerNoteLayout{absolute: true}, true
+	}
+	return makerNoteLayout{}, false
+}
+
+// rebaseMakerNote returns a copy of the maker note b, which has layout
+// l and was at offset from in TIFF data in byte order fromOrder, with
+// its IFD changed to work at offset to in TIFF data in byte order
+// toOrder. The offsets in the IFD are moved along with the maker note,
+// and the IFD and the values in the maker note are converted to the new
+// byte order. Values outside the maker note, and values of unknown
+// types, are left as they are. The boolean reports whether the IFD
+// could be read.
+func rebaseMakerNote(b []byte, l makerNoteLayout, from, to uint32, fromOrder, toOrder binary.ByteOrder) ([]byte, bool) {
+	p := l.ifd
+	if p+2 > len(b) {
+		return nil, false
+	}
+	n := int(fromOrder.Uint16(b[p:]))
+	end := p + 2 + 12*n
+	if end > len(b) {
+		return nil, false
+	}
+
+	// rebase returns the offset off moved along with the maker note.
+	// It returns the offset in the maker note that off points to, or
+	// -1 if off points outside of it.
+	rebase := func(off uint32) (uint32, int) {
+		moved := uint32(int64(off) + int64(to) - int64(from))
+		if off < from || uint64(off-from) >= uint64(len(b)) {
+			return moved, -1
+		}
+		return moved, int(off - from)
+	}
+
+	c := append([]byte(nil), b...)
+	toOrder.PutUint16(c[p:], uint16(n))
+	for i := 0; i < n; i++ {
+		e := p + 2 + 12*i
+		typ := fromOrder.Uint16(b[e+2:])
+		count := fromOrder.Uint32(b[e+4:])
+		toOrder.PutUint16(c[e:], fromOrder.Uint16(b[e:]))
+		toOrder.PutUint16(c[e+2:], typ)
+		toOrder.PutUint32(c[e+4:], count)
+
+		size := uint64(count) * uint64(typeSize(typ))
+		switch {
+		case size == 0:
+			// The value's type is unknown, so there's no telling
+			// whether it holds an offset or how to convert it.
+		case size <= 4:
+			copy(c[e+8:], convertOrder(typ, b[e+8:e+8+int(size)], fromOrder, toOrder))
+		default:
+			moved, vo := rebase(fromOrder.Uint32(b[e+8:]))
+			toOrder.PutUint32(c[e+8:], moved)
+			if vo >= 0 && uint64(vo)+size <= uint64(len(b)) {
+				copy(c[vo:], convertOrder(typ, b[vo:vo+int(size)], fromOrder, toOrder))
+			}
+		}
+	}
+
+	// Maker notes don't usually chain on another IFD, but if they do
+	// its offset needs moving too.
+	if end+4 <= len(b) {
+		if next := fromOrder.Uint32(b[end:]); next != 0 {
+			next, _ = rebase(next)
+			toOrder.PutUint32(c[end:], next)
+		}
+	}
+	return c, true
+}
+
+// makerNoteEntry returns an Exif IFD entry holding the maker note m,
+// from a camera whose maker is cameraMake, to be encoded in the
+// encoder's byte order.
+//
+// Maker notes whose offsets are from the start of the TIFF data have
+// them rebased for wherever the maker note ends up. Maker notes in
+// unknown layouts are put at the offset they were found at if that
+// doesn't overlap anything else, which keeps any offsets they hold
+// working.
+func (e *encoder) makerNoteEntry(m *metadata.MakerNote, cameraMake string) entry {
+	ent := entry{field: field{tag: tagMakerNote, typ: typeUndefined, count: uint32(len(m.Data)), value: m.Data}}
+	var fromOrder binary.ByteOrder = binary.LittleEndian
+	if m.BigEndian {
+		fromOrder = binary.BigEndian
+	}
+
+	// Without the offset the maker note was found at, there's no
+	// telling what its offsets are from.
+	if m.Offset == 0 {
+		return ent
+	}
+	l, ok := detectMakerNote(m.Data, cameraMake)
+	switch {
+	case !ok:
+		ent.at = m.Offset
+	case l.absolute:
+		ent.relocate = func(v []byte, off uint32) []byte {
+			if c, ok := rebaseMakerNote(v, l, m.Offset, off, fromOrder, e.order); ok {
+				return c
+			}
+			return v
+		}
+	}
+	return ent
+}
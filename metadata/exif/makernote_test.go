@@ -0,0 +1,146 @@
+package exif
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// testMakerNote returns a maker note with the given header, holding an
+// IFD with a short and an ASCII value, as it would be at offset off in
+// TIFF data in byte order o. The ASCII value is held in the maker
+// note, after the IFD.
+func testMakerNote(header string, o binary.ByteOrder, off uint32) []byte {
+	b := []byte(header)
+	p := len(b)
+	b = append(b, make([]byte, 2+2*12+4)...)
+	o.PutUint16(b[p:], 2)
+	e := b[p+2:]
+	o.PutUint16(e, 1)
+	o.PutUint16(e[2:], typeShort)
+	o.PutUint32(e[4:], 1)
+	o.PutUint16(e[8:], 5)
+	e = e[12:]
+	o.PutUint16(e, 6)
+	o.PutUint16(e[2:], typeASCII)
+	o.PutUint32(e[4:], 10)
+	o.PutUint32(e[8:], off+uint32(len(b)))
+	return append(b, "Model 123\x00"...)
+}
+
+// checkMakerNote checks that the maker note m holds the IFD from
+// testMakerNote after header, and that the IFD works at m's offset.
+func checkMakerNote(t *testing.T, name string, m *metadata.MakerNote, header string) {
+	t.Helper()
+	var o binary.ByteOrder = binary.LittleEndian
+	if m.BigEndian {
+		o = binary.BigEndian
+	}
+	b := m.Data
+	p := len(header)
+	if string(b[:p]) != header || o.Uint16(b[p:]) != 2 {
+		t.Errorf("%s: got maker note %q", name, b)
+		return
+	}
+	e := b[p+2:]
+	if o.Uint16(e) != 1 || o.Uint16(e[8:]) != 5 {
+		t.Errorf("%s: got short entry % x", name, e[:12])
+	}
+	e = e[12:]
+	vo := int64(o.Uint32(e[8:])) - int64(m.Offset)
+	if o.Uint16(e) != 6 || vo < 0 || vo+10 > int64(len(b)) || string(b[vo:vo+10]) != "Model 123\x00" {
+		t.Errorf("%s: got ASCII entry % x, value offset %d in maker note", name, e[:12], vo)
+	}
+}
+
+// makerNoteRoundTrip encodes m in EXIF data for a camera whose maker
+// is cameraMake, and decodes the maker note again.
+func makerNoteRoundTrip(t *testing.T, m *metadata.MakerNote, cameraMake string, bigEndian bool) *metadata.MakerNote {
+	t.Helper()
+	x := &metadata.EXIF{Make: cameraMake, Artist: "Someone", MakerNote: m}
+	x.Tags.Set(metadata.ASCIITag(metadata.ExifIFD, metadata.TagLensModel, "Lens"))
+	b, err := Encode(context.Background(), x, bigEndian)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(context.Background(), b, bigEndian)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.MakerNote == nil {
+		t.Fatal("no maker note")
+	}
+	if !bytes.Equal(b[got.MakerNote.Offset:int(got.MakerNote.Offset)+len(got.MakerNote.Data)], got.MakerNote.Data) {
+		t.Error("maker note offset doesn't point to the maker note")
+	}
+	if _, ok := got.Tags.Get(metadata.ExifIFD, metadata.TagMakerNote); ok {
+		t.Error("got maker note tag as well as field")
+	}
+	return got.MakerNote
+}
+
+func TestMakerNoteRebase(t *testing.T) {
+	tests := []struct {
+		name, header, make string
+	}{
+		{"Canon", "", "Canon"},
+		{"Sony", "SONY DSC \x00\x00\x00", "SONY"},
+		{"Panasonic", "Panasonic\x00\x00\x00", "Panasonic"},
+		{"old Olympus", "OLYMP\x00\x01\x00", "OLYMPUS IMAGING CORP."},
+		{"Nikon type 1", "Nikon\x00\x01\x00", "NIKON"},
+	}
+	for _, tc := range tests {
+		for _, from := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			for _, bigEndian := range []bool{true, false} {
+				m := &metadata.MakerNote{
+					Data:      testMakerNote(tc.header, from, 3000),
+					Offset:    3000,
+					BigEndian: from == binary.BigEndian,
+				}
+				checkMakerNote(t, tc.name, m, tc.header)
+				got := makerNoteRoundTrip(t, m, tc.make, bigEndian)
+				if got.Offset == m.Offset {
+					t.Errorf("%s: maker note wasn't moved", tc.name)
+				}
+				checkMakerNote(t, tc.name, got, tc.header)
+			}
+		}
+	}
+}
+
+func TestMakerNoteSelfContained(t *testing.T) {
+	for _, header := range []string{"Nikon\x00\x02\x10\x00\x00MM\x00*\x00\x00\x00\x08", "FUJIFILM\x0c\x00\x00\x00", "OLYMPUS\x00II\x03\x00"} {
+		m := &metadata.MakerNote{Data: append([]byte(header), "some data"...), Offset: 3000}
+		got := makerNoteRoundTrip(t, m, "", true)
+		if !bytes.Equal(got.Data, m.Data) {
+			t.Errorf("%q: got maker note %q, want %q", header, got.Data, m.Data)
+		}
+	}
+}
+
+func TestMakerNoteUnknown(t *testing.T) {
+	m := &metadata.MakerNote{Data: []byte("an unknown maker note"), Offset: 3000}
+	got := makerNoteRoundTrip(t, m, "Someone", false)
+	if got.Offset != m.Offset || !bytes.Equal(got.Data, m.Data) {
+		t.Errorf("got maker note %q at %d, want %q at %d", got.Data, got.Offset, m.Data, m.Offset)
+	}
+
+	// Without an offset it can go anywhere.
+	m.Offset = 0
+	got = makerNoteRoundTrip(t, m, "Someone", false)
+	if !bytes.Equal(got.Data, m.Data) || got.Offset > 1000 {
+		t.Errorf("got maker note %q at %d, want %q", got.Data, got.Offset, m.Data)
+	}
+}
+
+func TestMakerNoteDamaged(t *testing.T) {
+	// A Canon maker note whose IFD runs off its end is kept as it is.
+	m := &metadata.MakerNote{Data: []byte{0, 9, 1, 2, 3, 4, 5, 6}, Offset: 3000, BigEndian: true}
+	got := makerNoteRoundTrip(t, m, "Canon", true)
+	if !bytes.Equal(got.Data, m.Data) {
+		t.Errorf("got maker note % x, want % x", got.Data, m.Data)
+	}
+}
@@ -0,0 +1,627 @@
+package exif
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// field holds a single IFD entry.
+type field struct {
+	tag, typ uint16
+	count    uint32
+	// value holds the entry's undecoded values, in the byte order
+	// given by order.
+	value []byte
+	order binary.ByteOrder
+	// offset is the offset of the values in the TIFF data, if they
+	// didn't fit in the entry.
+	offset uint32
+}
+
+// ints returns the field's values as integers. The boolean reports
+// whether the field holds integers.
+func (f *field) ints() ([]int64, bool) {
+	v := make([]int64, f.count)
+	for i := range v {
+		switch f.typ {
+		case typeByte, typeUndefined:
+			v[i] = int64(f.value[i])
+		case typeSByte:
+			v[i] = int64(int8(f.value[i]))
+		case typeShort:
+			v[i] = int64(f.order.Uint16(f.value[2*i:]))
+		case typeSShort:
+			v[i] = int64(int16(f.order.Uint16(f.value[2*i:])))
+		case typeLong:
+			v[i] = int64(f.order.Uint32(f.value[4*i:]))
+		case typeSLong:
+			v[i] = int64(int32(f.order.Uint32(f.value[4*i:])))
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// uint returns the field's first value as an unsigned integer. The
+// boolean reports whether the field holds at least one non-negative
+// integer that fits in 32 bits.
+func (f *field) uint() (uint32, bool) {
+	v, ok := f.ints()
+	if !ok || len(v) == 0 || v[0] < 0 || v[0] > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(v[0]), true
+}
+
+// uint16s returns the field's values as unsigned 16 bit integers. The
+// boolean reports whether the field holds integers that all fit.
+func (f *field) uint16s() ([]uint16, bool) {
+	v, ok := f.ints()
+	if !ok {
+		return nil, false
+	}
+	u := make([]uint16, len(v))
+	for i, n := range v {
+		if n < 0 || n > math.MaxUint16 {
+			return nil, false
+		}
+		u[i] = uint16(n)
+	}
+	return u, true
+}
+
+// rationals returns the field's values as unsigned rationals. The
+// boolean reports whether the field holds unsigned rationals.
+func (f *field) rationals() ([]metadata.Rational, bool) {
+	if f.typ != typeRational {
+		return nil, false
+	}
+	v := make([]metadata.Rational, f.count)
+	for i := range v {
+		v[i].Numerator = f.order.Uint32(f.value[8*i:])
+		v[i].Denomenator = f.order.Uint32(f.value[8*i+4:])
+	}
+	return v, true
+}
+
+// floats returns the field's values as floating point numbers, which
+// works for every numeric field type. Rationals with a zero
+// denominator come back as NaN. The boolean reports whether the field
+// holds numbers.
+func (f *field) floats() ([]float64, bool) {
+	switch f.typ {
+	case typeRational, typeSRational:
+		v := make([]float64, f.count)
+		for i := range v {
+			n, d := f.order.Uint32(f.value[8*i:]), f.order.Uint32(f.value[8*i+4:])
+			if d == 0 {
+				v[i] = math.NaN()
+			} else if f.typ == typeRational {
+				v[i] = float64(n) / float64(d)
+			} else {
+				v[i] = float64(int32(n)) / float64(int32(d))
+			}
+		}
+		return v, true
+	case typeFloat:
+		v := make([]float64, f.count)
+		for i := range v {
+			v[i] = float64(math.Float32frombits(f.order.Uint32(f.value[4*i:])))
+		}
+		return v, true
+	case typeDouble:
+		v := make([]float64, f.count)
+		for i := range v {
+			v[i] = math.Float64frombits(f.order.Uint64(f.value[8*i:]))
+		}
+		return v, true
+	}
+	n, ok := f.ints()
+	if !ok {
+		return nil, false
+	}
+	v := make([]float64, len(n))
+	for i := range n {
+		v[i] = float64(n[i])
+	}
+	return v, true
+}
+
+// string returns the field's value as a string, without the trailing
+// nulls. The boolean reports whether the field holds text. Writers
+// often get the type wrong, so byte and undefined fields are taken as
+// text as well as ascii ones.
+func (f *field) string() (string, bool) {
+	switch f.typ {
+	case typeASCII, typeByte, typeUndefined:
+		return strings.TrimRight(string(f.value), "\x00"), true
+	}
+	return "", false
+}
+
+// decoder holds the state for decoding a single block of EXIF data.
+type decoder struct {
+	// b holds the TIFF data, starting with the byte order mark.
+	b     []byte
+	order binary.ByteOrder
+	// skipDamaged is set if fields and IFDs that run off the end of
+	// the data should be skipped rather than failing the decode.
+	skipDamaged bool
+}
+
+// readIFD reads the IFD at offset off. It returns the IFD's fields and
+// the offset of the next IFD, which is zero if there isn't one. Fields
+// of unknown types are skipped.
+func (d *decoder) readIFD(off uint32) ([]field, uint32, error) {
+	size := uint64(len(d.b))
+	if uint64(off)+2 > size {
+		return nil, 0, fmt.Errorf("exif: IFD offset %d out of range", off)
+	}
+	n := uint64(d.order.Uint16(d.b[off:]))
+	end := uint64(off) + 2 + n*12
+	if end > size {
+		return nil, 0, fmt.Errorf("exif: IFD at offset %d with %d entries runs past end of data", off, n)
+	}
+
+	fields := make([]field, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e := d.b[uint64(off)+2+i*12:]
+		f := field{
+			tag:   d.order.Uint16(e),
+			typ:   d.order.Uint16(e[2:]),
+			count: d.order.Uint32(e[4:]),
+			order: d.order,
+		}
+		ts := typeSize(f.typ)
+		if ts == 0 {
+			continue
+		}
+		vs := uint64(f.count) * uint64(ts)
+		if vs <= 4 {
+			f.value = e[8 : 8+vs]
+		} else {
+			vo := uint64(d.order.Uint32(e[8:]))
+			if vo+vs > size {
+				if d.skipDamaged {
+					continue
+				}
+				return nil, 0, fmt.Errorf("exif: value of tag %d runs past end of data", f.tag)
+			}
+			f.value = d.b[vo : vo+vs]
+			f.offset = uint32(vo)
+		}
+		fields = append(fields, f)
+	}
+
+	// Some writers leave out the next IFD offset after the last IFD.
+	var next uint32
+	if end+4 <= size {
+		next = d.order.Uint32(d.b[end:])
+	}
+	return fields, next, nil
+}
+
+// readSubIFD reads the IFD pointed to by the field f. It returns nil
+// if f isn't a valid pointer, or if the IFD is damaged and damaged
+// data is being skipped.
+func (d *decoder) readSubIFD(ctx context.Context, f *field) ([]field, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	off, ok := f.uint()
+	if !ok || off == 0 {
+		return nil, nil
+	}
+	fields, _, err := d.readIFD(off)
+	if err != nil && d.skipDamaged {
+		return nil, nil
+	}
+	return fields, err
+}
+
+// ifds holds the fields from each of the IFDs in a block of EXIF data.
+type ifds struct {
+	ifd0, exif, gps, interop, ifd1 []field
+}
+
+// walk reads IFD0 and the IFDs it leads to.
+func (d *decoder) walk(ctx context.Context) (*ifds, error) {
+	r := &ifds{}
+	ifd0, next, err := d.readIFD(d.order.Uint32(d.b[4:]))
+	if err != nil {
+		return nil, err
+	}
+	r.ifd0 = ifd0
+
+	for i := range ifd0 {
+		var err error
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			r.exif, err = d.readSubIFD(ctx, &ifd0[i])
+		case tagGPSIFD:
+			r.gps, err = d.readSubIFD(ctx, &ifd0[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range r.exif {
+		if r.exif[i].tag == tagInteropIFD {
+			if r.interop, err = d.readSubIFD(ctx, &r.exif[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// IFD1 describes the thumbnail. Any IFDs after it aren't part of
+	// EXIF, so they're ignored.
+	if next != 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r.ifd1, _, err = d.readIFD(next)
+		if err != nil {
+			if !d.skipDamaged {
+				return nil, err
+			}
+			r.ifd1 = nil
+		}
+	}
+	return r, nil
+}
+
+// Decode decodes EXIF metadata. The data must start with the TIFF
+// header, which is the byte order mark, the magic number 42, and the
+// offset of IFD0, though it may be preceded by the "Exif\x00\x00"
+// header used in jpeg files. The byte order comes from the TIFF
+// header, and is recorded in the LittleEndian field; isBigEndian is
+// only a hint from the caller and is ignored.
+//
+// Decode walks IFD0, the Exif, GPS and Interoperability IFDs, and
+// IFD1, and fills in the EXIF fields from IFD0, the GPS field from the
+// GPS IFD, the maker note from the Exif IFD, and the thumbnail from
+// IFD1. Each date and time is combined with its offset and subsecond
+// tags from the Exif IFD; it's in UTC if there's no offset. Every
+// other tag, including IFD0 and GPS tags that don't have the type or
+// number of values the EXIF standard gives for them, and the pixel
+// dimensions in the Exif IFD, is kept in the Tags field, apart from
+// the ones giving the offsets of other IFDs and image data. Offsets
+// that point outside the data fail the decode, unless the read options
+// ask for damaged data to be skipped.
+func Decode(ctx context.Context, b []byte, isBigEndian bool, opt ...image.ReadOption) (*metadata.EXIF, error) {
+	s, err := image.ResolveReadOptions(opt...)
+	if err != nil {
+		return nil, err
+	}
+
+	b = bytes.TrimPrefix(b, []byte(header))
+	if len(b) < 8 {
+		return nil, errors.New("exif: data too short for TIFF header")
+	}
+	d := &decoder{b: b, skipDamaged: s.Damage.SkipDamagedData}
+	switch string(b[:4]) {
+	case "II*\x00":
+		d.order = binary.LittleEndian
+	case "MM\x00*":
+		d.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid TIFF header")
+	}
+
+	r, err := d.walk(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &metadata.EXIF{LittleEndian: d.order == binary.LittleEndian}
+	used := setDateTimes(x, r)
+	for i := range r.ifd0 {
+		f := &r.ifd0[i]
+		if used[tagKey{metadata.IFD0, f.tag}] {
+			continue
+		}
+		if !setField(x, f) && !structural(metadata.IFD0, f.tag) {
+			x.Tags.Set(f.asTag(metadata.IFD0))
+		}
+	}
+	if r.gps != nil {
+		x.GPS = &metadata.GPS{}
+		for i := range r.gps {
+			f := &r.gps[i]
+			if !setGPSField(x.GPS, f) {
+				x.Tags.Set(f.asTag(metadata.GPSIFD))
+			}
+		}
+	}
+	for _, l := range []struct {
+		ifd    metadata.IFD
+		fields []field
+	}{
+		{metadata.ExifIFD, r.exif},
+		{metadata.InteropIFD, r.interop},
+		{metadata.IFD1, r.ifd1},
+	} {
+		for i := range l.fields {
+			f := &l.fields[i]
+			switch {
+			case structural(l.ifd, f.tag), used[tagKey{l.ifd, f.tag}]:
+			case l.ifd == metadata.ExifIFD && f.tag == tagMakerNote:
+				x.MakerNote = &metadata.MakerNote{
+					Data:      append([]byte(nil), f.value...),
+					Offset:    f.offset,
+					BigEndian: d.order == binary.BigEndian,
+				}
+			default:
+				x.Tags.Set(f.asTag(l.ifd))
+			}
+		}
+	}
+	if x.Thumbnail, err = d.thumbnail(r.ifd1); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// thumbnail returns a copy of the thumbnail that the IFD1 fields in
+// ifd1 point to, or nil if they don't point to one.
+func (d *decoder) thumbnail(ifd1 []field) ([]byte, error) {
+	var off, n uint32
+	for i := range ifd1 {
+		switch ifd1[i].tag {
+		case tagJPEGInterchangeFormat:
+			off, _ = ifd1[i].uint()
+		case tagJPEGInterchangeFormatLength:
+			n, _ = ifd1[i].uint()
+		}
+	}
+	if off == 0 || n == 0 {
+		return nil, nil
+	}
+	if uint64(off)+uint64(n) > uint64(len(d.b)) {
+		if d.skipDamaged {
+			return nil, nil
+		}
+		return nil, errors.New("exif: thumbnail runs past end of data")
+	}
+	return append([]byte(nil), d.b[off:off+n]...), nil
+}
+
+// setField sets the field of x that the IFD0 field f holds. It reports
+// whether there is one, and f has the type and number of values it
+// needs.
+func setField(x *metadata.EXIF, f *field) bool {
+	switch f.tag {
+	case tagImageWidth:
+		return setUint32(&x.ImageWidth, f)
+	case tagImageHeight:
+		return setUint32(&x.ImageHeight, f)
+	case tagBitsPerSample:
+		v, ok := f.uint16s()
+		if !ok || len(v) > len(x.BitsPerSample) {
+			return false
+		}
+		copy(x.BitsPerSample[:], v)
+	case tagCompression:
+		return setUint16(&x.Compression, f)
+	case tagPhotometricInterpretation:
+		return setUint16(&x.PhotometricInterpretation, f)
+	case tagOrientation:
+		return setUint16(&x.Orientation, f)
+	case tagSamplesPerPixel:
+		return setUint16(&x.SamplesPerPixel, f)
+	case tagPlanarConfiguration:
+		return setUint16(&x.PlanarConfiguration, f)
+	case tagYCbCrSubSampling:
+		v, ok := f.uint16s()
+		if !ok || len(v) != 2 {
+			return false
+		}
+		copy(x.YCbCrSubsampling[:], v)
+	case tagYCbCrPositioning:
+		return setUint16(&x.YCbCrPositioning, f)
+	case tagXResolution:
+		return setRational(&x.XResolution, f)
+	case tagYResolution:
+		return setRational(&x.YResolution, f)
+	case tagResolutionUnit:
+		return setUint16(&x.ResolutionUnit, f)
+	case tagTransferFunction:
+		// The table is given once for each of three channels, or just
+		// once if it applies to all of them.
+		v, ok := f.uint16s()
+		if !ok || (len(v) != 256 && len(v) != 3*256) {
+			return false
+		}
+		for c := range x.TransferFunction {
+			copy(x.TransferFunction[c][:], v[c*256%len(v):])
+		}
+	case tagWhitePoint:
+		return setRationals(x.WhitePoint[:], f)
+	case tagPrimaryChromaticities:
+		return setRationals(x.PrimaryChromaticities[:], f)
+	case tagYCbCrCoefficients:
+		return setRationals(x.YCbCrCoefficient[:], f)
+	case tagReferenceBlackWhite:
+		return setRationals(x.ReferenceBlackWhite[:], f)
+	case tagImageDescription:
+		return setString(&x.ImageDescription, f)
+	case tagMake:
+		return setString(&x.Make, f)
+	case tagModel:
+		return setString(&x.Model, f)
+	case tagSoftware:
+		return setString(&x.Software, f)
+	case tagArtist:
+		return setString(&x.Artist, f)
+	case tagCopyright:
+		// The photographer and editor copyrights are separated by a
+		// null, which is kept.
+		return setString(&x.Copyright, f)
+	default:
+		return false
+	}
+	return true
+}
+
+// setGPSField sets the field of g that the GPS IFD field f holds. It
+// reports whether there is one, and f has the type and number of
+// values it needs.
+func setGPSField(g *metadata.GPS, f *field) bool {
+	switch metadata.TagID(f.tag) {
+	case metadata.TagGPSVersionID:
+		if f.typ != typeByte || f.count != 4 {
+			return false
+		}
+		copy(g.VersionID[:], f.value)
+	case metadata.TagGPSLatitudeRef:
+		return setString(&g.LatitudeRef, f)
+	case metadata.TagGPSLatitude:
+		return setRationals(g.Latitude[:], f)
+	case metadata.TagGPSLongitudeRef:
+		return setString(&g.LongitudeRef, f)
+	case metadata.TagGPSLongitude:
+		return setRationals(g.Longitude[:], f)
+	case metadata.TagGPSAltitudeRef:
+		if f.typ != typeByte || f.count != 1 {
+			return false
+		}
+		g.AltitudeRef = f.value[0]
+	case metadata.TagGPSAltitude:
+		return setRational(&g.Altitude, f)
+	case metadata.TagGPSTimeStamp:
+		return setRationals(g.TimeStamp[:], f)
+	case metadata.TagGPSSpeedRef:
+		return setString(&g.SpeedRef, f)
+	case metadata.TagGPSSpeed:
+		return setRational(&g.Speed, f)
+	case metadata.TagGPSImgDirectionRef:
+		return setString(&g.ImgDirectionRef, f)
+	case metadata.TagGPSImgDirection:
+		return setRational(&g.ImgDirection, f)
+	case metadata.TagGPSMapDatum:
+		return setString(&g.MapDatum, f)
+	case metadata.TagGPSDateStamp:
+		return setString(&g.DateStamp, f)
+	default:
+		return false
+	}
+	return true
+}
+
+// setUint32 sets *p to the single value held by f, if it holds one
+// that fits in 32 bits.
+func setUint32(p *uint32, f *field) bool {
+	if f.count != 1 {
+		return false
+	}
+	v, ok := f.uint()
+	if ok {
+		*p = v
+	}
+	return ok
+}
+
+// setUint16 sets *p to the single 16 bit value held by f, if it holds
+// one.
+func setUint16(p *uint16, f *field) bool {
+	v, ok := f.uint16s()
+	if !ok || len(v) != 1 {
+		return false
+	}
+	*p = v[0]
+	return true
+}
+
+// setRational sets *p to the single rational held by f, if it holds
+// one.
+func setRational(p *metadata.Rational, f *field) bool {
+	v, ok := f.rationals()
+	if !ok || len(v) != 1 {
+		return false
+	}
+	*p = v[0]
+	return true
+}
+
+// setRationals sets dst to the rationals held by f, if it holds
+// exactly len(dst) of them.
+func setRationals(dst []metadata.Rational, f *field) bool {
+	v, ok := f.rationals()
+	if !ok || len(v) != len(dst) {
+		return false
+	}
+	copy(dst, v)
+	return true
+}
+
+// setString sets *p to the string held by f, if it holds one.
+func setString(p *string, f *field) bool {
+	v, ok := f.string()
+	if ok {
+		*p = v
+	}
+	return ok
+}
+
+// asTag returns f as a metadata.Tag in the given IFD, with its value
+// converted to big endian byte order.
+func (f *field) asTag(ifd metadata.IFD) metadata.Tag {
+	t := metadata.Tag{IFD: ifd, ID: metadata.TagID(f.tag), Type: metadata.TagType(f.typ), Count: f.count}
+	t.Value = convertOrder(f.typ, f.value, f.order, binary.BigEndian)
+	return t
+}
+
+// convertOrder returns a copy of the values in b, which have type typ
+// and are in the byte order from, in the byte order to.
+func convertOrder(typ uint16, b []byte, from, to binary.ByteOrder) []byte {
+	c := append([]byte(nil), b...)
+	if from == to {
+		return c
+	}
+	// Rationals are pairs of 32 bit values.
+	size := typeSize(typ)
+	switch typ {
+	case typeRational, typeSRational:
+		size = 4
+	}
+	for i := 0; i+int(size) <= len(c); i += int(size) {
+		switch size {
+		case 2:
+			to.PutUint16(c[i:], from.Uint16(c[i:]))
+		case 4:
+			to.PutUint32(c[i:], from.Uint32(c[i:]))
+		case 8:
+			to.PutUint64(c[i:], from.Uint64(c[i:]))
+		}
+	}
+	return c
+}
+
+// structural reports whether the tag with the given ID in the given IFD
+// gives the layout of the EXIF data, such as the offset of another IFD
+// or of image data. Those are meaningless once the data is decoded, so
+// they aren't kept as tags.
+func structural(ifd metadata.IFD, id uint16) bool {
+	switch ifd {
+	case metadata.IFD0, metadata.IFD1:
+		switch metadata.TagID(id) {
+		case metadata.TagExifIFDPointer, metadata.TagGPSInfoIFDPointer,
+			metadata.TagJPEGInterchangeFormat, metadata.TagJPEGInterchangeFormatLength,
+			metadata.TagStripOffsets, metadata.TagStripByteCounts,
+			tagTileOffsets, tagTileByteCounts:
+			return true
+		}
+	case metadata.ExifIFD:
+		return metadata.TagID(id) == metadata.TagInteroperabilityIFDPointer
+	}
+	return false
+}
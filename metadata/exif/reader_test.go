@@ -0,0 +1,348 @@
+package exif
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// testEntry is an IFD entry for buildTIFF. If sub is set the entry is
+// a LONG pointing to that IFD, and value is ignored.
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+	sub      *testIFD
+}
+
+// testIFD is an IFD for buildTIFF.
+type testIFD struct {
+	entries []testEntry
+	next    *testIFD
+}
+
+// tiffBuilder lays out TIFF data for the tests.
+type tiffBuilder struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// buildTIFF returns TIFF data in the given byte order whose IFD0 is
+// ifd0. Each IFD is followed by the values that don't fit in its
+// entries and then by the IFDs it points to.
+func buildTIFF(order binary.ByteOrder, ifd0 *testIFD) []byte {
+	t := &tiffBuilder{order: order}
+	if order == binary.BigEndian {
+		t.b = []byte("MM\x00*\x00\x00\x00\x08")
+	} else {
+		t.b = []byte("II*\x00\x08\x00\x00\x00")
+	}
+	t.writeIFD(ifd0)
+	return t.b
+}
+
+func (t *tiffBuilder) writeIFD(ifd *testIFD) uint32 {
+	off := len(t.b)
+	t.b = append(t.b, make([]byte, 2+12*len(ifd.entries)+4)...)
+	t.order.PutUint16(t.b[off:], uint16(len(ifd.entries)))
+	for i, e := range ifd.entries {
+		p := off + 2 + 12*i
+		t.order.PutUint16(t.b[p:], e.tag)
+		t.order.PutUint16(t.b[p+2:], e.typ)
+		t.order.PutUint32(t.b[p+4:], e.count)
+		value := e.value
+		if e.sub != nil {
+			value = t.longs(t.writeIFD(e.sub))
+		}
+		if len(value) <= 4 {
+			copy(t.b[p+8:p+12], value)
+			continue
+		}
+		t.order.PutUint32(t.b[p+8:], uint32(len(t.b)))
+		t.b = append(t.b, value...)
+		if len(t.b)%2 != 0 {
+			t.b = append(t.b, 0)
+		}
+	}
+	if ifd.next != nil {
+		next := t.writeIFD(ifd.next)
+		t.order.PutUint32(t.b[off+2+12*len(ifd.entries):], next)
+	}
+	return uint32(off)
+}
+
+func (t *tiffBuilder) shorts(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, n := range v {
+		t.order.PutUint16(b[2*i:], n)
+	}
+	return b
+}
+
+func (t *tiffBuilder) longs(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		t.order.PutUint32(b[4*i:], n)
+	}
+	return b
+}
+
+// The entry helpers return an entry holding values of a single type.
+
+func (t *tiffBuilder) short(tag uint16, v ...uint16) testEntry {
+	return testEntry{tag: tag, typ: typeShort, count: uint32(len(v)), value: t.shorts(v...)}
+}
+
+func (t *tiffBuilder) long(tag uint16, v ...uint32) testEntry {
+	return testEntry{tag: tag, typ: typeLong, count: uint32(len(v)), value: t.longs(v...)}
+}
+
+func (t *tiffBuilder) rational(tag uint16, v ...uint32) testEntry {
+	return testEntry{tag: tag, typ: typeRational, count: uint32(len(v) / 2), value: t.longs(v...)}
+}
+
+func ascii(tag uint16, s string) testEntry {
+	return testEntry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), value: []byte(s + "\x00")}
+}
+
+// testEXIF returns TIFF data in the given byte order with every IFD
+// and a value for every field in metadata.EXIF.
+func testEXIF(order binary.ByteOrder) []byte {
+	t := &tiffBuilder{order: order}
+	tf := make([]uint16, 3*256)
+	for i := range tf {
+		tf[i] = uint16(i)
+	}
+	interop := &testIFD{entries: []testEntry{ascii(1, "R98")}}
+	exifIFD := &testIFD{entries: []testEntry{
+		ascii(36867, "2019:05:06 07:08:09"),
+		{tag: tagInteropIFD, typ: typeLong, count: 1, sub: interop},
+	}}
+	gps := &testIFD{entries: []testEntry{
+		{tag: 0, typ: typeByte, count: 4, value: []byte{2, 3, 0, 0}},
+		ascii(1, "N"),
+		t.rational(2, 51, 1, 30, 1, 0, 1),
+	}}
+	ifd1 := &testIFD{entries: []testEntry{t.short(tagCompression, 6)}}
+	return buildTIFF(order, &testIFD{
+		entries: []testEntry{
+			t.long(tagImageWidth, 4000),
+			t.short(tagImageHeight, 3000),
+			t.short(tagBitsPerSample, 8, 8, 8),
+			t.short(tagCompression, 1),
+			t.short(tagPhotometricInterpretation, 2),
+			ascii(tagImageDescription, "a description"),
+			ascii(tagMake, "Maker"),
+			ascii(tagModel, "Model 1"),
+			t.short(tagOrientation, 6),
+			t.short(tagSamplesPerPixel, 3),
+			t.rational(tagXResolution, 300, 1),
+			t.rational(tagYResolution, 600, 2),
+			t.short(tagPlanarConfiguration, 1),
+			t.short(tagResolutionUnit, 2),
+			t.short(tagTransferFunction, tf...),
+			ascii(tagSoftware, "Software 2.0"),
+			ascii(tagDateTime, "2020:01:02 03:04:05"),
+			ascii(tagArtist, "An Artist"),
+			t.rational(tagWhitePoint, 3127, 10000, 3290, 10000),
+			t.rational(tagPrimaryChromaticities, 64, 100, 33, 100, 30, 100, 60, 100, 15, 100, 6, 100),
+			t.rational(tagYCbCrCoefficients, 299, 1000, 587, 1000, 114, 1000),
+			t.short(tagYCbCrSubSampling, 2, 1),
+			t.short(tagYCbCrPositioning, 2),
+			t.rational(tagReferenceBlackWhite, 0, 1, 255, 1, 128, 1, 255, 1, 128, 1, 255, 1),
+			ascii(tagCopyright, "Photographer\x00Editor"),
+			{tag: tagExifIFD, typ: typeLong, count: 1, sub: exifIFD},
+			{tag: tagGPSIFD, typ: typeLong, count: 1, sub: gps},
+		},
+		next: ifd1,
+	})
+}
+
+func TestDecode(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, prefix := range []string{"", header} {
+			b := append([]byte(prefix), testEXIF(order)...)
+			x, err := Decode(context.Background(), b, order == binary.BigEndian)
+			if err != nil {
+				t.Fatalf("%v, prefix %q: Decode: %v", order, prefix, err)
+			}
+
+			if x.ImageWidth != 4000 || x.ImageHeight != 3000 {
+				t.Errorf("%v: got size %d x %d, want 4000 x 3000", order, x.ImageWidth, x.ImageHeight)
+			}
+			if x.BitsPerSample != [3]uint16{8, 8, 8} || x.SamplesPerPixel != 3 {
+				t.Errorf("%v: got %v bits in %d samples per pixel", order, x.BitsPerSample, x.SamplesPerPixel)
+			}
+			if x.Compression != 1 || x.PhotometricInterpretation != 2 || x.Orientation != 6 || x.PlanarConfiguration != 1 {
+				t.Errorf("%v: got compression %d, photometric interpretation %d, orientation %d, planar configuration %d",
+					order, x.Compression, x.PhotometricInterpretation, x.Orientation, x.PlanarConfiguration)
+			}
+			if x.XResolution != (metadata.Rational{Numerator: 300, Denomenator: 1}) ||
+				x.YResolution != (metadata.Rational{Numerator: 600, Denomenator: 2}) || x.ResolutionUnit != 2 {
+				t.Errorf("%v: got resolution %v x %v unit %d", order, x.XResolution, x.YResolution, x.ResolutionUnit)
+			}
+			if x.TransferFunction[0][5] != 5 || x.TransferFunction[1][5] != 256+5 || x.TransferFunction[2][255] != 3*256-1 {
+				t.Errorf("%v: got wrong transfer function", order)
+			}
+			if x.WhitePoint[1] != (metadata.Rational{Numerator: 3290, Denomenator: 10000}) ||
+				x.PrimaryChromaticities[5] != (metadata.Rational{Numerator: 6, Denomenator: 100}) ||
+				x.YCbCrCoefficient[2] != (metadata.Rational{Numerator: 114, Denomenator: 1000}) ||
+				x.ReferenceBlackWhite[3] != (metadata.Rational{Numerator: 255, Denomenator: 1}) {
+				t.Errorf("%v: got wrong color rationals", order)
+			}
+			if x.YCbCrSubsampling != [2]uint16{2, 1} || x.YCbCrPositioning != 2 {
+				t.Errorf("%v: got subsampling %v, positioning %d", order, x.YCbCrSubsampling, x.YCbCrPositioning)
+			}
+			want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if x.DateTime == nil || !x.DateTime.Equal(want) {
+				t.Errorf("%v: got date/time %v, want %v", order, x.DateTime, want)
+			}
+			strs := []string{x.ImageDescription, x.Make, x.Model, x.Software, x.Artist, x.Copyright}
+			wantStrs := []string{"a description", "Maker", "Model 1", "Software 2.0", "An Artist", "Photographer\x00Editor"}
+			if strings.Join(strs, "|") != strings.Join(wantStrs, "|") {
+				t.Errorf("%v: got strings %q, want %q", order, strs, wantStrs)
+			}
+		}
+	}
+}
+
+func TestDecodeIFDs(t *testing.T) {
+	b := testEXIF(binary.LittleEndian)
+	d := &decoder{b: b, order: binary.LittleEndian}
+	r, err := d.walk(context.Background())
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(r.ifd0) != 27 || len(r.exif) != 2 || len(r.gps) != 3 || len(r.interop) != 1 || len(r.ifd1) != 1 {
+		t.Fatalf("got IFD sizes %d, %d, %d, %d, %d, want 27, 2, 3, 1, 1",
+			len(r.ifd0), len(r.exif), len(r.gps), len(r.interop), len(r.ifd1))
+	}
+	if s, _ := r.interop[0].string(); s != "R98" {
+		t.Errorf("got interoperability index %q, want R98", s)
+	}
+	if v, _ := r.gps[2].floats(); len(v) != 3 || v[0] != 51 || v[1] != 30 {
+		t.Errorf("got GPS latitude %v, want [51 30 0]", v)
+	}
+}
+
+func TestDecodePixelDimensions(t *testing.T) {
+	// The pixel dimensions in the Exif IFD stay there, rather than
+	// turning into IFD0 tags when the data is encoded again.
+	tb := &tiffBuilder{order: binary.BigEndian}
+	b := buildTIFF(binary.BigEndian, &testIFD{entries: []testEntry{
+		{tag: tagExifIFD, typ: typeLong, count: 1, sub: &testIFD{entries: []testEntry{
+			tb.long(tagPixelXDimension, 640),
+			tb.short(tagPixelYDimension, 480),
+		}}},
+	}})
+	x, err := Decode(context.Background(), b, true)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if x.ImageWidth != 0 || x.ImageHeight != 0 {
+		t.Errorf("got IFD0 size %d x %d, want none", x.ImageWidth, x.ImageHeight)
+	}
+	if _, ok := x.Tags.Get(metadata.ExifIFD, metadata.TagPixelXDimension); !ok {
+		t.Error("no PixelXDimension tag")
+	}
+	b, err = Encode(context.Background(), x, true)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	d := &decoder{b: b, order: binary.BigEndian}
+	r, err := d.walk(context.Background())
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	for _, f := range r.ifd0 {
+		if f.tag == tagImageWidth || f.tag == tagImageHeight {
+			t.Errorf("got IFD0 tag %d", f.tag)
+		}
+	}
+}
+
+func TestFieldTypes(t *testing.T) {
+	o := binary.BigEndian
+	f64 := make([]byte, 8)
+	o.PutUint64(f64, math.Float64bits(-2.5))
+	tests := []struct {
+		f    field
+		want []float64
+	}{
+		{field{typ: typeByte, count: 2, value: []byte{1, 255}}, []float64{1, 255}},
+		{field{typ: typeSByte, count: 2, value: []byte{1, 255}}, []float64{1, -1}},
+		{field{typ: typeUndefined, count: 1, value: []byte{7}}, []float64{7}},
+		{field{typ: typeShort, count: 1, value: []byte{0xff, 0xfe}}, []float64{65534}},
+		{field{typ: typeSShort, count: 1, value: []byte{0xff, 0xfe}}, []float64{-2}},
+		{field{typ: typeLong, count: 1, value: []byte{0xff, 0xff, 0xff, 0xfe}}, []float64{4294967294}},
+		{field{typ: typeSLong, count: 1, value: []byte{0xff, 0xff, 0xff, 0xfe}}, []float64{-2}},
+		{field{typ: typeRational, count: 1, value: []byte{0, 0, 0, 3, 0, 0, 0, 2}}, []float64{1.5}},
+		{field{typ: typeSRational, count: 1, value: []byte{0xff, 0xff, 0xff, 0xfd, 0, 0, 0, 2}}, []float64{-1.5}},
+		{field{typ: typeFloat, count: 1, value: []byte{0x3f, 0xc0, 0, 0}}, []float64{1.5}},
+		{field{typ: typeDouble, count: 1, value: f64}, []float64{-2.5}},
+	}
+	for _, tc := range tests {
+		tc.f.order = o
+		got, ok := tc.f.floats()
+		if !ok || len(got) != len(tc.want) {
+			t.Errorf("type %d: got %v, %v, want %v", tc.f.typ, got, ok, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("type %d: got %v, want %v", tc.f.typ, got, tc.want)
+				break
+			}
+		}
+	}
+
+	f := field{typ: typeRational, count: 1, value: make([]byte, 8), order: o}
+	if v, _ := f.floats(); !math.IsNaN(v[0]) {
+		t.Errorf("zero denominator: got %v, want NaN", v[0])
+	}
+	if _, ok := f.ints(); ok {
+		t.Error("rational field reported as holding integers")
+	}
+}
+
+func TestDecodeDamaged(t *testing.T) {
+	tb := &tiffBuilder{order: binary.BigEndian}
+	good := buildTIFF(binary.BigEndian, &testIFD{entries: []testEntry{
+		ascii(tagMake, "Maker"),
+		ascii(tagModel, "Model 1"),
+		tb.short(tagOrientation, 3),
+	}})
+
+	// Point the model's value past the end of the data.
+	bad := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(bad[8+2+12+8:], uint32(len(bad)))
+	if _, err := Decode(context.Background(), bad, true); err == nil {
+		t.Error("value out of range: got nil error")
+	}
+	x, err := Decode(context.Background(), bad, true, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("value out of range, skipping damaged data: %v", err)
+	}
+	if x.Make != "Maker" || x.Model != "" || x.Orientation != 3 {
+		t.Errorf("got make %q, model %q, orientation %d, want Maker, empty, 3", x.Make, x.Model, x.Orientation)
+	}
+
+	// Claim more entries than there's room for.
+	bad = append([]byte(nil), good...)
+	binary.BigEndian.PutUint16(bad[8:], 100)
+	if _, err := Decode(context.Background(), bad, true); err == nil {
+		t.Error("too many entries: got nil error")
+	}
+
+	for _, b := range []string{"", "MM\x00*", "XX\x00*\x00\x00\x00\x08", "MM\x00*\x00\x01\x00\x00"} {
+		if _, err := Decode(context.Background(), []byte(b), true); err == nil {
+			t.Errorf("%q: got nil error", b)
+		}
+	}
+}
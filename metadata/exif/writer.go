@@ -0,0 +1,420 @@
+package exif
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// exifVersion is the version of the EXIF standard that Encode writes.
+const exifVersion = "0232"
+
+// gpsVersion is the version of the GPS IFD that Encode writes.
+var gpsVersion = [4]uint8{2, 3, 0, 0}
+
+// entry is an IFD entry waiting to be encoded.
+type entry struct {
+	field
+	// sub is the IFD that a pointer entry points to. Its offset is
+	// filled in as the entry's value when it's laid out.
+	sub *ifd
+	// blob is data that the entry points to, such as the thumbnail.
+	// Its offset is filled in as the entry's value when it's laid out.
+	blob []byte
+	// at is the offset the entry's values should be put at, if they
+	// don't fit in the entry. Padding is added to reach it if it's
+	// past the end of the data, and it's ignored otherwise.
+	at uint32
+	// relocate, if set, is called with the entry's values and the
+	// offset they're being put at, if they don't fit in the entry,
+	// and returns the values to write.
+	relocate func(v []byte, off uint32) []byte
+}
+
+// ifd is an IFD waiting to be encoded.
+type ifd struct {
+	entries []entry
+	next    *ifd
+}
+
+// encoder holds the state for encoding a single block of EXIF data.
+type encoder struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// add adds a field of type typ holding the values in v, which are
+// already in the encoder's byte order, to the IFD.
+func (d *ifd) add(tag, typ uint16, count uint32, v []byte) {
+	d.entries = append(d.entries, entry{field: field{tag: tag, typ: typ, count: count, value: v}})
+}
+
+// has reports whether the IFD has an entry for tag.
+func (d *ifd) has(tag uint16) bool {
+	for i := range d.entries {
+		if d.entries[i].tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// addTags adds the tags in x.Tags that belong in the IFD identified by
+// which, apart from any the IFD already has an entry for.
+func (e *encoder) addTags(d *ifd, x *metadata.EXIF, which metadata.IFD) error {
+	for _, t := range x.Tags.All() {
+		if t.IFD != which || d.has(uint16(t.ID)) || structural(which, uint16(t.ID)) {
+			continue
+		}
+		size := t.Type.Size()
+		if size == 0 {
+			return fmt.Errorf("exif: tag %d in %v has unknown type %d", t.ID, which, t.Type)
+		}
+		if uint64(len(t.Value)) != uint64(t.Count)*uint64(size) {
+			return fmt.Errorf("exif: tag %d in %v has %d bytes of values, want %d", t.ID, which, len(t.Value), uint64(t.Count)*uint64(size))
+		}
+		d.add(uint16(t.ID), uint16(t.Type), t.Count, convertOrder(uint16(t.Type), t.Value, binary.BigEndian, e.order))
+	}
+	return nil
+}
+
+// pointTo adds an entry with the given tag to d pointing to sub.
+func (d *ifd) pointTo(tag uint16, sub *ifd) {
+	d.entries = append(d.entries, entry{field: field{tag: tag, typ: typeLong, count: 1}, sub: sub})
+}
+
+// The add methods add a field holding the given values to the IFD.
+
+func (e *encoder) addShorts(d *ifd, tag uint16, v ...uint16) {
+	b := make([]byte, 2*len(v))
+	for i, n := range v {
+		e.order.PutUint16(b[2*i:], n)
+	}
+	d.add(tag, typeShort, uint32(len(v)), b)
+}
+
+func (e *encoder) addLong(d *ifd, tag uint16, v uint32) {
+	b := make([]byte, 4)
+	e.order.PutUint32(b, v)
+	d.add(tag, typeLong, 1, b)
+}
+
+func (e *encoder) addRationals(d *ifd, tag uint16, v ...metadata.Rational) {
+	b := make([]byte, 8*len(v))
+	for i, r := range v {
+		e.order.PutUint32(b[8*i:], r.Numerator)
+		e.order.PutUint32(b[8*i+4:], r.Denomenator)
+	}
+	d.add(tag, typeRational, uint32(len(v)), b)
+}
+
+func addString(d *ifd, tag uint16, s string) {
+	d.add(tag, typeASCII, uint32(len(s)+1), append([]byte(s), 0))
+}
+
+// The maybeAdd methods add a field only if its value isn't zero.
+
+func (e *encoder) maybeAddShort(d *ifd, tag uint16, v uint16) {
+	if v != 0 {
+		e.addShorts(d, tag, v)
+	}
+}
+
+func (e *encoder) maybeAddRationals(d *ifd, tag uint16, v ...metadata.Rational) {
+	for _, r := range v {
+		if r != (metadata.Rational{}) {
+			e.addRationals(d, tag, v...)
+			return
+		}
+	}
+}
+
+func maybeAddString(d *ifd, tag uint16, s string) {
+	if s != "" {
+		addString(d, tag, s)
+	}
+}
+
+// pad appends a zero byte if the data has an odd length, as TIFF
+// offsets should be even.
+func (e *encoder) pad() {
+	if len(e.b)%2 != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+// offset returns the current length of the data as an offset, or an
+// error if it's too large for one.
+func (e *encoder) offset() (uint32, error) {
+	if uint64(len(e.b)) > math.MaxUint32 {
+		return 0, errors.New("exif: data too large")
+	}
+	return uint32(len(e.b)), nil
+}
+
+// writeIFD writes d, then the values that don't fit in its entries,
+// the IFDs and data they point to, and d's next IFD. It returns the
+// offset d was written at.
+func (e *encoder) writeIFD(ctx context.Context, d *ifd) (uint32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(d.entries) > math.MaxUint16 {
+		return 0, errors.New("exif: too many fields in IFD")
+	}
+	// TIFF readers may expect the entries to be sorted by tag.
+	sort.SliceStable(d.entries, func(i, j int) bool { return d.entries[i].tag < d.entries[j].tag })
+
+	e.pad()
+	off, err := e.offset()
+	if err != nil {
+		return 0, err
+	}
+	e.b = append(e.b, make([]byte, 2+12*len(d.entries)+4)...)
+	e.order.PutUint16(e.b[off:], uint16(len(d.entries)))
+
+	// Pointer entries are filled in once the data they point to has
+	// been written.
+	var pointers []int
+	for i := range d.entries {
+		f := &d.entries[i].field
+		p := int(off) + 2 + 12*i
+		e.order.PutUint16(e.b[p:], f.tag)
+		e.order.PutUint16(e.b[p+2:], f.typ)
+		e.order.PutUint32(e.b[p+4:], f.count)
+		switch {
+		case d.entries[i].sub != nil || d.entries[i].blob != nil:
+			pointers = append(pointers, i)
+		case len(f.value) <= 4:
+			copy(e.b[p+8:p+12], f.value)
+		default:
+			e.pad()
+			if at := d.entries[i].at; uint64(at) > uint64(len(e.b)) && at%2 == 0 {
+				e.b = append(e.b, make([]byte, int(at)-len(e.b))...)
+			}
+			vo, err := e.offset()
+			if err != nil {
+				return 0, err
+			}
+			e.order.PutUint32(e.b[p+8:], vo)
+			v := f.value
+			if r := d.entries[i].relocate; r != nil {
+				v = r(v, vo)
+			}
+			e.b = append(e.b, v...)
+		}
+	}
+
+	for _, i := range pointers {
+		var po uint32
+		if sub := d.entries[i].sub; sub != nil {
+			if po, err = e.writeIFD(ctx, sub); err != nil {
+				return 0, err
+			}
+		} else {
+			e.pad()
+			if po, err = e.offset(); err != nil {
+				return 0, err
+			}
+			e.b = append(e.b, d.entries[i].blob...)
+		}
+		e.order.PutUint32(e.b[int(off)+2+12*i+8:], po)
+	}
+
+	if d.next != nil {
+		next, err := e.writeIFD(ctx, d.next)
+		if err != nil {
+			return 0, err
+		}
+		e.order.PutUint32(e.b[int(off)+2+12*len(d.entries):], next)
+	}
+	return off, nil
+}
+
+// Encode encodes EXIF metadata as TIFF data in big endian byte order if
+// isBigEndian is set, or little endian byte order otherwise. The data
+// doesn't include the "Exif\x00\x00" header used in jpeg files. Write
+// options are accepted so that image encoders can pass their own on,
+// but none of them affect EXIF data.
+//
+// Fields with a zero value are left out. The tags in x.Tags are written
+// to their IFDs, except where a field of x gives the same tag. IFD0
+// always points to an Exif IFD, which gives the EXIF version if x.Tags
+// doesn't. The GPS, Interoperability and IFD1 IFDs are only written if
+// they have tags or, for the GPS IFD, x.GPS is set, or, for IFD1,
+// there's a thumbnail. The maker note is kept working where its maker's
+// format is known, as described for makerNoteEntry. Dates and times
+// are split into the date and time, offset and subsecond tags, with no
+// offset for times whose location is time.UTC. Decoding the data gives
+// back x, along with the EXIF version tag.
+func Encode(ctx context.Context, x *metadata.EXIF, isBigEndian bool, opt ...image.WriteOption) ([]byte, error) {
+	if x == nil {
+		return nil, errors.New("exif: nil EXIF data")
+	}
+	e := &encoder{order: binary.LittleEndian, b: []byte("II*\x00\x08\x00\x00\x00")}
+	if isBigEndian {
+		e.order = binary.BigEndian
+		e.b = []byte("MM\x00*\x00\x00\x00\x08")
+	}
+
+	ifd0 := &ifd{}
+	if x.ImageWidth != 0 {
+		e.addLong(ifd0, tagImageWidth, x.ImageWidth)
+	}
+	if x.ImageHeight != 0 {
+		e.addLong(ifd0, tagImageHeight, x.ImageHeight)
+	}
+	switch bps := x.BitsPerSample; {
+	case bps[1] != 0 || bps[2] != 0:
+		e.addShorts(ifd0, tagBitsPerSample, bps[:]...)
+	case bps[0] != 0:
+		e.addShorts(ifd0, tagBitsPerSample, bps[0])
+	}
+	e.maybeAddShort(ifd0, tagCompression, x.Compression)
+	e.maybeAddShort(ifd0, tagPhotometricInterpretation, x.PhotometricInterpretation)
+	maybeAddString(ifd0, tagImageDescription, x.ImageDescription)
+	maybeAddString(ifd0, tagMake, x.Make)
+	maybeAddString(ifd0, tagModel, x.Model)
+	e.maybeAddShort(ifd0, tagOrientation, x.Orientation)
+	e.maybeAddShort(ifd0, tagSamplesPerPixel, x.SamplesPerPixel)
+	e.maybeAddRationals(ifd0, tagXResolution, x.XResolution)
+	e.maybeAddRationals(ifd0, tagYResolution, x.YResolution)
+	e.maybeAddShort(ifd0, tagPlanarConfiguration, x.PlanarConfiguration)
+	e.maybeAddShort(ifd0, tagResolutionUnit, x.ResolutionUnit)
+	if x.TransferFunction != ([3][256]uint16{}) {
+		tf := make([]uint16, 0, 3*256)
+		for c := range x.TransferFunction {
+			tf = append(tf, x.TransferFunction[c][:]...)
+		}
+		e.addShorts(ifd0, tagTransferFunction, tf...)
+	}
+	maybeAddString(ifd0, tagSoftware, x.Software)
+	maybeAddString(ifd0, tagArtist, x.Artist)
+	e.maybeAddRationals(ifd0, tagWhitePoint, x.WhitePoint[:]...)
+	e.maybeAddRationals(ifd0, tagPrimaryChromaticities, x.PrimaryChromaticities[:]...)
+	e.maybeAddRationals(ifd0, tagYCbCrCoefficients, x.YCbCrCoefficient[:]...)
+	if x.YCbCrSubsampling != ([2]uint16{}) {
+		e.addShorts(ifd0, tagYCbCrSubSampling, x.YCbCrSubsampling[:]...)
+	}
+	e.maybeAddShort(ifd0, tagYCbCrPositioning, x.YCbCrPositioning)
+	e.maybeAddRationals(ifd0, tagReferenceBlackWhite, x.ReferenceBlackWhite[:]...)
+	maybeAddString(ifd0, tagCopyright, x.Copyright)
+
+	exifIFD := &ifd{}
+	addDateTimes(ifd0, exifIFD, x)
+	if err := e.addTags(ifd0, x, metadata.IFD0); err != nil {
+		return nil, err
+	}
+
+	if x.MakerNote != nil {
+		exifIFD.entries = append(exifIFD.entries, e.makerNoteEntry(x.MakerNote, x.Make))
+	}
+	if err := e.addTags(exifIFD, x, metadata.ExifIFD); err != nil {
+		return nil, err
+	}
+	if !exifIFD.has(tagExifVersion) {
+		exifIFD.add(tagExifVersion, typeUndefined, uint32(len(exifVersion)), []byte(exifVersion))
+	}
+	ifd0.pointTo(tagExifIFD, exifIFD)
+
+	interop := &ifd{}
+	if err := e.addTags(interop, x, metadata.InteropIFD); err != nil {
+		return nil, err
+	}
+	if len(interop.entries) > 0 {
+		exifIFD.pointTo(tagInteropIFD, interop)
+	}
+	gps := &ifd{}
+	if x.GPS != nil {
+		e.addGPS(gps, x.GPS)
+	}
+	if err := e.addTags(gps, x, metadata.GPSIFD); err != nil {
+		return nil, err
+	}
+	if len(gps.entries) > 0 {
+		ifd0.pointTo(tagGPSIFD, gps)
+	}
+
+	ifd1 := &ifd{}
+	if err := e.addTags(ifd1, x, metadata.IFD1); err != nil {
+		return nil, err
+	}
+	if len(x.Thumbnail) > 0 {
+		e.addThumbnail(ifd1, x)
+	}
+	if len(ifd1.entries) > 0 {
+		ifd0.next = ifd1
+	}
+
+	if _, err := e.writeIFD(ctx, ifd0); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+// addGPS adds the fields of g with a non-zero value to the GPS IFD,
+// along with the GPS version, which defaults to 2.3.0.0.
+func (e *encoder) addGPS(d *ifd, g *metadata.GPS) {
+	version := g.VersionID
+	if version == ([4]uint8{}) {
+		version = gpsVersion
+	}
+	d.add(uint16(metadata.TagGPSVersionID), typeByte, 4, version[:])
+	maybeAddString(d, uint16(metadata.TagGPSLatitudeRef), g.LatitudeRef)
+	e.maybeAddRationals(d, uint16(metadata.TagGPSLatitude), g.Latitude[:]...)
+	maybeAddString(d, uint16(metadata.TagGPSLongitudeRef), g.LongitudeRef)
+	e.maybeAddRationals(d, uint16(metadata.TagGPSLongitude), g.Longitude[:]...)
+	// An altitude ref of zero means above sea level, so it's only
+	// left out if there's no altitude.
+	if g.AltitudeRef != 0 || g.Altitude != (metadata.Rational{}) {
+		d.add(uint16(metadata.TagGPSAltitudeRef), typeByte, 1, []byte{g.AltitudeRef})
+	}
+	e.maybeAddRationals(d, uint16(metadata.TagGPSAltitude), g.Altitude)
+	e.maybeAddRationals(d, uint16(metadata.TagGPSTimeStamp), g.TimeStamp[:]...)
+	maybeAddString(d, uint16(metadata.TagGPSSpeedRef), g.SpeedRef)
+	e.maybeAddRationals(d, uint16(metadata.TagGPSSpeed), g.Speed)
+	maybeAddString(d, uint16(metadata.TagGPSImgDirectionRef), g.ImgDirectionRef)
+	e.maybeAddRationals(d, uint16(metadata.TagGPSImgDirection), g.ImgDirection)
+	maybeAddString(d, uint16(metadata.TagGPSMapDatum), g.MapDatum)
+	maybeAddString(d, uint16(metadata.TagGPSDateStamp), g.DateStamp)
+}
+
+// addThumbnail adds the entries pointing to the thumbnail in x to
+// ifd1, along with the other entries IFD1 needs that it doesn't
+// already have. Those give the same resolution as IFD0, or 72 pixels
+// per inch if IFD0 doesn't have one.
+func (e *encoder) addThumbnail(ifd1 *ifd, x *metadata.EXIF) {
+	xres, yres, unit := x.XResolution, x.YResolution, x.ResolutionUnit
+	if xres == (metadata.Rational{}) || yres == (metadata.Rational{}) {
+		xres = metadata.Rational{Numerator: 72, Denomenator: 1}
+		yres, unit = xres, 2
+	}
+	if unit == 0 {
+		unit = 2
+	}
+
+	if !ifd1.has(tagCompression) {
+		// Compression 6 means the thumbnail is jpeg compressed.
+		e.addShorts(ifd1, tagCompression, 6)
+	}
+	if !ifd1.has(tagXResolution) {
+		e.addRationals(ifd1, tagXResolution, xres)
+	}
+	if !ifd1.has(tagYResolution) {
+		e.addRationals(ifd1, tagYResolution, yres)
+	}
+	if !ifd1.has(tagResolutionUnit) {
+		e.addShorts(ifd1, tagResolutionUnit, unit)
+	}
+	ifd1.entries = append(ifd1.entries, entry{
+		field: field{tag: tagJPEGInterchangeFormat, typ: typeLong, count: 1},
+		blob:  x.Thumbnail,
+	})
+	e.addLong(ifd1, tagJPEGInterchangeFormatLength, uint32(len(x.Thumbnail)))
+}
@@ -0,0 +1,286 @@
+package exif
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/png"
+)
+
+// fullEXIF returns EXIF metadata with every field set.
+func fullEXIF() *metadata.EXIF {
+	r := func(n, d uint32) metadata.Rational { return metadata.Rational{Numerator: n, Denomenator: d} }
+	dt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	x := &metadata.EXIF{
+		ImageWidth:                640,
+		ImageHeight:               480,
+		BitsPerSample:             [3]uint16{8, 8, 8},
+		Compression:               1,
+		PhotometricInterpretation: 2,
+		Orientation:               1,
+		SamplesPerPixel:           3,
+		PlanarConfiguration:       1,
+		YCbCrSubsampling:          [2]uint16{2, 2},
+		YCbCrPositioning:          1,
+		XResolution:               r(72, 1),
+		YResolution:               r(72, 1),
+		ResolutionUnit:            2,
+		WhitePoint:                [2]metadata.Rational{r(3127, 10000), r(3290, 10000)},
+		PrimaryChromaticities:     [6]metadata.Rational{r(64, 100), r(33, 100), r(30, 100), r(60, 100), r(15, 100), r(6, 100)},
+		YCbCrCoefficient:          [3]metadata.Rational{r(299, 1000), r(587, 1000), r(114, 1000)},
+		ReferenceBlackWhite:       [6]metadata.Rational{r(0, 1), r(255, 1), r(128, 1), r(255, 1), r(128, 1), r(255, 1)},
+		DateTime:                  &dt,
+		ImageDescription:          "A description",
+		Make:                      "Make",
+		Model:                     "Model",
+		Software:                  "Software",
+		Artist:                    "Artist",
+		Copyright:                 "Photographer\x00Editor",
+		Thumbnail:                 []byte("\xff\xd8 not really a jpeg \xff\xd9"),
+	}
+	for c := range x.TransferFunction {
+		for i := range x.TransferFunction[c] {
+			x.TransferFunction[c][i] = uint16(c*1000 + i)
+		}
+	}
+	return x
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := map[string]*metadata.EXIF{
+		"empty":         {},
+		"artist":        {Artist: "Someone", Copyright: "2021 Someone"},
+		"one sample":    {BitsPerSample: [3]uint16{8}, SamplesPerPixel: 1},
+		"thumbnail":     {Thumbnail: []byte{0xff, 0xd8, 0xff, 0xd9, 1}},
+		"all the flags": fullEXIF(),
+	}
+	for name, want := range tests {
+		for _, bigEndian := range []bool{true, false} {
+			b, err := Encode(context.Background(), want, bigEndian)
+			if err != nil {
+				t.Fatalf("%s: Encode: %v", name, err)
+			}
+			if bom := string(b[:2]); (bom == "MM") != bigEndian {
+				t.Errorf("%s: got byte order mark %q for big endian %v", name, bom, bigEndian)
+			}
+			got, err := Decode(context.Background(), b, bigEndian)
+			if err != nil {
+				t.Fatalf("%s: Decode: %v", name, err)
+			}
+
+			// Encoding the decoded data should give the same bytes,
+			// since it includes the tags Encode adds.
+			again, err := Encode(context.Background(), got, bigEndian)
+			if err != nil {
+				t.Fatalf("%s: Encode again: %v", name, err)
+			}
+			if !bytes.Equal(again, b) {
+				t.Errorf("%s, big endian %v: encoding decoded data gave different bytes", name, bigEndian)
+			}
+
+			// Apart from those tags and the byte order, decoding should
+			// give back what was encoded.
+			if got.LittleEndian == bigEndian {
+				t.Errorf("%s, big endian %v: got little endian %v", name, bigEndian, got.LittleEndian)
+			}
+			got.LittleEndian = false
+			got.Tags.Delete(metadata.ExifIFD, metadata.TagExifVersion)
+			for _, id := range []metadata.TagID{metadata.TagCompression, metadata.TagXResolution, metadata.TagYResolution, metadata.TagResolutionUnit} {
+				if want.Thumbnail != nil && !got.Tags.Delete(metadata.IFD1, id) {
+					t.Errorf("%s: no IFD1 tag %d for the thumbnail", name, id)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, big endian %v: got\n%+v\nwant\n%+v", name, bigEndian, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	b, err := Encode(context.Background(), fullEXIF(), false)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	d := &decoder{b: b, order: binary.LittleEndian}
+	r, err := d.walk(context.Background())
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	for name, fields := range map[string][]field{"IFD0": r.ifd0, "Exif IFD": r.exif, "IFD1": r.ifd1} {
+		for i := 1; i < len(fields); i++ {
+			if fields[i-1].tag >= fields[i].tag {
+				t.Errorf("%s: tag %d comes before tag %d", name, fields[i-1].tag, fields[i].tag)
+			}
+		}
+	}
+	if len(r.exif) != 1 || r.exif[0].tag != tagExifVersion || string(r.exif[0].value) != exifVersion {
+		t.Errorf("got Exif IFD %+v, want just the EXIF version", r.exif)
+	}
+	if r.gps != nil || r.interop != nil {
+		t.Errorf("got GPS IFD %v and Interoperability IFD %v, want neither", r.gps, r.interop)
+	}
+
+	// Every value offset in IFD0 should be even, and inside the data.
+	o := binary.LittleEndian
+	off := o.Uint32(b[4:])
+	for i := uint32(0); i < uint32(o.Uint16(b[off:])); i++ {
+		e := b[off+2+12*i:]
+		vs := o.Uint32(e[4:]) * typeSize(o.Uint16(e[2:]))
+		if vs <= 4 {
+			continue
+		}
+		if vo := o.Uint32(e[8:]); vo%2 != 0 || vo+vs > uint32(len(b)) {
+			t.Errorf("tag %d: got value offset %d", o.Uint16(e), vo)
+		}
+	}
+}
+
+func TestEncodeNil(t *testing.T) {
+	if _, err := Encode(context.Background(), nil, true); err == nil {
+		t.Error("got nil error")
+	}
+}
+
+func TestEncodeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Encode(ctx, fullEXIF(), true); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestEncodeInImages checks that EXIF data set on png and jpeg metadata
+// is written out and can be read back.
+func TestEncodeInImages(t *testing.T) {
+	want := &metadata.EXIF{Artist: "Someone", Copyright: "2021 Someone"}
+	for _, tc := range []struct {
+		format string
+		md     metadata.EXIFCarrier
+	}{
+		{"png", &png.Metadata{}},
+		{"jpeg", &jpeg.Metadata{}},
+	} {
+		tc.md.SetEXIF(want)
+		var buf bytes.Buffer
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		if err := image.EncodeWithOptions(context.Background(), &buf, tc.format, img, tc.md.(image.WriteOption)); err != nil {
+			t.Fatalf("%s: encoding: %v", tc.format, err)
+		}
+		_, md, _, err := image.DecodeWithOptions(context.Background(), &buf)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", tc.format, err)
+		}
+		got, err := md.(metadata.EXIFCarrier).EXIF(context.Background())
+		if err != nil {
+			t.Fatalf("%s: EXIF: %v", tc.format, err)
+		}
+		got.Tags.Delete(metadata.ExifIFD, metadata.TagExifVersion)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", tc.format, got, want)
+		}
+	}
+}
+
+func TestTagsRoundTrip(t *testing.T) {
+	x := &metadata.EXIF{Make: "Make", Model: "Model"}
+	tags := []metadata.Tag{
+		metadata.RationalTag(metadata.ExifIFD, metadata.TagExposureTime, metadata.Rational{Numerator: 1, Denomenator: 250}),
+		metadata.RationalTag(metadata.ExifIFD, metadata.TagFNumber, metadata.Rational{Numerator: 28, Denomenator: 10}),
+		metadata.ShortTag(metadata.ExifIFD, metadata.TagPhotographicSensitivity, 400),
+		metadata.SRationalTag(metadata.ExifIFD, metadata.TagExposureBiasValue, metadata.SRational{Numerator: -1, Denomenator: 3}),
+		metadata.ASCIITag(metadata.ExifIFD, metadata.TagLensModel, "50mm f/1.8"),
+		metadata.ASCIITag(metadata.ExifIFD, metadata.TagBodySerialNumber, "12345"),
+		// An unknown date is kept as a tag, since it doesn't parse.
+		metadata.ASCIITag(metadata.ExifIFD, metadata.TagDateTimeOriginal, "    :  :     :  :  "),
+		metadata.UndefinedTag(metadata.ExifIFD, metadata.TagExifVersion, []byte("0231")),
+		metadata.ASCIITag(metadata.GPSIFD, metadata.TagGPSSatellites, "5"),
+		metadata.RationalTag(metadata.GPSIFD, metadata.TagGPSDOP, metadata.Rational{Numerator: 25, Denomenator: 10}),
+		metadata.ASCIITag(metadata.InteropIFD, metadata.TagInteroperabilityIndex, "R98"),
+		// A private tag, and a modelled tag with the wrong type.
+		metadata.SLongTag(metadata.IFD0, 50000, -5, 7),
+		metadata.SShortTag(metadata.IFD0, 50001, -2),
+		metadata.ASCIITag(metadata.IFD0, metadata.TagOrientation, "top-left"),
+		{IFD: metadata.IFD0, ID: 50002, Type: metadata.TypeDouble, Count: 1, Value: []byte{0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}},
+		metadata.ShortTag(metadata.IFD1, metadata.TagYCbCrPositioning, 2),
+	}
+	for _, tag := range tags {
+		x.Tags.Set(tag)
+	}
+
+	for _, bigEndian := range []bool{true, false} {
+		b, err := Encode(context.Background(), x, bigEndian)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		got, err := Decode(context.Background(), b, bigEndian)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if got.Make != x.Make || got.Model != x.Model || got.Orientation != 0 {
+			t.Errorf("big endian %v: got make %q, model %q, orientation %d", bigEndian, got.Make, got.Model, got.Orientation)
+		}
+		if got.Tags.Len() != len(tags) {
+			t.Errorf("big endian %v: got %d tags, want %d", bigEndian, got.Tags.Len(), len(tags))
+		}
+		for _, want := range tags {
+			tag, ok := got.Tags.Get(want.IFD, want.ID)
+			if !ok || !reflect.DeepEqual(tag, want) {
+				t.Errorf("big endian %v: got %v tag %d %+v, want %+v", bigEndian, want.IFD, want.ID, tag, want)
+			}
+		}
+	}
+
+	tag, _ := x.Tags.Get(metadata.ExifIFD, metadata.TagExposureTime)
+	if v, ok := tag.Rationals(); !ok || v[0] != (metadata.Rational{Numerator: 1, Denomenator: 250}) {
+		t.Errorf("got exposure time %v, %v", v, ok)
+	}
+	tag, _ = x.Tags.Get(metadata.ExifIFD, metadata.TagPhotographicSensitivity)
+	if v, ok := tag.Uints(); !ok || v[0] != 400 {
+		t.Errorf("got sensitivity %v, %v", v, ok)
+	}
+	tag, _ = x.Tags.Get(metadata.IFD0, 50000)
+	if v, ok := tag.Ints(); !ok || v[0] != -5 || v[1] != 7 {
+		t.Errorf("got private tag %v, %v", v, ok)
+	}
+	tag, _ = x.Tags.Get(metadata.IFD0, 50002)
+	if v, ok := tag.Floats(); !ok || v[0] < 3.14159 || v[0] > 3.1416 {
+		t.Errorf("got double tag %v, %v", v, ok)
+	}
+	tag, _ = x.Tags.Get(metadata.ExifIFD, metadata.TagLensModel)
+	if v, ok := tag.Text(); !ok || v != "50mm f/1.8" {
+		t.Errorf("got lens model %q, %v", v, ok)
+	}
+	if _, ok := tag.Uints(); ok {
+		t.Error("ASCII tag reported as holding integers")
+	}
+
+	if !x.Tags.Delete(metadata.ExifIFD, metadata.TagLensModel) || x.Tags.Delete(metadata.ExifIFD, metadata.TagLensModel) {
+		t.Error("Delete didn't remove the tag exactly once")
+	}
+	x.Tags.Set(metadata.ShortTag(metadata.ExifIFD, metadata.TagPhotographicSensitivity, 800))
+	if all := x.Tags.All(); len(all) != len(tags)-1 || all[2].ID != metadata.TagPhotographicSensitivity {
+		t.Errorf("Set didn't replace the tag in place: %v", all)
+	}
+}
+
+func TestEncodeBadTag(t *testing.T) {
+	for _, tag := range []metadata.Tag{
+		{IFD: metadata.ExifIFD, ID: 1, Type: 99, Count: 1, Value: []byte{0}},
+		{IFD: metadata.ExifIFD, ID: 1, Type: metadata.TypeShort, Count: 2, Value: []byte{0, 1}},
+	} {
+		x := &metadata.EXIF{}
+		x.Tags.Set(tag)
+		if _, err := Encode(context.Background(), x, true); err == nil {
+			t.Errorf("%+v: got nil error", tag)
+		}
+	}
+}
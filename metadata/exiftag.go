@@ -0,0 +1,490 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// IFD identifies one of the IFDs that EXIF data is split into.
+type IFD uint8
+
+const (
+	// IFD0 describes the main image.
+	IFD0 IFD = iota
+	// ExifIFD holds the camera and exposure information.
+	ExifIFD
+	// GPSIFD holds the location the image was taken at.
+	GPSIFD
+	// InteropIFD holds interoperability information.
+	InteropIFD
+	// IFD1 describes the thumbnail.
+	IFD1
+)
+
+// String returns the IFD's name.
+func (i IFD) String() string {
+	switch i {
+	case IFD0:
+		return "IFD0"
+	case ExifIFD:
+		return "Exif IFD"
+	case GPSIFD:
+		return "GPS IFD"
+	case InteropIFD:
+		return "Interoperability IFD"
+	case IFD1:
+		return "IFD1"
+	}
+	return "unknown IFD"
+}
+
+// TagType is the type of the values held by an EXIF tag.
+type TagType uint16
+
+const (
+	TypeByte      TagType = 1  // 8 bit unsigned integers
+	TypeASCII     TagType = 2  // null terminated text
+	TypeShort     TagType = 3  // 16 bit unsigned integers
+	TypeLong      TagType = 4  // 32 bit unsigned integers
+	TypeRational  TagType = 5  // pairs of 32 bit unsigned integers
+	TypeSByte     TagType = 6  // 8 bit signed integers
+	TypeUndefined TagType = 7  // bytes whose meaning depends on the tag
+	TypeSShort    TagType = 8  // 16 bit signed integers
+	TypeSLong     TagType = 9  // 32 bit signed integers
+	TypeSRational TagType = 10 // pairs of 32 bit signed integers
+	TypeFloat     TagType = 11 // 32 bit floating point numbers
+	TypeDouble    TagType = 12 // 64 bit floating point numbers
+)
+
+// Size returns the size in bytes of a single value of the type, or zero
+// if the type is unknown.
+func (t TagType) Size() int {
+	switch t {
+	case TypeByte, TypeASCII, TypeSByte, TypeUndefined:
+		return 1
+	case TypeShort, TypeSShort:
+		return 2
+	case TypeLong, TypeSLong, TypeFloat:
+		return 4
+	case TypeRational, TypeSRational, TypeDouble:
+		return 8
+	}
+	return 0
+}
+
+// TagID identifies an EXIF tag within its IFD.
+type TagID uint16
+
+// Tags in IFD0 and IFD1.
+const (
+	TagImageWidth                  TagID = 256
+	TagImageLength                 TagID = 257
+	TagBitsPerSample               TagID = 258
+	TagCompression                 TagID = 259
+	TagPhotometricInterpretation   TagID = 262
+	TagImageDescription            TagID = 270
+	TagMake                        TagID = 271
+	TagModel                       TagID = 272
+	TagStripOffsets                TagID = 273
+	TagOrientation                 TagID = 274
+	TagSamplesPerPixel             TagID = 277
+	TagRowsPerStrip                TagID = 278
+	TagStripByteCounts             TagID = 279
+	TagXResolution                 TagID = 282
+	TagYResolution                 TagID = 283
+	TagPlanarConfiguration         TagID = 284
+	TagResolutionUnit              TagID = 296
+	TagTransferFunction            TagID = 301
+	TagSoftware                    TagID = 305
+	TagDateTime                    TagID = 306
+	TagArtist                      TagID = 315
+	TagWhitePoint                  TagID = 318
+	TagPrimaryChromaticities       TagID = 319
+	TagJPEGInterchangeFormat       TagID = 513
+	TagJPEGInterchangeFormatLength TagID = 514
+	TagYCbCrCoefficients           TagID = 529
+	TagYCbCrSubSampling            TagID = 530
+	TagYCbCrPositioning            TagID = 531
+	TagReferenceBlackWhite         TagID = 532
+	TagCopyright                   TagID = 33432
+	TagExifIFDPointer              TagID = 34665
+	TagGPSInfoIFDPointer           TagID = 34853
+)
+
+// Tags in the Exif IFD.
+const (
+	TagExposureTime               TagID = 33434
+	TagFNumber                    TagID = 33437
+	TagExposureProgram            TagID = 34850
+	TagSpectralSensitivity        TagID = 34852
+	TagPhotographicSensitivity    TagID = 34855 // called ISOSpeedRatings before EXIF 2.3
+	TagOECF                       TagID = 34856
+	TagSensitivityType            TagID = 34864
+	TagStandardOutputSensitivity  TagID = 34865
+	TagRecommendedExposureIndex   TagID = 34866
+	TagISOSpeed                   TagID = 34867
+	TagExifVersion                TagID = 36864
+	TagDateTimeOriginal           TagID = 36867
+	TagDateTimeDigitized          TagID = 36868
+	TagOffsetTime                 TagID = 36880
+	TagOffsetTimeOriginal         TagID = 36881
+	TagOffsetTimeDigitized        TagID = 36882
+	TagComponentsConfiguration    TagID = 37121
+	TagCompressedBitsPerPixel     TagID = 37122
+	TagShutterSpeedValue          TagID = 37377
+	TagApertureValue              TagID = 37378
+	TagBrightnessValue            TagID = 37379
+	TagExposureBiasValue          TagID = 37380
+	TagMaxApertureValue           TagID = 37381
+	TagSubjectDistance            TagID = 37382
+	TagMeteringMode               TagID = 37383
+	TagLightSource                TagID = 37384
+	TagFlash                      TagID = 37385
+	TagFocalLength                TagID = 37386
+	TagSubjectArea                TagID = 37396
+	TagMakerNote                  TagID = 37500
+	TagUserComment                TagID = 37510
+	TagSubSecTime                 TagID = 37520
+	TagSubSecTimeOriginal         TagID = 37521
+	TagSubSecTimeDigitized        TagID = 37522
+	TagFlashpixVersion            TagID = 40960
+	TagColorSpace                 TagID = 40961
+	TagPixelXDimension            TagID = 40962
+	TagPixelYDimension            TagID = 40963
+	TagRelatedSoundFile           TagID = 40964
+	TagInteroperabilityIFDPointer TagID = 40965
+	TagFlashEnergy                TagID = 41483
+	TagFocalPlaneXResolution      TagID = 41486
+	TagFocalPlaneYResolution      TagID = 41487
+	TagFocalPlaneResolutionUnit   TagID = 41488
+	TagSubjectLocation            TagID = 41492
+	TagExposureIndex              TagID = 41493
+	TagSensingMethod              TagID = 41495
+	TagFileSource                 TagID = 41728
+	TagSceneType                  TagID = 41729
+	TagCFAPattern                 TagID = 41730
+	TagCustomRendered             TagID = 41985
+	TagExposureMode               TagID = 41986
+	TagWhiteBalance               TagID = 41987
+	TagDigitalZoomRatio           TagID = 41988
+	TagFocalLengthIn35mmFilm      TagID = 41989
+	TagSceneCaptureType           TagID = 41990
+	TagGainControl                TagID = 41991
+	TagContrast                   TagID = 41992
+	TagSaturation                 TagID = 41993
+	TagSharpness                  TagID = 41994
+	TagDeviceSettingDescription   TagID = 41995
+	TagSubjectDistanceRange       TagID = 41996
+	TagImageUniqueID              TagID = 42016
+	TagCameraOwnerName            TagID = 42032
+	TagBodySerialNumber           TagID = 42033
+	TagLensSpecification          TagID = 42034
+	TagLensMake                   TagID = 42035
+	TagLensModel                  TagID = 42036
+	TagLensSerialNumber           TagID = 42037
+	TagGamma                      TagID = 42240
+)
+
+// Tags in the GPS IFD.
+const (
+	TagGPSVersionID         TagID = 0
+	TagGPSLatitudeRef       TagID = 1
+	TagGPSLatitude          TagID = 2
+	TagGPSLongitudeRef      TagID = 3
+	TagGPSLongitude         TagID = 4
+	TagGPSAltitudeRef       TagID = 5
+	TagGPSAltitude          TagID = 6
+	TagGPSTimeStamp         TagID = 7
+	TagGPSSatellites        TagID = 8
+	TagGPSStatus            TagID = 9
+	TagGPSMeasureMode       TagID = 10
+	TagGPSDOP               TagID = 11
+	TagGPSSpeedRef          TagID = 12
+	TagGPSSpeed             TagID = 13
+	TagGPSTrackRef          TagID = 14
+	TagGPSTrack             TagID = 15
+	TagGPSImgDirectionRef   TagID = 16
+	TagGPSImgDirection      TagID = 17
+	TagGPSMapDatum          TagID = 18
+	TagGPSDestLatitudeRef   TagID = 19
+	TagGPSDestLatitude      TagID = 20
+	TagGPSDestLongitudeRef  TagID = 21
+	TagGPSDestLongitude     TagID = 22
+	TagGPSDestBearingRef    TagID = 23
+	TagGPSDestBearing       TagID = 24
+	TagGPSDestDistanceRef   TagID = 25
+	TagGPSDestDistance      TagID = 26
+	TagGPSProcessingMethod  TagID = 27
+	TagGPSAreaInformation   TagID = 28
+	TagGPSDateStamp         TagID = 29
+	TagGPSDifferential      TagID = 30
+	TagGPSHPositioningError TagID = 31
+)
+
+// Tags in the Interoperability IFD.
+const (
+	TagInteroperabilityIndex TagID = 1
+)
+
+// SRational holds a signed rational number.
+type SRational struct {
+	Numerator   int32
+	Denomenator int32
+}
+
+// Tag holds a single EXIF tag that has no field of its own in EXIF.
+type Tag struct {
+	IFD  IFD
+	ID   TagID
+	Type TagType
+	// Count is the number of values the tag holds. For ASCII tags it
+	// includes the terminating null.
+	Count uint32
+	// Value holds the tag's values, in big endian byte order whatever
+	// the byte order of the EXIF data they came from.
+	Value []byte
+}
+
+// Uints returns the tag's values if it holds unsigned integers, that
+// is if its type is TypeByte, TypeShort or TypeLong. The boolean
+// reports whether it does.
+func (t Tag) Uints() ([]uint32, bool) {
+	if !t.valid() {
+		return nil, false
+	}
+	v := make([]uint32, t.Count)
+	for i := range v {
+		switch t.Type {
+		case TypeByte:
+			v[i] = uint32(t.Value[i])
+		case TypeShort:
+			v[i] = uint32(binary.BigEndian.Uint16(t.Value[2*i:]))
+		case TypeLong:
+			v[i] = binary.BigEndian.Uint32(t.Value[4*i:])
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Ints returns the tag's values if it holds signed integers, that is
+// if its type is TypeSByte, TypeSShort or TypeSLong. The boolean
+// reports whether it does.
+func (t Tag) Ints() ([]int32, bool) {
+	if !t.valid() {
+		return nil, false
+	}
+	v := make([]int32, t.Count)
+	for i := range v {
+		switch t.Type {
+		case TypeSByte:
+			v[i] = int32(int8(t.Value[i]))
+		case TypeSShort:
+			v[i] = int32(int16(binary.BigEndian.Uint16(t.Value[2*i:])))
+		case TypeSLong:
+			v[i] = int32(binary.BigEndian.Uint32(t.Value[4*i:]))
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Rationals returns the tag's values if its type is TypeRational. The
+// boolean reports whether it is.
+func (t Tag) Rationals() ([]Rational, bool) {
+	if t.Type != TypeRational || !t.valid() {
+		return nil, false
+	}
+	v := make([]Rational, t.Count)
+	for i := range v {
+		v[i].Numerator = binary.BigEndian.Uint32(t.Value[8*i:])
+		v[i].Denomenator = binary.BigEndian.Uint32(t.Value[8*i+4:])
+	}
+	return v, true
+}
+
+// SRationals returns the tag's values if its type is TypeSRational.
+// The boolean reports whether it is.
+func (t Tag) SRationals() ([]SRational, bool) {
+	if t.Type != TypeSRational || !t.valid() {
+		return nil, false
+	}
+	v := make([]SRational, t.Count)
+	for i := range v {
+		v[i].Numerator = int32(binary.BigEndian.Uint32(t.Value[8*i:]))
+		v[i].Denomenator = int32(binary.BigEndian.Uint32(t.Value[8*i+4:]))
+	}
+	return v, true
+}
+
+// Floats returns the tag's values if its type is TypeFloat or
+// TypeDouble. The boolean reports whether it is.
+func (t Tag) Floats() ([]float64, bool) {
+	if !t.valid() {
+		return nil, false
+	}
+	v := make([]float64, t.Count)
+	for i := range v {
+		switch t.Type {
+		case TypeFloat:
+			v[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(t.Value[4*i:])))
+		case TypeDouble:
+			v[i] = math.Float64frombits(binary.BigEndian.Uint64(t.Value[8*i:]))
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Text returns the tag's value as text, without the terminating
+// nulls, if its type is TypeASCII. The boolean reports whether it is.
+func (t Tag) Text() (string, bool) {
+	if t.Type != TypeASCII {
+		return "", false
+	}
+	return strings.TrimRight(string(t.Value), "\x00"), true
+}
+
+// valid reports whether the tag's value is the size its type and count
+// call for.
+func (t Tag) valid() bool {
+	return uint64(len(t.Value)) == uint64(t.Count)*uint64(t.Type.Size())
+}
+
+// The tag constructors return a tag holding the given values.
+
+// ByteTag returns a tag of type TypeByte.
+func ByteTag(ifd IFD, id TagID, v ...uint8) Tag {
+	return Tag{IFD: ifd, ID: id, Type: TypeByte, Count: uint32(len(v)), Value: append([]byte(nil), v...)}
+}
+
+// ASCIITag returns a tag of type TypeASCII holding s and a
+// terminating null.
+func ASCIITag(ifd IFD, id TagID, s string) Tag {
+	return Tag{IFD: ifd, ID: id, Type: TypeASCII, Count: uint32(len(s) + 1), Value: append([]byte(s), 0)}
+}
+
+// ShortTag returns a tag of type TypeShort.
+func ShortTag(ifd IFD, id TagID, v ...uint16) Tag {
+	b := make([]byte, 2*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint16(b[2*i:], n)
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeShort, Count: uint32(len(v)), Value: b}
+}
+
+// LongTag returns a tag of type TypeLong.
+func LongTag(ifd IFD, id TagID, v ...uint32) Tag {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(b[4*i:], n)
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeLong, Count: uint32(len(v)), Value: b}
+}
+
+// RationalTag returns a tag of type TypeRational.
+func RationalTag(ifd IFD, id TagID, v ...Rational) Tag {
+	b := make([]byte, 8*len(v))
+	for i, r := range v {
+		binary.BigEndian.PutUint32(b[8*i:], r.Numerator)
+		binary.BigEndian.PutUint32(b[8*i+4:], r.Denomenator)
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeRational, Count: uint32(len(v)), Value: b}
+}
+
+// SShortTag returns a tag of type TypeSShort.
+func SShortTag(ifd IFD, id TagID, v ...int16) Tag {
+	b := make([]byte, 2*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint16(b[2*i:], uint16(n))
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeSShort, Count: uint32(len(v)), Value: b}
+}
+
+// SLongTag returns a tag of type TypeSLong.
+func SLongTag(ifd IFD, id TagID, v ...int32) Tag {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(b[4*i:], uint32(n))
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeSLong, Count: uint32(len(v)), Value: b}
+}
+
+// SRationalTag returns a tag of type TypeSRational.
+func SRationalTag(ifd IFD, id TagID, v ...SRational) Tag {
+	b := make([]byte, 8*len(v))
+	for i, r := range v {
+		binary.BigEndian.PutUint32(b[8*i:], uint32(r.Numerator))
+		binary.BigEndian.PutUint32(b[8*i+4:], uint32(r.Denomenator))
+	}
+	return Tag{IFD: ifd, ID: id, Type: TypeSRational, Count: uint32(len(v)), Value: b}
+}
+
+// UndefinedTag returns a tag of type TypeUndefined.
+func UndefinedTag(ifd IFD, id TagID, b []byte) Tag {
+	return Tag{IFD: ifd, ID: id, Type: TypeUndefined, Count: uint32(len(b)), Value: append([]byte(nil), b...)}
+}
+
+// Tags holds the EXIF tags that have no field of their own in EXIF, in
+// the order they were added. There's at most one tag for each IFD and
+// tag ID. The zero value is an empty set of tags.
+type Tags struct {
+	tags []Tag
+}
+
+// index returns the index of the tag with the given IFD and ID, or -1
+// if there isn't one.
+func (t *Tags) index(ifd IFD, id TagID) int {
+	for i := range t.tags {
+		if t.tags[i].IFD == ifd && t.tags[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the tag with the given IFD and ID. The boolean reports
+// whether there is one.
+func (t *Tags) Get(ifd IFD, id TagID) (Tag, bool) {
+	if i := t.index(ifd, id); i >= 0 {
+		return t.tags[i], true
+	}
+	return Tag{}, false
+}
+
+// Set adds tag, replacing any tag with the same IFD and ID in place.
+func (t *Tags) Set(tag Tag) {
+	if i := t.index(tag.IFD, tag.ID); i >= 0 {
+		t.tags[i] = tag
+		return
+	}
+	t.tags = append(t.tags, tag)
+}
+
+// Delete removes the tag with the given IFD and ID. It reports whether
+// there was one.
+func (t *Tags) Delete(ifd IFD, id TagID) bool {
+	i := t.index(ifd, id)
+	if i < 0 {
+		return false
+	}
+	t.tags = append(t.tags[:i], t.tags[i+1:]...)
+	if len(t.tags) == 0 {
+		t.tags = nil
+	}
+	return true
+}
+
+// Len returns the number of tags.
+func (t *Tags) Len() int {
+	return len(t.tags)
+}
+
+// All returns the tags in the order they were added.
+func (t *Tags) All() []Tag {
+	return append([]Tag(nil), t.tags...)
+}
@@ -0,0 +1,192 @@
+package metadata
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// GPS holds the location an image was taken at, from the EXIF GPS IFD.
+// Fields with a zero value weren't given.
+type GPS struct {
+	VersionID    [4]uint8    // 0
+	LatitudeRef  string      // 1, "N" or "S"
+	Latitude     [3]Rational // 2, degrees, minutes and seconds
+	LongitudeRef string      // 3, "E" or "W"
+	Longitude    [3]Rational // 4, degrees, minutes and seconds
+	// AltitudeRef is 0 if Altitude is above sea level, and 1 if it's
+	// below.
+	AltitudeRef uint8    // 5
+	Altitude    Rational // 6, meters
+	// TimeStamp and DateStamp give the time the location was fixed,
+	// in UTC.
+	TimeStamp       [3]Rational // 7, hours, minutes and seconds
+	SpeedRef        string      // 12, "K", "M" or "N" for kilometers, miles or knots per hour
+	Speed           Rational    // 13
+	ImgDirectionRef string      // 16, "T" or "M" for true or magnetic north
+	ImgDirection    Rational    // 17, degrees
+	MapDatum        string      // 18
+	DateStamp       string      // 29, "YYYY:MM:DD"
+}
+
+// gpsDateLayout is the layout of GPS date stamps.
+const gpsDateLayout = "2006:01:02"
+
+// float returns r as a floating point number. The boolean reports
+// whether r has a denominator.
+func (r Rational) float() (float64, bool) {
+	if r.Denomenator == 0 {
+		return 0, false
+	}
+	return float64(r.Numerator) / float64(r.Denomenator), true
+}
+
+// dmsDenominator is the denominator used for seconds of arc, which
+// gives a precision of a few millimeters.
+const dmsDenominator = 10000
+
+// degrees returns the degrees, minutes and seconds in dms as a number
+// of degrees. The boolean reports whether dms is valid.
+func degrees(dms [3]Rational) (float64, bool) {
+	d, ok1 := dms[0].float()
+	m, ok2 := dms[1].float()
+	s, ok3 := dms[2].float()
+	if !ok1 || !ok2 || !ok3 {
+		return 0, false
+	}
+	return d + m/60 + s/3600, true
+}
+
+// toDMS returns the non-negative number of degrees v as degrees,
+// minutes and seconds.
+func toDMS(v float64) [3]Rational {
+	d := math.Floor(v)
+	m := math.Floor((v - d) * 60)
+	s := math.Round(((v-d)*60 - m) * 60 * dmsDenominator)
+	// Rounding the seconds up can carry into the minutes and degrees.
+	if s >= 60*dmsDenominator {
+		s -= 60 * dmsDenominator
+		m++
+	}
+	if m >= 60 {
+		m -= 60
+		d++
+	}
+	return [3]Rational{
+		{Numerator: uint32(d), Denomenator: 1},
+		{Numerator: uint32(m), Denomenator: 1},
+		{Numerator: uint32(s), Denomenator: dmsDenominator},
+	}
+}
+
+// Coordinates returns the latitude and longitude in signed decimal
+// degrees, with north and east positive. The boolean reports whether
+// both are given.
+func (g *GPS) Coordinates() (lat, lon float64, ok bool) {
+	lat, ok1 := degrees(g.Latitude)
+	lon, ok2 := degrees(g.Longitude)
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	switch g.LatitudeRef {
+	case "N":
+	case "S":
+		lat = -lat
+	default:
+		return 0, 0, false
+	}
+	switch g.LongitudeRef {
+	case "E":
+	case "W":
+		lon = -lon
+	default:
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// SetCoordinates sets the latitude and longitude, and their refs, from
+// signed decimal degrees with north and east positive. It returns an
+// error if either is out of range.
+func (g *GPS) SetCoordinates(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+		return errors.New("gps: coordinates out of range")
+	}
+	g.LatitudeRef, g.LongitudeRef = "N", "E"
+	if lat < 0 {
+		g.LatitudeRef, lat = "S", -lat
+	}
+	if lon < 0 {
+		g.LongitudeRef, lon = "W", -lon
+	}
+	g.Latitude, g.Longitude = toDMS(lat), toDMS(lon)
+	return nil
+}
+
+// AltitudeMeters returns the altitude in meters, negative below sea
+// level. The boolean reports whether it's given.
+func (g *GPS) AltitudeMeters() (float64, bool) {
+	a, ok := g.Altitude.float()
+	if !ok {
+		return 0, false
+	}
+	if g.AltitudeRef == 1 {
+		a = -a
+	}
+	return a, true
+}
+
+// SetAltitude sets the altitude, and its ref, from meters, negative
+// below sea level. It's kept to the nearest millimeter.
+func (g *GPS) SetAltitude(m float64) error {
+	g.AltitudeRef = 0
+	if m < 0 {
+		g.AltitudeRef, m = 1, -m
+	}
+	v := math.Round(m * 1000)
+	if !(v <= math.MaxUint32) {
+		return errors.New("gps: altitude out of range")
+	}
+	g.Altitude = Rational{Numerator: uint32(v), Denomenator: 1000}
+	return nil
+}
+
+// Time returns the time the location was fixed, from the time stamp
+// and date stamp. The boolean reports whether both are given.
+func (g *GPS) Time() (time.Time, bool) {
+	d, err := time.Parse(gpsDateLayout, g.DateStamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	h, ok1 := g.TimeStamp[0].float()
+	m, ok2 := g.TimeStamp[1].float()
+	s, ok3 := g.TimeStamp[2].float()
+	if !ok1 || !ok2 || !ok3 {
+		return time.Time{}, false
+	}
+	ns := math.Round((h*3600 + m*60 + s) * float64(time.Second))
+	return d.Add(time.Duration(ns)), true
+}
+
+// SetTime sets the time stamp and date stamp to t, in UTC. The seconds
+// are kept to the nearest millisecond.
+func (g *GPS) SetTime(t time.Time) {
+	t = t.UTC().Round(time.Millisecond)
+	g.DateStamp = t.Format(gpsDateLayout)
+	g.TimeStamp = [3]Rational{
+		{Numerator: uint32(t.Hour()), Denomenator: 1},
+		{Numerator: uint32(t.Minute()), Denomenator: 1},
+		{Numerator: uint32(t.Second()*1000 + t.Nanosecond()/int(time.Millisecond)), Denomenator: 1000},
+	}
+}
+
+// StripGPS removes the location from x, that is the GPS field and the
+// tags from the GPS IFD.
+func (x *EXIF) StripGPS() {
+	x.GPS = nil
+	for _, t := range x.Tags.All() {
+		if t.IFD == GPSIFD {
+			x.Tags.Delete(t.IFD, t.ID)
+		}
+	}
+}
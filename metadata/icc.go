@@ -85,6 +85,15 @@ type ICC struct {
 	RenderingIntent                  uint32
 	ProfileConnectionSpaceIlluminant XYZNumber
 	ProfileCreatorSignature          uint32
+	// DeviceVendorAttributes holds the vendor specific second half of
+	// the header's device attributes. DeviceAttributes holds the first
+	// half, the attribute flags the ICC defines.
+	DeviceVendorAttributes uint32
+	// ProfileID holds the MD5 checksum of the profile, or zeros if it
+	// wasn't computed. Version 2 profiles don't have one.
+	ProfileID [16]byte
+	// Tags holds the profile's tags, in the order of its tag table.
+	Tags []*ICCTag
 }
 
 func DecodeICC(ctx context.Context, b []byte, opt ...image.ReadOption) (*ICC, error) {
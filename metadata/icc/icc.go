@@ -4,9 +4,6 @@
 package icc
 
 import (
-	"context"
-
-	"github.com/rmamba/image"
 	"github.com/rmamba/image/metadata"
 )
 
@@ -14,13 +11,3 @@ func init() {
 	metadata.RegisterICCDecoder(Decode)
 	metadata.RegisterICCEncoder(Encode)
 }
-
-// Decode decodes ICC color profiles.
-func Decode(ctx context.Context, b []byte, opt ...image.ReadOption) (*metadata.ICC, error) {
-	panic("Not implemented")
-}
-
-// Encode encodes ICC color profiles.
-func Encode(ctx context.Context, i *metadata.ICC, opt ...image.WriteOption) ([]byte, error) {
-	panic("Not implemented")
-}
@@ -0,0 +1,135 @@
+package icc
+
+import (
+	"time"
+
+	"github.com/rmamba/image/internal/iccutil"
+	"github.com/rmamba/image/metadata"
+)
+
+// The built-in profiles are compact version 4.3 display profiles, with
+// parametric tone response curves and, for the RGB ones, colorant tags
+// adapted to D50 with the Bradford transform. Each function returns a
+// new profile, which the caller may change. They're small enough to tag
+// every image with: an RGB profile encodes to about 500 bytes.
+
+// d65 holds the chromaticity of the D65 white point the built-in
+// profiles' color spaces use.
+var d65 = [2]float64{0.3127, 0.3290}
+
+// d50 holds the D50 profile connection space illuminant.
+var d50 = metadata.XYZNumber{
+	X: metadata.S15Fixed16{Integer: 0, Fraction: 0xf6d6},
+	Y: metadata.S15Fixed16{Integer: 1, Fraction: 0},
+	Z: metadata.S15Fixed16{Integer: 0, Fraction: 0xd32d},
+}
+
+// creationTime is the creation time of the built-in profiles, so that
+// they encode the same way every time.
+var creationTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// srgbCurve is the sRGB transfer function, which Display P3 also uses.
+var srgbCurve = parametric(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+// parametric returns a parametric curve with the given function type
+// and parameters.
+func parametric(function uint16, params ...float64) metadata.ICCParametricCurve {
+	c := metadata.ICCParametricCurve{Function: function}
+	for _, p := range params {
+		c.Params = append(c.Params, metadata.NewS15Fixed16(p))
+	}
+	return c
+}
+
+// xyz returns an XYZType tag value for a single XYZ value.
+func xyz(v [3]float64) metadata.ICCXYZ {
+	return metadata.ICCXYZ{{
+		X: metadata.NewS15Fixed16(v[0]),
+		Y: metadata.NewS15Fixed16(v[1]),
+		Z: metadata.NewS15Fixed16(v[2]),
+	}}
+}
+
+// text returns a multiLocalizedUnicodeType tag value holding English
+// text.
+func text(s string) metadata.ICCMultiLocalizedUnicode {
+	return metadata.ICCMultiLocalizedUnicode{{Language: "en", Country: "US", Text: s}}
+}
+
+// newProfile returns a display profile for the given color space,
+// with a description and the tags common to all the built-in profiles.
+func newProfile(space uint32, description string) *metadata.ICC {
+	return &metadata.ICC{
+		ProfileVersion:                   metadata.ProfileVersion{Major: 4, Minor: 0x30},
+		ProfileClassSignature:            metadata.ICCClassDisplay,
+		ColorSpace:                       space,
+		ProfileConnectionSpace:           metadata.ICCSpaceXYZ,
+		ProfileCreationTime:              creationTime,
+		ProfileConnectionSpaceIlluminant: d50,
+		Tags: []*metadata.ICCTag{
+			{Signature: metadata.ICCTagProfileDescription, Value: text(description)},
+			{Signature: metadata.ICCTagCopyright, Value: text("No copyright, use freely")},
+			// Version 4 display profiles have the D50 illuminant as
+			// their media white point.
+			{Signature: metadata.ICCTagMediaWhitePoint, Value: metadata.ICCXYZ{d50}},
+		},
+	}
+}
+
+// rgbProfile returns an RGB display profile with D65 white, the given
+// primaries and the same tone response curve for each channel.
+func rgbProfile(description string, red, green, blue [2]float64, trc metadata.ICCParametricCurve) *metadata.ICC {
+	x := newProfile(metadata.ICCSpaceRGB, description)
+	c := &iccutil.Chromaticities{White: d65, Red: red, Green: green, Blue: blue}
+	m, err := c.Matrix()
+	if err != nil {
+		panic(err)
+	}
+	chad := iccutil.Adaptation(d65)
+	var a metadata.ICCS15Fixed16Array
+	for _, row := range chad {
+		for _, v := range row {
+			a = append(a, metadata.NewS15Fixed16(v))
+		}
+	}
+	x.Tags = append(x.Tags, &metadata.ICCTag{Signature: metadata.ICCTagChromaticAdaptation, Value: a})
+	for i, sig := range []uint32{metadata.ICCTagRedColorant, metadata.ICCTagGreenColorant, metadata.ICCTagBlueColorant} {
+		x.Tags = append(x.Tags, &metadata.ICCTag{Signature: sig, Value: xyz([3]float64{m[0][i], m[1][i], m[2][i]})})
+	}
+	for _, sig := range []uint32{metadata.ICCTagRedTRC, metadata.ICCTagGreenTRC, metadata.ICCTagBlueTRC} {
+		x.Tags = append(x.Tags, &metadata.ICCTag{Signature: sig, Value: trc})
+	}
+	return x
+}
+
+// SRGB returns a profile for the sRGB color space of IEC 61966-2-1.
+func SRGB() *metadata.ICC {
+	return rgbProfile("sRGB", [2]float64{0.64, 0.33}, [2]float64{0.30, 0.60}, [2]float64{0.15, 0.06}, srgbCurve)
+}
+
+// DisplayP3 returns a profile for the Display P3 color space, which has
+// the DCI-P3 primaries with the white point and transfer function of
+// sRGB.
+func DisplayP3() *metadata.ICC {
+	return rgbProfile("Display P3", [2]float64{0.680, 0.320}, [2]float64{0.265, 0.690}, [2]float64{0.150, 0.060}, srgbCurve)
+}
+
+// AdobeRGB returns a profile for the Adobe RGB (1998) color space.
+func AdobeRGB() *metadata.ICC {
+	return rgbProfile("Adobe RGB (1998)", [2]float64{0.64, 0.33}, [2]float64{0.21, 0.71}, [2]float64{0.15, 0.06}, parametric(0, 563.0/256))
+}
+
+// Rec2020 returns a profile for the ITU-R BT.2020 color space, with
+// its SDR transfer function.
+func Rec2020() *metadata.ICC {
+	const alpha, beta = 1.09929682680944, 0.018053968510807
+	trc := parametric(3, 1/0.45, 1/alpha, (alpha-1)/alpha, 1/4.5, 4.5*beta)
+	return rgbProfile("Rec. 2020", [2]float64{0.708, 0.292}, [2]float64{0.170, 0.797}, [2]float64{0.131, 0.046}, trc)
+}
+
+// GrayGamma22 returns a profile for gray samples with a gamma of 2.2.
+func GrayGamma22() *metadata.ICC {
+	x := newProfile(metadata.ICCSpaceGray, "Gray Gamma 2.2")
+	x.Tags = append(x.Tags, &metadata.ICCTag{Signature: metadata.ICCTagGrayTRC, Value: parametric(0, 2.2)})
+	return x
+}
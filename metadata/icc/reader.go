@@ -0,0 +1,494 @@
+package icc
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// headerSize is the size of a profile's header, which is followed by
+// the tag table.
+const headerSize = 128
+
+// Tag type signatures.
+const (
+	typeText       = 0x74657874 // 'text'
+	typeDesc       = 0x64657363 // 'desc'
+	typeMLUC       = 0x6D6C7563 // 'mluc'
+	typeXYZ        = 0x58595A20 // 'XYZ '
+	typeCurve      = 0x63757276 // 'curv'
+	typeParametric = 0x70617261 // 'para'
+	typeS15Fixed16 = 0x73663332 // 'sf32'
+	typeLut8       = 0x6D667431 // 'mft1'
+	typeLut16      = 0x6D667432 // 'mft2'
+	typeLutAToB    = 0x6D414220 // 'mAB '
+	typeLutBToA    = 0x6D424120 // 'mBA '
+)
+
+// The profile file signature, at offset 36 of the header.
+const profileSignature = "acsp"
+
+// Decode decodes an ICC color profile. The header fills in the fields
+// of metadata.ICC, and the tag table its Tags. Tags of the types
+// listed in metadata.ICCTag have their values decoded; other tags are
+// just kept.
+//
+// The profile's size has to fit the data, and if it has a profile ID,
+// it has to match the profile's MD5 checksum. If the read options say
+// to skip damaged data, a profile with a bad ID is returned anyway, and
+// tags that can't be read are left out.
+func Decode(ctx context.Context, b []byte, opt ...image.ReadOption) (*metadata.ICC, error) {
+	s, err := image.ResolveReadOptions(opt...)
+	if err != nil {
+		return nil, err
+	}
+	skipDamaged := s.Damage.SkipDamagedData
+
+	if len(b) < headerSize+4 {
+		return nil, errors.New("icc: profile too short")
+	}
+	size := binary.BigEndian.Uint32(b)
+	if size < headerSize+4 || uint64(size) > uint64(len(b)) {
+		return nil, fmt.Errorf("icc: profile size %d doesn't fit %d bytes of data", size, len(b))
+	}
+	b = b[:size]
+	if string(b[36:40]) != profileSignature {
+		return nil, errors.New("icc: missing profile file signature")
+	}
+
+	x := decodeHeader(b)
+	var zero [16]byte
+	if x.ProfileID != zero && x.ProfileID != profileID(b) && !skipDamaged {
+		return nil, errors.New("icc: profile ID doesn't match the profile's checksum")
+	}
+
+	count := binary.BigEndian.Uint32(b[headerSize:])
+	if uint64(count)*12+headerSize+4 > uint64(len(b)) {
+		return nil, fmt.Errorf("icc: tag table with %d entries runs past the end of the profile", count)
+	}
+	for i := 0; i < int(count); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := b[headerSize+4+i*12:]
+		sig := binary.BigEndian.Uint32(e)
+		off := uint64(binary.BigEndian.Uint32(e[4:]))
+		n := uint64(binary.BigEndian.Uint32(e[8:]))
+		if off+n > uint64(len(b)) || n < 8 {
+			if skipDamaged {
+				continue
+			}
+			return nil, fmt.Errorf("icc: tag %s runs past the end of the profile", sigString(sig))
+		}
+		data := b[off : off+n]
+		v, err := decodeTag(data)
+		if err != nil {
+			if !skipDamaged {
+				return nil, fmt.Errorf("icc: tag %s: %v", sigString(sig), err)
+			}
+			v = nil
+		}
+		x.Tags = append(x.Tags, &metadata.ICCTag{
+			Signature: sig,
+			Data:      append([]byte(nil), data...),
+			Value:     v,
+		})
+	}
+	return x, nil
+}
+
+// decodeHeader returns the profile with the header fields set from the
+// profile data b.
+func decodeHeader(b []byte) *metadata.ICC {
+	u32 := func(off int) uint32 { return binary.BigEndian.Uint32(b[off:]) }
+	u16 := func(off int) int { return int(binary.BigEndian.Uint16(b[off:])) }
+	x := &metadata.ICC{
+		CMMTypeSignature:                 u32(4),
+		ProfileVersion:                   metadata.ProfileVersion{Major: b[8], Minor: b[9]},
+		ProfileClassSignature:            u32(12),
+		ColorSpace:                       u32(16),
+		ProfileConnectionSpace:           u32(20),
+		PrimaryPlatformSignature:         u32(40),
+		CMMFlags:                         u32(44),
+		DeviceManufacturer:               u32(48),
+		DeviceModel:                      u32(52),
+		DeviceAttributes:                 u32(56),
+		DeviceVendorAttributes:           u32(60),
+		RenderingIntent:                  u32(64),
+		ProfileConnectionSpaceIlluminant: xyzNumber(b[68:]),
+		ProfileCreatorSignature:          u32(80),
+	}
+	// A zeroed date means the profile doesn't say when it was made.
+	if u16(24) != 0 {
+		x.ProfileCreationTime = time.Date(u16(24), time.Month(u16(26)), u16(28), u16(30), u16(32), u16(34), 0, time.UTC)
+	}
+	copy(x.ProfileID[:], b[84:100])
+	return x
+}
+
+// profileID returns the profile ID of the profile b: the MD5 checksum
+// of the profile with the flags, rendering intent and profile ID fields
+// zeroed.
+func profileID(b []byte) [16]byte {
+	c := append([]byte(nil), b...)
+	for _, r := range [][2]int{{44, 48}, {64, 68}, {84, 100}} {
+		for i := r[0]; i < r[1]; i++ {
+			c[i] = 0
+		}
+	}
+	return md5.Sum(c)
+}
+
+// sigString returns the four character signature sig as a string.
+func sigString(sig uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sig)
+	return fmt.Sprintf("%q", b[:])
+}
+
+// s15Fixed16 returns the s15Fixed16Number at the start of b.
+func s15Fixed16(b []byte) metadata.S15Fixed16 {
+	return metadata.S15Fixed16{
+		Integer:  int16(binary.BigEndian.Uint16(b)),
+		Fraction: binary.BigEndian.Uint16(b[2:]),
+	}
+}
+
+// xyzNumber returns the XYZNumber at the start of b.
+func xyzNumber(b []byte) metadata.XYZNumber {
+	return metadata.XYZNumber{X: s15Fixed16(b), Y: s15Fixed16(b[4:]), Z: s15Fixed16(b[8:])}
+}
+
+var errShort = errors.New("tag data too short")
+
+// decodeTag returns the value of the tag data b, or nil if its type
+// isn't one that's decoded. The data starts with the type signature and
+// four reserved bytes.
+func decodeTag(b []byte) (interface{}, error) {
+	switch binary.BigEndian.Uint32(b) {
+	case typeText:
+		t := b[8:]
+		for i, c := range t {
+			if c == 0 {
+				t = t[:i]
+				break
+			}
+		}
+		return metadata.ICCText(t), nil
+	case typeDesc:
+		return decodeDesc(b)
+	case typeMLUC:
+		return decodeMLUC(b)
+	case typeXYZ:
+		v := make(metadata.ICCXYZ, (len(b)-8)/12)
+		for i := range v {
+			v[i] = xyzNumber(b[8+i*12:])
+		}
+		return v, nil
+	case typeCurve, typeParametric:
+		c, _, err := decodeCurve(b)
+		return c, err
+	case typeS15Fixed16:
+		v := make(metadata.ICCS15Fixed16Array, (len(b)-8)/4)
+		for i := range v {
+			v[i] = s15Fixed16(b[8+i*4:])
+		}
+		return v, nil
+	case typeLut8, typeLut16:
+		return decodeLut(b)
+	case typeLutAToB, typeLutBToA:
+		return decodeLutAB(b)
+	}
+	return nil, nil
+}
+
+// decodeDesc decodes a textDescriptionType tag.
+func decodeDesc(b []byte) (interface{}, error) {
+	if len(b) < 12 {
+		return nil, errShort
+	}
+	n := uint64(binary.BigEndian.Uint32(b[8:]))
+	if 12+n+8 > uint64(len(b)) {
+		return nil, errShort
+	}
+	var d metadata.ICCTextDescription
+	d.ASCII = trimNull(b[12 : 12+n])
+
+	u := b[12+n:]
+	d.UnicodeLanguage = binary.BigEndian.Uint32(u)
+	un := uint64(binary.BigEndian.Uint32(u[4:]))
+	if 8+un*2 > uint64(len(u)) {
+		return nil, errShort
+	}
+	d.Unicode = trimNull([]byte(string(utf16.Decode(uint16s(u[8 : 8+un*2])))))
+	return d, nil
+}
+
+// decodeMLUC decodes a multiLocalizedUnicodeType tag.
+func decodeMLUC(b []byte) (interface{}, error) {
+	if len(b) < 16 {
+		return nil, errShort
+	}
+	n := uint64(binary.BigEndian.Uint32(b[8:]))
+	recSize := uint64(binary.BigEndian.Uint32(b[12:]))
+	if recSize < 12 || 16+n*recSize > uint64(len(b)) {
+		return nil, errShort
+	}
+	v := make(metadata.ICCMultiLocalizedUnicode, n)
+	for i := range v {
+		r := b[16+uint64(i)*recSize:]
+		size := uint64(binary.BigEndian.Uint32(r[4:]))
+		off := uint64(binary.BigEndian.Uint32(r[8:]))
+		if off+size > uint64(len(b)) {
+			return nil, errShort
+		}
+		v[i] = metadata.ICCLocalizedString{
+			Language: string(r[:2]),
+			Country:  string(r[2:4]),
+			Text:     string(utf16.Decode(uint16s(b[off : off+size]))),
+		}
+	}
+	return v, nil
+}
+
+// trimNull returns b up to its first null byte as a string.
+func trimNull(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// uint16s returns the big endian 16 bit values in b.
+func uint16s(b []byte) []uint16 {
+	v := make([]uint16, len(b)/2)
+	for i := range v {
+		v[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return v
+}
+
+// decodeCurve decodes the curveType or parametricCurveType data at the
+// start of b. It also returns the size of the data, padded to a
+// multiple of four bytes as the curves in lutAToBType and lutBToAType
+// tags are.
+func decodeCurve(b []byte) (metadata.ICCToneCurve, int, error) {
+	if len(b) < 12 {
+		return nil, 0, errShort
+	}
+	switch binary.BigEndian.Uint32(b) {
+	case typeCurve:
+		n := uint64(binary.BigEndian.Uint32(b[8:]))
+		if 12+n*2 > uint64(len(b)) {
+			return nil, 0, errShort
+		}
+		return metadata.ICCCurve(uint16s(b[12 : 12+n*2])), pad4(12 + int(n)*2), nil
+	case typeParametric:
+		c := metadata.ICCParametricCurve{Function: binary.BigEndian.Uint16(b[8:])}
+		n := c.ParamCount()
+		if n == 0 {
+			return nil, 0, fmt.Errorf("unknown parametric curve function type %d", c.Function)
+		}
+		if 12+n*4 > len(b) {
+			return nil, 0, errShort
+		}
+		c.Params = make([]metadata.S15Fixed16, n)
+		for i := range c.Params {
+			c.Params[i] = s15Fixed16(b[12+i*4:])
+		}
+		return c, 12 + n*4, nil
+	}
+	return nil, 0, fmt.Errorf("unknown curve type %s", sigString(binary.BigEndian.Uint32(b)))
+}
+
+// pad4 returns n rounded up to a multiple of four.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// gridSize returns the number of grid points in a color lookup table
+// with the given number of points along each dimension, or an error if
+// it's unreasonably big.
+func gridSize(points []int) (int, error) {
+	n := 1
+	for _, p := range points {
+		if p < 2 {
+			return 0, errors.New("color lookup table with fewer than two grid points")
+		}
+		n *= p
+		if n > 1<<24 {
+			return 0, errors.New("color lookup table too big")
+		}
+	}
+	return n, nil
+}
+
+// readTable reads n entries of precision bytes each from the start of
+// b, scaled to 16 bits.
+func readTable(b []byte, n, precision int) []uint16 {
+	v := make([]uint16, n)
+	for i := range v {
+		if precision == 1 {
+			v[i] = uint16(b[i]) * 257
+		} else {
+			v[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+	}
+	return v
+}
+
+// decodeLut decodes a lut8Type or lut16Type tag.
+func decodeLut(b []byte) (interface{}, error) {
+	if len(b) < 48 {
+		return nil, errShort
+	}
+	l := &metadata.ICCLut{
+		Precision:      1,
+		InputChannels:  int(b[8]),
+		OutputChannels: int(b[9]),
+		GridPoints:     int(b[10]),
+	}
+	if l.InputChannels == 0 || l.OutputChannels == 0 || l.InputChannels > 15 || l.OutputChannels > 15 {
+		return nil, fmt.Errorf("lut with %d input and %d output channels", l.InputChannels, l.OutputChannels)
+	}
+	for i := range l.Matrix {
+		l.Matrix[i] = s15Fixed16(b[12+i*4:])
+	}
+	inEntries, outEntries := 256, 256
+	p := 48
+	if binary.BigEndian.Uint32(b) == typeLut16 {
+		if len(b) < 52 {
+			return nil, errShort
+		}
+		l.Precision = 2
+		inEntries = int(binary.BigEndian.Uint16(b[48:]))
+		outEntries = int(binary.BigEndian.Uint16(b[50:]))
+		p = 52
+	}
+	points := make([]int, l.InputChannels)
+	for i := range points {
+		points[i] = l.GridPoints
+	}
+	grid, err := gridSize(points)
+	if err != nil {
+		return nil, err
+	}
+
+	need := (l.InputChannels*inEntries + grid*l.OutputChannels + l.OutputChannels*outEntries) * l.Precision
+	if p+need > len(b) {
+		return nil, errShort
+	}
+	for i := 0; i < l.InputChannels; i++ {
+		l.InputTables = append(l.InputTables, readTable(b[p:], inEntries, l.Precision))
+		p += inEntries * l.Precision
+	}
+	l.CLUT = readTable(b[p:], grid*l.OutputChannels, l.Precision)
+	p += grid * l.OutputChannels * l.Precision
+	for i := 0; i < l.OutputChannels; i++ {
+		l.OutputTables = append(l.OutputTables, readTable(b[p:], outEntries, l.Precision))
+		p += outEntries * l.Precision
+	}
+	return l, nil
+}
+
+// decodeLutAB decodes a lutAToBType or lutBToAType tag.
+func decodeLutAB(b []byte) (interface{}, error) {
+	if len(b) < 32 {
+		return nil, errShort
+	}
+	l := &metadata.ICCLutAB{
+		AToB:           binary.BigEndian.Uint32(b) == typeLutAToB,
+		InputChannels:  int(b[8]),
+		OutputChannels: int(b[9]),
+	}
+	if l.InputChannels == 0 || l.OutputChannels == 0 || l.InputChannels > 15 || l.OutputChannels > 15 {
+		return nil, fmt.Errorf("lut with %d input and %d output channels", l.InputChannels, l.OutputChannels)
+	}
+	offB := int(binary.BigEndian.Uint32(b[12:]))
+	offMatrix := int(binary.BigEndian.Uint32(b[16:]))
+	offM := int(binary.BigEndian.Uint32(b[20:]))
+	offCLUT := int(binary.BigEndian.Uint32(b[24:]))
+	offA := int(binary.BigEndian.Uint32(b[28:]))
+
+	// The A side is the input of A to B transforms and the output of
+	// B to A ones, and the B side the other way round.
+	aChannels, bChannels := l.InputChannels, l.OutputChannels
+	if !l.AToB {
+		aChannels, bChannels = bChannels, aChannels
+	}
+
+	curves := func(off, n int) ([]metadata.ICCToneCurve, error) {
+		if off == 0 {
+			return nil, nil
+		}
+		var c []metadata.ICCToneCurve
+		for i := 0; i < n; i++ {
+			if off < 0 || off >= len(b) {
+				return nil, errShort
+			}
+			curve, size, err := decodeCurve(b[off:])
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, curve)
+			off += size
+		}
+		return c, nil
+	}
+	var err error
+	if l.A, err = curves(offA, aChannels); err != nil {
+		return nil, err
+	}
+	if l.M, err = curves(offM, bChannels); err != nil {
+		return nil, err
+	}
+	if l.B, err = curves(offB, bChannels); err != nil {
+		return nil, err
+	}
+
+	if offMatrix != 0 {
+		if offMatrix < 0 || offMatrix+48 > len(b) {
+			return nil, errShort
+		}
+		var m [12]metadata.S15Fixed16
+		for i := range m {
+			m[i] = s15Fixed16(b[offMatrix+i*4:])
+		}
+		l.Matrix = &m
+	}
+
+	if offCLUT != 0 {
+		if offCLUT < 0 || offCLUT+20 > len(b) {
+			return nil, errShort
+		}
+		c := &metadata.ICCCLUT{Precision: int(b[offCLUT+16])}
+		if c.Precision != 1 && c.Precision != 2 {
+			return nil, fmt.Errorf("color lookup table with precision %d", c.Precision)
+		}
+		points := make([]int, aChannels)
+		for i := range points {
+			c.GridPoints = append(c.GridPoints, b[offCLUT+i])
+			points[i] = int(b[offCLUT+i])
+		}
+		grid, err := gridSize(points)
+		if err != nil {
+			return nil, err
+		}
+		n := grid * bChannels
+		if offCLUT+20+n*c.Precision > len(b) {
+			return nil, errShort
+		}
+		c.Data = readTable(b[offCLUT+20:], n, c.Precision)
+		l.CLUT = c
+	}
+	return l, nil
+}
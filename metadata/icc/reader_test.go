@@ -0,0 +1,316 @@
+package icc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+	_ "github.com/rmamba/image/png"
+)
+
+// testTag holds a tag for buildProfile.
+type testTag struct {
+	sig  uint32
+	data []byte
+}
+
+// buildProfile returns a version 4 RGB display profile with the given
+// tags. If id is set, it has a profile ID.
+func buildProfile(tags []testTag, id bool) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, headerSize))
+	be := func(v interface{}) { binary.Write(&b, binary.BigEndian, v) }
+	be(uint32(len(tags)))
+	off := headerSize + 4 + 12*len(tags)
+	for _, t := range tags {
+		be([]uint32{t.sig, uint32(off), uint32(len(t.data))})
+		off += (len(t.data) + 3) &^ 3
+	}
+	for _, t := range tags {
+		b.Write(t.data)
+		b.Write(make([]byte, (4-len(t.data)%4)%4))
+	}
+
+	p := b.Bytes()
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	copy(p[4:], "test")
+	p[8] = 4
+	p[9] = 0x30
+	binary.BigEndian.PutUint32(p[12:], metadata.ICCClassDisplay)
+	binary.BigEndian.PutUint32(p[16:], metadata.ICCSpaceRGB)
+	binary.BigEndian.PutUint32(p[20:], metadata.ICCSpaceXYZ)
+	for i, v := range []uint16{2021, 6, 1, 12, 30, 15} {
+		binary.BigEndian.PutUint16(p[24+i*2:], v)
+	}
+	copy(p[36:], profileSignature)
+	copy(p[40:], "APPL")
+	binary.BigEndian.PutUint32(p[44:], 1)
+	binary.BigEndian.PutUint64(p[56:], 0x0102030405060708)
+	binary.BigEndian.PutUint32(p[64:], 1)
+	copy(p[68:], []byte{0, 0, 0xf6, 0xd6, 0, 1, 0, 0, 0, 0, 0xd3, 0x2d})
+	copy(p[80:], "test")
+	if id {
+		sum := profileID(p)
+		copy(p[84:], sum[:])
+	}
+	return p
+}
+
+// tagData returns the tag data with type sig and the given contents.
+func tagData(sig uint32, v ...interface{}) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{sig, 0})
+	for _, v := range v {
+		if s, ok := v.(string); ok {
+			b.WriteString(s)
+			continue
+		}
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	return b.Bytes()
+}
+
+// fixed returns v as a big endian s15Fixed16Number.
+func fixed(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+// colorants returns the colorant tags for primaries with the given
+// chromaticities, scaled to a luminance of 1/3.
+func colorants(prim [3][2]float64) []testTag {
+	var tags []testTag
+	for i, sig := range []uint32{metadata.ICCTagRedColorant, metadata.ICCTagGreenColorant, metadata.ICCTagBlueColorant} {
+		x, y := prim[i][0], prim[i][1]
+		Y := 1.0 / 3
+		tags = append(tags, testTag{sig, tagData(typeXYZ, []uint32{fixed(x / y * Y), fixed(Y), fixed((1 - x - y) / y * Y)})})
+	}
+	return tags
+}
+
+var srgbPrimaries = [3][2]float64{{0.6484, 0.3309}, {0.3212, 0.5979}, {0.1559, 0.0660}}
+
+func testProfile(id bool) []byte {
+	mluc := tagData(typeMLUC, []uint32{2, 12}, "deDE", []uint32{8, 40}, "enUS", []uint32{8, 48}, []uint16{'T', 'e', 's', 't'}, []uint16{'T', 'e', 's', 't'})
+	tags := []testTag{
+		{metadata.ICCTagProfileDescription, mluc},
+		{metadata.ICCTagCopyright, tagData(typeText, "No copyright\x00")},
+		{metadata.ICCTagMediaWhitePoint, tagData(typeXYZ, []uint32{fixed(0.9642), fixed(1), fixed(0.8249)})},
+		{metadata.ICCTagRedTRC, tagData(typeCurve, uint32(0))},
+		{metadata.ICCTagGreenTRC, tagData(typeCurve, uint32(1), uint16(0x0200))},
+		{metadata.ICCTagBlueTRC, tagData(typeParametric, uint16(3), uint16(0), []uint32{fixed(2.4), fixed(1 / 1.055), fixed(0.055 / 1.055), fixed(1 / 12.92), fixed(0.04045)})},
+		{metadata.ICCTagChromaticAdaptation, tagData(typeS15Fixed16, []uint32{fixed(1), 0, 0, 0, fixed(1), 0, 0, 0, fixed(1)})},
+		{metadata.ICCTagAToB0, tagData(typeLut16, []uint8{1, 1, 2, 0}, []uint32{fixed(1), 0, 0, 0, fixed(1), 0, 0, 0, fixed(1)},
+			[]uint16{2, 2}, []uint16{0, 0xffff}, []uint16{0x1000, 0x2000}, []uint16{0, 0xffff})},
+		{metadata.ICCTagBToA0, tagData(typeLutBToA, []uint8{1, 1, 0, 0}, []uint32{32, 0, 0, 44, 0},
+			// The B curves, then the color lookup table.
+			tagData(typeCurve, uint32(0)), []uint8{3}, make([]byte, 15), []uint8{1, 0, 0, 0}, []uint8{0, 0x80, 0xff})},
+		{0x74657374, tagData(0x74657374, "data")},
+	}
+	return buildProfile(append(tags, colorants(srgbPrimaries)...), id)
+}
+
+func TestDecode(t *testing.T) {
+	x, err := Decode(context.Background(), testProfile(true))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if x.ProfileVersion != (metadata.ProfileVersion{Major: 4, Minor: 0x30}) ||
+		x.ProfileClassSignature != metadata.ICCClassDisplay ||
+		x.ColorSpace != metadata.ICCSpaceRGB ||
+		x.ProfileConnectionSpace != metadata.ICCSpaceXYZ ||
+		x.CMMFlags != 1 || x.DeviceAttributes != 0x01020304 || x.DeviceVendorAttributes != 0x05060708 || x.RenderingIntent != 1 {
+		t.Errorf("got header %+v", x)
+	}
+	if want := time.Date(2021, 6, 1, 12, 30, 15, 0, time.UTC); !x.ProfileCreationTime.Equal(want) {
+		t.Errorf("got creation time %v, want %v", x.ProfileCreationTime, want)
+	}
+	if got := x.ProfileConnectionSpaceIlluminant.Floats(); math.Abs(got[0]-0.9642) > 1e-4 || math.Abs(got[2]-0.8249) > 1e-4 {
+		t.Errorf("got illuminant %v", got)
+	}
+	if len(x.Tags) != 13 {
+		t.Fatalf("got %d tags, want 13", len(x.Tags))
+	}
+
+	if got := x.Description(); got != "Test" {
+		t.Errorf("got description %q, want %q", got, "Test")
+	}
+	if got := x.Tag(metadata.ICCTagCopyright).Value; got != metadata.ICCText("No copyright") {
+		t.Errorf("got copyright %#v", got)
+	}
+	if got := x.Tag(metadata.ICCTagChromaticAdaptation).Value.(metadata.ICCS15Fixed16Array); len(got) != 9 || got[4].Float() != 1 {
+		t.Errorf("got chromatic adaptation %v", got)
+	}
+	unknown := x.Tag(0x74657374)
+	if unknown.Value != nil || string(unknown.Data) != "test\x00\x00\x00\x00data" {
+		t.Errorf("got unknown tag %+v", unknown)
+	}
+
+	for _, tc := range []struct {
+		sig       uint32
+		x, want   float64
+		tolerance float64
+	}{
+		{metadata.ICCTagRedTRC, 0.25, 0.25, 0},
+		{metadata.ICCTagGreenTRC, 0.5, 0.25, 1e-9},
+		{metadata.ICCTagBlueTRC, 0.5, 0.2140, 1e-3},
+		{metadata.ICCTagBlueTRC, 0.01, 0.01 / 12.92, 1e-5},
+	} {
+		c, ok := x.Tag(tc.sig).Value.(metadata.ICCToneCurve)
+		if !ok {
+			t.Errorf("%x: got %T, want a curve", tc.sig, x.Tag(tc.sig).Value)
+			continue
+		}
+		if got := c.Eval(tc.x); math.Abs(got-tc.want) > tc.tolerance {
+			t.Errorf("%x: Eval(%v) = %v, want %v", tc.sig, tc.x, got, tc.want)
+		}
+	}
+
+	wantLut := &metadata.ICCLut{
+		Precision:      2,
+		InputChannels:  1,
+		OutputChannels: 1,
+		GridPoints:     2,
+		Matrix:         [9]metadata.S15Fixed16{{Integer: 1}, {}, {}, {}, {Integer: 1}, {}, {}, {}, {Integer: 1}},
+		InputTables:    [][]uint16{{0, 0xffff}},
+		CLUT:           []uint16{0x1000, 0x2000},
+		OutputTables:   [][]uint16{{0, 0xffff}},
+	}
+	if got := x.Tag(metadata.ICCTagAToB0).Value; !reflect.DeepEqual(got, wantLut) {
+		t.Errorf("got lut %+v, want %+v", got, wantLut)
+	}
+	wantLutAB := &metadata.ICCLutAB{
+		InputChannels:  1,
+		OutputChannels: 1,
+		B:              []metadata.ICCToneCurve{metadata.ICCCurve{}},
+		CLUT:           &metadata.ICCCLUT{GridPoints: []uint8{3}, Precision: 1, Data: []uint16{0, 0x8080, 0xffff}},
+	}
+	if got := x.Tag(metadata.ICCTagBToA0).Value; !reflect.DeepEqual(got, wantLutAB) {
+		t.Errorf("got lut %+v, want %+v", got, wantLutAB)
+	}
+}
+
+func TestWideGamut(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		prim [3][2]float64
+		want bool
+	}{
+		{"sRGB", srgbPrimaries, false},
+		{"Display P3", [3][2]float64{{0.6822, 0.3197}, {0.2859, 0.6673}, {0.1527, 0.0630}}, true},
+		{"Adobe RGB", [3][2]float64{{0.6484, 0.3309}, {0.2302, 0.7016}, {0.1559, 0.0660}}, true},
+		{"narrow", [3][2]float64{{0.6, 0.34}, {0.33, 0.55}, {0.17, 0.1}}, false},
+	} {
+		x, err := Decode(context.Background(), buildProfile(colorants(tc.prim), false))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tc.name, err)
+		}
+		if got := x.WideGamut(); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeProfileID(t *testing.T) {
+	b := testProfile(true)
+	// The ID is the checksum with the flags, rendering intent and ID
+	// zeroed.
+	p := testProfile(false)
+	copy(p[44:48], make([]byte, 4))
+	copy(p[64:68], make([]byte, 4))
+	if want := md5.Sum(p); !bytes.Equal(b[84:100], want[:]) {
+		t.Errorf("got profile ID %x, want %x", b[84:100], want)
+	}
+
+	// So changing the rendering intent doesn't invalidate it.
+	b[67] = 0
+	if _, err := Decode(context.Background(), b); err != nil {
+		t.Errorf("changed rendering intent: %v", err)
+	}
+	b[100] = 1
+	if _, err := Decode(context.Background(), b); err == nil {
+		t.Error("got nil error for a bad profile ID")
+	}
+	x, err := Decode(context.Background(), b, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("skipping damaged data: %v", err)
+	}
+	if !bytes.Equal(x.ProfileID[:], b[84:100]) {
+		t.Errorf("got profile ID %x, want %x", x.ProfileID, b[84:100])
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good := testProfile(false)
+	for _, tc := range []struct {
+		name   string
+		change func([]byte) []byte
+	}{
+		{"short", func(b []byte) []byte { return b[:100] }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-4] }},
+		{"small size", func(b []byte) []byte { binary.BigEndian.PutUint32(b, 64); return b }},
+		{"signature", func(b []byte) []byte { copy(b[36:], "abcd"); return b }},
+		{"tag table", func(b []byte) []byte { binary.BigEndian.PutUint32(b[headerSize:], 1000); return b }},
+		{"tag offset", func(b []byte) []byte { binary.BigEndian.PutUint32(b[headerSize+8:], uint32(len(b))); return b }},
+		{"tag data", func(b []byte) []byte {
+			// Give the description more records than it has room for.
+			off := binary.BigEndian.Uint32(b[headerSize+8:])
+			binary.BigEndian.PutUint32(b[off+8:], 100)
+			return b
+		}},
+	} {
+		b := tc.change(append([]byte(nil), good...))
+		if _, err := Decode(context.Background(), b); err == nil {
+			t.Errorf("%s: got nil error", tc.name)
+		}
+	}
+
+	// Skipping damaged data leaves out tags that can't be read.
+	b := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(b[headerSize+8:], uint32(len(b)))
+	x, err := Decode(context.Background(), b, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("skipping damaged data: %v", err)
+	}
+	if len(x.Tags) != 12 || x.Description() != "" {
+		t.Errorf("got %d tags and description %q", len(x.Tags), x.Description())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Decode(ctx, good); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDecodeFromImage(t *testing.T) {
+	f, err := ioutil.ReadFile("../../testdata/kauaii_1.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, md, _, err := image.DecodeWithOptions(context.Background(), bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("decoding image: %v", err)
+	}
+	x, err := md.(interface {
+		ICC(context.Context, ...image.ReadOption) (*metadata.ICC, error)
+	}).ICC(context.Background())
+	if err != nil {
+		t.Fatalf("ICC: %v", err)
+	}
+	if got, want := x.Description(), "Apple Wide Color Sharing Profile"; got != want {
+		t.Errorf("got description %q, want %q", got, want)
+	}
+	// The profile is LUT based, so there are no colorants to tell
+	// whether it's wide gamut.
+	if _, ok := x.Tag(metadata.ICCTagAToB0).Value.(*metadata.ICCLutAB); !ok {
+		t.Errorf("got A2B0 %T", x.Tag(metadata.ICCTagAToB0).Value)
+	}
+}
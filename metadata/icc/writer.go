@@ -0,0 +1,442 @@
+package icc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// curveTableSize is the number of entries in the curves that
+// parametric curves are turned into for version 2 profiles, which
+// don't have them.
+const curveTableSize = 1024
+
+// Encode encodes an ICC color profile. Tags with a Value are encoded
+// from it, and other tags are written out as their Data. Tags with the
+// same data share it.
+//
+// The profile's major version has to be 2 or 4. Version 4 profiles get
+// a profile ID. Version 2 profiles don't have the multiLocalizedUnicode
+// and parametric curve tag types, so those values are written out as
+// textDescription and curve tags; version 4 profiles don't have the
+// textDescription type, which is written out as multiLocalizedUnicode.
+func Encode(ctx context.Context, x *metadata.ICC, opt ...image.WriteOption) ([]byte, error) {
+	if x == nil {
+		return nil, errors.New("icc: can't encode a nil profile")
+	}
+	v2 := x.ProfileVersion.Major == 2
+	if !v2 && x.ProfileVersion.Major != 4 {
+		return nil, fmt.Errorf("icc: can't encode version %d profiles", x.ProfileVersion.Major)
+	}
+
+	var table, data bytes.Buffer
+	be := func(b *bytes.Buffer, v interface{}) { binary.Write(b, binary.BigEndian, v) }
+	be(&table, uint32(len(x.Tags)))
+	start := headerSize + 4 + 12*len(x.Tags)
+	offsets := make(map[string]int)
+	for _, t := range x.Tags {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return nil, errors.New("icc: nil tag")
+		}
+		b := t.Data
+		if t.Value != nil {
+			var err error
+			if b, err = encodeTag(t.Value, v2); err != nil {
+				return nil, fmt.Errorf("icc: tag %s: %v", sigString(t.Signature), err)
+			}
+		}
+		if len(b) < 8 {
+			return nil, fmt.Errorf("icc: tag %s has no data", sigString(t.Signature))
+		}
+		off, ok := offsets[string(b)]
+		if !ok {
+			off = start + data.Len()
+			offsets[string(b)] = off
+			data.Write(b)
+			data.Write(make([]byte, pad4(len(b))-len(b)))
+		}
+		be(&table, []uint32{t.Signature, uint32(off), uint32(len(b))})
+	}
+
+	b := make([]byte, headerSize, start+data.Len())
+	b = append(b, table.Bytes()...)
+	b = append(b, data.Bytes()...)
+	encodeHeader(b, x)
+	if !v2 {
+		id := profileID(b)
+		copy(b[84:], id[:])
+	}
+	return b, nil
+}
+
+// encodeHeader fills in the header at the start of b, the encoded
+// profile, from x.
+func encodeHeader(b []byte, x *metadata.ICC) {
+	u32 := func(off int, v uint32) { binary.BigEndian.PutUint32(b[off:], v) }
+	u32(0, uint32(len(b)))
+	u32(4, x.CMMTypeSignature)
+	b[8] = x.ProfileVersion.Major
+	b[9] = x.ProfileVersion.Minor
+	u32(12, x.ProfileClassSignature)
+	u32(16, x.ColorSpace)
+	u32(20, x.ProfileConnectionSpace)
+	if t := x.ProfileCreationTime; !t.IsZero() {
+		t = t.UTC()
+		for i, v := range []int{t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute(), t.Second()} {
+			binary.BigEndian.PutUint16(b[24+i*2:], uint16(v))
+		}
+	}
+	copy(b[36:], profileSignature)
+	u32(40, x.PrimaryPlatformSignature)
+	u32(44, x.CMMFlags)
+	u32(48, x.DeviceManufacturer)
+	u32(52, x.DeviceModel)
+	u32(56, x.DeviceAttributes)
+	u32(60, x.DeviceVendorAttributes)
+	u32(64, x.RenderingIntent)
+	putXYZNumber(b[68:], x.ProfileConnectionSpaceIlluminant)
+	u32(80, x.ProfileCreatorSignature)
+}
+
+// putS15Fixed16 puts the s15Fixed16Number f at the start of b.
+func putS15Fixed16(b []byte, f metadata.S15Fixed16) {
+	binary.BigEndian.PutUint16(b, uint16(f.Integer))
+	binary.BigEndian.PutUint16(b[2:], f.Fraction)
+}
+
+// putXYZNumber puts the XYZNumber n at the start of b.
+func putXYZNumber(b []byte, n metadata.XYZNumber) {
+	putS15Fixed16(b, n.X)
+	putS15Fixed16(b[4:], n.Y)
+	putS15Fixed16(b[8:], n.Z)
+}
+
+// tagWriter builds the data of a tag.
+type tagWriter struct {
+	bytes.Buffer
+}
+
+// newTagWriter returns a tagWriter for a tag of the given type, with
+// the type signature and reserved bytes written.
+func newTagWriter(typ uint32) *tagWriter {
+	w := &tagWriter{}
+	w.u32(typ, 0)
+	return w
+}
+
+func (w *tagWriter) u32(v ...uint32) { binary.Write(w, binary.BigEndian, v) }
+func (w *tagWriter) u16(v ...uint16) { binary.Write(w, binary.BigEndian, v) }
+
+func (w *tagWriter) s15Fixed16(v ...metadata.S15Fixed16) {
+	var b [4]byte
+	for _, f := range v {
+		putS15Fixed16(b[:], f)
+		w.Write(b[:])
+	}
+}
+
+// pad pads the data to a multiple of four bytes.
+func (w *tagWriter) pad() {
+	w.Write(make([]byte, pad4(w.Len())-w.Len()))
+}
+
+// encodeTag returns the tag data for the tag value v, for a version 2
+// profile if v2 is set.
+func encodeTag(v interface{}, v2 bool) ([]byte, error) {
+	switch v := v.(type) {
+	case metadata.ICCText:
+		w := newTagWriter(typeText)
+		w.WriteString(string(v))
+		w.WriteByte(0)
+		return w.Bytes(), nil
+	case metadata.ICCTextDescription:
+		if !v2 {
+			return encodeMLUC(metadata.ICCMultiLocalizedUnicode{{Language: "en", Country: "US", Text: v.String()}}), nil
+		}
+		return encodeDesc(v), nil
+	case metadata.ICCMultiLocalizedUnicode:
+		if v2 {
+			return encodeDesc(metadata.ICCTextDescription{ASCII: v.String()}), nil
+		}
+		return encodeMLUC(v), nil
+	case metadata.ICCXYZ:
+		w := newTagWriter(typeXYZ)
+		for _, n := range v {
+			w.s15Fixed16(n.X, n.Y, n.Z)
+		}
+		return w.Bytes(), nil
+	case metadata.ICCCurve:
+		return encodeCurve(v), nil
+	case metadata.ICCParametricCurve:
+		if v.ParamCount() == 0 || len(v.Params) < v.ParamCount() {
+			return nil, fmt.Errorf("parametric curve function type %d with %d parameters", v.Function, len(v.Params))
+		}
+		if v2 {
+			return encodeCurve(curveTable(v)), nil
+		}
+		w := newTagWriter(typeParametric)
+		w.u16(v.Function, 0)
+		w.s15Fixed16(v.Params[:v.ParamCount()]...)
+		return w.Bytes(), nil
+	case metadata.ICCS15Fixed16Array:
+		w := newTagWriter(typeS15Fixed16)
+		w.s15Fixed16(v...)
+		return w.Bytes(), nil
+	case *metadata.ICCLut:
+		return encodeLut(v)
+	case *metadata.ICCLutAB:
+		if v2 {
+			return nil, errors.New("lutAToB and lutBToA tags need a version 4 profile")
+		}
+		return encodeLutAB(v)
+	}
+	return nil, fmt.Errorf("can't encode %T values", v)
+}
+
+// encodeDesc encodes a textDescriptionType tag. Characters in the
+// ASCII description that aren't ASCII are replaced with question marks.
+func encodeDesc(d metadata.ICCTextDescription) []byte {
+	w := newTagWriter(typeDesc)
+	ascii := []byte(d.ASCII)
+	for i, c := range ascii {
+		if c >= 0x80 {
+			ascii[i] = '?'
+		}
+	}
+	w.u32(uint32(len(ascii) + 1))
+	w.Write(ascii)
+	w.WriteByte(0)
+	if d.Unicode == "" {
+		w.u32(0, 0)
+	} else {
+		u := append(utf16.Encode([]rune(d.Unicode)), 0)
+		w.u32(d.UnicodeLanguage, uint32(len(u)))
+		w.u16(u...)
+	}
+	// The unused ScriptCode description.
+	w.Write(make([]byte, 70))
+	return w.Bytes()
+}
+
+// encodeMLUC encodes a multiLocalizedUnicodeType tag.
+func encodeMLUC(v metadata.ICCMultiLocalizedUnicode) []byte {
+	w := newTagWriter(typeMLUC)
+	w.u32(uint32(len(v)), 12)
+	var text tagWriter
+	off := 16 + 12*len(v)
+	for _, s := range v {
+		code := func(c string) string {
+			return (c + "\x00\x00")[:2]
+		}
+		w.WriteString(code(s.Language) + code(s.Country))
+		u := utf16.Encode([]rune(s.Text))
+		w.u32(uint32(len(u)*2), uint32(off+text.Len()))
+		text.u16(u...)
+	}
+	w.Write(text.Bytes())
+	return w.Bytes()
+}
+
+// encodeCurve encodes a curveType tag.
+func encodeCurve(c metadata.ICCCurve) []byte {
+	w := newTagWriter(typeCurve)
+	w.u32(uint32(len(c)))
+	w.u16(c...)
+	return w.Bytes()
+}
+
+// curveTable returns the parametric curve c as a curve, sampled with
+// curveTableSize entries. Pure power functions become a curve with a
+// single gamma entry instead.
+func curveTable(c metadata.ICCParametricCurve) metadata.ICCCurve {
+	if c.Function == 0 {
+		if g := math.Round(c.Params[0].Float() * 256); g > 0 && g <= math.MaxUint16 {
+			return metadata.ICCCurve{uint16(g)}
+		}
+	}
+	t := make(metadata.ICCCurve, curveTableSize)
+	for i := range t {
+		t[i] = uint16(math.Round(c.Eval(float64(i)/(curveTableSize-1)) * 0xffff))
+	}
+	return t
+}
+
+// checkChannels returns an error if a lookup table with the given
+// numbers of input and output channels can't be encoded.
+func checkChannels(in, out int) error {
+	if in < 1 || in > 15 || out < 1 || out > 15 {
+		return fmt.Errorf("lut with %d input and %d output channels", in, out)
+	}
+	return nil
+}
+
+// writeTable writes the entries of a lookup table with the given
+// precision.
+func (w *tagWriter) writeTable(v []uint16, precision int) {
+	if precision == 2 {
+		w.u16(v...)
+		return
+	}
+	for _, e := range v {
+		w.WriteByte(uint8((uint32(e) + 128) / 257))
+	}
+}
+
+// encodeLut encodes a lut8Type or lut16Type tag.
+func encodeLut(l *metadata.ICCLut) ([]byte, error) {
+	if err := checkChannels(l.InputChannels, l.OutputChannels); err != nil {
+		return nil, err
+	}
+	if l.GridPoints < 2 || l.GridPoints > 255 {
+		return nil, fmt.Errorf("lut with %d grid points", l.GridPoints)
+	}
+	if len(l.InputTables) != l.InputChannels || len(l.OutputTables) != l.OutputChannels {
+		return nil, errors.New("lut with the wrong number of tables")
+	}
+	points := make([]int, l.InputChannels)
+	for i := range points {
+		points[i] = l.GridPoints
+	}
+	grid, err := gridSize(points)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.CLUT) != grid*l.OutputChannels {
+		return nil, fmt.Errorf("color lookup table with %d entries, want %d", len(l.CLUT), grid*l.OutputChannels)
+	}
+	inEntries, outEntries := len(l.InputTables[0]), len(l.OutputTables[0])
+	for _, t := range l.InputTables {
+		if len(t) != inEntries {
+			return nil, errors.New("lut input tables of different sizes")
+		}
+	}
+	for _, t := range l.OutputTables {
+		if len(t) != outEntries {
+			return nil, errors.New("lut output tables of different sizes")
+		}
+	}
+
+	typ := uint32(typeLut16)
+	switch l.Precision {
+	case 1:
+		if inEntries != 256 || outEntries != 256 {
+			return nil, errors.New("lut8 tables need 256 entries")
+		}
+		typ = typeLut8
+	case 2:
+		if inEntries < 2 || inEntries > 4096 || outEntries < 2 || outEntries > 4096 {
+			return nil, fmt.Errorf("lut16 tables with %d and %d entries", inEntries, outEntries)
+		}
+	default:
+		return nil, fmt.Errorf("lut with precision %d", l.Precision)
+	}
+
+	w := newTagWriter(typ)
+	w.Write([]byte{uint8(l.InputChannels), uint8(l.OutputChannels), uint8(l.GridPoints), 0})
+	w.s15Fixed16(l.Matrix[:]...)
+	if l.Precision == 2 {
+		w.u16(uint16(inEntries), uint16(outEntries))
+	}
+	for _, t := range l.InputTables {
+		w.writeTable(t, l.Precision)
+	}
+	w.writeTable(l.CLUT, l.Precision)
+	for _, t := range l.OutputTables {
+		w.writeTable(t, l.Precision)
+	}
+	return w.Bytes(), nil
+}
+
+// encodeLutAB encodes a lutAToBType or lutBToAType tag. The elements are written in the order the
+// ICC specification suggests: the B curves, matrix, M curves, color
+// lookup table, then the A curves.
+func encodeLutAB(l *metadata.ICCLutAB) ([]byte, error) {
+	if err := checkChannels(l.InputChannels, l.OutputChannels); err != nil {
+		return nil, err
+	}
+	aChannels, bChannels := l.InputChannels, l.OutputChannels
+	typ := uint32(typeLutAToB)
+	if !l.AToB {
+		aChannels, bChannels = bChannels, aChannels
+		typ = typeLutBToA
+	}
+	w := newTagWriter(typ)
+	w.Write([]byte{uint8(l.InputChannels), uint8(l.OutputChannels), 0, 0})
+	w.Write(make([]byte, 20))
+	offset := func(i int) {
+		binary.BigEndian.PutUint32(w.Bytes()[12+i*4:], uint32(w.Len()))
+	}
+
+	curves := func(i int, c []metadata.ICCToneCurve, n int) error {
+		if c == nil {
+			return nil
+		}
+		if len(c) != n {
+			return fmt.Errorf("%d curves, want %d", len(c), n)
+		}
+		offset(i)
+		for _, c := range c {
+			if c == nil {
+				return errors.New("nil curve")
+			}
+			b, err := encodeTag(c, false)
+			if err != nil {
+				return err
+			}
+			w.Write(b)
+			w.pad()
+		}
+		return nil
+	}
+
+	if err := curves(0, l.B, bChannels); err != nil {
+		return nil, err
+	}
+	if l.Matrix != nil {
+		offset(1)
+		w.s15Fixed16(l.Matrix[:]...)
+	}
+	if err := curves(2, l.M, bChannels); err != nil {
+		return nil, err
+	}
+	if c := l.CLUT; c != nil {
+		if len(c.GridPoints) != aChannels {
+			return nil, fmt.Errorf("color lookup table with %d dimensions, want %d", len(c.GridPoints), aChannels)
+		}
+		if c.Precision != 1 && c.Precision != 2 {
+			return nil, fmt.Errorf("color lookup table with precision %d", c.Precision)
+		}
+		points := make([]int, len(c.GridPoints))
+		for i, p := range c.GridPoints {
+			points[i] = int(p)
+		}
+		grid, err := gridSize(points)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Data) != grid*bChannels {
+			return nil, fmt.Errorf("color lookup table with %d entries, want %d", len(c.Data), grid*bChannels)
+		}
+		offset(3)
+		var g [16]byte
+		copy(g[:], c.GridPoints)
+		w.Write(g[:])
+		w.Write([]byte{uint8(c.Precision), 0, 0, 0})
+		w.writeTable(c.Data, c.Precision)
+		w.pad()
+	}
+	if err := curves(4, l.A, aChannels); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,254 @@
+package icc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/png"
+)
+
+// tagValues returns the profile's tag values by signature.
+func tagValues(x *metadata.ICC) map[uint32]interface{} {
+	v := make(map[uint32]interface{})
+	for _, t := range x.Tags {
+		v[t.Signature] = t.Value
+	}
+	return v
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	want, err := Decode(context.Background(), testProfile(true))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	b, err := Encode(context.Background(), want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if len(b)%4 != 0 {
+		t.Errorf("got %d bytes, want a multiple of four", len(b))
+	}
+	got, err := Decode(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Decode of encoded profile: %v", err)
+	}
+	if !reflect.DeepEqual(tagValues(got), tagValues(want)) {
+		t.Errorf("got tags %+v, want %+v", tagValues(got), tagValues(want))
+	}
+	// The unknown tag is written out as it is.
+	if got := got.Tag(0x74657374); !bytes.Equal(got.Data, want.Tag(0x74657374).Data) {
+		t.Errorf("got unknown tag data %q", got.Data)
+	}
+	b2, err := Encode(context.Background(), got)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Error("re-encoding changed the profile")
+	}
+
+	// Encoding a version 4 profile gives it an ID.
+	var zero [16]byte
+	if got.ProfileID == zero {
+		t.Error("got no profile ID")
+	}
+	got.Tags, want.Tags = nil, nil
+	got.ProfileID = want.ProfileID
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got header %+v, want %+v", got, want)
+	}
+}
+
+func TestEncodeVersion2(t *testing.T) {
+	x := SRGB()
+	x.ProfileVersion = metadata.ProfileVersion{Major: 2, Minor: 0x10}
+	b, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(b[84:100], make([]byte, 16)) {
+		t.Errorf("got profile ID %x in a version 2 profile", b[84:100])
+	}
+	got, err := Decode(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if v, ok := got.Tag(metadata.ICCTagProfileDescription).Value.(metadata.ICCTextDescription); !ok || v.ASCII != "sRGB" {
+		t.Errorf("got description %#v", got.Tag(metadata.ICCTagProfileDescription).Value)
+	}
+	c, ok := got.Tag(metadata.ICCTagRedTRC).Value.(metadata.ICCCurve)
+	if !ok || len(c) != curveTableSize {
+		t.Fatalf("got red TRC %T", got.Tag(metadata.ICCTagRedTRC).Value)
+	}
+	for _, v := range []float64{0.01, 0.2, 0.5, 0.9} {
+		if got, want := c.Eval(v), srgbCurve.Eval(v); math.Abs(got-want) > 1e-4 {
+			t.Errorf("Eval(%v) = %v, want %v", v, got, want)
+		}
+	}
+
+	// Pure gamma curves become a single gamma entry.
+	x = AdobeRGB()
+	x.ProfileVersion.Major = 2
+	if got, err := encodeAndDecode(x); err != nil {
+		t.Fatal(err)
+	} else if c := got.Tag(metadata.ICCTagGreenTRC).Value; !reflect.DeepEqual(c, metadata.ICCCurve{563}) {
+		t.Errorf("got green TRC %v", c)
+	}
+
+	// And descriptions in version 4 profiles become
+	// multiLocalizedUnicode.
+	x.ProfileVersion.Major = 4
+	x.Tags[0].Value = metadata.ICCTextDescription{ASCII: "Description"}
+	if got, err := encodeAndDecode(x); err != nil {
+		t.Fatal(err)
+	} else if d := got.Tag(metadata.ICCTagProfileDescription).Value; !reflect.DeepEqual(d, text("Description")) {
+		t.Errorf("got description %#v", d)
+	}
+}
+
+func encodeAndDecode(x *metadata.ICC) (*metadata.ICC, error) {
+	b, err := Encode(context.Background(), x)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(context.Background(), b)
+}
+
+func TestProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile func() *metadata.ICC
+		wide    bool
+	}{
+		{"sRGB", SRGB, false},
+		{"Display P3", DisplayP3, true},
+		{"Adobe RGB (1998)", AdobeRGB, true},
+		{"Rec. 2020", Rec2020, true},
+		{"Gray Gamma 2.2", GrayGamma22, false},
+	} {
+		b, err := Encode(context.Background(), tc.profile())
+		if err != nil {
+			t.Fatalf("%s: Encode: %v", tc.name, err)
+		}
+		if len(b) > 600 {
+			t.Errorf("%s: got %d bytes, want a compact profile", tc.name, len(b))
+		}
+		x, err := Decode(context.Background(), b)
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tc.name, err)
+		}
+		if got := x.Description(); got != tc.name {
+			t.Errorf("got description %q, want %q", got, tc.name)
+		}
+		if got := x.WideGamut(); got != tc.wide {
+			t.Errorf("%s: got wide gamut %v, want %v", tc.name, got, tc.wide)
+		}
+	}
+
+	// The sRGB colorants match the usual ones.
+	x := SRGB()
+	want := [][3]float64{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	for i, sig := range []uint32{metadata.ICCTagRedColorant, metadata.ICCTagGreenColorant, metadata.ICCTagBlueColorant} {
+		got := x.Tag(sig).Value.(metadata.ICCXYZ)[0].Floats()
+		for j := range got {
+			if math.Abs(got[j]-want[i][j]) > 2e-4 {
+				t.Errorf("%x: got %v, want %v", sig, got, want[i])
+				break
+			}
+		}
+	}
+
+	// The tone response curves share their data.
+	b, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	offsets := make(map[uint32]bool)
+	for i := 0; i < int(binary.BigEndian.Uint32(b[headerSize:])); i++ {
+		e := b[headerSize+4+i*12:]
+		switch binary.BigEndian.Uint32(e) {
+		case metadata.ICCTagRedTRC, metadata.ICCTagGreenTRC, metadata.ICCTagBlueTRC:
+			offsets[binary.BigEndian.Uint32(e[4:])] = true
+		}
+	}
+	if len(offsets) != 1 {
+		t.Errorf("got TRC offsets %v, want one", offsets)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(context.Background(), nil); err == nil {
+		t.Error("nil profile: got nil error")
+	}
+	for _, tc := range []struct {
+		name   string
+		change func(*metadata.ICC)
+	}{
+		{"version", func(x *metadata.ICC) { x.ProfileVersion.Major = 3 }},
+		{"nil tag", func(x *metadata.ICC) { x.Tags = append(x.Tags, nil) }},
+		{"no data", func(x *metadata.ICC) { x.Tags = append(x.Tags, &metadata.ICCTag{Signature: 1}) }},
+		{"unknown value", func(x *metadata.ICC) { x.Tags[0].Value = 1 }},
+		{"parameters", func(x *metadata.ICC) { x.Tags[0].Value = metadata.ICCParametricCurve{Function: 3} }},
+		{"lut tables", func(x *metadata.ICC) {
+			x.Tags[0].Value = &metadata.ICCLut{Precision: 1, InputChannels: 1, OutputChannels: 1, GridPoints: 2,
+				InputTables: [][]uint16{{0, 1}}, OutputTables: [][]uint16{{0, 1}}, CLUT: []uint16{0, 1}}
+		}},
+		{"lut in version 2", func(x *metadata.ICC) {
+			x.ProfileVersion.Major = 2
+			x.Tags[0].Value = &metadata.ICCLutAB{AToB: true, InputChannels: 3, OutputChannels: 3}
+		}},
+		{"lut curves", func(x *metadata.ICC) {
+			x.Tags[0].Value = &metadata.ICCLutAB{AToB: true, InputChannels: 3, OutputChannels: 3, B: []metadata.ICCToneCurve{metadata.ICCCurve{}}}
+		}},
+	} {
+		x := SRGB()
+		tc.change(x)
+		if _, err := Encode(context.Background(), x); err == nil {
+			t.Errorf("%s: got nil error", tc.name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Encode(ctx, SRGB()); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestEncodeInImages(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		md     interface {
+			image.WriteOption
+			SetIcc(*metadata.ICC)
+		}
+	}{
+		{"png", &png.Metadata{}},
+		{"jpeg", &jpeg.Metadata{}},
+	} {
+		tc.md.SetIcc(DisplayP3())
+		var buf bytes.Buffer
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		if err := image.EncodeWithOptions(context.Background(), &buf, tc.format, img, tc.md); err != nil {
+			t.Fatalf("%s: encoding: %v", tc.format, err)
+		}
+		_, md, _, err := image.DecodeWithOptions(context.Background(), &buf)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", tc.format, err)
+		}
+		x, err := md.(metadata.ICCCarrier).ICC(context.Background())
+		if err != nil {
+			t.Fatalf("%s: ICC: %v", tc.format, err)
+		}
+		if x == nil || x.Description() != "Display P3" || !x.WideGamut() {
+			t.Errorf("%s: got profile %+v", tc.format, x)
+		}
+	}
+}
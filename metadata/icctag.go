@@ -0,0 +1,392 @@
+package metadata
+
+import (
+	"math"
+	"strings"
+)
+
+// Float returns the value of f.
+func (f S15Fixed16) Float() float64 {
+	return float64(f.Integer) + float64(f.Fraction)/65536
+}
+
+// NewS15Fixed16 returns the s15Fixed16Number nearest to v, clamped to
+// the range the type can hold.
+func NewS15Fixed16(v float64) S15Fixed16 {
+	n := math.Round(v * 65536)
+	n = math.Max(math.Min(n, math.MaxInt32), math.MinInt32)
+	return S15Fixed16{Integer: int16(int32(n) >> 16), Fraction: uint16(int32(n))}
+}
+
+// Float returns the value of f.
+func (f U16Fixed16) Float() float64 {
+	return float64(f.Integer) + float64(f.Fraction)/65536
+}
+
+// Float returns the value of f.
+func (f U8Fixed8) Float() float64 {
+	return float64(f.Integer) + float64(f.Fraction)/256
+}
+
+// Floats returns the X, Y and Z values of n.
+func (n XYZNumber) Floats() [3]float64 {
+	return [3]float64{n.X.Float(), n.Y.Float(), n.Z.Float()}
+}
+
+// Profile classes, for ICC.ProfileClassSignature.
+const (
+	ICCClassInput      = 0x73636E72 // 'scnr'
+	ICCClassDisplay    = 0x6D6E7472 // 'mntr'
+	ICCClassOutput     = 0x70727472 // 'prtr'
+	ICCClassLink       = 0x6C696E6B // 'link'
+	ICCClassAbstract   = 0x61627374 // 'abst'
+	ICCClassColorSpace = 0x73706163 // 'spac'
+	ICCClassNamedColor = 0x6E6D636C // 'nmcl'
+)
+
+// Color spaces, for ICC.ColorSpace and ICC.ProfileConnectionSpace.
+const (
+	ICCSpaceXYZ  = 0x58595A20 // 'XYZ '
+	ICCSpaceLab  = 0x4C616220 // 'Lab '
+	ICCSpaceRGB  = 0x52474220 // 'RGB '
+	ICCSpaceGray = 0x47524159 // 'GRAY'
+	ICCSpaceCMYK = 0x434D594B // 'CMYK'
+)
+
+// Tag signatures.
+const (
+	ICCTagAToB0                = 0x41324230 // 'A2B0'
+	ICCTagAToB1                = 0x41324231 // 'A2B1'
+	ICCTagAToB2                = 0x41324232 // 'A2B2'
+	ICCTagBToA0                = 0x42324130 // 'B2A0'
+	ICCTagBToA1                = 0x42324131 // 'B2A1'
+	ICCTagBToA2                = 0x42324132 // 'B2A2'
+	ICCTagBlueColorant         = 0x6258595A // 'bXYZ'
+	ICCTagBlueTRC              = 0x62545243 // 'bTRC'
+	ICCTagChromaticAdaptation  = 0x63686164 // 'chad'
+	ICCTagCopyright            = 0x63707274 // 'cprt'
+	ICCTagDeviceMfgDesc        = 0x646D6E64 // 'dmnd'
+	ICCTagDeviceModelDesc      = 0x646D6464 // 'dmdd'
+	ICCTagGrayTRC              = 0x6B545243 // 'kTRC'
+	ICCTagGreenColorant        = 0x6758595A // 'gXYZ'
+	ICCTagGreenTRC             = 0x67545243 // 'gTRC'
+	ICCTagMediaWhitePoint      = 0x77747074 // 'wtpt'
+	ICCTagProfileDescription   = 0x64657363 // 'desc'
+	ICCTagRedColorant          = 0x7258595A // 'rXYZ'
+	ICCTagRedTRC               = 0x72545243 // 'rTRC'
+	ICCTagTechnology           = 0x74656368 // 'tech'
+	ICCTagViewingCondDesc      = 0x76756564 // 'vued'
+	ICCTagMediaBlackPoint      = 0x626B7074 // 'bkpt'
+	ICCTagCharTarget           = 0x74617267 // 'targ'
+	ICCTagCalibrationDateTime  = 0x63616C74 // 'calt'
+	ICCTagLuminance            = 0x6C756D69 // 'lumi'
+	ICCTagMeasurement          = 0x6D656173 // 'meas'
+	ICCTagViewingConditions    = 0x76696577 // 'view'
+	ICCTagColorantTable        = 0x636C7274 // 'clrt'
+	ICCTagPerceptualRenderInfo = 0x72696730 // 'rig0'
+)
+
+// ICCTag holds a tag from an ICC profile's tag table.
+type ICCTag struct {
+	// Signature identifies the tag.
+	Signature uint32
+	// Data holds the tag's data as it was in the profile, starting
+	// with its type signature.
+	Data []byte
+	// Value holds the tag's decoded value, or nil if its type isn't
+	// one that's decoded. It's one of ICCText, ICCTextDescription,
+	// ICCMultiLocalizedUnicode, ICCXYZ, ICCCurve, ICCParametricCurve,
+	// ICCS15Fixed16Array, *ICCLut or *ICCLutAB. Encoders use the
+	// Value if it's set, and the Data if it isn't.
+	Value interface{}
+}
+
+// ICCText is the value of a textType tag.
+type ICCText string
+
+// ICCTextDescription is the value of a textDescriptionType tag, which
+// version 2 profiles use for descriptions.
+type ICCTextDescription struct {
+	ASCII string
+	// Unicode holds the description in the language given by
+	// UnicodeLanguage, if there is one.
+	Unicode         string
+	UnicodeLanguage uint32
+}
+
+// String returns the ASCII description, or the Unicode one if there's
+// no ASCII one.
+func (d ICCTextDescription) String() string {
+	if d.ASCII == "" {
+		return d.Unicode
+	}
+	return d.ASCII
+}
+
+// ICCLocalizedString is text in one language.
+type ICCLocalizedString struct {
+	// Language and Country hold the ISO 639-1 language code and the
+	// ISO 3166-1 country code, such as "en" and "US".
+	Language, Country string
+	Text              string
+}
+
+// ICCMultiLocalizedUnicode is the value of a multiLocalizedUnicodeType
+// tag, which version 4 profiles use for text.
+type ICCMultiLocalizedUnicode []ICCLocalizedString
+
+// String returns the English text, or the first text if there's no
+// English text.
+func (m ICCMultiLocalizedUnicode) String() string {
+	for _, s := range m {
+		if strings.EqualFold(s.Language, "en") {
+			return s.Text
+		}
+	}
+	if len(m) > 0 {
+		return m[0].Text
+	}
+	return ""
+}
+
+// ICCXYZ is the value of an XYZType tag.
+type ICCXYZ []XYZNumber
+
+// ICCS15Fixed16Array is the value of an s15Fixed16ArrayType tag, such
+// as the 3x3 matrix in a chromatic adaptation tag.
+type ICCS15Fixed16Array []S15Fixed16
+
+// ICCToneCurve is a one dimensional curve. It's implemented by ICCCurve
+// and ICCParametricCurve.
+type ICCToneCurve interface {
+	// Eval returns the curve's value at x. Both are in [0, 1].
+	Eval(x float64) float64
+}
+
+// ICCCurve is the value of a curveType tag. With no entries it's the
+// identity, with one entry it's a gamma curve with the entry's value as
+// a u8Fixed8Number exponent, and otherwise it's a table of values
+// evenly spaced over the input range.
+type ICCCurve []uint16
+
+// Eval returns the curve's value at x, interpolating between table
+// entries.
+func (c ICCCurve) Eval(x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	switch len(c) {
+	case 0:
+		return x
+	case 1:
+		return math.Pow(x, float64(c[0])/256)
+	}
+	x *= float64(len(c) - 1)
+	i := int(x)
+	if i >= len(c)-1 {
+		return float64(c[len(c)-1]) / 0xffff
+	}
+	a, b := float64(c[i]), float64(c[i+1])
+	return (a + (b-a)*(x-float64(i))) / 0xffff
+}
+
+// ICCParametricCurve is the value of a parametricCurveType tag. The
+// function type says which of the formulas in the ICC specification
+// the parameters are for.
+type ICCParametricCurve struct {
+	Function uint16
+	Params   []S15Fixed16
+}
+
+// iccCurveParams holds the number of parameters for each parametric
+// curve function type.
+var iccCurveParams = []int{1, 3, 4, 5, 7}
+
+// ParamCount returns the number of parameters the function type
+// function takes, or zero if it's unknown.
+func (c ICCParametricCurve) ParamCount() int {
+	if int(c.Function) >= len(iccCurveParams) {
+		return 0
+	}
+	return iccCurveParams[c.Function]
+}
+
+// Eval returns the curve's value at x. Curves with an unknown
+// function type, or too few parameters, are the identity.
+func (c ICCParametricCurve) Eval(x float64) float64 {
+	n := c.ParamCount()
+	if n == 0 || len(c.Params) < n {
+		return x
+	}
+	p := make([]float64, 7)
+	for i := 0; i < n; i++ {
+		p[i] = c.Params[i].Float()
+	}
+	g, a, b, cc, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(v float64) float64 { return math.Pow(math.Max(v, 0), g) }
+	var y float64
+	switch c.Function {
+	case 0:
+		y = pow(x)
+	case 1:
+		if x >= -b/a {
+			y = pow(a*x + b)
+		}
+	case 2:
+		y = cc
+		if x >= -b/a {
+			y = pow(a*x+b) + cc
+		}
+	case 3:
+		y = cc * x
+		if x >= d {
+			y = pow(a*x + b)
+		}
+	case 4:
+		y = cc*x + f
+		if x >= d {
+			y = pow(a*x+b) + e
+		}
+	}
+	return math.Max(0, math.Min(1, y))
+}
+
+// ICCLut is the value of a lut8Type or lut16Type tag. It transforms
+// values by applying the matrix, which is only used when the input is
+// XYZ, then the input tables, then the color lookup table, and then the
+// output tables.
+type ICCLut struct {
+	// Precision is the size in bytes of the table entries: 1 for
+	// lut8Type tags and 2 for lut16Type tags. Entries are held scaled
+	// to 16 bits either way, so the 8 bit entry v is held as v*257.
+	Precision      int
+	InputChannels  int
+	OutputChannels int
+	// GridPoints is the number of grid points along each dimension of
+	// the color lookup table.
+	GridPoints int
+	Matrix     [9]S15Fixed16
+	// InputTables and OutputTables hold a table for each input and
+	// output channel.
+	InputTables  [][]uint16
+	OutputTables [][]uint16
+	// CLUT holds the color lookup table's entries, with the first
+	// input channel varying slowest and the output channels together
+	// at each grid point.
+	CLUT []uint16
+}
+
+// ICCCLUT holds the color lookup table of a lutAToBType or lutBToAType
+// tag.
+type ICCCLUT struct {
+	// GridPoints holds the number of grid points along each input
+	// dimension.
+	GridPoints []uint8
+	// Precision is the size in bytes of the entries. Entries are held
+	// scaled to 16 bits either way.
+	Precision int
+	// Data holds the entries, laid out as for ICCLut.
+	Data []uint16
+}
+
+// ICCLutAB is the value of a lutAToBType or lutBToAType tag. A to B
+// transforms apply the A curves, the color lookup table, the M curves,
+// the matrix and then the B curves; B to A transforms apply them in the
+// opposite order. Any of them may be missing.
+type ICCLutAB struct {
+	// AToB is set for lutAToBType tags.
+	AToB           bool
+	InputChannels  int
+	OutputChannels int
+	// A has a curve for each of the channels on the A side, which is
+	// the input for A to B transforms. M and B have three curves.
+	A, M, B []ICCToneCurve
+	// Matrix holds a 3x3 matrix, row by row, followed by the offsets
+	// added to each row's result.
+	Matrix *[12]S15Fixed16
+	CLUT   *ICCCLUT
+}
+
+// Tag returns the profile's tag with the given signature, or nil if it
+// doesn't have one.
+func (x *ICC) Tag(sig uint32) *ICCTag {
+	for _, t := range x.Tags {
+		if t.Signature == sig {
+			return t
+		}
+	}
+	return nil
+}
+
+// Description returns the profile's description, which names it. It
+// prefers English text, and returns an empty string if the profile
+// doesn't have a description.
+func (x *ICC) Description() string {
+	t := x.Tag(ICCTagProfileDescription)
+	if t == nil {
+		return ""
+	}
+	switch v := t.Value.(type) {
+	case ICCText:
+		return string(v)
+	case ICCTextDescription:
+		return v.String()
+	case ICCMultiLocalizedUnicode:
+		return v.String()
+	}
+	return ""
+}
+
+// srgbColorants holds the chromaticities of the sRGB primaries, once
+// they've been adapted to the D50 profile connection space as profile
+// colorant tags hold them.
+var srgbColorants = [3][2]float64{
+	{0.6484, 0.3309},
+	{0.3212, 0.5979},
+	{0.1559, 0.0660},
+}
+
+// WideGamut reports whether the profile is for an RGB color space with
+// a gamut that goes beyond sRGB, such as Display P3, Adobe RGB or
+// Rec. 2020. It only knows about matrix based profiles, which describe
+// their primaries with colorant tags; for other profiles it returns
+// false.
+func (x *ICC) WideGamut() bool {
+	if x.ColorSpace != ICCSpaceRGB {
+		return false
+	}
+	var prim [3][2]float64
+	for i, sig := range []uint32{ICCTagRedColorant, ICCTagGreenColorant, ICCTagBlueColorant} {
+		t := x.Tag(sig)
+		if t == nil {
+			return false
+		}
+		v, ok := t.Value.(ICCXYZ)
+		if !ok || len(v) == 0 {
+			return false
+		}
+		xyz := v[0].Floats()
+		sum := xyz[0] + xyz[1] + xyz[2]
+		if sum <= 0 {
+			return false
+		}
+		prim[i] = [2]float64{xyz[0] / sum, xyz[1] / sum}
+	}
+
+	// The gamut is wider if any of its primaries is outside the sRGB
+	// triangle, give or take rounding in the profile.
+	const tolerance = 0.005
+	s := srgbColorants
+	for _, p := range prim {
+		for i := range s {
+			a, b := s[i], s[(i+1)%3]
+			// The signed distance of p from the edge from a to b,
+			// which is positive inside the triangle since its edges
+			// run counterclockwise.
+			dx, dy := b[0]-a[0], b[1]-a[1]
+			dist := (dx*(p[1]-a[1]) - dy*(p[0]-a[0])) / math.Hypot(dx, dy)
+			if dist < -tolerance {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rmamba/image"
+)
+
+var iptcDecoder func(context.Context, []byte, ...image.ReadOption) (*IPTC, error)
+
+func RegisterIPTCDecoder(d func(context.Context, []byte, ...image.ReadOption) (*IPTC, error)) {
+	iptcDecoder = d
+}
+
+var iptcEncoder func(context.Context, *IPTC, ...image.WriteOption) ([]byte, error)
+
+func RegisterIPTCEncoder(e func(context.Context, *IPTC, ...image.WriteOption) ([]byte, error)) {
+	iptcEncoder = e
+}
+
+// IPTCDataset holds a single IPTC-IIM dataset, identified by its record
+// and dataset numbers, such as 2:25 for keywords.
+type IPTCDataset struct {
+	Record  uint8
+	Dataset uint8
+	Data    []byte
+}
+
+// IPTC holds IPTC-IIM metadata, the news industry's metadata format.
+// The fields hold the commonly used datasets of the application record,
+// record 2, with the dataset numbers in the comments. Text is held as
+// UTF-8, whatever character set the data was read in.
+type IPTC struct {
+	ObjectName             string   // 2:05
+	Urgency                uint8    // 2:10, 1 (most urgent) to 8, or 0 if not set
+	Category               string   // 2:15
+	SupplementalCategories []string // 2:20
+	Keywords               []string // 2:25
+	SpecialInstructions    string   // 2:40
+	// DateCreated holds when the content was created. If the data
+	// only gives the date, DateOnly is set and the time is midnight
+	// UTC.
+	DateCreated                   *time.Time // 2:55, 2:60
+	DateOnly                      bool
+	Byline                        []string // 2:80
+	BylineTitle                   []string // 2:85
+	City                          string   // 2:90
+	Sublocation                   string   // 2:92
+	ProvinceState                 string   // 2:95
+	CountryCode                   string   // 2:100
+	Country                       string   // 2:101
+	OriginalTransmissionReference string   // 2:103
+	Headline                      string   // 2:105
+	Credit                        string   // 2:110
+	Source                        string   // 2:115
+	CopyrightNotice               string   // 2:116
+	Contact                       []string // 2:118
+	Caption                       string   // 2:120
+	CaptionWriter                 []string // 2:122
+	// Datasets holds the datasets that don't have a field of their
+	// own, other than the coded character set (1:90) and record
+	// version (2:00), which are written by the encoder. The text
+	// datasets of the application record are converted to UTF-8.
+	Datasets []IPTCDataset
+}
+
+func DecodeIPTC(ctx context.Context, b []byte, opt ...image.ReadOption) (*IPTC, error) {
+	if iptcDecoder == nil {
+		return nil, errors.New("No registered IPTC decoder")
+	}
+	return iptcDecoder(ctx, b, opt...)
+}
+
+func (x *IPTC) Encode(ctx context.Context, opt ...image.WriteOption) ([]byte, error) {
+	if iptcEncoder == nil {
+		return nil, errors.New("No registered IPTC encoder")
+	}
+	return iptcEncoder(ctx, x, opt...)
+}
@@ -0,0 +1,82 @@
+// Package iptc encodes and decodes IPTC-IIM format image metadata, as
+// found in the Photoshop image resources of jpeg files.
+//
+// This package must be explicitly imported for IPTC decoding to be
+// available.
+package iptc
+
+import "github.com/rmamba/image/metadata"
+
+func init() {
+	metadata.RegisterIPTCDecoder(Decode)
+	metadata.RegisterIPTCEncoder(Encode)
+}
+
+// tagMarker starts every dataset.
+const tagMarker = 0x1c
+
+// The records and datasets the package handles itself.
+const (
+	envelopeRecord    = 1
+	applicationRecord = 2
+
+	codedCharacterSet = 90 // 1:90
+	recordVersion     = 0  // 2:00
+	urgency           = 10 // 2:10
+	dateCreated       = 55 // 2:55
+	timeCreated       = 60 // 2:60
+)
+
+// recordVersionValue is the version of the application record this
+// package writes.
+const recordVersionValue = 4
+
+// utf8Escape is the ISO 2022 escape sequence that selects UTF-8, the
+// value of the coded character set dataset for UTF-8 data.
+const utf8Escape = "\x1b%G"
+
+// field ties a text dataset of the application record to the field of
+// an IPTC struct holding it. Exactly one of s and l is set, l for
+// repeatable datasets.
+type field struct {
+	dataset uint8
+	s       *string
+	l       *[]string
+}
+
+// fields returns the text fields of x, in dataset order.
+func fields(x *metadata.IPTC) []field {
+	return []field{
+		{dataset: 5, s: &x.ObjectName},
+		{dataset: 15, s: &x.Category},
+		{dataset: 20, l: &x.SupplementalCategories},
+		{dataset: 25, l: &x.Keywords},
+		{dataset: 40, s: &x.SpecialInstructions},
+		{dataset: 80, l: &x.Byline},
+		{dataset: 85, l: &x.BylineTitle},
+		{dataset: 90, s: &x.City},
+		{dataset: 92, s: &x.Sublocation},
+		{dataset: 95, s: &x.ProvinceState},
+		{dataset: 100, s: &x.CountryCode},
+		{dataset: 101, s: &x.Country},
+		{dataset: 103, s: &x.OriginalTransmissionReference},
+		{dataset: 105, s: &x.Headline},
+		{dataset: 110, s: &x.Credit},
+		{dataset: 115, s: &x.Source},
+		{dataset: 116, s: &x.CopyrightNotice},
+		{dataset: 118, l: &x.Contact},
+		{dataset: 120, s: &x.Caption},
+		{dataset: 122, l: &x.CaptionWriter},
+	}
+}
+
+// isBinary reports whether the application record dataset ds holds
+// binary data rather than text: the record version, the rasterized
+// caption and the object preview datasets.
+func isBinary(ds uint8) bool {
+	switch ds {
+	case recordVersion, 125, 200, 201, 202:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package iptc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// Decode decodes the IPTC-IIM datasets in b.
+//
+// Text is decoded with the character set given by the coded character
+// set dataset (1:90). UTF-8 and ISO 8859-1 are recognized; data without
+// the dataset, or with another character set, is taken to be UTF-8 if
+// it's valid UTF-8, and Windows-1252, the superset of ISO 8859-1 most
+// writers use, otherwise. Dates that can't be parsed are kept in the
+// Datasets field.
+func Decode(ctx context.Context, b []byte, opt ...image.ReadOption) (*metadata.IPTC, error) {
+	s, err := image.ResolveReadOptions(opt...)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := readDatasets(ctx, b, s.Damage.SkipDamagedData)
+	if err != nil {
+		return nil, err
+	}
+
+	decode := decodeText(nil)
+	for _, d := range sets {
+		if d.Record == envelopeRecord && d.Dataset == codedCharacterSet {
+			decode = decodeText(d.Data)
+		}
+	}
+
+	x := &metadata.IPTC{}
+	fs := make(map[uint8]field)
+	for _, f := range fields(x) {
+		fs[f.dataset] = f
+	}
+	var date, clock []byte
+	for _, d := range sets {
+		if d.Record == envelopeRecord && d.Dataset == codedCharacterSet {
+			continue
+		}
+		if d.Record != applicationRecord {
+			x.Datasets = append(x.Datasets, d)
+			continue
+		}
+		if f, ok := fs[d.Dataset]; ok {
+			v := decode(d.Data)
+			if f.s != nil {
+				*f.s = v
+			} else {
+				*f.l = append(*f.l, v)
+			}
+			continue
+		}
+		switch d.Dataset {
+		case recordVersion:
+			continue
+		case urgency:
+			if len(d.Data) == 1 && d.Data[0] >= '1' && d.Data[0] <= '8' {
+				x.Urgency = d.Data[0] - '0'
+				continue
+			}
+		case dateCreated:
+			date = d.Data
+			continue
+		case timeCreated:
+			clock = d.Data
+			continue
+		}
+		if !isBinary(d.Dataset) {
+			d.Data = []byte(decode(d.Data))
+		}
+		x.Datasets = append(x.Datasets, d)
+	}
+
+	if date != nil {
+		t, dateOnly, ok := parseDate(string(date), string(clock))
+		if ok {
+			x.DateCreated, x.DateOnly = &t, dateOnly
+		} else {
+			x.Datasets = append(x.Datasets, metadata.IPTCDataset{Record: applicationRecord, Dataset: dateCreated, Data: date})
+			if clock != nil {
+				x.Datasets = append(x.Datasets, metadata.IPTCDataset{Record: applicationRecord, Dataset: timeCreated, Data: clock})
+			}
+		}
+	} else if clock != nil {
+		x.Datasets = append(x.Datasets, metadata.IPTCDataset{Record: applicationRecord, Dataset: timeCreated, Data: clock})
+	}
+	return x, nil
+}
+
+// readDatasets splits b into datasets. With skip set, damaged data at
+// the end is dropped instead of giving an error.
+func readDatasets(ctx context.Context, b []byte, skip bool) ([]metadata.IPTCDataset, error) {
+	var sets []metadata.IPTCDataset
+	fail := func(msg string) ([]metadata.IPTCDataset, error) {
+		if skip {
+			return sets, nil
+		}
+		return nil, errors.New("iptc: " + msg)
+	}
+	for len(b) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if b[0] != tagMarker {
+			// Some writers pad the data out with zeros.
+			if len(bytes.Trim(b, "\x00")) == 0 {
+				break
+			}
+			return fail(fmt.Sprintf("invalid tag marker %#x", b[0]))
+		}
+		if len(b) < 5 {
+			return fail("short dataset header")
+		}
+		d := metadata.IPTCDataset{Record: b[1], Dataset: b[2]}
+		n := int(b[3])<<8 | int(b[4])
+		b = b[5:]
+		// Extended datasets, which are longer than 32767 bytes, give
+		// the size of their length in place of the length.
+		if n&0x8000 != 0 {
+			k := n & 0x7fff
+			if k == 0 || k > 4 || len(b) < k {
+				return fail("invalid extended dataset length")
+			}
+			n = 0
+			for _, c := range b[:k] {
+				n = n<<8 | int(c)
+			}
+			b = b[k:]
+		}
+		if n > len(b) {
+			return fail(fmt.Sprintf("dataset %d:%02d is truncated", d.Record, d.Dataset))
+		}
+		d.Data = b[:n:n]
+		b = b[n:]
+		sets = append(sets, d)
+	}
+	return sets, nil
+}
+
+// decodeText returns the function that decodes text in the character
+// set selected by the ISO 2022 escape sequence esc, the value of the
+// coded character set dataset.
+func decodeText(esc []byte) func([]byte) string {
+	switch string(esc) {
+	case utf8Escape:
+		return func(b []byte) string { return string(b) }
+	case "\x1b.A", "\x1b-A":
+		return latin1
+	}
+	return func(b []byte) string {
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		return latin1(b)
+	}
+}
+
+// cp1252 holds the characters Windows-1252 has in place of the C1
+// control characters of ISO 8859-1, from 0x80 on. The undefined ones
+// are left as they are.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// latin1 decodes ISO 8859-1 text, using Windows-1252 for the C1
+// control characters.
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+		if c >= 0x80 && c < 0xa0 {
+			r[i] = cp1252[c-0x80]
+		}
+	}
+	return string(r)
+}
+
+// parseDate parses the date and time created datasets. The date is
+// CCYYMMDD and the time HHMMSS±HHMM, though some writers separate
+// their parts with dashes and colons. dateOnly is set if there's no
+// time.
+func parseDate(date, clock string) (t time.Time, dateOnly, ok bool) {
+	d, err := time.Parse("20060102", date)
+	if err != nil {
+		if d, err = time.Parse("2006-01-02", date); err != nil {
+			return time.Time{}, false, false
+		}
+	}
+	if clock == "" {
+		return d, true, true
+	}
+	// Times without an offset from UTC are taken to be in UTC, as are
+	// times with a zero offset.
+	for _, layout := range []string{"150405-0700", "15:04:05-07:00", "150405", "15:04:05"} {
+		c, err := time.Parse(layout, clock)
+		if err != nil {
+			continue
+		}
+		loc := time.UTC
+		if _, off := c.Zone(); off != 0 {
+			loc = time.FixedZone(c.Format("-07:00"), off)
+		}
+		return time.Date(d.Year(), d.Month(), d.Day(), c.Hour(), c.Minute(), c.Second(), 0, loc), false, true
+	}
+	return time.Time{}, false, false
+}
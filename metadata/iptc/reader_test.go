@@ -0,0 +1,215 @@
+package iptc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image"
+	_ "github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+)
+
+// dataset returns the dataset record:ds holding v.
+func dataset(record, ds uint8, v string) []byte {
+	return append([]byte{tagMarker, record, ds, byte(len(v) >> 8), byte(len(v))}, v...)
+}
+
+// join concatenates datasets.
+func join(sets ...[]byte) []byte {
+	return bytes.Join(sets, nil)
+}
+
+func TestDecode(t *testing.T) {
+	b := join(
+		dataset(1, 90, utf8Escape),
+		dataset(1, 20, "\x00\x01"),
+		dataset(2, 0, "\x00\x04"),
+		dataset(2, 5, "Harbour"),
+		dataset(2, 10, "3"),
+		dataset(2, 25, "boats"),
+		dataset(2, 25, "Hafen"),
+		dataset(2, 25, "ボート"),
+		dataset(2, 55, "20190114"),
+		dataset(2, 60, "110456-1000"),
+		dataset(2, 80, "A. Photographer"),
+		dataset(2, 90, "Zürich"),
+		dataset(2, 101, "Switzerland"),
+		dataset(2, 105, "Boats in the harbour"),
+		dataset(2, 110, "Agency"),
+		dataset(2, 120, "Boats moored in the harbour."),
+		dataset(2, 135, "de"),
+		dataset(2, 200, "\x00\xff"),
+		// Padding.
+		[]byte{0, 0, 0},
+	)
+	x, err := Decode(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	date := time.Date(2019, 1, 14, 11, 4, 56, 0, time.FixedZone("-10:00", -10*3600))
+	want := &metadata.IPTC{
+		ObjectName:  "Harbour",
+		Urgency:     3,
+		Keywords:    []string{"boats", "Hafen", "ボート"},
+		DateCreated: &date,
+		Byline:      []string{"A. Photographer"},
+		City:        "Zürich",
+		Country:     "Switzerland",
+		Headline:    "Boats in the harbour",
+		Credit:      "Agency",
+		Caption:     "Boats moored in the harbour.",
+		Datasets: []metadata.IPTCDataset{
+			{Record: 1, Dataset: 20, Data: []byte{0, 1}},
+			{Record: 2, Dataset: 135, Data: []byte("de")},
+			{Record: 2, Dataset: 200, Data: []byte{0, 0xff}},
+		},
+	}
+	if !x.DateCreated.Equal(date) {
+		t.Errorf("got date created %v, want %v", x.DateCreated, date)
+	}
+	if _, off := x.DateCreated.Zone(); off != -10*3600 {
+		t.Errorf("got offset %d, want %d", off, -10*3600)
+	}
+	x.DateCreated = &date
+	if !reflect.DeepEqual(x, want) {
+		t.Errorf("got %+v, want %+v", x, want)
+	}
+}
+
+func TestDecodeCharsets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		charset string
+		city    string
+		want    string
+	}{
+		{"UTF-8", utf8Escape, "Z\xc3\xbcrich", "Zürich"},
+		{"Latin-1", "\x1b.A", "Z\xfcrich", "Zürich"},
+		// Without a character set, text that isn't UTF-8 is taken to
+		// be Windows-1252.
+		{"guessed UTF-8", "", "Z\xc3\xbcrich", "Zürich"},
+		{"guessed Windows-1252", "", "\x93Z\xfcrich\x94", "“Zürich”"},
+	} {
+		b := dataset(2, 90, tc.city)
+		if tc.charset != "" {
+			b = join(dataset(1, 90, tc.charset), b)
+		}
+		x, err := Decode(context.Background(), b)
+		if err != nil {
+			t.Errorf("%s: Decode: %v", tc.name, err)
+			continue
+		}
+		if x.City != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, x.City, tc.want)
+		}
+	}
+}
+
+func TestDecodeDates(t *testing.T) {
+	for _, tc := range []struct {
+		date, clock string
+		want        time.Time
+		dateOnly    bool
+	}{
+		{"20200229", "", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), true},
+		{"2020-02-29", "235959+0000", time.Date(2020, 2, 29, 23, 59, 59, 0, time.UTC), false},
+		{"20200229", "07:30:00+05:30", time.Date(2020, 2, 29, 7, 30, 0, 0, time.FixedZone("", 5*3600+1800)), false},
+		{"20200229", "073000", time.Date(2020, 2, 29, 7, 30, 0, 0, time.UTC), false},
+	} {
+		b := dataset(2, 55, tc.date)
+		if tc.clock != "" {
+			b = join(b, dataset(2, 60, tc.clock))
+		}
+		x, err := Decode(context.Background(), b)
+		if err != nil {
+			t.Errorf("%s %s: Decode: %v", tc.date, tc.clock, err)
+			continue
+		}
+		if x.DateCreated == nil || !x.DateCreated.Equal(tc.want) || x.DateOnly != tc.dateOnly {
+			t.Errorf("%s %s: got %v, %v, want %v, %v", tc.date, tc.clock, x.DateCreated, x.DateOnly, tc.want, tc.dateOnly)
+		}
+	}
+
+	// Dates that can't be parsed are kept as they are.
+	x, err := Decode(context.Background(), join(dataset(2, 55, "2020"), dataset(2, 60, "noon")))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := []metadata.IPTCDataset{
+		{Record: 2, Dataset: 55, Data: []byte("2020")},
+		{Record: 2, Dataset: 60, Data: []byte("noon")},
+	}
+	if x.DateCreated != nil || !reflect.DeepEqual(x.Datasets, want) {
+		t.Errorf("got %v, %+v, want nil, %+v", x.DateCreated, x.Datasets, want)
+	}
+}
+
+func TestDecodeExtended(t *testing.T) {
+	caption := string(bytes.Repeat([]byte("a"), 0x9000))
+	b := append([]byte{tagMarker, 2, 120, 0x80, 4, 0, 0, 0x90, 0}, caption...)
+	x, err := Decode(context.Background(), join(b, dataset(2, 122, "Editor")))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if x.Caption != caption || len(x.CaptionWriter) != 1 || x.CaptionWriter[0] != "Editor" {
+		t.Errorf("got caption of %d bytes and caption writer %q", len(x.Caption), x.CaptionWriter)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good := dataset(2, 5, "Title")
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{"bad marker", join(good, []byte{0x1d, 2, 25, 0, 0})},
+		{"short header", join(good, []byte{tagMarker, 2, 25})},
+		{"truncated", join(good, dataset(2, 25, "keyword")[:8])},
+		{"bad extended length", join(good, []byte{tagMarker, 2, 25, 0x80, 9, 0})},
+	} {
+		if _, err := Decode(context.Background(), tc.b); err == nil {
+			t.Errorf("%s: got nil error", tc.name)
+		}
+		// The datasets before the damage are kept when skipping
+		// damaged data.
+		x, err := Decode(context.Background(), tc.b, image.DamageHandlingOptions{SkipDamagedData: true})
+		if err != nil {
+			t.Errorf("%s: skipping damaged data: %v", tc.name, err)
+			continue
+		}
+		if x.ObjectName != "Title" || x.Keywords != nil {
+			t.Errorf("%s: skipping damaged data: got %+v", tc.name, x)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Decode(ctx, good); err != context.Canceled {
+		t.Errorf("cancelled: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDecodeFromImage(t *testing.T) {
+	f, err := ioutil.ReadFile("../../testdata/kauaii_1.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, md, _, err := image.DecodeWithOptions(context.Background(), bytes.NewReader(f))
+	if err != nil {
+		t.Fatalf("decoding image: %v", err)
+	}
+	x, err := md.(metadata.IPTCCarrier).IPTC(context.Background())
+	if err != nil {
+		t.Fatalf("IPTC: %v", err)
+	}
+	// The image was written with the date created separated by
+	// dashes.
+	want := time.Date(2019, 1, 14, 11, 4, 56, 0, time.FixedZone("", -10*3600))
+	if x == nil || x.DateCreated == nil || !x.DateCreated.Equal(want) || x.DateOnly {
+		t.Errorf("got %+v, want date created %v", x, want)
+	}
+}
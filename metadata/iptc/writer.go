@@ -0,0 +1,84 @@
+package iptc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// maxLength is the longest dataset that doesn't need the extended
+// dataset format.
+const maxLength = 0x7fff
+
+// Encode encodes x as IPTC-IIM datasets. Text is written as UTF-8, with
+// a coded character set dataset saying so, and the datasets are written
+// in record and dataset order, as the IIM specification requires.
+func Encode(ctx context.Context, x *metadata.IPTC, opt ...image.WriteOption) ([]byte, error) {
+	if x == nil {
+		return nil, errors.New("iptc: nil IPTC data")
+	}
+	sets := []metadata.IPTCDataset{
+		{Record: envelopeRecord, Dataset: codedCharacterSet, Data: []byte(utf8Escape)},
+		{Record: applicationRecord, Dataset: recordVersion, Data: []byte{0, recordVersionValue}},
+	}
+	add := func(ds uint8, v string) {
+		sets = append(sets, metadata.IPTCDataset{Record: applicationRecord, Dataset: ds, Data: []byte(v)})
+	}
+	for _, f := range fields(x) {
+		if f.s != nil {
+			if *f.s != "" {
+				add(f.dataset, *f.s)
+			}
+			continue
+		}
+		for _, v := range *f.l {
+			add(f.dataset, v)
+		}
+	}
+	if x.Urgency != 0 {
+		if x.Urgency > 8 {
+			return nil, errors.New("iptc: urgency out of range")
+		}
+		add(urgency, string([]byte{'0' + x.Urgency}))
+	}
+	if t := x.DateCreated; t != nil {
+		add(dateCreated, t.Format("20060102"))
+		if !x.DateOnly {
+			add(timeCreated, t.Format("150405-0700"))
+		}
+	}
+	for _, d := range x.Datasets {
+		if (d.Record == envelopeRecord && d.Dataset == codedCharacterSet) ||
+			(d.Record == applicationRecord && d.Dataset == recordVersion) {
+			continue
+		}
+		sets = append(sets, d)
+	}
+	sort.SliceStable(sets, func(i, j int) bool {
+		if sets[i].Record != sets[j].Record {
+			return sets[i].Record < sets[j].Record
+		}
+		return sets[i].Dataset < sets[j].Dataset
+	})
+
+	var b bytes.Buffer
+	for _, d := range sets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b.Write([]byte{tagMarker, d.Record, d.Dataset})
+		if n := len(d.Data); n > maxLength {
+			// The extended format gives the length in the four bytes
+			// after the size of the length.
+			b.Write([]byte{0x80, 4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+		} else {
+			b.Write([]byte{byte(n >> 8), byte(n)})
+		}
+		b.Write(d.Data)
+	}
+	return b.Bytes(), nil
+}
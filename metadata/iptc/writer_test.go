@@ -0,0 +1,128 @@
+package iptc
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	date := time.Date(2021, 7, 4, 18, 30, 0, 0, time.FixedZone("-04:00", -4*3600))
+	x := &metadata.IPTC{
+		ObjectName:                    "Fireworks",
+		Urgency:                       2,
+		Category:                      "NEW",
+		SupplementalCategories:        []string{"holidays"},
+		Keywords:                      []string{"fireworks", "Independence Day", "feux d'artifice"},
+		SpecialInstructions:           "Embargoed until 20:00",
+		DateCreated:                   &date,
+		Byline:                        []string{"Jane Doe", "John Roe"},
+		BylineTitle:                   []string{"Staff photographer"},
+		City:                          "New York",
+		Sublocation:                   "East River",
+		ProvinceState:                 "NY",
+		CountryCode:                   "USA",
+		Country:                       "United States",
+		OriginalTransmissionReference: "NYC-123",
+		Headline:                      "Fireworks over the East River",
+		Credit:                        "Agency",
+		Source:                        "Agency Photo Desk",
+		CopyrightNotice:               "© 2021 Agency",
+		Contact:                       []string{"desk@example.com"},
+		Caption:                       "Fireworks light up the sky over the East River.",
+		CaptionWriter:                 []string{"jr"},
+		Datasets: []metadata.IPTCDataset{
+			{Record: 1, Dataset: 20, Data: []byte{0, 1}},
+			{Record: 2, Dataset: 135, Data: []byte("en")},
+		},
+	}
+	b, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.DateCreated.Equal(date) {
+		t.Errorf("got date created %v, want %v", got.DateCreated, date)
+	}
+	got.DateCreated = &date
+	if !reflect.DeepEqual(got, x) {
+		t.Errorf("got %+v, want %+v", got, x)
+	}
+
+	// Dates without times stay that way.
+	x = &metadata.IPTC{DateCreated: &date, DateOnly: true}
+	if b, err = Encode(context.Background(), x); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if got, err = Decode(context.Background(), b); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.DateOnly || got.DateCreated.Format("20060102") != "20210704" {
+		t.Errorf("got date created %v, date only %v", got.DateCreated, got.DateOnly)
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	x := &metadata.IPTC{
+		Caption:  "Caption",
+		Keywords: []string{"one", "two"},
+		Datasets: []metadata.IPTCDataset{
+			{Record: 2, Dataset: 26, Data: []byte("x")},
+			// These are written by the encoder.
+			{Record: 1, Dataset: 90, Data: []byte("\x1b.A")},
+			{Record: 2, Dataset: 0, Data: []byte{0, 2}},
+		},
+	}
+	b, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// The datasets are in record and dataset order, with repeated
+	// datasets in their order in the struct.
+	want := join(
+		dataset(1, 90, utf8Escape),
+		dataset(2, 0, "\x00\x04"),
+		dataset(2, 25, "one"),
+		dataset(2, 25, "two"),
+		dataset(2, 26, "x"),
+		dataset(2, 120, "Caption"),
+	)
+	if !bytes.Equal(b, want) {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
+	// Long datasets use the extended format.
+	x = &metadata.IPTC{Caption: strings.Repeat("a", 0x8000)}
+	if b, err = Encode(context.Background(), x); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if i := bytes.Index(b, []byte{tagMarker, 2, 120}); i < 0 || !bytes.Equal(b[i+3:i+9], []byte{0x80, 4, 0, 0, 0x80, 0}) {
+		t.Errorf("long caption: got header %x", b[i:i+9])
+	}
+	got, err := Decode(context.Background(), b)
+	if err != nil || got.Caption != x.Caption {
+		t.Errorf("long caption: got %d bytes, %v", len(got.Caption), err)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(context.Background(), nil); err == nil {
+		t.Error("nil: got nil error")
+	}
+	if _, err := Encode(context.Background(), &metadata.IPTC{Urgency: 9}); err == nil {
+		t.Error("urgency 9: got nil error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Encode(ctx, &metadata.IPTC{Headline: "Headline"}); err != context.Canceled {
+		t.Errorf("cancelled: got %v, want %v", err, context.Canceled)
+	}
+}
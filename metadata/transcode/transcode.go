@@ -0,0 +1,404 @@
+// Package transcode converts image metadata from one image format to
+// another, so that it survives when an image is converted.
+//
+// EXIF, XMP and ICC data are carried over undecoded whenever possible,
+// so converting metadata doesn't require the EXIF, XMP or ICC decoders
+// to be registered.
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/gif"
+	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/png"
+)
+
+// Item describes a piece of metadata that couldn't be carried over to
+// the target format.
+type Item struct {
+	// Name names the piece of metadata, such as "EXIF" or "png gAMA
+	// chunk".
+	Name string
+	// Reason says why the target format can't represent it.
+	Reason string
+}
+
+// String generates a human readable version of the item.
+func (i Item) String() string {
+	return fmt.Sprintf("%s: %s", i.Name, i.Reason)
+}
+
+// Report lists the metadata that Convert couldn't represent in the
+// target format.
+type Report struct {
+	// Unrepresentable holds the items that were dropped, in the order
+	// they were found.
+	Unrepresentable []Item
+}
+
+// drop records that the named item couldn't be carried over.
+func (r *Report) drop(name, reason string) {
+	r.Unrepresentable = append(r.Unrepresentable, Item{name, reason})
+}
+
+// The raw carrier interfaces are implemented by image metadata that
+// gives access to its embedded metadata without decoding it.
+type (
+	rawEXIFCarrier interface {
+		RawEXIF() []byte
+		SetRawEXIF(b []byte)
+	}
+	rawXMPCarrier interface {
+		RawXMP() string
+		SetRawXMP(x string)
+	}
+	rawICCCarrier interface {
+		RawICC() []byte
+		SetRawICC(b []byte)
+	}
+	rawIPTCCarrier interface {
+		RawIPTC() []byte
+	}
+)
+
+// Resolution units.
+const (
+	// unitNone means the resolution only gives the pixel aspect ratio.
+	unitNone = iota
+	unitInch
+	unitCentimeter
+	unitMeter
+)
+
+// resolution holds the physical pixel density of an image.
+type resolution struct {
+	x, y float64
+	unit int
+}
+
+// perMeter returns the resolution's pixel density in pixels per meter.
+// Resolutions that only give the aspect ratio are returned unchanged.
+func (r *resolution) perMeter() (float64, float64) {
+	switch r.unit {
+	case unitInch:
+		return r.x / 0.0254, r.y / 0.0254
+	case unitCentimeter:
+		return r.x * 100, r.y * 100
+	}
+	return r.x, r.y
+}
+
+// common holds the metadata that more than one image format can
+// represent, in a format-neutral way.
+type common struct {
+	config image.Config
+
+	// The EXIF, XMP and ICC data are each held either undecoded or
+	// decoded, depending on how they were found.
+	rawEXIF []byte
+	exif    *metadata.EXIF
+	rawXMP  string
+	xmp     *metadata.XMP
+	rawICC  []byte
+	icc     *metadata.ICC
+
+	res      *resolution
+	comments []string
+}
+
+// commentKey is the png text key that holds comments.
+const commentKey = "Comment"
+
+// Convert returns metadata for the image format named format, one of
+// "png", "jpeg" or "gif", holding as much of the metadata in src as
+// that format can represent. The result can be passed as a write
+// option to the format's EncodeExtended function, or to
+// image.EncodeWithOptions. The report lists the items from src that
+// had to be dropped.
+//
+// EXIF, XMP and ICC data, the physical resolution and comments are
+// carried over between formats wherever the target has a place for
+// them. If src is already in the target format it's returned as it is.
+// A nil src gives empty metadata. An unknown format gives an error
+// wrapping image.ErrFormat.
+func Convert(ctx context.Context, src image.Metadata, format string) (image.Metadata, *Report, error) {
+	r := &Report{}
+	switch format {
+	case "png", "jpeg", "gif":
+	default:
+		return nil, nil, fmt.Errorf("%w: can't convert metadata to %q", image.ErrFormat, format)
+	}
+	if src != nil && src.ImageMetadataFormat() == format {
+		return src, r, nil
+	}
+
+	c := &common{}
+	if src != nil {
+		if err := c.extract(ctx, src, r); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch format {
+	case "png":
+		return c.toPNG(r), r, nil
+	case "jpeg":
+		return c.toJPEG(r), r, nil
+	}
+	return c.toGIF(r), r, nil
+}
+
+// extract fills in c from src, recording anything that has no place
+// in c in r.
+func (c *common) extract(ctx context.Context, src image.Metadata, r *Report) error {
+	c.config = src.GetConfig()
+
+	if rc, ok := src.(rawEXIFCarrier); ok {
+		// The EXIF data should be a bare TIFF structure, but some
+		// writers leave the jpeg APP1 segment's "Exif\x00\x00" header in
+		// front of it. A png eXIf chunk can't hold the header, and the
+		// jpeg encoder adds its own, so it's dropped here.
+		c.rawEXIF = bytes.TrimPrefix(rc.RawEXIF(), []byte("Exif\x00\x00"))
+	}
+	if ec, ok := src.(metadata.EXIFCarrier); ok && c.rawEXIF == nil {
+		x, err := ec.EXIF(ctx)
+		if err != nil {
+			return err
+		}
+		c.exif = x
+	}
+	if rc, ok := src.(rawXMPCarrier); ok {
+		c.rawXMP = rc.RawXMP()
+	}
+	if xc, ok := src.(metadata.XMPCarrier); ok && c.rawXMP == "" {
+		x, err := xc.XMP(ctx)
+		if err != nil {
+			return err
+		}
+		c.xmp = x
+	}
+	if rc, ok := src.(rawICCCarrier); ok {
+		c.rawICC = rc.RawICC()
+	}
+	if ic, ok := src.(metadata.ICCCarrier); ok && c.rawICC == nil {
+		i, err := ic.ICC(ctx)
+		if err != nil {
+			return err
+		}
+		c.icc = i
+	}
+
+	// Only jpeg files hold IPTC data, and src isn't in the target
+	// format.
+	if rc, ok := src.(rawIPTCCarrier); ok && rc.RawIPTC() != nil {
+		r.drop("IPTC", "only jpeg files hold IPTC data")
+	} else if ic, ok := src.(metadata.IPTCCarrier); ok {
+		x, err := ic.IPTC(ctx)
+		if err != nil {
+			return err
+		}
+		if x != nil {
+			r.drop("IPTC", "only jpeg files hold IPTC data")
+		}
+	}
+
+	switch m := src.(type) {
+	case *png.Metadata:
+		c.extractPNG(m, r)
+	case *jpeg.Metadata:
+		c.extractJPEG(m, r)
+	case *gif.Metadata:
+		c.comments = append(c.comments, m.Comments...)
+		for id := range m.Extensions {
+			r.drop(fmt.Sprintf("gif application extension %q", id), "only gif files have application extensions")
+		}
+	}
+	return nil
+}
+
+// extractPNG fills in c from the png specific metadata in m.
+func (c *common) extractPNG(m *png.Metadata, r *Report) {
+	if d := m.Dimension; d != nil {
+		c.res = &resolution{x: float64(d.X), y: float64(d.Y), unit: unitNone}
+		if d.Unit == png.UnitMeter {
+			c.res.unit = unitMeter
+		}
+	}
+	for _, t := range m.Text {
+		if t.Key == commentKey {
+			c.comments = append(c.comments, t.Value)
+			continue
+		}
+		r.drop(fmt.Sprintf("png text entry %q", t.Key), "only png files have keyed text entries")
+	}
+
+	const reason = "only png files have this chunk"
+	if m.LastModified != nil {
+		r.drop("png tIME chunk", reason)
+	}
+	if m.Chroma != nil {
+		r.drop("png cHRM chunk", reason)
+	}
+	if m.Gamma != nil {
+		r.drop("png gAMA chunk", reason)
+	}
+	if m.SRGBIntent != nil {
+		r.drop("png sRGB chunk", reason)
+	}
+	if m.SignificantBits != nil {
+		r.drop("png sBIT chunk", reason)
+	}
+	if m.Background != nil {
+		r.drop("png bKGD chunk", reason)
+	}
+	if m.Histogram != nil {
+		r.drop("png hIST chunk", reason)
+	}
+}
+
+// extractJPEG fills in c from the jpeg specific metadata in m.
+func (c *common) extractJPEG(m *jpeg.Metadata, r *Report) {
+	if m.XDensity != 0 && m.YDensity != 0 {
+		c.res = &resolution{x: float64(m.XDensity), y: float64(m.YDensity), unit: unitNone}
+		switch m.Units {
+		case 1:
+			c.res.unit = unitInch
+		case 2:
+			c.res.unit = unitCentimeter
+		}
+	}
+	c.comments = append(c.comments, m.Comments...)
+
+	if m.Thumbnail != nil {
+		r.drop("jpeg JFIF thumbnail", "only jpeg files have JFIF thumbnails")
+	}
+	for _, res := range m.PhotoshopResources {
+		r.drop(fmt.Sprintf("jpeg Photoshop image resource %#04x", res.ID), "only jpeg files have Photoshop image resources")
+	}
+	for marker := 0xe0; marker <= 0xef; marker++ {
+		for range m.UnknownSegments()[uint8(marker)] {
+			r.drop(fmt.Sprintf("jpeg APP%d segment", marker-0xe0), "the segment's contents aren't understood")
+		}
+	}
+}
+
+// toPNG returns c as png metadata.
+func (c *common) toPNG(r *Report) *png.Metadata {
+	m := &png.Metadata{Width: c.config.Width, Height: c.config.Height, ColorModel: c.config.ColorModel}
+	c.setCarriers(m)
+
+	if c.res != nil {
+		x, y := c.res.perMeter()
+		d := &png.Dimension{X: int(math.Round(x)), Y: int(math.Round(y)), Unit: png.UnitUnknown}
+		if c.res.unit != unitNone {
+			d.Unit = png.UnitMeter
+		}
+		if d.X > 0 && d.Y > 0 && d.X <= math.MaxInt32 && d.Y <= math.MaxInt32 {
+			m.Dimension = d
+		} else {
+			r.drop("resolution", "out of range for a png pHYs chunk")
+		}
+	}
+
+	for _, v := range c.comments {
+		t := &png.TextEntry{Key: commentKey, Value: v, EntryType: png.EtText}
+		// tEXt chunks hold Latin-1 text, so anything else has to go
+		// in an iTXt chunk.
+		for _, ch := range v {
+			if ch > 0xff {
+				t.EntryType = png.EtItext
+				break
+			}
+		}
+		m.Text = append(m.Text, t)
+	}
+	return m
+}
+
+// toJPEG returns c as jpeg metadata.
+func (c *common) toJPEG(r *Report) *jpeg.Metadata {
+	m := &jpeg.Metadata{Width: c.config.Width, Height: c.config.Height, ColorModel: c.config.ColorModel}
+	c.setCarriers(m)
+
+	if c.res != nil {
+		x, y := c.res.x, c.res.y
+		switch c.res.unit {
+		case unitInch:
+			m.Units = 1
+		case unitCentimeter:
+			m.Units = 2
+		case unitMeter:
+			// JFIF has no per-meter unit, and pixels per inch is
+			// what most readers expect.
+			m.Units = 1
+			x, y = x*0.0254, y*0.0254
+		}
+		x, y = math.Round(x), math.Round(y)
+		if x >= 1 && y >= 1 && x <= math.MaxUint16 && y <= math.MaxUint16 {
+			m.XDensity, m.YDensity = uint16(x), uint16(y)
+		} else {
+			m.Units = 0
+			r.drop("resolution", "out of range for a jpeg JFIF segment")
+		}
+	}
+
+	m.Comments = append(m.Comments, c.comments...)
+	return m
+}
+
+// toGIF returns c as gif metadata.
+func (c *common) toGIF(r *Report) *gif.Metadata {
+	m := &gif.Metadata{Width: c.config.Width, Height: c.config.Height, ColorModel: c.config.ColorModel}
+	c.setCarriers(m)
+
+	if c.rawEXIF != nil || c.exif != nil {
+		r.drop("EXIF", "gif files can't hold EXIF data")
+	}
+	if c.rawICC != nil || c.icc != nil {
+		r.drop("ICC profile", "gif files can't hold ICC profiles")
+	}
+	if c.res != nil {
+		r.drop("resolution", "gif files can't hold a physical resolution")
+	}
+
+	m.Comments = append(m.Comments, c.comments...)
+	return m
+}
+
+// setCarriers sets the EXIF, XMP and ICC data in m, for each of them
+// that m can carry. The undecoded data is used if there is any.
+func (c *common) setCarriers(m image.Metadata) {
+	if c.rawEXIF != nil {
+		if rc, ok := m.(rawEXIFCarrier); ok {
+			rc.SetRawEXIF(c.rawEXIF)
+		}
+	} else if c.exif != nil {
+		if ec, ok := m.(metadata.EXIFCarrier); ok {
+			ec.SetEXIF(c.exif)
+		}
+	}
+	if c.rawXMP != "" {
+		if rc, ok := m.(rawXMPCarrier); ok {
+			rc.SetRawXMP(c.rawXMP)
+		}
+	} else if c.xmp != nil {
+		if xc, ok := m.(metadata.XMPCarrier); ok {
+			xc.SetXMP(c.xmp)
+		}
+	}
+	if c.rawICC != nil {
+		if rc, ok := m.(rawICCCarrier); ok {
+			rc.SetRawICC(c.rawICC)
+		}
+	} else if c.icc != nil {
+		if ic, ok := m.(metadata.ICCCarrier); ok {
+			ic.SetICC(c.icc)
+		}
+	}
+}
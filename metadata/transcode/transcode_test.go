@@ -0,0 +1,210 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/gif"
+	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	_ "github.com/rmamba/image/metadata/exif"
+	"github.com/rmamba/image/png"
+)
+
+// Stand-ins for embedded metadata. They're never decoded, so they
+// don't need to be valid.
+var (
+	testEXIF = []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00")
+	testXMP  = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?><x:xmpmeta xmlns:x="adobe:ns:meta/"/><?xpacket end="w"?>`
+	testICC  = bytes.Repeat([]byte("icc profile "), 10)
+)
+
+// roundTrip encodes an image with the metadata m in the image format
+// named format and decodes it again, returning the decoded metadata.
+func roundTrip(t *testing.T, format string, m image.Metadata) image.Metadata {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := image.EncodeWithOptions(context.Background(), &buf, format, img, m.(image.WriteOption)); err != nil {
+		t.Fatalf("encoding %s: %v", format, err)
+	}
+	_, md, _, err := image.DecodeWithOptions(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", format, err)
+	}
+	return md
+}
+
+// names returns the names of the items in r.
+func names(r *Report) []string {
+	var n []string
+	for _, i := range r.Unrepresentable {
+		n = append(n, i.Name)
+	}
+	return n
+}
+
+func TestJPEGToPNG(t *testing.T) {
+	src := &jpeg.Metadata{Units: 1, XDensity: 72, YDensity: 72, Comments: []string{"plain", "ünïcode ☺"}}
+	src.SetRawEXIF(testEXIF)
+	src.SetRawXMP(testXMP)
+	src.SetRawICC(testICC)
+
+	md, r, err := Convert(context.Background(), roundTrip(t, "jpeg", src), "png")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if len(r.Unrepresentable) != 0 {
+		t.Errorf("got unrepresentable items %v, want none", r.Unrepresentable)
+	}
+
+	got := roundTrip(t, "png", md).(*png.Metadata)
+	if !bytes.Equal(got.RawEXIF(), testEXIF) {
+		t.Errorf("got EXIF %q, want %q", got.RawEXIF(), testEXIF)
+	}
+	if got.RawXMP() != testXMP {
+		t.Errorf("got XMP %q, want %q", got.RawXMP(), testXMP)
+	}
+	if !bytes.Equal(got.RawICC(), testICC) {
+		t.Errorf("got ICC profile %q, want %q", got.RawICC(), testICC)
+	}
+	wantDim := png.Dimension{X: 2835, Y: 2835, Unit: png.UnitMeter}
+	if got.Dimension == nil || *got.Dimension != wantDim {
+		t.Errorf("got dimension %v, want %v", got.Dimension, wantDim)
+	}
+	var comments []string
+	for _, e := range got.Text {
+		if e.Key == "Comment" {
+			comments = append(comments, e.Value)
+		}
+	}
+	if !reflect.DeepEqual(comments, src.Comments) {
+		t.Errorf("got comments %q, want %q", comments, src.Comments)
+	}
+}
+
+func TestJPEGToPNGEXIFHeader(t *testing.T) {
+	ctx := context.Background()
+	tiff, err := (&metadata.EXIF{Artist: "someone"}).Encode(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &jpeg.Metadata{}
+	src.SetRawEXIF(append([]byte("Exif\x00\x00"), tiff...))
+
+	md, _, err := Convert(ctx, src, "png")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	got := roundTrip(t, "png", md).(*png.Metadata)
+	if !bytes.Equal(got.RawEXIF(), tiff) {
+		t.Errorf("got EXIF %q, want %q", got.RawEXIF(), tiff)
+	}
+	x, err := got.EXIF(ctx)
+	if err != nil {
+		t.Fatalf("EXIF: %v", err)
+	}
+	if x == nil || x.Artist != "someone" {
+		t.Errorf("got EXIF %+v, want Artist someone", x)
+	}
+}
+
+func TestJPEGPhotoshopResources(t *testing.T) {
+	src := &jpeg.Metadata{PhotoshopResources: []jpeg.PhotoshopResource{{ID: 0x03ed, Data: []byte{0, 0x48}}}}
+	src.SetRawIPTC([]byte("\x1c\x02\x05\x00\x05Title"))
+	_, r, err := Convert(context.Background(), src, "png")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	wantDropped := []string{"IPTC", "jpeg Photoshop image resource 0x03ed"}
+	if got := names(r); !reflect.DeepEqual(got, wantDropped) {
+		t.Errorf("got unrepresentable items %q, want %q", got, wantDropped)
+	}
+}
+
+func TestPNGToJPEG(t *testing.T) {
+	gamma := uint32(45455)
+	src := &png.Metadata{
+		Dimension: &png.Dimension{X: 3780, Y: 3780, Unit: png.UnitMeter},
+		Gamma:     &gamma,
+		Text: []*png.TextEntry{
+			{Key: "Comment", Value: "hello", EntryType: png.EtText},
+			{Key: "Author", Value: "someone", EntryType: png.EtText},
+		},
+	}
+	src.SetRawEXIF(testEXIF)
+	src.SetRawICC(testICC)
+
+	md, r, err := Convert(context.Background(), roundTrip(t, "png", src), "jpeg")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	wantDropped := []string{`png text entry "Author"`, "png gAMA chunk"}
+	if got := names(r); !reflect.DeepEqual(got, wantDropped) {
+		t.Errorf("got unrepresentable items %q, want %q", got, wantDropped)
+	}
+
+	got := roundTrip(t, "jpeg", md).(*jpeg.Metadata)
+	if !bytes.Equal(got.RawEXIF(), testEXIF) {
+		t.Errorf("got EXIF %q, want %q", got.RawEXIF(), testEXIF)
+	}
+	if !bytes.Equal(got.RawICC(), testICC) {
+		t.Errorf("got ICC profile %q, want %q", got.RawICC(), testICC)
+	}
+	if got.Units != 1 || got.XDensity != 96 || got.YDensity != 96 {
+		t.Errorf("got density %v x %v %v, want 96 x 96 Inch", got.XDensity, got.YDensity, got.Units)
+	}
+	if want := []string{"hello"}; !reflect.DeepEqual(got.Comments, want) {
+		t.Errorf("got comments %q, want %q", got.Comments, want)
+	}
+}
+
+func TestPNGToGIF(t *testing.T) {
+	src := &png.Metadata{
+		Dimension: &png.Dimension{X: 3780, Y: 3780, Unit: png.UnitMeter},
+		Text:      []*png.TextEntry{{Key: "Comment", Value: "hello", EntryType: png.EtText}},
+	}
+	src.SetRawEXIF(testEXIF)
+	src.SetRawXMP(testXMP)
+	src.SetRawICC(testICC)
+
+	md, r, err := Convert(context.Background(), src, "gif")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	wantDropped := []string{"EXIF", "ICC profile", "resolution"}
+	if got := names(r); !reflect.DeepEqual(got, wantDropped) {
+		t.Errorf("got unrepresentable items %q, want %q", got, wantDropped)
+	}
+
+	got := roundTrip(t, "gif", md).(*gif.Metadata)
+	if got.RawXMP() != testXMP {
+		t.Errorf("got XMP %q, want %q", got.RawXMP(), testXMP)
+	}
+	if want := []string{"hello"}; !reflect.DeepEqual(got.Comments, want) {
+		t.Errorf("got comments %q, want %q", got.Comments, want)
+	}
+}
+
+func TestConvertSameFormat(t *testing.T) {
+	src := &gif.Metadata{Comments: []string{"hello"}}
+	md, r, err := Convert(context.Background(), src, "gif")
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if md != src || len(r.Unrepresentable) != 0 {
+		t.Errorf("got %v, %v, want the source metadata unchanged", md, r.Unrepresentable)
+	}
+}
+
+func TestConvertUnknownFormat(t *testing.T) {
+	_, _, err := Convert(context.Background(), &gif.Metadata{}, "tiff")
+	if !errors.Is(err, image.ErrFormat) || !strings.Contains(err.Error(), "tiff") {
+		t.Errorf("got error %v, want ErrFormat", err)
+	}
+}
@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"context"
+	"encoding/xml"
 	"errors"
 	"time"
 
@@ -20,6 +21,36 @@ func RegisterXMPEncoder(e func(context.Context, *XMP, ...image.WriteOption) (str
 	xmpEncoder = e
 }
 
+// XMPDate is an XMP date. XMP dates may stop at the year, month, day
+// or minute, and may leave out the time zone, so XMPDate keeps how much
+// was given alongside the time, and is written back the same way.
+type XMPDate struct {
+	time.Time
+	// Precision says how much of the date is given.
+	Precision XMPDatePrecision
+	// NoZone is set for dates given without a time zone. Their time is
+	// in UTC.
+	NoZone bool
+}
+
+// XMPDatePrecision says how much of an XMP date is given.
+type XMPDatePrecision int
+
+const (
+	// XMPDateSecond dates are given to the second, with fractional
+	// seconds if there are any.
+	XMPDateSecond XMPDatePrecision = iota
+	XMPDateYear
+	XMPDateMonth
+	XMPDateDay
+	XMPDateMinute
+)
+
+// NewXMPDate returns the XMP date for t, given to the second.
+func NewXMPDate(t time.Time) XMPDate {
+	return XMPDate{Time: t}
+}
+
 type LanguageAlternative struct {
 	Language string
 	Text     string
@@ -64,16 +95,63 @@ type XMPRights struct {
 	WebStatement string
 }
 
-// These three are placeholders and should be fixed later
+// GUID identifies a document, or one version of a document. It's a
+// URI, such as "xmp.did:..." or "uuid:...".
 type GUID string
-type ResourceRef string
+
+// RenditionClass says what kind of rendition of a document a file is.
+// It's one of the tokens below, optionally followed by a colon and
+// further qualifiers, as in "thumbnail:jpeg".
 type RenditionClass string
 
+const (
+	RenditionDefault   RenditionClass = "default"
+	RenditionDraft     RenditionClass = "draft"
+	RenditionLowRes    RenditionClass = "low-res"
+	RenditionProof     RenditionClass = "proof"
+	RenditionScreen    RenditionClass = "screen"
+	RenditionThumbnail RenditionClass = "thumbnail"
+)
+
+// ResourceRef refers to a document, or a particular version or
+// rendition of one. Its fields are in the ResourceRef namespace.
+type ResourceRef struct {
+	DocumentID         GUID           `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# documentID"`
+	FilePath           string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# filePath"`
+	InstanceID         GUID           `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# instanceID"`
+	LastModifyDate     *XMPDate       `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# lastModifyDate"`
+	Manager            string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# manager"`
+	ManagerVariant     string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# managerVariant"`
+	ManageTo           string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# manageTo"`
+	ManageUI           string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# manageUI"`
+	OriginalDocumentID GUID           `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# originalDocumentID"`
+	RenditionClass     RenditionClass `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# renditionClass"`
+	RenditionParams    string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# renditionParams"`
+	VersionID          string         `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceRef# versionID"`
+}
+
+// ResourceEvent describes something done to a document, as an entry in
+// its history. Its fields are in the ResourceEvent namespace.
+type ResourceEvent struct {
+	// Action is what was done, such as "created", "saved" or
+	// "converted".
+	Action string `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# action"`
+	// Changed lists the parts of the document that were changed,
+	// separated by semicolons.
+	Changed       string   `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# changed"`
+	InstanceID    GUID     `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# instanceID"`
+	Parameters    string   `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# parameters"`
+	SoftwareAgent string   `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# softwareAgent"`
+	When          *XMPDate `xmp:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# when"`
+}
+
 // Things in the XMP Media Management namespace
 type XMPMediaManagement struct {
-	DerivedFrom        ResourceRef
+	DerivedFrom        *ResourceRef
 	DocumentID         GUID
+	History            []ResourceEvent
 	InstanceID         GUID
+	ManagedFrom        *ResourceRef
 	OriginalDocumentID GUID
 	RenditionClass     RenditionClass
 	RenditionParams    string
@@ -83,6 +161,46 @@ type XMPIDQ struct {
 	Scheme string
 }
 
+// XMPKind says what kind of value an XMP property holds.
+type XMPKind int
+
+const (
+	// XMPSimple properties hold text.
+	XMPSimple XMPKind = iota
+	// XMPURI properties hold a URI, given as an rdf:resource
+	// attribute.
+	XMPURI
+	// XMPStruct properties hold named fields.
+	XMPStruct
+	// XMPBag properties hold an unordered array.
+	XMPBag
+	// XMPSeq properties hold an ordered array.
+	XMPSeq
+	// XMPAlt properties hold an array of alternatives, such as the
+	// same text in several languages.
+	XMPAlt
+)
+
+// XMPProperty holds an XMP property in a generic form. It's used for
+// the properties XMP has no field for.
+type XMPProperty struct {
+	// Name is the property's name. Array items have no name.
+	Name xml.Name
+	Kind XMPKind
+	// Value holds the text or URI of simple and URI properties.
+	Value string
+	// Lang holds the property's xml:lang qualifier, if it has one.
+	Lang string
+	// Fields holds the fields of struct properties.
+	Fields []*XMPProperty
+	// Items holds the items of array properties.
+	Items []*XMPProperty
+	// Qualifiers holds the property's qualifiers other than xml:lang,
+	// such as the xmpidq:Scheme of an identifier. Qualified properties
+	// are written with their value in an rdf:value element.
+	Qualifiers []*XMPProperty
+}
+
 // XMP holds the XMP metadata. It's a collection of sub-types
 type XMP struct {
 	CoreProperties  *Core
@@ -90,6 +208,24 @@ type XMP struct {
 	Rights          *XMPRights
 	MediaManagement *XMPMediaManagement
 	IDQ             *XMPIDQ
+
+	// About holds the rdf:about attribute of the XMP, which names the
+	// resource it describes. It's usually empty.
+	About string
+	// Other holds the properties that have no field of their own, such
+	// as those from other namespaces, in the order they were found.
+	// Dates that leave out the time or the time zone are kept here too
+	// rather than in a time.Time field, so they're written back as they
+	// were given.
+	Other []*XMPProperty
+	// Prefixes maps namespace URIs to the prefixes the XMP gave them,
+	// so they can be kept when it's encoded again.
+	Prefixes map[string]string
+	// Schemas holds the properties in namespaces with a schema
+	// registered with the XMP decoder, keyed by namespace URI. Each
+	// value is a pointer to the struct type registered for the
+	// namespace.
+	Schemas map[string]interface{}
 }
 
 func DecodeXMP(ctx context.Context, b string, opt ...image.ReadOption) (*XMP, error) {
@@ -99,6 +235,22 @@ func DecodeXMP(ctx context.Context, b string, opt ...image.ReadOption) (*XMP, er
 	return xmpDecoder(ctx, b, opt...)
 }
 
+// XMPOptions are the write options for encoding XMP. The image
+// format encoders pass them on to the XMP encoder.
+type XMPOptions struct {
+	// Padding is the number of bytes of whitespace padding to put at
+	// the end of the XMP packet, which lets the XMP grow when it's
+	// edited without moving whatever comes after it in the file.
+	Padding int
+	// Size, if it's non-zero, is the size the packet has to be. The
+	// padding is chosen to make it that size, so the packet can
+	// replace one of that size in place, and Padding is ignored.
+	Size int
+}
+
+func (_ XMPOptions) IsImageWriteOption() {
+}
+
 func (x *XMP) Encode(ctx context.Context, opt ...image.WriteOption) (string, error) {
 	if xmpEncoder == nil {
 		return "", errors.New("No registered XMP encoder")
@@ -0,0 +1,602 @@
+package xmp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// node is an element of the XML document that holds the XMP.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*node
+	// text holds the element's character data, if it has no child
+	// elements.
+	text string
+}
+
+// attr returns the value of the node's attribute with the given name.
+// The boolean reports whether it has one.
+func (n *node) attr(space, local string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// parse reads the XML document in s into a tree of nodes, and returns
+// its root. It also returns the prefixes the document gives to
+// namespace URIs.
+func parse(s string) (*node, map[string]string, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	prefixes := make(map[string]string)
+	var root *node
+	var stack []*node
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("xmp: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					prefixes[a.Value] = a.Name.Local
+					continue
+				}
+				if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					continue
+				}
+				n.attrs = append(n.attrs, a)
+			}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, nil, errors.New("xmp: more than one root element")
+				}
+				root = n
+			} else {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, nil, errors.New("xmp: no XML elements")
+	}
+	return root, prefixes, nil
+}
+
+// findRDF returns the rdf:RDF element in the tree rooted at n, or nil
+// if there isn't one. It's usually inside an x:xmpmeta element, but
+// may be the root itself.
+func findRDF(n *node) *node {
+	if n.name.Space == nsRDF && n.name.Local == "RDF" {
+		return n
+	}
+	for _, c := range n.children {
+		if r := findRDF(c); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// isSyntaxAttr reports whether the attribute a is part of the RDF or
+// XML syntax rather than a property.
+func isSyntaxAttr(a xml.Attr) bool {
+	return a.Name.Space == nsRDF || a.Name.Space == nsXML || a.Name.Space == ""
+}
+
+// rdfValue is the name of the rdf:value element, which holds the value
+// of a qualified property.
+var rdfValue = xml.Name{Space: nsRDF, Local: "value"}
+
+// properties returns the properties given by the attributes and child
+// elements of the node n, which is an rdf:Description or a property
+// element with rdf:parseType="Resource". An rdf:value attribute or
+// element is returned as a property named rdf:value.
+func properties(n *node) ([]*metadata.XMPProperty, error) {
+	var props []*metadata.XMPProperty
+	for _, a := range n.attrs {
+		if a.Name == rdfValue {
+			props = append(props, &metadata.XMPProperty{Name: a.Name, Kind: metadata.XMPSimple, Value: a.Value})
+			continue
+		}
+		if isSyntaxAttr(a) {
+			continue
+		}
+		props = append(props, &metadata.XMPProperty{Name: a.Name, Kind: metadata.XMPSimple, Value: a.Value})
+	}
+	for _, c := range n.children {
+		p, err := property(c)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, p)
+	}
+	return props, nil
+}
+
+// property returns the property given by the property element n. The
+// property is named after the element, apart from rdf:li array items,
+// which have no name.
+func property(n *node) (*metadata.XMPProperty, error) {
+	p := &metadata.XMPProperty{Name: n.name}
+	if n.name.Space == nsRDF {
+		switch n.name.Local {
+		case "li":
+			p.Name = xml.Name{}
+		case "value":
+		default:
+			return nil, fmt.Errorf("xmp: unexpected rdf:%s element", n.name.Local)
+		}
+	}
+	p.Lang, _ = n.attr(nsXML, "lang")
+
+	if r, ok := n.attr(nsRDF, "resource"); ok {
+		p.Kind = metadata.XMPURI
+		p.Value = r
+		return p, nil
+	}
+	if pt, ok := n.attr(nsRDF, "parseType"); ok && pt == "Resource" {
+		fields, err := properties(n)
+		if err != nil {
+			return nil, err
+		}
+		return setFields(p, fields)
+	}
+
+	if len(n.children) > 0 {
+		if len(n.children) > 1 {
+			return nil, fmt.Errorf("xmp: property %s has more than one value", n.name.Local)
+		}
+		c := n.children[0]
+		if c.name.Space != nsRDF {
+			return nil, fmt.Errorf("xmp: property %s holds unexpected element %s", n.name.Local, c.name.Local)
+		}
+		switch c.name.Local {
+		case "Bag", "Seq", "Alt":
+			p.Kind = map[string]metadata.XMPKind{
+				"Bag": metadata.XMPBag,
+				"Seq": metadata.XMPSeq,
+				"Alt": metadata.XMPAlt,
+			}[c.name.Local]
+			for _, li := range c.children {
+				if li.name.Space != nsRDF || li.name.Local != "li" {
+					return nil, fmt.Errorf("xmp: array %s holds a %s element", n.name.Local, li.name.Local)
+				}
+				item, err := property(li)
+				if err != nil {
+					return nil, err
+				}
+				p.Items = append(p.Items, item)
+			}
+			return p, nil
+		case "Description":
+			fields, err := properties(c)
+			if err != nil {
+				return nil, err
+			}
+			return setFields(p, fields)
+		}
+		return nil, fmt.Errorf("xmp: property %s holds unexpected rdf:%s element", n.name.Local, c.name.Local)
+	}
+
+	// A property element with property attributes and no content is
+	// shorthand for a struct.
+	for _, a := range n.attrs {
+		if !isSyntaxAttr(a) || a.Name == rdfValue {
+			fields, err := properties(n)
+			if err != nil {
+				return nil, err
+			}
+			return setFields(p, fields)
+		}
+	}
+	p.Kind = metadata.XMPSimple
+	p.Value = n.text
+	return p, nil
+}
+
+// setFields makes p a struct with the given fields. If one of them is
+// an rdf:value, p is instead a qualified property: it takes the value
+// of the rdf:value, and the other fields are its qualifiers.
+func setFields(p *metadata.XMPProperty, fields []*metadata.XMPProperty) (*metadata.XMPProperty, error) {
+	var value *metadata.XMPProperty
+	var quals []*metadata.XMPProperty
+	for _, f := range fields {
+		if f.Name != rdfValue {
+			quals = append(quals, f)
+			continue
+		}
+		if value != nil {
+			return nil, fmt.Errorf("xmp: property %s has more than one rdf:value", p.Name.Local)
+		}
+		value = f
+	}
+	if value == nil {
+		p.Kind = metadata.XMPStruct
+		p.Fields = fields
+		return p, nil
+	}
+	q := *value
+	q.Name = p.Name
+	if q.Lang == "" {
+		q.Lang = p.Lang
+	}
+	q.Qualifiers = append(quals, q.Qualifiers...)
+	return &q, nil
+}
+
+// Decode decodes XMP format metadata. The packet wrapper is optional,
+// and the rdf:RDF element may be inside an x:xmpmeta element or be the
+// root element itself. XMP with no rdf:RDF element decodes as empty
+// metadata.
+//
+// Both the attribute and element forms of properties are understood,
+// along with rdf:Bag, rdf:Seq and rdf:Alt arrays, rdf:resource URIs,
+// structs given by rdf:Description elements, rdf:parseType "Resource"
+// or property attributes, and qualified values given by rdf:value.
+// Properties in the Dublin Core, XMP basic, XMP rights management, XMP
+// media management and XMP identifier qualifier namespaces fill in the
+// matching fields of metadata.XMP, and those in namespaces with a
+// schema registered with RegisterSchema fill in its Schemas. Every
+// other property, and those that don't have the form their field
+// needs, such as qualified ones, is kept in the Other field.
+func Decode(ctx context.Context, b string, opt ...image.ReadOption) (*metadata.XMP, error) {
+	if _, err := image.ResolveReadOptions(opt...); err != nil {
+		return nil, err
+	}
+	root, prefixes, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &metadata.XMP{Prefixes: prefixes}
+	rdf := findRDF(root)
+	if rdf == nil {
+		return x, nil
+	}
+	for _, desc := range rdf.children {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if desc.name.Space != nsRDF || desc.name.Local != "Description" {
+			return nil, fmt.Errorf("xmp: unexpected %s element in rdf:RDF", desc.name.Local)
+		}
+		if about, ok := desc.attr(nsRDF, "about"); ok && about != "" {
+			x.About = about
+		}
+		props, err := properties(desc)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range props {
+			if p.Name == rdfValue {
+				return nil, errors.New("xmp: unexpected rdf:value in rdf:Description")
+			}
+			if !setProperty(x, p) {
+				x.Other = append(x.Other, p)
+			}
+		}
+	}
+	return x, nil
+}
+
+// setProperty sets the field of x that the property p gives. It reports
+// whether there is one, and p has the form it needs.
+func setProperty(x *metadata.XMP, p *metadata.XMPProperty) bool {
+	switch p.Name.Space {
+	case nsDC:
+		c := x.CoreProperties
+		if c == nil {
+			c = &metadata.Core{}
+		}
+		if !setCore(c, p) {
+			return false
+		}
+		x.CoreProperties = c
+	case nsXMP:
+		b := x.Properties
+		if b == nil {
+			b = &metadata.XMPSpecific{}
+		}
+		if !setBasic(b, p) {
+			return false
+		}
+		x.Properties = b
+	case nsXMPRights:
+		r := x.Rights
+		if r == nil {
+			r = &metadata.XMPRights{}
+		}
+		if !setRights(r, p) {
+			return false
+		}
+		x.Rights = r
+	case nsXMPMM:
+		m := x.MediaManagement
+		if m == nil {
+			m = &metadata.XMPMediaManagement{}
+		}
+		if !setMediaManagement(m, p) {
+			return false
+		}
+		x.MediaManagement = m
+	case nsXMPIDQ:
+		var scheme string
+		if p.Name.Local != "Scheme" || !setText(&scheme, p) {
+			return false
+		}
+		x.IDQ = &metadata.XMPIDQ{Scheme: scheme}
+	default:
+		return setSchemaProperty(x, p)
+	}
+	return true
+}
+
+func setCore(c *metadata.Core, p *metadata.XMPProperty) bool {
+	switch p.Name.Local {
+	case "contributor":
+		return setList(&c.Contributor, p)
+	case "coverage":
+		return setText(&c.Coverage, p)
+	case "creator":
+		return setList(&c.Creator, p)
+	case "date":
+		var s []string
+		if !setList(&s, p) {
+			return false
+		}
+		dates := make([]time.Time, len(s))
+		for i := range s {
+			t, ok := parseTime(s[i])
+			if !ok {
+				return false
+			}
+			dates[i] = t
+		}
+		c.Date = dates
+		return true
+	case "description":
+		return setLangAlt(&c.Description, p)
+	case "format":
+		return setText(&c.Format, p)
+	case "identifier":
+		return setText(&c.Identifier, p)
+	case "language":
+		return setList(&c.Language, p)
+	case "publisher":
+		return setList(&c.Publisher, p)
+	case "relation":
+		return setList(&c.Relation, p)
+	case "rights":
+		return setLangAlt(&c.Rights, p)
+	case "source":
+		return setText(&c.Source, p)
+	case "subject":
+		return setList(&c.Subject, p)
+	case "title":
+		return setLangAlt(&c.Title, p)
+	case "type":
+		return setList(&c.Type, p)
+	}
+	return false
+}
+
+func setBasic(b *metadata.XMPSpecific, p *metadata.XMPProperty) bool {
+	switch p.Name.Local {
+	case "CreateDate":
+		return setDate(&b.CreateDate, p)
+	case "CreatorTool":
+		return setText(&b.CreatorTool, p)
+	case "Identifier":
+		return setList(&b.Identifier, p)
+	case "Label":
+		return setText(&b.Label, p)
+	case "MetadataDate":
+		return setDate(&b.MetadataData, p)
+	case "ModifyDate":
+		return setDate(&b.ModifiedDate, p)
+	case "Rating":
+		var s string
+		if !setText(&s, p) {
+			return false
+		}
+		r, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return false
+		}
+		b.Rating = r
+		return true
+	}
+	return false
+}
+
+func setRights(r *metadata.XMPRights, p *metadata.XMPProperty) bool {
+	switch p.Name.Local {
+	case "Certificate":
+		return setText(&r.Certificate, p)
+	case "Marked":
+		var s string
+		if !setText(&s, p) {
+			return false
+		}
+		// XMP booleans are "True" or "False", but other
+		// capitalizations turn up.
+		var v bool
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true":
+			v = true
+		case "false":
+		default:
+			return false
+		}
+		r.Marked = &v
+		return true
+	case "Owner":
+		return setList(&r.Owner, p)
+	case "UsageTerms":
+		return setLangAlt(&r.UsageTerms, p)
+	case "WebStatement":
+		return setText(&r.WebStatement, p)
+	}
+	return false
+}
+
+func setMediaManagement(m *metadata.XMPMediaManagement, p *metadata.XMPProperty) bool {
+	var s string
+	switch p.Name.Local {
+	case "DerivedFrom":
+		return fromProperty(reflect.ValueOf(&m.DerivedFrom).Elem(), p, nsXMPMM)
+	case "History":
+		return fromProperty(reflect.ValueOf(&m.History).Elem(), p, nsXMPMM)
+	case "ManagedFrom":
+		return fromProperty(reflect.ValueOf(&m.ManagedFrom).Elem(), p, nsXMPMM)
+	case "DocumentID":
+		if !setText(&s, p) {
+			return false
+		}
+		m.DocumentID = metadata.GUID(s)
+	case "InstanceID":
+		if !setText(&s, p) {
+			return false
+		}
+		m.InstanceID = metadata.GUID(s)
+	case "OriginalDocumentID":
+		if !setText(&s, p) {
+			return false
+		}
+		m.OriginalDocumentID = metadata.GUID(s)
+	case "RenditionClass":
+		if !setText(&s, p) {
+			return false
+		}
+		m.RenditionClass = metadata.RenditionClass(s)
+	case "RenditionParams":
+		return setText(&m.RenditionParams, p)
+	default:
+		return false
+	}
+	return true
+}
+
+// setText sets *s to the text of p, if it's a simple property or a
+// URI without a language.
+func setText(s *string, p *metadata.XMPProperty) bool {
+	if (p.Kind != metadata.XMPSimple && p.Kind != metadata.XMPURI) || p.Lang != "" || p.Qualifiers != nil {
+		return false
+	}
+	*s = p.Value
+	return true
+}
+
+// setList sets *l to the items of p, if it's an array of simple
+// items without languages.
+func setList(l *[]string, p *metadata.XMPProperty) bool {
+	if (p.Kind != metadata.XMPBag && p.Kind != metadata.XMPSeq) || p.Qualifiers != nil {
+		return false
+	}
+	v := make([]string, len(p.Items))
+	for i, item := range p.Items {
+		if !setText(&v[i], item) {
+			return false
+		}
+	}
+	*l = v
+	return true
+}
+
+// setLangAlt sets *l to the items of p, if it's an alternative array
+// of simple items.
+func setLangAlt(l *[]metadata.LanguageAlternative, p *metadata.XMPProperty) bool {
+	if p.Kind != metadata.XMPAlt || p.Qualifiers != nil {
+		return false
+	}
+	v := make([]metadata.LanguageAlternative, len(p.Items))
+	for i, item := range p.Items {
+		if item.Kind != metadata.XMPSimple || item.Qualifiers != nil {
+			return false
+		}
+		v[i] = metadata.LanguageAlternative{Language: item.Lang, Text: item.Value}
+	}
+	*l = v
+	return true
+}
+
+// setDate sets *t to the date given by p, if it's a simple property
+// holding one that parseTime takes.
+func setDate(t **time.Time, p *metadata.XMPProperty) bool {
+	var s string
+	if !setText(&s, p) {
+		return false
+	}
+	d, ok := parseTime(s)
+	if ok {
+		*t = &d
+	}
+	return ok
+}
+
+// dateLayouts are the layouts of XMP dates, which are a subset of ISO
+// 8601, with how much of the date they give and whether they have a
+// time zone. Dates without a time zone are taken to be in UTC.
+var dateLayouts = []struct {
+	layout    string
+	precision metadata.XMPDatePrecision
+	noZone    bool
+}{
+	{"2006", metadata.XMPDateYear, true},
+	{"2006-01", metadata.XMPDateMonth, true},
+	{"2006-01-02", metadata.XMPDateDay, true},
+	{"2006-01-02T15:04Z07:00", metadata.XMPDateMinute, false},
+	{"2006-01-02T15:04:05.999999999Z07:00", metadata.XMPDateSecond, false},
+	{"2006-01-02T15:04", metadata.XMPDateMinute, true},
+	{"2006-01-02T15:04:05.999999999", metadata.XMPDateSecond, true},
+}
+
+// parseDate parses the XMP date s. The boolean reports whether it's a
+// valid date.
+func parseDate(s string) (metadata.XMPDate, bool) {
+	s = strings.TrimSpace(s)
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return metadata.XMPDate{Time: t, Precision: l.precision, NoZone: l.noZone}, true
+		}
+	}
+	return metadata.XMPDate{}, false
+}
+
+// parseTime parses the XMP date s for a time.Time field. Only dates
+// given to the second with a time zone are taken, since a time.Time
+// can't say that the rest were given less precisely.
+func parseTime(s string) (time.Time, bool) {
+	d, ok := parseDate(s)
+	if !ok || d.Precision != metadata.XMPDateSecond || d.NoZone {
+		return time.Time{}, false
+	}
+	return d.Time, true
+}
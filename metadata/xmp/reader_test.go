@@ -0,0 +1,242 @@
+package xmp
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+const testPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+    xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+    xmp:CreatorTool="Some Editor 1.0"
+    xmp:CreateDate="2021-06-01T12:00:00+02:00"
+    xmp:Rating="3"
+    xmpMM:DocumentID="xmp.did:1234"
+    tiff:Orientation="1">
+   <xmp:ModifyDate>2021-06-02</xmp:ModifyDate>
+  </rdf:Description>
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/">
+   <dc:format>image/png</dc:format>
+   <dc:creator>
+    <rdf:Seq>
+     <rdf:li>Someone</rdf:li>
+     <rdf:li>Someone Else</rdf:li>
+    </rdf:Seq>
+   </dc:creator>
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>cats</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <dc:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">A Title</rdf:li>
+     <rdf:li xml:lang="de">Ein Titel</rdf:li>
+    </rdf:Alt>
+   </dc:title>
+   <dc:date><rdf:Seq><rdf:li>2021-06-01T12:00:00.5Z</rdf:li></rdf:Seq></dc:date>
+   <xmpRights:Marked>True</xmpRights:Marked>
+   <xmpRights:WebStatement rdf:resource="http://example.com/rights"/>
+   <Iptc4xmpCore:CreatorContactInfo rdf:parseType="Resource">
+    <Iptc4xmpCore:CiAdrCity>Ljubljana</Iptc4xmpCore:CiAdrCity>
+   </Iptc4xmpCore:CreatorContactInfo>
+   <Iptc4xmpCore:Location Iptc4xmpCore:City="Maribor"/>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const nsIPTC = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+
+func TestDecode(t *testing.T) {
+	x, err := Decode(context.Background(), testPacket)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	create := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	if x.Properties == nil || x.Properties.CreateDate == nil || !x.Properties.CreateDate.Equal(create) {
+		t.Fatalf("got properties %+v, want CreateDate %v", x.Properties, create)
+	}
+	// A date without a time doesn't fit a time.Time field exactly, so
+	// it's kept with the other properties instead.
+	if x.Properties.ModifiedDate != nil {
+		t.Errorf("got ModifiedDate %v, want nil", x.Properties.ModifiedDate)
+	}
+	if x.Properties.CreatorTool != "Some Editor 1.0" || x.Properties.Rating != 3 {
+		t.Errorf("got CreatorTool %q, Rating %v", x.Properties.CreatorTool, x.Properties.Rating)
+	}
+
+	wantCore := &metadata.Core{
+		Creator: []string{"Someone", "Someone Else"},
+		Date:    []time.Time{time.Date(2021, 6, 1, 12, 0, 0, 5e8, time.UTC)},
+		Format:  "image/png",
+		Subject: []string{"cats"},
+		Title: []metadata.LanguageAlternative{
+			{Language: "x-default", Text: "A Title"},
+			{Language: "de", Text: "Ein Titel"},
+		},
+	}
+	if !reflect.DeepEqual(x.CoreProperties, wantCore) {
+		t.Errorf("got core properties %+v, want %+v", x.CoreProperties, wantCore)
+	}
+
+	if x.Rights == nil || x.Rights.Marked == nil || !*x.Rights.Marked || x.Rights.WebStatement != "http://example.com/rights" {
+		t.Errorf("got rights %+v", x.Rights)
+	}
+	if x.MediaManagement == nil || x.MediaManagement.DocumentID != "xmp.did:1234" {
+		t.Errorf("got media management %+v", x.MediaManagement)
+	}
+	if x.IDQ != nil {
+		t.Errorf("got IDQ %+v, want nil", x.IDQ)
+	}
+
+	wantOther := []*metadata.XMPProperty{
+		{Name: xml.Name{Space: "http://ns.adobe.com/tiff/1.0/", Local: "Orientation"}, Value: "1"},
+		{Name: xml.Name{Space: "http://ns.adobe.com/xap/1.0/", Local: "ModifyDate"}, Value: "2021-06-02"},
+		{Name: xml.Name{Space: nsIPTC, Local: "CreatorContactInfo"}, Kind: metadata.XMPStruct, Fields: []*metadata.XMPProperty{
+			{Name: xml.Name{Space: nsIPTC, Local: "CiAdrCity"}, Value: "Ljubljana"},
+		}},
+		{Name: xml.Name{Space: nsIPTC, Local: "Location"}, Kind: metadata.XMPStruct, Fields: []*metadata.XMPProperty{
+			{Name: xml.Name{Space: nsIPTC, Local: "City"}, Value: "Maribor"},
+		}},
+	}
+	if !reflect.DeepEqual(x.Other, wantOther) {
+		t.Errorf("got other properties:")
+		for _, p := range x.Other {
+			t.Errorf("\t%+v", p)
+		}
+	}
+
+	if x.Prefixes[nsIPTC] != "Iptc4xmpCore" || x.Prefixes[nsXMPMM] != "xmpMM" || x.Prefixes["adobe:ns:meta/"] != "x" {
+		t.Errorf("got prefixes %v", x.Prefixes)
+	}
+}
+
+func TestDecodeMisfits(t *testing.T) {
+	// Properties from known namespaces that don't have the form their
+	// field needs are kept with the others.
+	const s = `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+  xmlns:dc="http://purl.org/dc/elements/1.1/"
+  xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+ <rdf:Description rdf:about="uuid:1" xmp:Rating="lots" xmp:Nickname="nick">
+  <dc:creator>Someone</dc:creator>
+ </rdf:Description>
+</rdf:RDF>`
+	x, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if x.About != "uuid:1" {
+		t.Errorf("got About %q, want uuid:1", x.About)
+	}
+	if x.CoreProperties != nil || x.Properties != nil {
+		t.Errorf("got core properties %+v, properties %+v", x.CoreProperties, x.Properties)
+	}
+	var names []string
+	for _, p := range x.Other {
+		names = append(names, p.Name.Local)
+	}
+	if want := []string{"Rating", "Nickname", "creator"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got other properties %v, want %v", names, want)
+	}
+}
+
+func TestDecodeQualifiers(t *testing.T) {
+	// Qualified values are given by rdf:value, in either the element or
+	// the attribute form.
+	const s = `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+  xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+  xmlns:xmpidq="http://ns.adobe.com/xmp/Identifier/qual/1.0/">
+ <rdf:Description rdf:about="">
+  <xmp:Identifier>
+   <rdf:Bag>
+    <rdf:li rdf:parseType="Resource">
+     <rdf:value>0-13-468599-7</rdf:value>
+     <xmpidq:Scheme>ISBN</xmpidq:Scheme>
+    </rdf:li>
+    <rdf:li rdf:value="urn:x" xmpidq:Scheme="URN"/>
+    <rdf:li>plain</rdf:li>
+   </rdf:Bag>
+  </xmp:Identifier>
+ </rdf:Description>
+</rdf:RDF>`
+	x, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	scheme := func(v string) []*metadata.XMPProperty {
+		return []*metadata.XMPProperty{{Name: xml.Name{Space: nsXMPIDQ, Local: "Scheme"}, Value: v}}
+	}
+	want := []*metadata.XMPProperty{{
+		Name: xml.Name{Space: nsXMP, Local: "Identifier"},
+		Kind: metadata.XMPBag,
+		Items: []*metadata.XMPProperty{
+			{Value: "0-13-468599-7", Qualifiers: scheme("ISBN")},
+			{Value: "urn:x", Qualifiers: scheme("URN")},
+			{Value: "plain"},
+		},
+	}}
+	// The qualifiers have nowhere to go in the Identifier field, so the
+	// property is kept with the others.
+	if x.Properties != nil || !reflect.DeepEqual(x.Other, want) {
+		t.Fatalf("got properties %+v, other properties %+v, want %+v", x.Properties, x.Other, want)
+	}
+
+	// Re-encoding keeps the qualifiers.
+	e, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !strings.Contains(e, "<rdf:value>0-13-468599-7</rdf:value>") {
+		t.Errorf("qualified value not written as rdf:value:\n%s", e)
+	}
+	got, err := Decode(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Decode: %v\n%s", err, e)
+	}
+	if !reflect.DeepEqual(got.Other, want) {
+		t.Errorf("got %+v after re-encoding, want %+v", got.Other, want)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	x, err := Decode(context.Background(), `<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if x.CoreProperties != nil || x.Properties != nil || len(x.Other) != 0 {
+		t.Errorf("got %+v, want empty XMP", x)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF>`,
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Seq/></rdf:RDF>`,
+	} {
+		if _, err := Decode(context.Background(), s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Decode(ctx, testPacket); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
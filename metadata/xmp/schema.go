@@ -0,0 +1,424 @@
+package xmp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+// schema is a registered Go struct type for the properties in a
+// namespace.
+type schema struct {
+	prefix string
+	typ    reflect.Type
+}
+
+var (
+	schemasMu sync.Mutex
+	schemas   = make(map[string]*schema)
+)
+
+// RegisterSchema registers the struct type v points to as the schema
+// for the properties in the namespace ns, whose preferred prefix is
+// prefix. Decode then puts the properties in ns into a value of that
+// type in the XMP's Schemas, and Encode writes them back out.
+//
+// Each exported field of the struct is a property. The field's xmp tag
+// gives the property's name, which is in ns unless the tag has the
+// form "namespace name". Without a tag, the property has the field's
+// name; a tag of "-" leaves the field out. Fields may be strings,
+// bools, numbers, metadata.XMPDate and time.Time values, structs,
+// which are XMP structs whose fields follow the same rules, and
+// pointers to and slices of any of those. Slices are bags, unless the
+// tag ends ",seq" or ",alt"; slices of metadata.LanguageAlternative are
+// always alternatives. Dates in time.Time fields are written to the
+// second, whatever their precision was; metadata.XMPDate keeps it.
+//
+// Zero and empty fields aren't written, so a bool field that has to be
+// written when it's false should be a *bool. A property that doesn't
+// fit its field, and one in ns with no field, is kept in the XMP's
+// Other properties instead.
+//
+// RegisterSchema panics if v isn't a pointer to a struct it can
+// handle, if ns is one of the namespaces XMP has fields for, or if ns
+// already has a schema.
+func RegisterSchema(ns, prefix string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("xmp: schema for %s is a %T, not a pointer to a struct", ns, v))
+	}
+	if _, ok := defaultPrefixes[ns]; ok || ns == "" || ns == nsRDF || ns == nsXML {
+		panic("xmp: can't register a schema for " + ns)
+	}
+	if !validPrefix(prefix) {
+		panic("xmp: invalid prefix " + prefix + " for " + ns)
+	}
+	if err := checkType(t.Elem(), ns); err != nil {
+		panic(err)
+	}
+	fields, _ := structFields(t.Elem(), ns)
+	for _, f := range fields {
+		if f.name.Space != ns {
+			panic(fmt.Sprintf("xmp: property %s of the schema for %s is in %s", f.name.Local, ns, f.name.Space))
+		}
+	}
+
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	if _, ok := schemas[ns]; ok {
+		panic("xmp: schema for " + ns + " registered twice")
+	}
+	schemas[ns] = &schema{prefix: prefix, typ: t.Elem()}
+}
+
+// lookupSchema returns the schema registered for the namespace ns, or
+// nil if there isn't one.
+func lookupSchema(ns string) *schema {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	return schemas[ns]
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	dateType    = reflect.TypeOf(metadata.XMPDate{})
+	langAltType = reflect.TypeOf(metadata.LanguageAlternative{})
+)
+
+// fieldInfo describes how a struct field maps onto a property.
+type fieldInfo struct {
+	index int
+	name  xml.Name
+	// kind is the kind of array a slice field is.
+	kind metadata.XMPKind
+}
+
+// structFields returns the properties of the fields of the struct type
+// t, whose unqualified property names are in the namespace ns.
+func structFields(t reflect.Type, ns string) ([]fieldInfo, error) {
+	var fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("xmp")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		fi := fieldInfo{index: i, name: xml.Name{Space: ns, Local: f.Name}, kind: metadata.XMPBag}
+		name, opt := tag, ""
+		if c := strings.IndexByte(tag, ','); c >= 0 {
+			name, opt = tag[:c], tag[c+1:]
+		}
+		if s := strings.LastIndexByte(name, ' '); s >= 0 {
+			fi.name.Space, name = name[:s], name[s+1:]
+		}
+		if name != "" {
+			fi.name.Local = name
+		}
+		switch opt {
+		case "":
+		case "bag":
+		case "seq":
+			fi.kind = metadata.XMPSeq
+		case "alt":
+			fi.kind = metadata.XMPAlt
+		default:
+			return nil, fmt.Errorf("xmp: unknown option %q for field %s of %v", opt, f.Name, t)
+		}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem() == langAltType {
+			fi.kind = metadata.XMPAlt
+		}
+		fields = append(fields, fi)
+	}
+	return fields, nil
+}
+
+// checkType returns an error if values of type t can't be properties.
+// Unqualified names of the fields of structs are in the namespace ns.
+func checkType(t reflect.Type, ns string) error {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	case reflect.Ptr:
+		return checkType(t.Elem(), ns)
+	case reflect.Slice:
+		if k := t.Elem().Kind(); k == reflect.Slice || k == reflect.Ptr {
+			return fmt.Errorf("xmp: can't hold %v in an array", t.Elem())
+		}
+		return checkType(t.Elem(), ns)
+	case reflect.Struct:
+		if t == timeType || t == dateType || t == langAltType {
+			return nil
+		}
+		fields, err := structFields(t, ns)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := checkType(t.Field(f.index).Type, ns); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("xmp: can't hold %v in a property", t)
+}
+
+// toProperty returns the property named name holding v. Slices are
+// arrays of the given kind. It returns nil if v is zero or empty,
+// unless keep is set.
+func toProperty(v reflect.Value, name xml.Name, kind metadata.XMPKind, ns string, keep bool) *metadata.XMPProperty {
+	p := &metadata.XMPProperty{Name: name}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return toProperty(v.Elem(), name, kind, ns, true)
+	case reflect.Slice:
+		if v.Len() == 0 && !keep {
+			return nil
+		}
+		p.Kind = kind
+		for i := 0; i < v.Len(); i++ {
+			p.Items = append(p.Items, toProperty(v.Index(i), xml.Name{}, metadata.XMPBag, ns, true))
+		}
+		return p
+	case reflect.Struct:
+		switch v.Type() {
+		case timeType:
+			t := v.Interface().(time.Time)
+			if t.IsZero() && !keep {
+				return nil
+			}
+			p.Value = formatDate(metadata.NewXMPDate(t))
+			return p
+		case dateType:
+			d := v.Interface().(metadata.XMPDate)
+			if d.IsZero() && !keep {
+				return nil
+			}
+			p.Value = formatDate(d)
+			return p
+		case langAltType:
+			a := v.Interface().(metadata.LanguageAlternative)
+			p.Value, p.Lang = a.Text, a.Language
+			return p
+		}
+		p.Kind = metadata.XMPStruct
+		fields, _ := structFields(v.Type(), ns)
+		for _, f := range fields {
+			if fp := toProperty(v.Field(f.index), f.name, f.kind, ns, false); fp != nil {
+				p.Fields = append(p.Fields, fp)
+			}
+		}
+		if len(p.Fields) == 0 && !keep {
+			return nil
+		}
+		return p
+	case reflect.String:
+		p.Value = v.String()
+	case reflect.Bool:
+		p.Value = "False"
+		if v.Bool() {
+			p.Value = "True"
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.Value = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		p.Value = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		p.Value = strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	}
+	if v.IsZero() && !keep {
+		return nil
+	}
+	return p
+}
+
+// fromProperty sets v, which must be settable, to the value of the
+// property p. Unqualified names of the fields of structs are in the
+// namespace ns. It reports whether p has the form v's type needs; if
+// it doesn't, v is left as it was.
+func fromProperty(v reflect.Value, p *metadata.XMPProperty, ns string) bool {
+	// Fields have nowhere to keep qualifiers.
+	if p.Qualifiers != nil {
+		return false
+	}
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Ptr:
+		n := reflect.New(t.Elem())
+		if !fromProperty(n.Elem(), p, ns) {
+			return false
+		}
+		v.Set(n)
+		return true
+	case reflect.Slice:
+		if p.Kind != metadata.XMPBag && p.Kind != metadata.XMPSeq && p.Kind != metadata.XMPAlt {
+			return false
+		}
+		s := reflect.MakeSlice(t, len(p.Items), len(p.Items))
+		for i, item := range p.Items {
+			if !fromProperty(s.Index(i), item, ns) {
+				return false
+			}
+		}
+		v.Set(s)
+		return true
+	case reflect.Struct:
+		switch t {
+		case timeType, dateType:
+			var s string
+			if !setText(&s, p) {
+				return false
+			}
+			d, ok := parseDate(s)
+			if !ok {
+				return false
+			}
+			if t == timeType {
+				v.Set(reflect.ValueOf(d.Time))
+			} else {
+				v.Set(reflect.ValueOf(d))
+			}
+			return true
+		case langAltType:
+			if p.Kind != metadata.XMPSimple {
+				return false
+			}
+			v.Set(reflect.ValueOf(metadata.LanguageAlternative{Language: p.Lang, Text: p.Value}))
+			return true
+		}
+		if p.Kind != metadata.XMPStruct {
+			return false
+		}
+		s := reflect.New(t).Elem()
+		fields, _ := structFields(t, ns)
+		for _, fp := range p.Fields {
+			if !setField(s, fields, fp, ns) {
+				return false
+			}
+		}
+		v.Set(s)
+		return true
+	}
+
+	var s string
+	if !setText(&s, p) {
+		return false
+	}
+	s = strings.TrimSpace(s)
+	switch t.Kind() {
+	case reflect.String:
+		// Text is kept as it is, spaces and all.
+		v.SetString(p.Value)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "true":
+			v.SetBool(true)
+		case "false":
+			v.SetBool(false)
+		default:
+			return false
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return false
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return false
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return false
+		}
+		v.SetFloat(f)
+	default:
+		return false
+	}
+	return true
+}
+
+// setField sets the field of the struct s that the property p gives,
+// where fields describes the struct's fields. It reports whether there
+// is one, and p has the form it needs.
+func setField(s reflect.Value, fields []fieldInfo, p *metadata.XMPProperty, ns string) bool {
+	for _, f := range fields {
+		if f.name == p.Name {
+			return fromProperty(s.Field(f.index), p, ns)
+		}
+	}
+	return false
+}
+
+// setSchemaProperty sets the field of the value for the property p's
+// namespace in x's Schemas, if the namespace has a registered schema.
+// It reports whether p was used.
+func setSchemaProperty(x *metadata.XMP, p *metadata.XMPProperty) bool {
+	ns := p.Name.Space
+	sc := lookupSchema(ns)
+	if sc == nil {
+		return false
+	}
+	v, ok := x.Schemas[ns]
+	if !ok {
+		v = reflect.New(sc.typ).Interface()
+	}
+	fields, _ := structFields(sc.typ, ns)
+	if !setField(reflect.ValueOf(v).Elem(), fields, p, ns) {
+		return false
+	}
+	if x.Schemas == nil {
+		x.Schemas = make(map[string]interface{})
+	}
+	x.Schemas[ns] = v
+	return true
+}
+
+// schemaProperties returns the properties held in x's Schemas, in order
+// of their namespace URIs.
+func schemaProperties(x *metadata.XMP) ([]*metadata.XMPProperty, error) {
+	var namespaces []string
+	for ns := range x.Schemas {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var props []*metadata.XMPProperty
+	for _, ns := range namespaces {
+		sc := lookupSchema(ns)
+		if sc == nil {
+			return nil, fmt.Errorf("xmp: no schema registered for %s", ns)
+		}
+		v := reflect.ValueOf(x.Schemas[ns])
+		if v.Type() != reflect.PtrTo(sc.typ) {
+			return nil, fmt.Errorf("xmp: schema for %s is a %v, want a %v", ns, v.Type(), reflect.PtrTo(sc.typ))
+		}
+		if v.IsNil() {
+			continue
+		}
+		fields, _ := structFields(sc.typ, ns)
+		for _, f := range fields {
+			if p := toProperty(v.Elem().Field(f.index), f.name, f.kind, ns, false); p != nil {
+				props = append(props, p)
+			}
+		}
+	}
+	return props, nil
+}
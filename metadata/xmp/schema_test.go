@@ -0,0 +1,188 @@
+package xmp
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image/metadata"
+)
+
+const nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+
+type testPhotoshop struct {
+	City         string
+	Credit       string
+	DateCreated  *time.Time
+	Urgency      int
+	ColorMode    uint8
+	Flagged      *bool       `xmp:"http://ns.adobe.com/photoshop/1.0/ Flag"`
+	Keywords     []string    `xmp:"SupplementalCategories"`
+	Layers       []testLayer `xmp:"TextLayers,seq"`
+	Descriptions []metadata.LanguageAlternative
+	Ignored      string `xmp:"-"`
+	unexported   string
+}
+
+type testLayer struct {
+	Name string `xmp:"LayerName"`
+	Text string `xmp:"LayerText"`
+}
+
+func init() {
+	RegisterSchema(nsPhotoshop, "photoshop", &testPhotoshop{})
+}
+
+const schemaPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:ps="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+    xmlns:stRef="http://ns.adobe.com/xap/1.0/sType/ResourceRef#"
+    xmlns:stEvt="http://ns.adobe.com/xap/1.0/sType/ResourceEvent#"
+    ps:City="Ljubljana"
+    ps:Urgency=" 2 "
+    ps:ColorMode="300"
+    ps:Unknown="?">
+   <ps:DateCreated>2021-06-01</ps:DateCreated>
+   <ps:Flag>False</ps:Flag>
+   <ps:SupplementalCategories><rdf:Bag><rdf:li>a</rdf:li><rdf:li>b</rdf:li></rdf:Bag></ps:SupplementalCategories>
+   <ps:TextLayers>
+    <rdf:Seq>
+     <rdf:li ps:LayerName="Title" ps:LayerText="Hello"/>
+    </rdf:Seq>
+   </ps:TextLayers>
+   <xmpMM:DerivedFrom rdf:parseType="Resource">
+    <stRef:instanceID>xmp.iid:1</stRef:instanceID>
+    <stRef:documentID>xmp.did:2</stRef:documentID>
+   </xmpMM:DerivedFrom>
+   <xmpMM:History>
+    <rdf:Seq>
+     <rdf:li stEvt:action="created" stEvt:when="2021-06-01T10:00:00Z"/>
+     <rdf:li stEvt:action="saved" stEvt:changed="/" stEvt:softwareAgent="Editor"/>
+    </rdf:Seq>
+   </xmpMM:History>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestDecodeSchema(t *testing.T) {
+	x, err := Decode(context.Background(), schemaPacket)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	ps, ok := x.Schemas[nsPhotoshop].(*testPhotoshop)
+	if !ok {
+		t.Fatalf("got schemas %v", x.Schemas)
+	}
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	flagged := false
+	want := &testPhotoshop{
+		City:        "Ljubljana",
+		DateCreated: &created,
+		Urgency:     2,
+		Flagged:     &flagged,
+		Keywords:    []string{"a", "b"},
+		Layers:      []testLayer{{Name: "Title", Text: "Hello"}},
+	}
+	if !reflect.DeepEqual(ps, want) {
+		t.Errorf("got %+v, want %+v", ps, want)
+	}
+	// ColorMode doesn't fit in a uint8.
+	var names []string
+	for _, p := range x.Other {
+		names = append(names, p.Name.Local)
+	}
+	if want := []string{"ColorMode", "Unknown"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got other properties %v, want %v", names, want)
+	}
+
+	m := x.MediaManagement
+	if m == nil || m.DerivedFrom == nil {
+		t.Fatalf("got media management %+v", m)
+	}
+	if want := (metadata.ResourceRef{InstanceID: "xmp.iid:1", DocumentID: "xmp.did:2"}); *m.DerivedFrom != want {
+		t.Errorf("got DerivedFrom %+v, want %+v", *m.DerivedFrom, want)
+	}
+	when := metadata.NewXMPDate(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC))
+	wantHistory := []metadata.ResourceEvent{
+		{Action: "created", When: &when},
+		{Action: "saved", Changed: "/", SoftwareAgent: "Editor"},
+	}
+	if !reflect.DeepEqual(m.History, wantHistory) {
+		t.Errorf("got history %+v, want %+v", m.History, wantHistory)
+	}
+}
+
+func TestEncodeSchema(t *testing.T) {
+	want, err := Decode(context.Background(), schemaPacket)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	s, err := Encode(context.Background(), want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// The prefix the packet gave the namespace is kept.
+	if !strings.Contains(s, "<ps:TextLayers>") || !strings.Contains(s, "<stRef:instanceID>") {
+		t.Errorf("got packet:\n%s", s)
+	}
+	got, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v\n%s", err, s)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Without one, the registered prefix is used.
+	x := &metadata.XMP{Schemas: map[string]interface{}{nsPhotoshop: &testPhotoshop{Credit: "Someone", Ignored: "x"}}}
+	s, err = Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !strings.Contains(s, "<photoshop:Credit>Someone</photoshop:Credit>") || strings.Contains(s, "Ignored") {
+		t.Errorf("got packet:\n%s", s)
+	}
+
+	for _, schemas := range []map[string]interface{}{
+		{"http://example.com/unregistered/": &testPhotoshop{}},
+		{nsPhotoshop: testPhotoshop{}},
+		{nsPhotoshop: &testLayer{}},
+	} {
+		if _, err := Encode(context.Background(), &metadata.XMP{Schemas: schemas}); err == nil {
+			t.Errorf("%v: got nil error", schemas)
+		}
+	}
+}
+
+func TestRegisterSchemaPanics(t *testing.T) {
+	type badField struct {
+		Values map[string]string
+	}
+	type otherNamespace struct {
+		Value string `xmp:"http://example.com/other/ Value"`
+	}
+	for _, tc := range []struct {
+		name, ns, prefix string
+		v                interface{}
+	}{
+		{"duplicate", nsPhotoshop, "photoshop", &testPhotoshop{}},
+		{"built-in", nsDC, "dc", &testLayer{}},
+		{"not a pointer", "http://example.com/a/", "a", testLayer{}},
+		{"bad prefix", "http://example.com/b/", "xmlb", &testLayer{}},
+		{"bad field", "http://example.com/c/", "c", &badField{}},
+		{"other namespace", "http://example.com/d/", "d", &otherNamespace{}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: didn't panic", tc.name)
+				}
+			}()
+			RegisterSchema(tc.ns, tc.prefix, tc.v)
+		}()
+	}
+}
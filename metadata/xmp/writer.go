@@ -0,0 +1,411 @@
+package xmp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+// packetID is the ID every XMP packet wrapper carries.
+const packetID = "W5M0MpCehiHzreSzNTczkc9d"
+
+// DefaultPadding is the number of bytes of padding Encode puts in an
+// XMP packet if it isn't given metadata.XMPOptions. It's what the XMP
+// specification suggests, and is enough for most edits.
+const DefaultPadding = 2048
+
+// defaultPrefixes holds the prefixes used for the namespaces XMP has
+// fields for, if the XMP doesn't give its own.
+var defaultPrefixes = map[string]string{
+	nsDC:        "dc",
+	nsXMP:       "xmp",
+	nsXMPRights: "xmpRights",
+	nsXMPMM:     "xmpMM",
+	nsXMPIDQ:    "xmpidq",
+	nsStRef:     "stRef",
+	nsStEvt:     "stEvt",
+}
+
+// Encode encodes XMP format metadata as an RDF/XML packet, in an
+// xpacket wrapper that marks it as writable. All the properties are
+// written in the element form in a single rdf:Description, followed by
+// the properties in Schemas and then those in Other. Namespaces keep
+// the prefixes given in Prefixes where they can, and otherwise get
+// their usual or registered prefixes.
+//
+// The packet ends with DefaultPadding bytes of padding, unless it's
+// passed metadata.XMPOptions saying otherwise. If the options give a
+// Size the packet doesn't fit in, Encode returns an error.
+func Encode(ctx context.Context, x *metadata.XMP, opt ...image.WriteOption) (string, error) {
+	if x == nil {
+		return "", errors.New("xmp: nil XMP data")
+	}
+	o := metadata.XMPOptions{Padding: DefaultPadding}
+	for _, v := range opt {
+		if v, ok := v.(metadata.XMPOptions); ok {
+			o = v
+		}
+	}
+	if o.Padding < 0 || o.Size < 0 {
+		return "", errors.New("xmp: negative padding or size")
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	props, err := schemaProperties(x)
+	if err != nil {
+		return "", err
+	}
+	props = append(append(typedProperties(x), props...), x.Other...)
+	w := &writer{prefixes: make(map[string]string), used: make(map[string]bool)}
+	for _, p := range props {
+		if p == nil || p.Name.Local == "" || p.Name.Space == "" {
+			return "", errors.New("xmp: property without a namespace and name")
+		}
+		if err := w.addNamespaces(p, x.Prefixes); err != nil {
+			return "", err
+		}
+	}
+
+	b := &w.b
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"" + packetID + "\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"" + nsRDF + "\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"")
+	escape(b, x.About)
+	b.WriteString("\"")
+	for _, ns := range w.namespaces {
+		b.WriteString("\n    xmlns:" + w.prefixes[ns] + "=\"")
+		escape(b, ns)
+		b.WriteString("\"")
+	}
+	if len(props) == 0 {
+		b.WriteString("/>\n")
+	} else {
+		b.WriteString(">\n")
+		for _, p := range props {
+			w.property(p, "   ")
+		}
+		b.WriteString("  </rdf:Description>\n")
+	}
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+
+	const trailer = "<?xpacket end=\"w\"?>"
+	padding := o.Padding
+	if o.Size != 0 {
+		padding = o.Size - b.Len() - len(trailer)
+		if padding < 0 {
+			return "", fmt.Errorf("xmp: packet needs %d bytes, more than the %d available", b.Len()+len(trailer), o.Size)
+		}
+	}
+	pad(b, padding)
+	b.WriteString(trailer)
+	return b.String(), nil
+}
+
+// pad writes n bytes of padding to b, as lines of spaces.
+func pad(b *strings.Builder, n int) {
+	for n > 0 {
+		l := n
+		if l > 100 {
+			l = 100
+		}
+		b.WriteString(strings.Repeat(" ", l-1))
+		b.WriteByte('\n')
+		n -= l
+	}
+}
+
+// writer writes the properties of an XMP packet.
+type writer struct {
+	b strings.Builder
+	// namespaces holds the namespaces the properties use, in the order
+	// they're first used.
+	namespaces []string
+	// prefixes maps each namespace to its prefix.
+	prefixes map[string]string
+	// used holds the prefixes that are taken.
+	used map[string]bool
+}
+
+// reservedPrefixes are the prefixes the packet itself uses, or that
+// XML reserves.
+var reservedPrefixes = map[string]bool{"x": true, "rdf": true, "xml": true, "xmlns": true}
+
+// validPrefix reports whether s can be used as a namespace prefix.
+func validPrefix(s string) bool {
+	if s == "" || reservedPrefixes[s] || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+		case i > 0 && (r == '-' || r == '.' || '0' <= r && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// addNamespaces gives prefixes to the namespaces the property p and
+// its fields and items use, preferring those given by prefixes.
+func (w *writer) addNamespaces(p *metadata.XMPProperty, prefixes map[string]string) error {
+	if ns := p.Name.Space; ns != "" && w.prefixes[ns] == "" {
+		if ns == nsRDF || ns == nsXML {
+			return fmt.Errorf("xmp: property %s is in a reserved namespace", p.Name.Local)
+		}
+		prefix := prefixes[ns]
+		if !validPrefix(prefix) || w.used[prefix] {
+			prefix = defaultPrefixes[ns]
+		}
+		if sc := lookupSchema(ns); sc != nil && (!validPrefix(prefix) || w.used[prefix]) {
+			prefix = sc.prefix
+		}
+		for i := 1; !validPrefix(prefix) || w.used[prefix]; i++ {
+			prefix = "ns" + strconv.Itoa(i)
+		}
+		w.namespaces = append(w.namespaces, ns)
+		w.prefixes[ns] = prefix
+		w.used[prefix] = true
+	}
+	for _, f := range p.Fields {
+		if f == nil || f.Name.Local == "" || f.Name.Space == "" {
+			return fmt.Errorf("xmp: field of %s without a namespace and name", p.Name.Local)
+		}
+		if err := w.addNamespaces(f, prefixes); err != nil {
+			return err
+		}
+	}
+	for _, q := range p.Qualifiers {
+		if q == nil || q.Name.Local == "" || q.Name.Space == "" {
+			return fmt.Errorf("xmp: qualifier of %s without a namespace and name", p.Name.Local)
+		}
+		if err := w.addNamespaces(q, prefixes); err != nil {
+			return err
+		}
+	}
+	for _, item := range p.Items {
+		if item == nil {
+			return fmt.Errorf("xmp: nil item in %s", p.Name.Local)
+		}
+		// Items are written as rdf:li elements, whatever their name.
+		n := *item
+		n.Name = xml.Name{}
+		if err := w.addNamespaces(&n, prefixes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// property writes the property element for p, indented by indent.
+// Items of arrays are written as rdf:li elements, and the values of
+// qualified properties as rdf:value elements.
+func (w *writer) property(p *metadata.XMPProperty, indent string) {
+	b := &w.b
+	name := "rdf:li"
+	switch {
+	case p.Name == rdfValue:
+		name = "rdf:value"
+	case p.Name.Local != "":
+		name = w.prefixes[p.Name.Space] + ":" + p.Name.Local
+	}
+	if len(p.Qualifiers) > 0 {
+		b.WriteString(indent + "<" + name + " rdf:parseType=\"Resource\">\n")
+		v := *p
+		v.Name = rdfValue
+		v.Qualifiers = nil
+		w.property(&v, indent+" ")
+		for _, q := range p.Qualifiers {
+			w.property(q, indent+" ")
+		}
+		b.WriteString(indent + "</" + name + ">\n")
+		return
+	}
+	b.WriteString(indent + "<" + name)
+	if p.Lang != "" {
+		b.WriteString(" xml:lang=\"")
+		escape(b, p.Lang)
+		b.WriteString("\"")
+	}
+
+	switch p.Kind {
+	case metadata.XMPURI:
+		b.WriteString(" rdf:resource=\"")
+		escape(b, p.Value)
+		b.WriteString("\"/>\n")
+	case metadata.XMPStruct:
+		b.WriteString(" rdf:parseType=\"Resource\"")
+		if len(p.Fields) == 0 {
+			b.WriteString("/>\n")
+			return
+		}
+		b.WriteString(">\n")
+		for _, f := range p.Fields {
+			w.property(f, indent+" ")
+		}
+		b.WriteString(indent + "</" + name + ">\n")
+	case metadata.XMPBag, metadata.XMPSeq, metadata.XMPAlt:
+		array := map[metadata.XMPKind]string{
+			metadata.XMPBag: "rdf:Bag",
+			metadata.XMPSeq: "rdf:Seq",
+			metadata.XMPAlt: "rdf:Alt",
+		}[p.Kind]
+		b.WriteString(">\n" + indent + " <" + array)
+		if len(p.Items) == 0 {
+			b.WriteString("/>\n")
+		} else {
+			b.WriteString(">\n")
+			for _, item := range p.Items {
+				n := *item
+				n.Name = xml.Name{}
+				w.property(&n, indent+"  ")
+			}
+			b.WriteString(indent + " </" + array + ">\n")
+		}
+		b.WriteString(indent + "</" + name + ">\n")
+	default:
+		b.WriteString(">")
+		escape(b, p.Value)
+		b.WriteString("</" + name + ">\n")
+	}
+}
+
+// escape writes s to b, escaped for use in XML text and attribute
+// values.
+func escape(b *strings.Builder, s string) {
+	// EscapeText only fails if the writer does.
+	xml.EscapeText(b, []byte(s))
+}
+
+// typedProperties returns the properties given by the fields of x, in
+// the order of the fields. Empty fields are left out.
+func typedProperties(x *metadata.XMP) []*metadata.XMPProperty {
+	var props []*metadata.XMPProperty
+	text := func(ns, name, v string) {
+		if v != "" {
+			props = append(props, &metadata.XMPProperty{Name: xml.Name{Space: ns, Local: name}, Value: v})
+		}
+	}
+	list := func(ns, name string, kind metadata.XMPKind, v []string) {
+		if len(v) == 0 {
+			return
+		}
+		p := &metadata.XMPProperty{Name: xml.Name{Space: ns, Local: name}, Kind: kind}
+		for _, s := range v {
+			p.Items = append(p.Items, &metadata.XMPProperty{Value: s})
+		}
+		props = append(props, p)
+	}
+	langAlt := func(ns, name string, v []metadata.LanguageAlternative) {
+		if len(v) == 0 {
+			return
+		}
+		p := &metadata.XMPProperty{Name: xml.Name{Space: ns, Local: name}, Kind: metadata.XMPAlt}
+		for _, a := range v {
+			p.Items = append(p.Items, &metadata.XMPProperty{Value: a.Text, Lang: a.Language})
+		}
+		props = append(props, p)
+	}
+	date := func(ns, name string, t *time.Time) {
+		if t != nil {
+			text(ns, name, formatDate(metadata.NewXMPDate(*t)))
+		}
+	}
+
+	if c := x.CoreProperties; c != nil {
+		list(nsDC, "contributor", metadata.XMPBag, c.Contributor)
+		text(nsDC, "coverage", c.Coverage)
+		list(nsDC, "creator", metadata.XMPSeq, c.Creator)
+		var dates []string
+		for _, t := range c.Date {
+			dates = append(dates, formatDate(metadata.NewXMPDate(t)))
+		}
+		list(nsDC, "date", metadata.XMPSeq, dates)
+		langAlt(nsDC, "description", c.Description)
+		text(nsDC, "format", c.Format)
+		text(nsDC, "identifier", c.Identifier)
+		list(nsDC, "language", metadata.XMPBag, c.Language)
+		list(nsDC, "publisher", metadata.XMPBag, c.Publisher)
+		list(nsDC, "relation", metadata.XMPBag, c.Relation)
+		langAlt(nsDC, "rights", c.Rights)
+		text(nsDC, "source", c.Source)
+		list(nsDC, "subject", metadata.XMPBag, c.Subject)
+		langAlt(nsDC, "title", c.Title)
+		list(nsDC, "type", metadata.XMPBag, c.Type)
+	}
+	if p := x.Properties; p != nil {
+		date(nsXMP, "CreateDate", p.CreateDate)
+		text(nsXMP, "CreatorTool", p.CreatorTool)
+		list(nsXMP, "Identifier", metadata.XMPBag, p.Identifier)
+		text(nsXMP, "Label", p.Label)
+		date(nsXMP, "MetadataDate", p.MetadataData)
+		date(nsXMP, "ModifyDate", p.ModifiedDate)
+		if p.Rating != 0 {
+			text(nsXMP, "Rating", strconv.FormatFloat(p.Rating, 'f', -1, 64))
+		}
+	}
+	if r := x.Rights; r != nil {
+		text(nsXMPRights, "Certificate", r.Certificate)
+		if r.Marked != nil {
+			text(nsXMPRights, "Marked", map[bool]string{true: "True", false: "False"}[*r.Marked])
+		}
+		list(nsXMPRights, "Owner", metadata.XMPBag, r.Owner)
+		langAlt(nsXMPRights, "UsageTerms", r.UsageTerms)
+		text(nsXMPRights, "WebStatement", r.WebStatement)
+	}
+	value := func(ns, name string, kind metadata.XMPKind, v interface{}) {
+		if p := toProperty(reflect.ValueOf(v), xml.Name{Space: ns, Local: name}, kind, ns, false); p != nil {
+			props = append(props, p)
+		}
+	}
+
+	if m := x.MediaManagement; m != nil {
+		value(nsXMPMM, "DerivedFrom", 0, m.DerivedFrom)
+		text(nsXMPMM, "DocumentID", string(m.DocumentID))
+		value(nsXMPMM, "History", metadata.XMPSeq, m.History)
+		text(nsXMPMM, "InstanceID", string(m.InstanceID))
+		value(nsXMPMM, "ManagedFrom", 0, m.ManagedFrom)
+		text(nsXMPMM, "OriginalDocumentID", string(m.OriginalDocumentID))
+		text(nsXMPMM, "RenditionClass", string(m.RenditionClass))
+		text(nsXMPMM, "RenditionParams", m.RenditionParams)
+	}
+	if q := x.IDQ; q != nil {
+		text(nsXMPIDQ, "Scheme", q.Scheme)
+	}
+	return props
+}
+
+// formatDate returns the XMP date for d, given as precisely as d says,
+// with a time zone unless it had none. Fractional seconds are only
+// given if d has them.
+func formatDate(d metadata.XMPDate) string {
+	var layout string
+	switch d.Precision {
+	case metadata.XMPDateYear:
+		return d.Format("2006")
+	case metadata.XMPDateMonth:
+		return d.Format("2006-01")
+	case metadata.XMPDateDay:
+		return d.Format("2006-01-02")
+	case metadata.XMPDateMinute:
+		layout = "2006-01-02T15:04"
+	default:
+		layout = "2006-01-02T15:04:05.999999999"
+	}
+	if !d.NoZone {
+		layout += "Z07:00"
+	}
+	return d.Format(layout)
+}
@@ -0,0 +1,268 @@
+package xmp
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/gif"
+	"github.com/rmamba/image/jpeg"
+	"github.com/rmamba/image/metadata"
+	"github.com/rmamba/image/png"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	want, err := Decode(context.Background(), testPacket)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	s, err := Encode(context.Background(), want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode of encoded packet: %v\n%s", err, s)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Encoding again gives the same packet.
+	s2, err := Encode(context.Background(), got)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if s2 != s {
+		t.Errorf("re-encoding changed the packet:\n%s\nto\n%s", s, s2)
+	}
+}
+
+func TestEncodeFields(t *testing.T) {
+	marked := false
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.FixedZone("", 2*3600))
+	want := &metadata.XMP{
+		CoreProperties: &metadata.Core{
+			Creator:     []string{"Someone <someone@example.com>"},
+			Date:        []time.Time{time.Date(2021, 6, 1, 12, 0, 0, 5e8, time.UTC)},
+			Description: []metadata.LanguageAlternative{{Language: "x-default", Text: "Cats & dogs"}},
+			Identifier:  "id",
+			Language:    []string{"en-GB", "sl"},
+		},
+		Properties: &metadata.XMPSpecific{CreateDate: &created, Rating: 4.5, Identifier: []string{"a"}},
+		Rights:     &metadata.XMPRights{Marked: &marked, Owner: []string{"Someone"}},
+		MediaManagement: &metadata.XMPMediaManagement{
+			DocumentID:     "xmp.did:1",
+			InstanceID:     "xmp.iid:2",
+			RenditionClass: "proof:pdf",
+		},
+		IDQ:   &metadata.XMPIDQ{Scheme: "ISBN"},
+		About: "uuid:\"1\"",
+	}
+	s, err := Encode(context.Background(), want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v\n%s", err, s)
+	}
+	if !got.Properties.CreateDate.Equal(created) || !got.CoreProperties.Date[0].Equal(want.CoreProperties.Date[0]) {
+		t.Errorf("got dates %v and %v", got.Properties.CreateDate, got.CoreProperties.Date)
+	}
+	// Dates come back in fixed zones, so compare them separately.
+	got.Properties.CreateDate = want.Properties.CreateDate
+	got.CoreProperties.Date = want.CoreProperties.Date
+	got.Prefixes = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestEncodeDates(t *testing.T) {
+	// Dates are written back as precisely as they were given, with a
+	// time zone only if they had one. Those a time.Time can hold exactly
+	// are decoded into CreateDate, the rest are kept as they were.
+	for _, tc := range []struct {
+		date  string
+		typed bool
+	}{
+		{"2020", false},
+		{"2020-05", false},
+		{"2020-05-06", false},
+		{"2020-05-06T07:08", false},
+		{"2020-05-06T07:08+02:00", false},
+		{"2020-05-06T07:08:09", false},
+		{"2020-05-06T07:08:09Z", true},
+		{"2020-05-06T07:08:09.25-05:00", true},
+	} {
+		date := tc.date
+		x, err := Decode(context.Background(), `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+ <rdf:Description rdf:about=""><xmp:CreateDate>`+date+`</xmp:CreateDate></rdf:Description>
+</rdf:RDF>`)
+		if err != nil {
+			t.Errorf("%s: Decode: %v", date, err)
+			continue
+		}
+		if typed := x.Properties != nil && x.Properties.CreateDate != nil; typed != tc.typed {
+			t.Errorf("%s: got CreateDate set %v, want %v", date, typed, tc.typed)
+		}
+		s, err := Encode(context.Background(), x)
+		if err != nil {
+			t.Errorf("%s: Encode: %v", date, err)
+			continue
+		}
+		if want := "<xmp:CreateDate>" + date + "</xmp:CreateDate>"; !strings.Contains(s, want) {
+			t.Errorf("%s: packet doesn't hold %s:\n%s", date, want, s)
+		}
+	}
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	s, err := Encode(context.Background(), &metadata.XMP{})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	x, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v\n%s", err, s)
+	}
+	if x.CoreProperties != nil || len(x.Other) != 0 {
+		t.Errorf("got %+v, want empty XMP", x)
+	}
+}
+
+func TestEncodePrefixes(t *testing.T) {
+	const nsA, nsB, nsC = "http://example.com/a/", "http://example.com/b/", "http://example.com/c/"
+	x := &metadata.XMP{
+		Properties: &metadata.XMPSpecific{Label: "label"},
+		Other: []*metadata.XMPProperty{
+			{Name: xml.Name{Space: nsA, Local: "One"}, Value: "1"},
+			{Name: xml.Name{Space: nsB, Local: "Two"}, Kind: metadata.XMPStruct, Fields: []*metadata.XMPProperty{
+				{Name: xml.Name{Space: nsC, Local: "Three"}, Kind: metadata.XMPURI, Value: "http://example.com/"},
+			}},
+		},
+		// The prefix for nsA is already taken by the XMP namespace,
+		// and the one for nsB isn't valid.
+		Prefixes: map[string]string{nsXMP: "xap", nsA: "xap", nsB: "1b", nsC: "c"},
+	}
+	s, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, want := range []string{`xmlns:xap="` + nsXMP, `xmlns:ns1="` + nsA, `xmlns:ns2="` + nsB, `xmlns:c="` + nsC} {
+		if !strings.Contains(s, want) {
+			t.Errorf("packet doesn't declare %s:\n%s", want, s)
+		}
+	}
+	got, err := Decode(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Decode: %v\n%s", err, s)
+	}
+	if !reflect.DeepEqual(got.Other, x.Other) {
+		t.Errorf("got other properties %+v, want %+v", got.Other, x.Other)
+	}
+
+	for _, p := range []*metadata.XMPProperty{
+		{Name: xml.Name{Local: "NoNamespace"}, Value: "v"},
+		{Name: xml.Name{Space: nsRDF, Local: "value"}, Value: "v"},
+		{Name: xml.Name{Space: nsA, Local: "BadField"}, Kind: metadata.XMPStruct, Fields: []*metadata.XMPProperty{{Value: "v"}}},
+	} {
+		if _, err := Encode(context.Background(), &metadata.XMP{Other: []*metadata.XMPProperty{p}}); err == nil {
+			t.Errorf("%+v: got nil error", p)
+		}
+	}
+}
+
+func TestEncodePadding(t *testing.T) {
+	x := &metadata.XMP{Properties: &metadata.XMPSpecific{CreatorTool: "tool"}}
+	unpadded, err := Encode(context.Background(), x, metadata.XMPOptions{})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	s, err := Encode(context.Background(), x)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if len(s) != len(unpadded)+DefaultPadding {
+		t.Errorf("got %d bytes, want %d", len(s), len(unpadded)+DefaultPadding)
+	}
+	if !strings.HasSuffix(s, "\n<?xpacket end=\"w\"?>") {
+		t.Errorf("got packet ending %q", s[len(s)-30:])
+	}
+
+	// A bigger XMP can be made the same size as the old one, to
+	// replace it in place.
+	x.Properties.CreatorTool = "a newer version of the tool"
+	s2, err := Encode(context.Background(), x, metadata.XMPOptions{Size: len(s)})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if len(s2) != len(s) {
+		t.Errorf("got %d bytes, want %d", len(s2), len(s))
+	}
+	if got, err := Decode(context.Background(), s2); err != nil || got.Properties.CreatorTool != x.Properties.CreatorTool {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if _, err := Encode(context.Background(), x, metadata.XMPOptions{Size: len(unpadded)}); err == nil {
+		t.Error("got nil error for a packet that doesn't fit")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(context.Background(), nil); err == nil {
+		t.Error("nil XMP: got nil error")
+	}
+	if _, err := Encode(context.Background(), &metadata.XMP{}, metadata.XMPOptions{Padding: -1}); err == nil {
+		t.Error("negative padding: got nil error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Encode(ctx, &metadata.XMP{}); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestEncodeInImages(t *testing.T) {
+	want := &metadata.XMP{CoreProperties: &metadata.Core{Creator: []string{"Someone"}}}
+	opts := metadata.XMPOptions{Padding: 100}
+	for _, tc := range []struct {
+		format string
+		md     metadata.XMPCarrier
+	}{
+		{"png", &png.Metadata{}},
+		{"gif", &gif.Metadata{}},
+		{"jpeg", &jpeg.Metadata{}},
+	} {
+		tc.md.SetXMP(want)
+		var buf bytes.Buffer
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		if err := image.EncodeWithOptions(context.Background(), &buf, tc.format, img, tc.md.(image.WriteOption), opts); err != nil {
+			t.Fatalf("%s: encoding: %v", tc.format, err)
+		}
+		packet, err := Encode(context.Background(), want, opts)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte(packet)) {
+			t.Errorf("%s: packet not found in image", tc.format)
+		}
+		_, md, _, err := image.DecodeWithOptions(context.Background(), &buf)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", tc.format, err)
+		}
+		got, err := md.(metadata.XMPCarrier).XMP(context.Background())
+		if err != nil {
+			t.Fatalf("%s: XMP: %v", tc.format, err)
+		}
+		got.Prefixes = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", tc.format, got, want)
+		}
+	}
+}
@@ -1,24 +1,22 @@
 // Package xmp encodes and decodes XMP format image metadata.
 package xmp
 
-import (
-	"context"
-
-	"github.com/rmamba/image"
-	"github.com/rmamba/image/metadata"
-)
+import "github.com/rmamba/image/metadata"
 
 func init() {
 	metadata.RegisterXMPDecoder(Decode)
 	metadata.RegisterXMPEncoder(Encode)
 }
 
-// Decode decodes XMP format metadata.
-func Decode(ctx context.Context, b string, opt ...image.ReadOption) (*metadata.XMP, error) {
-	panic("Not implemented")
-}
-
-// Encode encodes XMP format metadata.
-func Encode(ctx context.Context, x *metadata.XMP, opt ...image.WriteOption) (string, error) {
-	panic("Not implemented")
-}
+// The namespaces XMP uses.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsXMPRights = "http://ns.adobe.com/xap/1.0/rights/"
+	nsXMPMM     = "http://ns.adobe.com/xap/1.0/mm/"
+	nsXMPIDQ    = "http://ns.adobe.com/xmp/Identifier/qual/1.0/"
+	nsStRef     = "http://ns.adobe.com/xap/1.0/sType/ResourceRef#"
+	nsStEvt     = "http://ns.adobe.com/xap/1.0/sType/ResourceEvent#"
+)
@@ -0,0 +1,74 @@
+package image
+
+import (
+	"fmt"
+)
+
+// ReadSettings holds the read options passed to a decode call after
+// they've been resolved. Each kind of option the core image package
+// defines appears once, with the defaults filled in, so image format
+// decoders don't each need to sort through the options themselves.
+type ReadSettings struct {
+	// Data holds the data decoding options. The DefaultDecodeOption
+	// values are replaced with the defaults: the image is decoded and
+	// the metadata decoding is deferred.
+	Data DataDecodeOptions
+	// Limits holds the image and metadata size limits.
+	Limits LimitOptions
+	// Damage holds the options for reading damaged image files.
+	Damage DamageHandlingOptions
+	// Transform holds the options for applying metadata transforms to
+	// the decoded image.
+	Transform ImageTransformOptions
+	// Other holds any options the core image package doesn't know
+	// about, in the order they were passed. These are generally
+	// format-specific options, and image format decoders should ignore
+	// the ones that aren't meant for them.
+	Other []ReadOption
+}
+
+// ResolveReadOptions gathers the read options passed to a decode call
+// into a single ReadSettings. Each kind of option may be passed more
+// than once as long as all the copies are the same; conflicting copies
+// of an option are rejected with an error wrapping ErrOption.
+func ResolveReadOptions(opts ...ReadOption) (*ReadSettings, error) {
+	s := &ReadSettings{}
+	var seenData, seenLimits, seenDamage, seenTransform bool
+	for _, o := range opts {
+		switch o := o.(type) {
+		case DataDecodeOptions:
+			if seenData && s.Data != o {
+				return nil, fmt.Errorf("%w: conflicting DataDecodeOptions", ErrOption)
+			}
+			s.Data, seenData = o, true
+		case LimitOptions:
+			if seenLimits && s.Limits != o {
+				return nil, fmt.Errorf("%w: conflicting LimitOptions", ErrOption)
+			}
+			s.Limits, seenLimits = o, true
+		case DamageHandlingOptions:
+			if seenDamage && s.Damage != o {
+				return nil, fmt.Errorf("%w: conflicting DamageHandlingOptions", ErrOption)
+			}
+			s.Damage, seenDamage = o, true
+		case ImageTransformOptions:
+			if seenTransform && s.Transform != o {
+				return nil, fmt.Errorf("%w: conflicting ImageTransformOptions", ErrOption)
+			}
+			s.Transform, seenTransform = o, true
+		case nil:
+			return nil, fmt.Errorf("%w: nil read option", ErrOption)
+		default:
+			s.Other = append(s.Other, o)
+		}
+	}
+
+	if s.Data.DecodeImage == DefaultDecodeOption {
+		s.Data.DecodeImage = DecodeData
+	}
+	if s.Data.DecodeMetadata == DefaultDecodeOption {
+		s.Data.DecodeMetadata = DeferData
+	}
+
+	return s, nil
+}
@@ -0,0 +1,65 @@
+package image
+
+import (
+	"errors"
+	"testing"
+)
+
+// testReadOption is a stand-in for a format-specific read option.
+type testReadOption struct{ n int }
+
+func (testReadOption) IsImageReadOption() {}
+
+func TestResolveReadOptionsDefaults(t *testing.T) {
+	s, err := ResolveReadOptions()
+	if err != nil {
+		t.Fatalf("ResolveReadOptions: %v", err)
+	}
+	want := DataDecodeOptions{DecodeImage: DecodeData, DecodeMetadata: DeferData}
+	if s.Data != want {
+		t.Fatalf("got data options %+v, want %+v", s.Data, want)
+	}
+}
+
+func TestResolveReadOptionsMerge(t *testing.T) {
+	limits := LimitOptions{MaxImageSize: 1024}
+	damage := DamageHandlingOptions{AllowTrailingData: true}
+	s, err := ResolveReadOptions(
+		DataDecodeOptions{DecodeImage: DiscardData, DecodeMetadata: DecodeData},
+		limits,
+		testReadOption{1},
+		damage,
+		limits,
+		testReadOption{2},
+	)
+	if err != nil {
+		t.Fatalf("ResolveReadOptions: %v", err)
+	}
+	if s.Data.DecodeImage != DiscardData || s.Data.DecodeMetadata != DecodeData {
+		t.Fatalf("got data options %+v", s.Data)
+	}
+	if s.Limits != limits {
+		t.Fatalf("got limits %+v, want %+v", s.Limits, limits)
+	}
+	if s.Damage != damage {
+		t.Fatalf("got damage options %+v, want %+v", s.Damage, damage)
+	}
+	if len(s.Other) != 2 || s.Other[0] != (testReadOption{1}) || s.Other[1] != (testReadOption{2}) {
+		t.Fatalf("got other options %v, want [{1} {2}]", s.Other)
+	}
+}
+
+func TestResolveReadOptionsConflict(t *testing.T) {
+	tests := [][]ReadOption{
+		{DataDecodeOptions{DecodeImage: DecodeData}, DataDecodeOptions{DecodeImage: DiscardData}},
+		{LimitOptions{MaxImageSize: 1}, LimitOptions{MaxImageSize: 2}},
+		{DamageHandlingOptions{}, DamageHandlingOptions{AllowTrailingData: true}},
+		{ImageTransformOptions{}, ImageTransformOptions{RotationTransform: ForwardImageTransform}},
+		{nil},
+	}
+	for i, opts := range tests {
+		if _, err := ResolveReadOptions(opts...); !errors.Is(err, ErrOption) {
+			t.Errorf("test %d: got error %v, want ErrOption", i, err)
+		}
+	}
+}
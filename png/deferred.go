@@ -22,6 +22,10 @@ type Deferred struct {
 	trns []byte // cached tRNS chunk
 	idat []byte // cached IDAT chunk.
 	img  image.Image
+
+	// transforms holds the metadata transforms to apply to the image,
+	// in order, when it's instantiated.
+	transforms []func(image.Image) image.Image
 }
 
 func (d *Deferred) ColorModel() color.Model {
@@ -99,8 +103,13 @@ func (i *Deferred) Instantiate(ctx context.Context, opts ...image.ReadOption) (i
 		}
 	}
 	i.img = d.img
+	if i.img != nil {
+		for _, t := range i.transforms {
+			i.img = t(i.img)
+		}
+	}
 
-	return d.img, nil
+	return i.img, nil
 
 }
 
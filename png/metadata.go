@@ -87,6 +87,14 @@ type Metadata struct {
 	Histogram []uint16
 }
 
+// Metadata implements the carrier interfaces for the kinds of
+// embedded metadata a png file can hold.
+var (
+	_ metadata.EXIFCarrier = (*Metadata)(nil)
+	_ metadata.XMPCarrier  = (*Metadata)(nil)
+	_ metadata.ICCCarrier  = (*Metadata)(nil)
+)
+
 // ImageMetadataFormat returns the type of image the associated
 // metadata was read from.
 func (m *Metadata) ImageMetadataFormat() string {
@@ -121,17 +129,23 @@ func (m *Metadata) EXIF(ctx context.Context, opt ...image.ReadOption) (*metadata
 		return nil, m.exifDecodeErr
 	}
 	if m.rawExif != nil {
+		// Some writers leave the jpeg "Exif\x00\x00" header in front
+		// of the TIFF data; the decoder skips it.
+		tiff := bytes.TrimPrefix(m.rawExif, []byte("Exif\x00\x00"))
+		if len(tiff) < 4 {
+			return nil, FormatError("exif data too short")
+		}
 		var isBigEndian bool
-		switch string(m.rawExif[0:4]) {
+		switch string(tiff[0:4]) {
 		case string([]byte{73, 73, 42, 0}):
 			isBigEndian = false
 		case string([]byte{77, 77, 0, 42}):
 			isBigEndian = true
 		default:
-			return nil, fmt.Errorf("Invalid exif prefix %v", m.rawExif[0:4])
+			return nil, fmt.Errorf("Invalid exif prefix %v", tiff[0:4])
 		}
 
-		x, err := metadata.DecodeEXIF(ctx, m.rawExif[4:], isBigEndian, opt...)
+		x, err := metadata.DecodeEXIF(ctx, m.rawExif, isBigEndian, opt...)
 		if err != nil {
 			m.exifDecodeErr = err
 			return nil, err
@@ -186,6 +200,12 @@ func (m *Metadata) SetXMP(x *metadata.XMP) {
 	m.rawXmp = nil
 }
 
+// ICC returns the ICC color profile associated with the metadata
+// object. If there is no profile then it will return nil. The returned
+// profile will still be associated with its parent metadata object,
+// and changes to it will be persistent.
+//
+// Note that the profile may be decoded lazily.
 func (m *Metadata) ICC(ctx context.Context, opt ...image.ReadOption) (*metadata.ICC, error) {
 	if m.icc != nil {
 		return m.icc, nil
@@ -206,10 +226,84 @@ func (m *Metadata) ICC(ctx context.Context, opt ...image.ReadOption) (*metadata.
 	return nil, nil
 }
 
-func (m *Metadata) SetIcc(i *metadata.ICC) {
+// defaultICCName is the profile name written to the iCCP chunk when
+// the metadata doesn't have one.
+const defaultICCName = "ICC profile"
+
+// SetICC replaces the ICC color profile associated with the metadata
+// object.
+func (m *Metadata) SetICC(i *metadata.ICC) {
 	m.icc = i
 	m.iccDecodeErr = nil
 	m.rawIcc = nil
+	if m.iccName == "" {
+		m.iccName = defaultICCName
+	}
+}
+
+// SetIcc replaces the ICC color profile associated with the metadata
+// object.
+//
+// Deprecated: use SetICC.
+func (m *Metadata) SetIcc(i *metadata.ICC) {
+	m.SetICC(i)
+}
+
+// RawEXIF returns the undecoded EXIF data read from the image, a TIFF
+// structure starting with its byte order mark. It returns nil if the
+// image had no EXIF data or the data has been decoded or replaced.
+func (m *Metadata) RawEXIF() []byte {
+	return m.rawExif
+}
+
+// SetRawEXIF replaces the EXIF information associated with the
+// metadata object with the undecoded data b, which is written out as
+// it is.
+func (m *Metadata) SetRawEXIF(b []byte) {
+	m.exif = nil
+	m.exifDecodeErr = nil
+	m.rawExif = b
+}
+
+// RawXMP returns the undecoded XMP packet read from the image. It
+// returns the empty string if the image had no XMP data or the data
+// has been decoded or replaced.
+func (m *Metadata) RawXMP() string {
+	if m.rawXmp == nil {
+		return ""
+	}
+	return *m.rawXmp
+}
+
+// SetRawXMP replaces the XMP information associated with the metadata
+// object with the undecoded packet x, which is written out as it is.
+// An empty packet removes the XMP information.
+func (m *Metadata) SetRawXMP(x string) {
+	m.xmp = nil
+	m.xmpDecodeErr = nil
+	m.rawXmp = nil
+	if x != "" {
+		m.rawXmp = &x
+	}
+}
+
+// RawICC returns the undecoded ICC profile read from the image. It
+// returns nil if the image had no profile or the profile has been
+// decoded or replaced.
+func (m *Metadata) RawICC() []byte {
+	return m.rawIcc
+}
+
+// SetRawICC replaces the ICC color profile associated with the
+// metadata object with the undecoded profile b, which is written out
+// as it is.
+func (m *Metadata) SetRawICC(b []byte) {
+	m.icc = nil
+	m.iccDecodeErr = nil
+	m.rawIcc = b
+	if m.iccName == "" {
+		m.iccName = defaultICCName
+	}
 }
 
 type TextType int
@@ -352,19 +446,19 @@ func (d Dimension) String() string {
 }
 
 func (d *decoder) parseTEXT(ctx context.Context, length uint32) error {
-	if _, err := io.ReadFull(d.r, d.tmp[:length]); err != nil {
+	tb, err := readData(ctx, d, length, true)
+	if err != nil {
 		return err
 	}
-	d.crc.Write(d.tmp[:length])
-	sep := bytes.IndexByte(d.tmp[:length], 0)
+	sep := bytes.IndexByte(tb, 0)
 	if sep == -1 {
 		return FormatError("no text separator found")
 	}
-	key := string(d.tmp[:sep])
+	key := string(tb[:sep])
 	val := ""
 	// We require a null at the end of the key, but the value might be empty.
 	if sep+1 <= int(length) {
-		val = string(d.tmp[sep+1 : length])
+		val = string(tb[sep+1 : length])
 	}
 	d.metadata.Text = append(d.metadata.Text, &TextEntry{key, val, EtText, "", ""})
 
@@ -376,7 +470,7 @@ func (d *decoder) parseZTXT(ctx context.Context, length uint32) error {
 	if err != nil {
 		return err
 	}
-	key, val, err := decodeKeyValComp(ctx, tb)
+	key, val, err := d.decodeKeyValComp(ctx, tb)
 	if err != nil {
 		return err
 	}
@@ -392,7 +486,7 @@ func (d *decoder) parseITXT(ctx context.Context, length uint32) error {
 		return err
 	}
 
-	key, lang, transkey, val, err := decodeItxtEntry(ctx, tb)
+	key, lang, transkey, val, err := d.decodeItxtEntry(ctx, tb)
 	if err != nil {
 		return err
 	}
@@ -472,7 +566,7 @@ func (d *decoder) parseICCP(ctx context.Context, length uint32) error {
 		return err
 	}
 	d.crc.Write(buf)
-	pname, profile, err := decodeKeyValComp(ctx, buf)
+	pname, profile, err := d.decodeKeyValComp(ctx, buf)
 	if err != nil {
 		return err
 	}
@@ -483,6 +577,21 @@ func (d *decoder) parseICCP(ctx context.Context, length uint32) error {
 	return d.verifyChecksum()
 }
 
+// parseEXIF reads the eXIf chunk. The chunk holds the exif data as a
+// TIFF file, starting with the byte order mark.
+func (d *decoder) parseEXIF(ctx context.Context, length uint32) error {
+	if d.metadata.rawExif != nil {
+		return FormatError("multiple eXIf chunks")
+	}
+	buf, err := readData(ctx, d, length, false)
+	if err != nil {
+		return err
+	}
+	d.metadata.rawExif = buf
+
+	return d.verifyChecksum()
+}
+
 func (d *decoder) parseSRGB(ctx context.Context, length uint32) error {
 	if length != 1 {
 		return FormatError("invalid sRGB length")
@@ -606,7 +715,7 @@ func (d *decoder) parseHIST(ctx context.Context, length uint32) error {
 }
 
 // decodeKeyValComp decodes a key/value pair where the value is compressed.
-func decodeKeyValComp(ctx context.Context, blob []byte) (string, string, error) {
+func (d *decoder) decodeKeyValComp(ctx context.Context, blob []byte) (string, string, error) {
 	sep := bytes.IndexByte(blob, 0)
 	if sep == -1 {
 		return "", "", FormatError("no text separator found")
@@ -614,16 +723,15 @@ func decodeKeyValComp(ctx context.Context, blob []byte) (string, string, error)
 	key := string(blob[:sep])
 	val := ""
 
+	if sep+1 >= len(blob) {
+		return "", "", FormatError("no compression method found")
+	}
+
 	// How is the value stored?
 	switch blob[sep+1] {
 	case 0:
 		// ZLib compressed
-		r, err := zlib.NewReader(bytes.NewReader(blob[sep+2:]))
-		if err != nil {
-			return "", "", err
-		}
-		defer r.Close()
-		u, err := ioutil.ReadAll(r)
+		u, err := d.inflate(blob[sep+2:])
 		if err != nil {
 			return "", "", err
 		}
@@ -636,9 +744,9 @@ func decodeKeyValComp(ctx context.Context, blob []byte) (string, string, error)
 	return key, val, nil
 }
 
-// decodeKeyValComp decodes an itxt entry. This contains a key,
+// decodeItxtEntry decodes an itxt entry. This contains a key,
 // language tag, translated keyword, and possibly-compressed value.
-func decodeItxtEntry(ctx context.Context, blob []byte) (string, string, string, string, error) {
+func (d *decoder) decodeItxtEntry(ctx context.Context, blob []byte) (string, string, string, string, error) {
 	sep := bytes.IndexByte(blob, 0)
 	if sep == -1 {
 		return "", "", "", "", FormatError("no text separator found")
@@ -678,18 +786,18 @@ func decodeItxtEntry(ctx context.Context, blob []byte) (string, string, string,
 	// How is the value stored?
 	switch blob[sep+1] {
 	case 0:
+		// Compressed values count against the metadata size limit as
+		// they're inflated, so uncompressed ones are counted here.
+		if err := d.countMetadata(len(rawValue)); err != nil {
+			return "", "", "", "", err
+		}
 		value = string(rawValue)
 	case 1:
 		if blob[sep+2] != 0 {
 			return "", "", "", "", FormatError(fmt.Sprintf("unknown compression flag %v", blob[sep+2]))
 		}
 		// ZLib compressed
-		r, err := zlib.NewReader(bytes.NewReader(rawValue))
-		if err != nil {
-			return "", "", "", "", err
-		}
-		defer r.Close()
-		u, err := ioutil.ReadAll(r)
+		u, err := d.inflate(rawValue)
 		if err != nil {
 			return "", "", "", "", err
 		}
@@ -701,6 +809,38 @@ func decodeItxtEntry(ctx context.Context, blob []byte) (string, string, string,
 	return key, languageTag, translatedKeyword, value, nil
 }
 
+// inflate decompresses a zlib compressed metadata value. The
+// decompressed bytes count against the metadata size limit, and
+// decompression stops as soon as the limit is passed.
+func (d *decoder) inflate(b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var lr io.Reader = r
+	if d.settings.Limits.MaxMetadataSize > 0 {
+		// Read one byte past the limit so we can tell when it's been
+		// exceeded.
+		lr = io.LimitReader(r, int64(d.settings.Limits.MaxMetadataSize)-d.metadataSize+1)
+	}
+	u, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.countMetadata(len(u)); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// countMetadata adds n bytes to the amount of metadata read so far
+// and checks the total against the metadata size limit.
+func (d *decoder) countMetadata(n int) error {
+	d.metadataSize += int64(n)
+	return d.settings.Limits.CheckMetadataSize(d.metadataSize)
+}
+
 func readData(ctx context.Context, d *decoder, length uint32, ut bool) ([]byte, error) {
 	// Do we need to read less data than will fit in our buffer? If so
 	// use the buffer.
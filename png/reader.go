@@ -12,11 +12,11 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"hash"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
 
 	"github.com/rmamba/image"
@@ -128,6 +128,29 @@ type decoder struct {
 	metadata         *Metadata
 	paletteCount     int // number of entries in the PLTE chunk
 
+	// settings holds the resolved read options for this decode.
+	settings image.ReadSettings
+	// metadataSize holds the number of bytes of metadata read so far.
+	metadataSize int64
+
+	// pendingChunk holds the header of a chunk that was read while
+	// looking for more IDAT chunks, if hasPendingChunk is set. The next
+	// call to parseChunk picks up from there.
+	pendingChunk    [8]byte
+	hasPendingChunk bool
+	// damaged is set if the image data was damaged or had extra data
+	// at the end and the DamageHandlingOptions let us carry on. The
+	// rest of the image data is skipped rather than checked.
+	damaged bool
+	// partial is set if the image is missing rows that couldn't be
+	// decoded, or the file ended before the IEND chunk, and the
+	// DamageHandlingOptions let us carry on.
+	partial bool
+	// seentRNS is set once a tRNS chunk has been read, and pendingtRNS
+	// holds a tRNS chunk that turned up before the PLTE chunk it
+	// applies to. These only matter with AllowMisorderedData set.
+	seentRNS    bool
+	pendingtRNS []byte
 }
 
 // A FormatError reports that the input is not a valid PNG.
@@ -135,6 +158,13 @@ type FormatError string
 
 func (e FormatError) Error() string { return "png: invalid format: " + string(e) }
 
+// ErrPartialImage is returned by DecodeExtended, along with the part of the
+// image that was decoded, when the image data is cut short or damaged part
+// way through, or the file ends before the IEND chunk, and
+// DamageHandlingOptions.SkipDamagedData is set. The rows that couldn't be
+// decoded are left zero.
+var ErrPartialImage = FormatError("image data ends before IEND chunk")
+
 var chunkOrderError = FormatError("chunk out of order")
 var multipleColorProfileError = FormatError("multiple color profiles seen")
 
@@ -239,6 +269,9 @@ func (d *decoder) parseIHDR(ctx context.Context, length uint32) error {
 	}
 	d.width, d.height = int(w), int(h)
 	d.metadata.Width, d.metadata.Height = int(w), int(h)
+	if err := d.settings.Limits.CheckImageSize(d.imageSize()); err != nil {
+		return err
+	}
 	return d.verifyChecksum()
 }
 
@@ -267,9 +300,11 @@ func (d *decoder) parsePLTE(ctx context.Context, length uint32) error {
 			d.palette[i] = color.RGBA{0x00, 0x00, 0x00, 0xff}
 		}
 		d.palette = d.palette[:np]
+		d.paletteCount = np
 	case cbTC8, cbTCA8, cbTC16, cbTCA16:
 		// As per the PNG spec, a PLTE chunk is optional (and for practical purposes,
 		// ignorable) for the ctTrueColor and ctTrueColorAlpha color types (section 4.1.2).
+		d.paletteCount = np
 	default:
 		return FormatError("PLTE, color type mismatch")
 	}
@@ -298,6 +333,11 @@ func (d *decoder) parsetRNS(ctx context.Context, length uint32) error {
 			d.transparent[1] *= 0x11
 		}
 		d.useTransparent = true
+		// Gray images with transparency decode to NRGBA, so they take
+		// more room than was checked for at the IHDR chunk.
+		if err := d.settings.Limits.CheckImageSize(d.imageSize()); err != nil {
+			return err
+		}
 
 	case cbTC8, cbTC16:
 		if length != 6 {
@@ -350,6 +390,9 @@ func (d *decoder) Read(p []byte) (int, error) {
 		return 0, nil
 	}
 	for d.idatLength == 0 {
+		if d.hasPendingChunk {
+			return 0, FormatError("not enough pixel data")
+		}
 		// We have exhausted an IDAT chunk. Verify the checksum of that chunk.
 		if err := d.verifyChecksum(); err != nil {
 			return 0, err
@@ -359,10 +402,14 @@ func (d *decoder) Read(p []byte) (int, error) {
 		if _, err := io.ReadFull(d.r, d.tmp[:8]); err != nil {
 			return 0, err
 		}
-		d.idatLength = binary.BigEndian.Uint32(d.tmp[:4])
 		if string(d.tmp[4:8]) != "IDAT" {
+			// Hang on to the chunk header so parseChunk can carry on
+			// from here if we're skipping damaged data.
+			copy(d.pendingChunk[:], d.tmp[:8])
+			d.hasPendingChunk = true
 			return 0, FormatError("not enough pixel data")
 		}
+		d.idatLength = binary.BigEndian.Uint32(d.tmp[:4])
 		d.crc.Reset()
 		d.crc.Write(d.tmp[4:8])
 	}
@@ -375,8 +422,43 @@ func (d *decoder) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// imageSize returns the number of bytes the decoded image will take,
+// based on the IHDR and tRNS chunks.
+func (d *decoder) imageSize() int64 {
+	bytesPerPixel := int64(0)
+	switch d.cb {
+	case cbG1, cbG2, cbG4, cbG8:
+		bytesPerPixel = 1
+		if d.useTransparent {
+			bytesPerPixel = 4
+		}
+	case cbGA8, cbTCA8, cbTC8:
+		bytesPerPixel = 4
+	case cbP1, cbP2, cbP4, cbP8:
+		bytesPerPixel = 1
+	case cbG16:
+		bytesPerPixel = 2
+		if d.useTransparent {
+			bytesPerPixel = 8
+		}
+	case cbGA16, cbTC16, cbTCA16:
+		bytesPerPixel = 8
+	}
+	return int64(d.width) * int64(d.height) * bytesPerPixel
+}
+
 // decode decodes the IDAT data into an image.
 func (d *decoder) decode(ctx context.Context) (image.Image, error) {
+	if cbPaletted(d.cb) && d.paletteCount == 0 {
+		// The PLTE chunk hasn't turned up yet, which only happens when
+		// misordered chunks are allowed. Decode against a black palette
+		// for now and swap in the real one when it arrives.
+		d.palette = make(color.Palette, 256)
+		for i := range d.palette {
+			d.palette[i] = color.RGBA{A: 0xff}
+		}
+		d.palette = d.palette[:0]
+	}
 	r, err := zlib.NewReader(d)
 	if err != nil {
 		return nil, err
@@ -386,7 +468,14 @@ func (d *decoder) decode(ctx context.Context) (image.Image, error) {
 	if d.interlace == itNone {
 		img, err = d.readImagePass(r, 0, false)
 		if err != nil {
-			return nil, err
+			// With SkipDamagedData set we keep the rows that were
+			// decoded before the image data ran out or went bad.
+			if !d.settings.Damage.SkipDamagedData || img == nil {
+				return nil, err
+			}
+			d.damaged = true
+			d.partial = true
+			return img, nil
 		}
 	} else if d.interlace == itAdam7 {
 		// Allocate a blank image of the full size.
@@ -396,12 +485,17 @@ func (d *decoder) decode(ctx context.Context) (image.Image, error) {
 		}
 		for pass := 0; pass < 7; pass++ {
 			imagePass, err := d.readImagePass(r, pass, false)
-			if err != nil {
+			if err != nil && (!d.settings.Damage.SkipDamagedData || imagePass == nil) {
 				return nil, err
 			}
 			if imagePass != nil {
 				d.mergePassInto(img, imagePass, pass)
 			}
+			if err != nil {
+				d.damaged = true
+				d.partial = true
+				return img, nil
+			}
 		}
 	}
 
@@ -414,10 +508,15 @@ func (d *decoder) decode(ctx context.Context) (image.Image, error) {
 		n, err = r.Read(d.tmp[:1])
 	}
 	if err != nil && err != io.EOF {
-		return nil, FormatError(err.Error())
-	}
-	if n != 0 || d.idatLength != 0 {
-		return nil, FormatError("too much pixel data")
+		if !d.settings.Damage.SkipDamagedData {
+			return nil, FormatError(err.Error())
+		}
+		d.damaged = true
+	} else if n != 0 || d.idatLength != 0 {
+		if !d.settings.Damage.AllowTrailingData {
+			return nil, FormatError("too much pixel data")
+		}
+		d.damaged = true
 	}
 
 	return img, nil
@@ -523,10 +622,12 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 		// Read the decompressed bytes.
 		_, err := io.ReadFull(r, cr)
 		if err != nil {
+			// Hand back what we've decoded so far along with the
+			// error, in case the caller is skipping damaged data.
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return nil, FormatError("not enough pixel data")
+				return img, FormatError("not enough pixel data")
 			}
-			return nil, err
+			return img, err
 		}
 
 		// Apply the filter.
@@ -556,7 +657,7 @@ func (d *decoder) readImagePass(r io.Reader, pass int, allocateOnly bool) (image
 		case ftPaeth:
 			filterPaeth(cdat, pdat, bytesPerPixel)
 		default:
-			return nil, FormatError("bad filter type")
+			return img, FormatError("bad filter type")
 		}
 
 		// Convert from bytes to colors.
@@ -862,16 +963,107 @@ func (d *decoder) parseIDAT(ctx context.Context, length uint32) (err error) {
 	if err != nil {
 		return err
 	}
+	if d.damaged {
+		// Skip whatever is left of the current IDAT chunk. If we've
+		// already run into the chunk after it there's nothing left.
+		if d.hasPendingChunk {
+			return nil
+		}
+		return d.discard(int64(d.idatLength) + 4)
+	}
 	return d.verifyChecksum()
 }
 
 func (d *decoder) parseIEND(ctx context.Context, length uint32) error {
 	if length != 0 {
-		return FormatError("bad IEND length")
+		if !d.settings.Damage.AllowTrailingData {
+			return FormatError("bad IEND length")
+		}
+		return d.skipChunk(ctx, length)
 	}
 	return d.verifyChecksum()
 }
 
+// parseLatePLTE finishes off a PLTE chunk read with
+// AllowMisorderedData set. It applies any tRNS chunk that was held
+// back waiting for the palette and hands the palette to the image if
+// it's already been decoded.
+func (d *decoder) parseLatePLTE(ctx context.Context) error {
+	if d.pendingtRNS != nil {
+		sr, scrc := d.r, d.crc
+		d.crc = crc32.NewIEEE()
+		d.r = bytes.NewReader(d.pendingtRNS)
+		err := d.parsetRNS(ctx, uint32(chunklen(d.pendingtRNS)))
+		d.r, d.crc = sr, scrc
+		d.pendingtRNS = nil
+		if err != nil {
+			return err
+		}
+	}
+	d.updatePalette()
+	return nil
+}
+
+// updatePalette gives the decoded image the current palette, for when
+// the PLTE or tRNS chunks came after the image data.
+func (d *decoder) updatePalette() {
+	p, ok := d.img.(*image.Paletted)
+	if !ok {
+		return
+	}
+	// The image's palette may have been extended to cover out of range
+	// pixel values, and the palette always has room for 256 entries.
+	if len(p.Palette) > len(d.palette) {
+		p.Palette = d.palette[:len(p.Palette)]
+	} else {
+		p.Palette = d.palette
+	}
+}
+
+// discard reads and throws away n bytes of chunk data without checking
+// the CRC.
+func (d *decoder) discard(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, d.r, n)
+	return err
+}
+
+// readAncillary checks the CRC of an ancillary chunk before the chunk
+// is parsed. A chunk that's going to be parsed is read in and returned,
+// followed by its CRC; anything else is streamed through the CRC
+// without being kept. It returns nil if the chunk should be dropped,
+// either because the CRC check failed or because it isn't parsed.
+func (d *decoder) readAncillary(ctx context.Context, length uint32, parse bool) ([]byte, error) {
+	if length > 0x7fffffff {
+		return nil, FormatError(fmt.Sprintf("Bad chunk length: %d", length))
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(d.tmp[4:8])
+	if !parse {
+		if _, err := io.CopyN(crc, d.r, int64(length)); err != nil {
+			return nil, err
+		}
+		_, err := io.ReadFull(d.r, d.tmp[:4])
+		return nil, err
+	}
+	if err := d.settings.Limits.CheckMetadataSize(d.metadataSize + int64(length)); err != nil {
+		return nil, err
+	}
+	// The buffer grows as the data comes in, so a chunk that claims to
+	// be much longer than the file only costs what's really there.
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, io.TeeReader(d.r, crc), int64(length)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(d.r, d.tmp[:4]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(d.tmp[:4]) != crc.Sum32() {
+		return nil, nil
+	}
+	b.Write(d.tmp[:4])
+	return b.Bytes(), nil
+}
+
 func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOption, parseMetadata bool) error {
 	// Check and see if our context was cancelled or expired.
 	select {
@@ -879,9 +1071,13 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 		return ctx.Err()
 	default:
 	}
-	// Read the length and chunk type.
-	_, err := io.ReadFull(d.r, d.tmp[:8])
-	if err != nil {
+	// Read the length and chunk type, unless the IDAT reader has already
+	// done that for us.
+	var err error
+	if d.hasPendingChunk {
+		copy(d.tmp[:8], d.pendingChunk[:])
+		d.hasPendingChunk = false
+	} else if _, err = io.ReadFull(d.r, d.tmp[:8]); err != nil {
 		return err
 	}
 	// Check and see if our context was cancelled after we read
@@ -892,6 +1088,42 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 	default:
 	}
 	length := binary.BigEndian.Uint32(d.tmp[:4])
+
+	// The metadata chunks we parse count against the metadata size
+	// limit before they're read in. Compressed ones count their inflated
+	// size as they're inflated instead, but their compressed data still
+	// has to fit. parsed notes the ancillary chunks the switch below
+	// parses rather than skips.
+	parsed := string(d.tmp[4:8]) == "tRNS"
+	if parseMetadata {
+		switch string(d.tmp[4:8]) {
+		case "iCCP", "iTXt", "zTXt":
+			parsed = true
+			if err := d.settings.Limits.CheckMetadataSize(d.metadataSize + int64(length)); err != nil {
+				return err
+			}
+		case "sRGB", "sBIT", "gAMA", "cHRM", "tIME", "tEXt", "bKGD", "pHYs", "hIST", "eXIf":
+			parsed = true
+			if err := d.countMetadata(int(length)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Ancillary chunks, the ones whose type starts with a lower case
+	// letter, aren't needed to display the image. If we're skipping
+	// damaged data then check each one's CRC up front and drop it if
+	// the CRC doesn't match, otherwise parse it from the copy we read.
+	// Chunks we don't parse are skipped once their CRC is checked.
+	if d.tmp[4]&0x20 != 0 && d.settings.Damage.SkipDamagedData {
+		b, err := d.readAncillary(ctx, length, parsed)
+		if err != nil || b == nil {
+			return err
+		}
+		sr := d.r
+		d.r = io.MultiReader(bytes.NewReader(b), sr)
+		defer func() { d.r = sr }()
+	}
 	d.crc.Reset()
 	d.crc.Write(d.tmp[4:8])
 
@@ -923,10 +1155,16 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 		return d.parseIHDR(ctx, length)
 
 	case "PLTE":
-		if d.stage != dsSeenIHDR {
-			return chunkOrderError
+		late := d.stage != dsSeenIHDR
+		if late {
+			// With AllowMisorderedData set we take a PLTE chunk after
+			// the tRNS or IDAT chunks, as long as it's the only one.
+			if !d.settings.Damage.AllowMisorderedData || d.stage < dsSeenIHDR || d.paletteCount != 0 {
+				return chunkOrderError
+			}
+		} else {
+			d.stage = dsSeenPLTE
 		}
-		d.stage = dsSeenPLTE
 		if parseImage == image.DeferData {
 			d.img.(*Deferred).plte, err = readData(ctx, d, length+4, false)
 			if err != nil {
@@ -939,21 +1177,60 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 			scrc := d.crc
 			d.crc = crc32.NewIEEE()
 			d.r = bytes.NewReader(d.img.(*Deferred).plte)
-			err := d.parsePLTE(ctx, length)
+			err = d.parsePLTE(ctx, length)
 			d.crc = scrc
 			d.r = sr
+		} else {
+			err = d.parsePLTE(ctx, length)
+		}
+		if err != nil || (!late && d.pendingtRNS == nil) {
 			return err
 		}
-		return d.parsePLTE(ctx, length)
+		return d.parseLatePLTE(ctx)
 	case "tRNS":
+		late := d.stage != dsSeenIHDR
 		if cbPaletted(d.cb) {
-			if d.stage != dsSeenPLTE {
+			late = d.stage != dsSeenPLTE
+		}
+		if late {
+			// With AllowMisorderedData set we take a tRNS chunk before
+			// the PLTE chunk or after the IDAT chunks.
+			if !d.settings.Damage.AllowMisorderedData || d.stage < dsSeenIHDR || d.seentRNS {
 				return chunkOrderError
 			}
-		} else if d.stage != dsSeenIHDR {
-			return chunkOrderError
+		} else {
+			d.stage = dsSeentRNS
+		}
+		d.seentRNS = true
+		if late && cbPaletted(d.cb) && d.paletteCount == 0 {
+			// There's no palette to apply this to yet, so hang on to
+			// it until the PLTE chunk turns up.
+			b, err := readData(ctx, d, length, false)
+			if err != nil {
+				return err
+			}
+			if err := d.verifyChecksum(); err != nil {
+				return err
+			}
+			d.pendingtRNS = append(b, 0, 0, 0, 0)
+			fixChecksum(d.pendingtRNS)
+			if parseImage == image.DeferData {
+				d.img.(*Deferred).trns = d.pendingtRNS
+			}
+			return nil
+		}
+		if late && !cbPaletted(d.cb) && d.stage >= dsSeenIDAT && parseImage == image.DecodeData {
+			// The image has already been decoded without the
+			// transparent color, and there's no going back.
+			return d.skipChunk(ctx, length)
+		}
+		if late && parseImage == image.DecodeData {
+			if err := d.parsetRNS(ctx, length); err != nil {
+				return err
+			}
+			d.updatePalette()
+			return nil
 		}
-		d.stage = dsSeentRNS
 		if parseImage == image.DeferData {
 			d.img.(*Deferred).trns, err = readData(ctx, d, length+4, false)
 			if err != nil {
@@ -973,7 +1250,9 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 		}
 		return d.parsetRNS(ctx, length)
 	case "IDAT":
-		if d.stage < dsSeenIHDR || d.stage > dsSeenIDAT || (d.stage == dsSeenIHDR && cbPaletted(d.cb)) {
+		if d.stage < dsSeenIHDR || d.stage > dsSeenIDAT {
+			return chunkOrderError
+		} else if d.stage == dsSeenIHDR && cbPaletted(d.cb) && !d.settings.Damage.AllowMisorderedData {
 			return chunkOrderError
 		} else if d.stage == dsSeenIDAT {
 			// Ignore trailing zero-length or garbage IDAT chunks.
@@ -981,6 +1260,9 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 			// This does not affect valid PNG images that contain multiple IDAT
 			// chunks, since the first call to parseIDAT below will consume all
 			// consecutive IDAT chunks required for decoding the image.
+			if d.damaged {
+				return d.discard(int64(length) + 4)
+			}
 			break
 		}
 		d.stage = dsSeenIDAT
@@ -1072,6 +1354,11 @@ func (d *decoder) parseChunk(ctx context.Context, parseImage image.DecodingOptio
 			return d.skipChunk(ctx, length)
 		}
 		return d.parseHIST(ctx, length)
+	case "eXIf":
+		if !parseMetadata {
+			return d.skipChunk(ctx, length)
+		}
+		return d.parseEXIF(ctx, length)
 
 	}
 	if length > 0x7fffffff {
@@ -1116,17 +1403,11 @@ func (d *decoder) checkHeader(ctx context.Context) error {
 }
 
 func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption) (image.Image, image.Metadata, error) {
-	if len(opts) > 1 {
-		return nil, nil, errors.New("Too many read options provided")
-	}
-	opt := image.DataDecodeOptions{}
-	if len(opts) == 1 {
-		o, ok := opts[0].(image.DataDecodeOptions)
-		if !ok {
-			return nil, nil, errors.New("Unknown read option type provided")
-		}
-		opt = o
+	s, err := image.ResolveReadOptions(opts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	opt := s.Data
 
 	// If they ask for nothing then return nothing. This is currently
 	// not an error.
@@ -1134,17 +1415,11 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		return nil, nil, nil
 	}
 
-	if opt.DecodeImage == image.DefaultDecodeOption {
-		opt.DecodeImage = image.DecodeData
-	}
-	if opt.DecodeMetadata == image.DefaultDecodeOption {
-		opt.DecodeMetadata = image.DeferData
-	}
-
 	d := &decoder{
 		r:        r,
 		crc:      crc32.NewIEEE(),
 		metadata: &Metadata{},
+		settings: *s,
 	}
 
 	// If we're deferring image reading then pre-fill the img field.
@@ -1169,9 +1444,18 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
+			// A file that's been cut off after the image data started
+			// can still give us an image if we're skipping damaged data.
+			if err == io.ErrUnexpectedEOF && s.Damage.SkipDamagedData && d.stage >= dsSeenIDAT {
+				d.partial = true
+				break
+			}
 			return nil, nil, err
 		}
 	}
+	if cbPaletted(d.cb) && d.paletteCount == 0 {
+		return nil, nil, FormatError("missing PLTE chunk")
+	}
 
 	switch d.cb {
 	case cbG1, cbG2, cbG4, cbG8:
@@ -1194,6 +1478,25 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		d.metadata.ColorModel = color.NRGBA64Model
 	}
 
+	if s.Transform.GammaTransform != image.NoImageTransform {
+		d.img, err = d.correctGamma(d.img)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if s.Transform.ColorTransform != image.NoImageTransform {
+		d.img, err = d.convertColor(ctx, d.img, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if s.Transform.RotationTransform != image.NoImageTransform {
+		d.img, err = d.orient(ctx, d.img, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// We read in all the metadata without decoding the expensive
 	// stuff. If the user wanted it decoded now then go decode it.
 	if opt.DecodeMetadata == image.DecodeData {
@@ -1201,17 +1504,19 @@ func DecodeExtended(ctx context.Context, r io.Reader, opts ...image.ReadOption)
 		if err != nil {
 			return nil, nil, err
 		}
-		// Right now we don't decode XMP by default because we can't
-		// _, err = d.metadata.XMP(ctx, opts...)
-		// if err != nil {
-		// 	return nil, nil, err
-		// }
+		_, err = d.metadata.XMP(ctx, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
 		_, err = d.metadata.ICC(ctx, opts...)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
+	if d.partial {
+		return d.img, d.metadata, ErrPartialImage
+	}
 	return d.img, d.metadata, nil
 
 }
@@ -1241,4 +1546,5 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 
 func init() {
 	image.RegisterFormatExtended("png", pngHeader, DecodeExtended)
+	image.RegisterEncoder("png", []string{"image/png"}, []string{".png"}, EncodeExtended)
 }
@@ -7,17 +7,26 @@ package png
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	_ "github.com/rmamba/image/metadata/exif"
+	_ "github.com/rmamba/image/metadata/icc"
+	_ "github.com/rmamba/image/metadata/xmp"
 )
 
 var filenames = []string{
@@ -783,6 +792,544 @@ func TestDimensionOverflow(t *testing.T) {
 	}
 }
 
+func TestImageSizeLimit(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/gray-gradient.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b), image.LimitOptions{MaxImageSize: 8})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+	// The size is checked as soon as the IHDR chunk has been read.
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b[:33]), image.LimitOptions{MaxImageSize: 8})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("IHDR only: got error %v, want a limit error", err)
+	}
+}
+
+func TestMetadataSizeLimit(t *testing.T) {
+	m := &Metadata{
+		Text: []*TextEntry{
+			{Key: "Comment", Value: strings.Repeat("x", 4096), EntryType: EtZtext},
+		},
+	}
+	var b bytes.Buffer
+	if err := EncodeExtended(context.TODO(), &b, image.NewGray(image.Rect(0, 0, 4, 4)), m); err != nil {
+		t.Fatal(err)
+	}
+	// The compressed text is tiny, but the inflated text is not.
+	_, _, err := DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), image.LimitOptions{MaxMetadataSize: 1024})
+	if !errors.Is(err, image.ErrLimit) {
+		t.Fatalf("got error %v, want a limit error", err)
+	}
+	// Only the inflated text counts, not the compressed text as well.
+	_, _, err = DecodeExtended(context.TODO(), bytes.NewReader(b.Bytes()), image.LimitOptions{MaxMetadataSize: 4096})
+	if err != nil {
+		t.Fatalf("decode with room for the metadata failed: %v", err)
+	}
+}
+
+// insertChunk returns a copy of the PNG file in data with a chunk
+// holding body added after the IHDR chunk.
+func insertChunk(data []byte, name string, body []byte) []byte {
+	return append(append(append([]byte{}, data[:33]...), makeChunk(name, body)...), data[33:]...)
+}
+
+// makeChunk returns a chunk with the given name and body, along with
+// its length and CRC.
+func makeChunk(name string, body []byte) []byte {
+	chunk := make([]byte, len(body)+12)
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)))
+	copy(chunk[4:], name)
+	copy(chunk[8:], body)
+	binary.BigEndian.PutUint32(chunk[8+len(body):], crc32.ChecksumIEEE(chunk[4:8+len(body)]))
+	return chunk
+}
+
+// exifOrientation returns big-endian exif data holding just an
+// Orientation tag with the value o.
+func exifOrientation(o uint16) []byte {
+	return []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // TIFF header
+		0, 1, // one IFD0 entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(o >> 8), byte(o), 0, 0, // Orientation
+		0, 0, 0, 0, // no IFD1
+	}
+}
+
+func TestRotationTransform(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	data := insertChunk(b.Bytes(), "eXIf", exifOrientation(6))
+
+	img, m, err := DecodeExtended(context.TODO(), bytes.NewReader(data), image.ImageTransformOptions{RotationTransform: image.ForwardImageTransform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 2, 3); got != want {
+		t.Fatalf("got bounds %v, want %v", got, want)
+	}
+	if c := m.GetConfig(); c.Width != 2 || c.Height != 3 {
+		t.Fatalf("got config size %dx%d, want 2x3", c.Width, c.Height)
+	}
+	// Rotating clockwise moves the bottom left pixel to the top left.
+	if got, want := img.At(0, 0), src.At(0, 1); got != want {
+		t.Fatalf("got top left pixel %v, want %v", got, want)
+	}
+	if got, want := img.At(1, 2), src.At(2, 0); got != want {
+		t.Fatalf("got bottom right pixel %v, want %v", got, want)
+	}
+
+	img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(data), image.ImageTransformOptions{RotationTransform: image.ReverseImageTransform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotating counter-clockwise moves the top right pixel to the top left.
+	if got, want := img.At(0, 0), src.At(2, 0); got != want {
+		t.Fatalf("reverse: got top left pixel %v, want %v", got, want)
+	}
+
+	img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, src) {
+		t.Fatalf("decode without transform changed the image")
+	}
+
+	// Exif data that can't be decoded fails the decode, unless damaged
+	// data is being skipped, when the image isn't rotated.
+	bad := exifOrientation(6)
+	bad[0], bad[1] = 'N', 'N'
+	data = insertChunk(b.Bytes(), "eXIf", bad)
+	opt := image.ImageTransformOptions{RotationTransform: image.ForwardImageTransform}
+	if _, _, err := DecodeExtended(context.TODO(), bytes.NewReader(data), opt); err == nil {
+		t.Error("bad exif: got nil error")
+	}
+	img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(data), opt, image.DamageHandlingOptions{SkipDamagedData: true})
+	if err != nil {
+		t.Fatalf("bad exif, skipping damaged data: %v", err)
+	}
+	if !reflect.DeepEqual(img, src) {
+		t.Fatalf("bad exif, skipping damaged data: image changed")
+	}
+}
+
+// testProfile returns an ICC profile for the color space cs, which is
+// either "RGB " or "GRAY". Its curves have a gamma of 1.0, and the RGB
+// profile has sRGB primaries, so converting to sRGB only changes the
+// transfer function.
+func testProfile(cs string) []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], uint32(int32(v*65536)))
+		}
+		return b
+	}
+	// A curv with one entry holds a u8Fixed8 gamma, padded to 16 bytes.
+	curv := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00")
+	tags := []struct {
+		sig  string
+		data []byte
+	}{{"kTRC", curv}}
+	if cs == "RGB " {
+		tags = []struct {
+			sig  string
+			data []byte
+		}{
+			{"rXYZ", xyz(0.4360747, 0.2225045, 0.0139322)},
+			{"gXYZ", xyz(0.3850649, 0.7168786, 0.0971045)},
+			{"bXYZ", xyz(0.1430804, 0.0606169, 0.7141733)},
+			{"rTRC", curv}, {"gTRC", curv}, {"bTRC", curv},
+		}
+	}
+
+	b := make([]byte, 132+12*len(tags))
+	copy(b[16:], cs)
+	copy(b[20:], "XYZ ")
+	copy(b[36:], "acsp")
+	binary.BigEndian.PutUint32(b[128:], uint32(len(tags)))
+	for i, t := range tags {
+		e := b[132+i*12:]
+		copy(e, t.sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(b)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(t.data)))
+		b = append(b, t.data...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// iccpChunk returns the body of an iCCP chunk holding profile.
+func iccpChunk(profile []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("test\x00\x00")
+	w := zlib.NewWriter(&b)
+	w.Write(profile)
+	w.Close()
+	return b.Bytes()
+}
+
+// hasICC reports whether the metadata m still holds a color profile.
+func hasICC(m image.Metadata) bool {
+	x, err := m.(*Metadata).ICC(context.TODO())
+	return x != nil || err != nil
+}
+
+func TestColorTransform(t *testing.T) {
+	tests := []struct {
+		cs  string
+		src image.Image
+	}{
+		{"RGB ", image.NewNRGBA(image.Rect(0, 0, 2, 2))},
+		{"RGB ", image.NewRGBA64(image.Rect(0, 0, 2, 2))},
+		{"GRAY", image.NewGray(image.Rect(0, 0, 2, 2))},
+	}
+	for _, tc := range tests {
+		src := tc.src.(interface {
+			image.Image
+			Set(x, y int, c color.Color)
+		})
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				src.Set(x, y, color.Gray{Y: 0x80})
+			}
+		}
+		var b bytes.Buffer
+		if err := Encode(&b, src); err != nil {
+			t.Fatal(err)
+		}
+		data := insertChunk(b.Bytes(), "iCCP", iccpChunk(testProfile(tc.cs)))
+
+		// Linear 0x80 is 0xbc in sRGB.
+		opt := image.ImageTransformOptions{ColorTransform: image.ForwardImageTransform}
+		img, m, err := DecodeExtended(context.TODO(), bytes.NewReader(data), opt)
+		if err != nil {
+			t.Fatalf("%T: %v", src, err)
+		}
+		if r, _, _, _ := img.At(1, 1).RGBA(); r>>8 != 0xbc {
+			t.Errorf("%T: got red %#x, want 0xbc", src, r>>8)
+		}
+		if hasICC(m) {
+			t.Errorf("%T: profile kept after conversion to sRGB", src)
+		}
+
+		// sRGB 0x80 is 0x37 in linear light.
+		opt.ColorTransform = image.ReverseImageTransform
+		img, _, err = DecodeExtended(context.TODO(), bytes.NewReader(data), opt)
+		if err != nil {
+			t.Fatalf("%T: %v", src, err)
+		}
+		if r, _, _, _ := img.At(1, 1).RGBA(); r>>8 != 0x37 {
+			t.Errorf("%T: reverse: got red %#x, want 0x37", src, r>>8)
+		}
+
+		// Without the transform the pixels are left alone.
+		img, m, err = DecodeExtended(context.TODO(), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%T: %v", src, err)
+		}
+		if r, _, _, _ := img.At(1, 1).RGBA(); r>>8 != 0x80 {
+			t.Errorf("%T: untransformed: got red %#x, want 0x80", src, r>>8)
+		}
+		if !hasICC(m) {
+			t.Errorf("%T: untransformed: profile dropped", src)
+		}
+	}
+}
+
+func TestColorTransformGrayPixels(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 0x80
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	opt := image.ImageTransformOptions{ColorTransform: image.ForwardImageTransform}
+
+	// Gray images with a tRNS chunk decode to NRGBA images, and their
+	// gray samples are still converted.
+	data := insertChunk(b.Bytes(), "tRNS", []byte{0, 0})
+	data = insertChunk(data, "iCCP", iccpChunk(testProfile("GRAY")))
+	img, m, err := DecodeExtended(context.TODO(), bytes.NewReader(data), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(1, 1).RGBA(); r>>8 != 0xbc || g>>8 != 0xbc || b>>8 != 0xbc {
+		t.Errorf("gray with tRNS: got %#x %#x %#x, want 0xbc", r>>8, g>>8, b>>8)
+	}
+	if hasICC(m) {
+		t.Error("gray with tRNS: profile kept after conversion to sRGB")
+	}
+
+	// An RGB profile doesn't apply to gray pixels, so they're left
+	// alone and the profile is kept.
+	data = insertChunk(b.Bytes(), "iCCP", iccpChunk(testProfile("RGB ")))
+	img, m, err = DecodeExtended(context.TODO(), bytes.NewReader(data), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(1, 1).RGBA(); r>>8 != 0x80 {
+		t.Errorf("RGB profile on gray: got %#x, want 0x80", r>>8)
+	}
+	if !hasICC(m) {
+		t.Error("RGB profile on gray: profile dropped without a conversion")
+	}
+}
+
+func TestGammaTransform(t *testing.T) {
+	src := image.NewGray16(image.Rect(0, 0, 2, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 0x80
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	// A gamma of 1.0 means the samples are linear.
+	linear := insertChunk(b.Bytes(), "gAMA", []byte{0, 0x01, 0x86, 0xa0})
+	v := float64(0x8080) / 0xffff
+
+	tests := []struct {
+		data []byte
+		opt  image.TransformOption
+		want float64
+		// sRGB is true if the gamma should be dropped from the metadata.
+		sRGB bool
+	}{
+		{linear, image.ForwardImageTransform, 1.055*math.Pow(v, 1/2.4) - 0.055, true},
+		{linear, image.ReverseImageTransform, math.Pow((v+0.055)/1.055, 2.4), false},
+		{linear, image.NoImageTransform, v, false},
+		// The sRGB chunk takes precedence over the gAMA chunk.
+		{insertChunk(linear, "sRGB", []byte{0}), image.ForwardImageTransform, v, false},
+	}
+	for i, tc := range tests {
+		img, m, err := DecodeExtended(context.TODO(), bytes.NewReader(tc.data), image.ImageTransformOptions{GammaTransform: tc.opt})
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		// 16 bit images should be converted with 16 bit precision,
+		// give or take the rounding of the profiles' curve parameters
+		// to s15Fixed16 numbers.
+		got := img.(*image.Gray16).Gray16At(1, 1).Y
+		if want := uint16(tc.want*0xffff + 0.5); got < want-1 || got > want+1 {
+			t.Errorf("test %d: got %#x, want %#x", i, got, want)
+		}
+		if g := m.(*Metadata).Gamma; (g == nil) != tc.sRGB {
+			t.Errorf("test %d: got gamma %v", i, g)
+		}
+	}
+}
+
+func TestGammaTransformChroma(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.Pix = []uint8{0xc0, 0x80, 0x40, 0xff}
+	var b bytes.Buffer
+	if err := Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	// Chromaticities with the sRGB primaries and white point, but a
+	// gamma of 1/2.2, which is close to the sRGB curve.
+	chrm := []byte{}
+	for _, v := range []uint32{31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000} {
+		chrm = append(chrm, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	data := insertChunk(b.Bytes(), "cHRM", chrm)
+	data = insertChunk(data, "gAMA", []byte{0, 0, 0xb1, 0x8f})
+
+	img, _, err := DecodeExtended(context.TODO(), bytes.NewReader(data), image.ImageTransformOptions{GammaTransform: image.ForwardImageTransform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+	for i, c := range []uint8{got.R, got.G, got.B} {
+		if d := int(c) - int(src.Pix[i]); d < -3 || d > 3 {
+			t.Fatalf("got %v, want close to %v", got, src.Pix[:3])
+		}
+	}
+}
+
+func TestSkipDamagedData(t *testing.T) {
+	ctx := context.TODO()
+	skip := image.DamageHandlingOptions{SkipDamagedData: true}
+
+	// A tEXt chunk with a bad CRC is dropped.
+	src, err := ioutil.ReadFile("testdata/pngsuite/basn0g08.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := insertChunk(src, "tEXt", []byte("Title\x00Damaged"))
+	b[33+12+len("Title\x00Damaged")-1] ^= 0xff
+	if _, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage); err == nil {
+		t.Error("bad tEXt CRC: got nil error without SkipDamagedData")
+	}
+	_, m, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage, skip)
+	if err != nil {
+		t.Fatalf("bad tEXt CRC: %v", err)
+	}
+	if text := m.(*Metadata).Text; len(text) != 0 {
+		t.Errorf("bad tEXt CRC: got %d text entries, want 0", len(text))
+	}
+
+	// Truncated image data gives back the rows decoded so far.
+	for _, filename := range []string{"testdata/gray-gradient.png", "testdata/gray-gradient.interlaced.png"} {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _, err := DecodeExtended(ctx, bytes.NewReader(src), image.OptionDecodeImage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := src[:len(src)*2/3]
+		if _, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage); err == nil {
+			t.Errorf("%s: got nil error without SkipDamagedData", filename)
+		}
+		got, _, err := DecodeExtended(ctx, bytes.NewReader(b), image.OptionDecodeImage, skip)
+		if err != ErrPartialImage {
+			t.Errorf("%s: got error %v, want ErrPartialImage", filename, err)
+			continue
+		}
+		if got.Bounds() != want.Bounds() {
+			t.Errorf("%s: got bounds %v, want %v", filename, got.Bounds(), want.Bounds())
+		}
+		if got.At(0, 0) != want.At(0, 0) {
+			t.Errorf("%s: got first pixel %v, want %v", filename, got.At(0, 0), want.At(0, 0))
+		}
+	}
+
+	// A file cut off after the image data still gives the whole image,
+	// but it's flagged as partial since the rest of the file is missing.
+	src, err = ioutil.ReadFile("testdata/gray-gradient.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodeExtended(ctx, bytes.NewReader(src[:len(src)-6]), image.OptionDecodeImage, skip); err != ErrPartialImage {
+		t.Errorf("missing IEND: got error %v, want ErrPartialImage", err)
+	}
+
+	// The 1x2 image from TestIncompleteIDATOnRowBoundary only has one
+	// row of pixel data.
+	const (
+		ihdr = "\x00\x00\x00\x0dIHDR\x00\x00\x00\x01\x00\x00\x00\x02\x08\x00\x00\x00\x00\xbc\xea\xe9\xfb"
+		idat = "\x00\x00\x00\x0eIDAT\x78\x9c\x62\x62\x00\x04\x00\x00\xff\xff\x00\x06\x00\x03\xfa\xd0\x59\xae"
+		iend = "\x00\x00\x00\x00IEND\xae\x42\x60\x82"
+	)
+	img, _, err := DecodeExtended(ctx, strings.NewReader(pngHeader+ihdr+idat+iend), image.OptionDecodeImage, skip)
+	if err != ErrPartialImage {
+		t.Fatalf("incomplete IDAT: got error %v, want ErrPartialImage", err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 1, 2); got != want {
+		t.Errorf("incomplete IDAT: got bounds %v, want %v", got, want)
+	}
+}
+
+// TestSkipDamagedDataChunkLength checks that an ancillary chunk claiming
+// to be far longer than the file isn't allocated up front.
+func TestSkipDamagedDataChunkLength(t *testing.T) {
+	const ihdr = "\x00\x00\x00\x0dIHDR\x00\x00\x00\x01\x00\x00\x00\x02\x08\x00\x00\x00\x00\xbc\xea\xe9\xfb"
+	for _, name := range []string{"tEXt", "zzZz"} {
+		b := pngHeader + ihdr + "\x7f\xff\xff\xf0" + name + "abc"
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := DecodeExtended(context.TODO(), strings.NewReader(b), image.OptionDecodeImage, image.DamageHandlingOptions{SkipDamagedData: true})
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("%s: got nil error", name)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: allocated %d bytes for a %d byte file", name, n, len(b))
+		}
+	}
+}
+
+func TestAllowTrailingData(t *testing.T) {
+	ctx := context.TODO()
+	allow := image.DamageHandlingOptions{AllowTrailingData: true}
+
+	// A 1x1 gray image with a second row of pixel data.
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte{0x00, 0x80, 0x00, 0xff})
+	zw.Close()
+	const ihdr = "\x00\x00\x00\x0dIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x00\x00\x00\x00\x3a\x7e\x9b\x55"
+	idat := string(makeChunk("IDAT", z.Bytes()))
+
+	testCases := []struct {
+		desc string
+		data string
+	}{
+		{"too much pixel data", pngHeader + ihdr + idat + string(makeChunk("IEND", nil))},
+		{"non-empty IEND", pngHeader + ihdr + string(makeChunk("IDAT", z.Bytes()[:z.Len()-4])) + string(makeChunk("IEND", []byte("junk")))},
+		{"data after IEND", pngHeader + ihdr + idat + string(makeChunk("IEND", nil)) + "junk"},
+	}
+	for _, tc := range testCases {
+		m, _, err := DecodeExtended(ctx, strings.NewReader(tc.data), image.OptionDecodeImage, allow)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if got, want := m.At(0, 0), (color.Gray{Y: 0x80}); got != want {
+			t.Errorf("%s: got %v, want %v", tc.desc, got, want)
+		}
+	}
+	if _, _, err := DecodeExtended(ctx, strings.NewReader(testCases[0].data), image.OptionDecodeImage); err == nil {
+		t.Error("too much pixel data: got nil error without AllowTrailingData")
+	}
+}
+
+func TestAllowMisorderedData(t *testing.T) {
+	ctx := context.TODO()
+	allow := image.DamageHandlingOptions{AllowMisorderedData: true}
+
+	// The chunks from TestMultipletRNSChunks.
+	const (
+		ihdr = "\x00\x00\x00\x0dIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x03\x00\x00\x00\x28\xcb\x34\xbb"
+		plte = "\x00\x00\x00\x03PLTE\xff\x00\x00\x19\xe2\x09\x37"
+		trns = "\x00\x00\x00\x01tRNS\x7f\x80\x5c\xb4\xcb"
+		idat = "\x00\x00\x00\x0eIDAT\x78\x9c\x62\x62\x00\x04\x00\x00\xff\xff\x00\x06\x00\x03\xfa\xd0\x59\xae"
+		iend = "\x00\x00\x00\x00IEND\xae\x42\x60\x82"
+	)
+	want := color.NRGBA{R: 0xff, A: 0x7f}
+	for _, chunks := range []string{
+		trns + plte + idat,
+		idat + plte + trns,
+		idat + trns + plte,
+		plte + idat + trns,
+	} {
+		b := pngHeader + ihdr + chunks + iend
+		if _, _, err := DecodeExtended(ctx, strings.NewReader(b), image.OptionDecodeImage); err == nil {
+			t.Errorf("%q: got nil error without AllowMisorderedData", chunks)
+		}
+		for _, decode := range []image.DataDecodeOptions{image.OptionDecodeImage, image.DataDecodeOptions{DecodeImage: image.DeferData}} {
+			m, _, err := DecodeExtended(ctx, strings.NewReader(b), decode, allow)
+			if err != nil {
+				t.Errorf("%q: %v", chunks, err)
+				continue
+			}
+			if got := m.At(0, 0); got != want {
+				t.Errorf("%q: got %v, want %v", chunks, got, want)
+			}
+		}
+	}
+
+	// A paletted image still needs a palette.
+	if _, _, err := DecodeExtended(ctx, strings.NewReader(pngHeader+ihdr+idat+iend), image.OptionDecodeImage, allow); err == nil {
+		t.Error("missing PLTE: got nil error")
+	}
+}
+
 func benchmarkDecode(b *testing.B, filename string, bytesPerPixel int) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -0,0 +1,161 @@
+package png
+
+import (
+	"context"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/cms"
+	"github.com/rmamba/image/internal/imageutil"
+	"github.com/rmamba/image/metadata"
+)
+
+// applyTransform applies t to the decoded image. Deferred images have
+// the transform applied when they're instantiated.
+func (d *decoder) applyTransform(img image.Image, t func(image.Image) image.Image) image.Image {
+	switch i := img.(type) {
+	case nil:
+		return nil
+	case *Deferred:
+		i.transforms = append(i.transforms, t)
+		return i
+	}
+	img = t(img)
+	d.metadata.ColorModel = img.ColorModel()
+	return img
+}
+
+// orient applies the exif orientation to the decoded image and to the
+// dimensions in the metadata, as requested by the RotationTransform
+// read option. Images without exif data, or without an orientation,
+// are returned unchanged, as are images whose exif data can't be
+// decoded if damaged data is being skipped.
+func (d *decoder) orient(ctx context.Context, img image.Image, opts ...image.ReadOption) (image.Image, error) {
+	x, err := d.metadata.EXIF(ctx, opts...)
+	if err != nil {
+		if d.settings.Damage.SkipDamagedData && ctx.Err() == nil {
+			return img, nil
+		}
+		return nil, err
+	}
+	if x == nil {
+		return img, nil
+	}
+
+	o := x.Orientation
+	reverse := d.settings.Transform.RotationTransform == image.ReverseImageTransform
+	if !reverse && o >= 2 && o <= 8 {
+		// The returned image is upright, so the exif data should say so.
+		x.Orientation = 1
+	}
+	d.metadata.Width, d.metadata.Height = imageutil.OrientedSize(d.metadata.Width, d.metadata.Height, o)
+	return d.applyTransform(img, func(m image.Image) image.Image {
+		return imageutil.Orient(m, o, reverse)
+	}), nil
+}
+
+// convertColor converts the decoded image from the color space of the
+// embedded ICC profile to sRGB, or from sRGB to the profile's color
+// space, as requested by the ColorTransform read option. Images
+// without a profile, with a profile the cms package can't convert
+// with, or with pixels the profile doesn't apply to, such as gray
+// pixels with an RGB profile, are returned unchanged, as are images
+// with a damaged profile if damaged data is being skipped.
+//
+// Once an image has been converted to sRGB the profile no longer
+// describes it, so the profile is removed from the metadata.
+func (d *decoder) convertColor(ctx context.Context, img image.Image, opts ...image.ReadOption) (image.Image, error) {
+	if d.metadata.rawIcc == nil {
+		return img, nil
+	}
+	x, err := d.metadata.ICC(ctx, opts...)
+	if err != nil {
+		if d.settings.Damage.SkipDamagedData && ctx.Err() == nil {
+			return img, nil
+		}
+		return nil, err
+	}
+
+	srgb, err := cms.NewProfile(0, nil, x.ColorSpace == metadata.ICCSpaceGray)
+	if err != nil {
+		return nil, err
+	}
+	forward := d.settings.Transform.ColorTransform == image.ForwardImageTransform
+	src, dst := srgb, x
+	if forward {
+		src, dst = x, srgb
+	}
+	t, err := cms.NewTransform(src, dst, cms.Perceptual)
+	if err != nil || !t.Converts(d.metadata.ColorModel) {
+		return img, nil
+	}
+	if forward {
+		d.metadata.SetICC(nil)
+		d.metadata.iccName = ""
+	}
+	return d.applyTransform(img, t.Convert), nil
+}
+
+// correctGamma converts the decoded image from the gamma and primaries
+// in the gAMA and cHRM chunks to sRGB, or from sRGB to that gamma and
+// those primaries, as requested by the GammaTransform read option.
+// Images with an sRGB or iCCP chunk are returned unchanged, as those
+// take precedence over gAMA and cHRM.
+//
+// Once an image has been converted to sRGB the gamma and chroma
+// information no longer describe it, so they're removed from the
+// metadata.
+func (d *decoder) correctGamma(img image.Image) (image.Image, error) {
+	m := d.metadata
+	if d.seenColorProfile || (m.Gamma == nil && m.Chroma == nil) {
+		return img, nil
+	}
+
+	var gamma float64
+	if m.Gamma != nil {
+		if *m.Gamma == 0 {
+			return nil, FormatError("invalid gamma")
+		}
+		gamma = float64(*m.Gamma) / 100000
+	}
+	var chroma *cms.Chromaticities
+	if c := m.Chroma; c != nil {
+		xy := func(x, y uint32) [2]float64 {
+			return [2]float64{float64(x) / 100000, float64(y) / 100000}
+		}
+		chroma = &cms.Chromaticities{
+			White: xy(c.WhiteX, c.WhiteY),
+			Red:   xy(c.RedX, c.RedY),
+			Green: xy(c.GreenX, c.GreenY),
+			Blue:  xy(c.BlueX, c.BlueY),
+		}
+	}
+	gray := false
+	switch d.cb {
+	case cbG1, cbG2, cbG4, cbG8, cbG16:
+		gray = true
+	case cbGA8, cbGA16:
+		// Gray images with alpha decode to RGB images, but the
+		// primaries don't apply to gray samples.
+		chroma = nil
+	}
+	p, err := cms.NewProfile(gamma, chroma, gray)
+	if err != nil {
+		return nil, FormatError(err.Error())
+	}
+	srgb, err := cms.NewProfile(0, nil, gray)
+	if err != nil {
+		return nil, err
+	}
+
+	src, dst := srgb, p
+	if d.settings.Transform.GammaTransform == image.ForwardImageTransform {
+		src, dst = p, srgb
+		m.Gamma = nil
+		m.Chroma = nil
+	}
+	t, err := cms.NewTransform(src, dst, cms.Perceptual)
+	if err != nil {
+		return nil, FormatError(err.Error())
+	}
+	return d.applyTransform(img, t.Convert), nil
+}
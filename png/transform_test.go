@@ -0,0 +1,47 @@
+package png
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rmamba/image"
+	"github.com/rmamba/image/metadata"
+)
+
+func TestOrient(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	orient := func(rt image.TransformOption) (*decoder, image.Image) {
+		d := &decoder{metadata: &Metadata{Width: 3, Height: 2}}
+		d.metadata.SetEXIF(&metadata.EXIF{Orientation: 6})
+		d.settings.Transform.RotationTransform = rt
+		img, err := d.orient(context.TODO(), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, img
+	}
+
+	d, img := orient(image.ForwardImageTransform)
+	if got, want := img.Bounds(), image.Rect(0, 0, 2, 3); got != want {
+		t.Fatalf("got bounds %v, want %v", got, want)
+	}
+	if c := d.metadata.GetConfig(); c.Width != 2 || c.Height != 3 {
+		t.Fatalf("got config size %dx%d, want 2x3", c.Width, c.Height)
+	}
+	// Rotating clockwise moves the bottom left pixel to the top left.
+	if got, want := img.At(0, 0), src.At(0, 1); got != want {
+		t.Fatalf("got top left pixel %v, want %v", got, want)
+	}
+	if got, want := img.At(1, 2), src.At(2, 0); got != want {
+		t.Fatalf("got bottom right pixel %v, want %v", got, want)
+	}
+
+	_, img = orient(image.ReverseImageTransform)
+	// Rotating counter-clockwise moves the top right pixel to the top left.
+	if got, want := img.At(0, 0), src.At(2, 0); got != want {
+		t.Fatalf("reverse: got top left pixel %v, want %v", got, want)
+	}
+}
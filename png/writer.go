@@ -17,6 +17,7 @@ import (
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
 )
 
 // Encoder configures encoding PNG images.
@@ -563,8 +564,10 @@ func (e *encoder) maybeWriteXMP(ctx context.Context, m *Metadata, opts ...image.
 		}
 	}
 
+	// The XMP is left uncompressed so its padding can be used to edit
+	// it in place.
 	v := &TextEntry{Key: xmpTextKey, Value: xmpText, EntryType: EtItext}
-	e.maybeWriteITXT(v)
+	e.writeITXT(v, false)
 	return
 }
 
@@ -654,14 +657,24 @@ func (e *encoder) maybeWriteZTXT(t *TextEntry) {
 
 // maybeWriteITXT will write out an iTXt entry.
 func (e *encoder) maybeWriteITXT(t *TextEntry) {
+	e.writeITXT(t, true)
+}
+
+// writeITXT writes out an iTXt entry, with its text compressed if
+// compress is set.
+func (e *encoder) writeITXT(t *TextEntry, compress bool) {
 	if e.err != nil {
 		return
 	}
 
-	val, method, err := e.pngCompress([]byte(t.Value))
-	if err != nil {
-		e.err = err
-		return
+	val, method := []byte(t.Value), 0
+	if compress {
+		var err error
+		val, method, err = e.pngCompress(val)
+		if err != nil {
+			e.err = err
+			return
+		}
 	}
 
 	buf := make([]byte, len(t.Key)+len(val)+len(t.LanguageTag)+len(t.TranslatedKey)+5)
@@ -670,13 +683,14 @@ func (e *encoder) maybeWriteITXT(t *TextEntry) {
 	copy(buf[:len(t.Key)], []byte(t.Key))
 	// Key null terminator
 	buf[len(t.Key)] = 0
-	// Compression flag. Which we always set, though it's optional and
-	// we could stuff uncompressed text in here.
+	// Compression flag.
 	//
 	// TODO: Check and see if the compressed buffer is smaller than the
 	// original text and choose compressed or uncompressed based on
 	// that.
-	buf[len(t.Key)+1] = 1
+	if compress {
+		buf[len(t.Key)+1] = 1
+	}
 	// Compression method (which is always 0, but whatever)
 	buf[len(t.Key)+2] = byte(method)
 	length := len(t.Key) + 3
@@ -801,24 +815,40 @@ func (e *encoder) maybeWriteICCP(ctx context.Context, m *Metadata, opts ...image
 			e.err = err
 			return
 		}
+	}
 
-		chunk, compression, err := e.pngCompress(chunk)
-		if err != nil {
-			e.err = err
-			return
-		}
-
-		var icc []byte
-		icc = []byte(m.iccName)
-		icc = append(icc, 0)
-		icc = append(icc, byte(compression))
-		icc = append(icc, chunk...)
-		e.writeChunk(icc, "iCCP")
+	chunk, compression, err := e.pngCompress(chunk)
+	if err != nil {
+		e.err = err
+		return
 	}
 
+	var icc []byte
+	icc = []byte(m.iccName)
+	icc = append(icc, 0)
+	icc = append(icc, byte(compression))
+	icc = append(icc, chunk...)
+	e.writeChunk(icc, "iCCP")
 	return
 }
 
+// maybeWriteEXIF will write out an eXIf chunk if the metadata has exif
+// information. Decoded exif data is encoded first.
+func (e *encoder) maybeWriteEXIF(ctx context.Context, m *Metadata, opts ...image.WriteOption) {
+	if e.err != nil || m == nil || (m.rawExif == nil && m.exif == nil) {
+		return
+	}
+
+	exif := m.rawExif
+	if m.exif != nil {
+		exif, e.err = m.exif.Encode(ctx, !m.exif.LittleEndian, opts...)
+		if e.err != nil {
+			return
+		}
+	}
+	e.writeChunk(exif, "eXIf")
+}
+
 // maybeWriteCHRM will write out a cHRM chunk if the metadata has
 // chroma information.
 func (e *encoder) maybeWriteCHRM(m *Metadata) {
@@ -898,20 +928,24 @@ func (enc *Encoder) Encode(w io.Writer, m image.Image) error {
 
 // EncodeExtended writes the Image m to w in PNG format
 func (enc *Encoder) EncodeExtended(ctx context.Context, w io.Writer, m image.Image, opts ...image.WriteOption) error {
-	var metadata *Metadata
+	var md *Metadata
 
 	//  Run through all the opts.
 	for _, o := range opts {
 		switch lo := o.(type) {
 		case *Metadata:
-			if metadata != nil {
-				return fmt.Errorf("Multiple metadata passed")
+			if md != nil {
+				return fmt.Errorf("Multiple md passed")
 			}
-			metadata = lo
+			md = lo
 			// Make sure the metadata is OK.
-			if err := metadata.validate(); err != nil {
+			if err := md.validate(); err != nil {
 				return err
 			}
+		case metadata.XMPOptions:
+			// These are passed on when the XMP is encoded.
+		case image.LimitOptions:
+			// Limits only apply when decoding.
 		default:
 			return fmt.Errorf("Unknown write option of type %T given", o)
 		}
@@ -999,16 +1033,17 @@ func (enc *Encoder) EncodeExtended(ctx context.Context, w io.Writer, m image.Ima
 	}
 
 	// If we have a gAMA chunk then it needs to be written now.
-	if metadata != nil {
-		e.maybeWriteGAMA(metadata)
-		e.maybeWriteCHRM(metadata)
-		e.maybeWriteSRGB(metadata)
-		e.maybeWriteTIME(metadata)
-		e.maybeWriteICCP(ctx, metadata, opts...)
-		e.maybeWritePHYS(metadata)
-
-		e.maybeWriteXMP(ctx, metadata, opts...)
-		for _, v := range metadata.Text {
+	if md != nil {
+		e.maybeWriteGAMA(md)
+		e.maybeWriteCHRM(md)
+		e.maybeWriteSRGB(md)
+		e.maybeWriteTIME(md)
+		e.maybeWriteICCP(ctx, md, opts...)
+		e.maybeWritePHYS(md)
+		e.maybeWriteEXIF(ctx, md, opts...)
+
+		e.maybeWriteXMP(ctx, md, opts...)
+		for _, v := range md.Text {
 			switch v.EntryType {
 			case EtText:
 				e.maybeWriteTEXT(v)
@@ -1033,7 +1068,7 @@ func (enc *Encoder) EncodeExtended(ctx context.Context, w io.Writer, m image.Ima
 			e.writePLTEAndTRNS(pal)
 		}
 	}
-	e.maybeWriteHIST(metadata)
+	e.maybeWriteHIST(md)
 
 	switch deferred {
 	case true:
@@ -19,6 +19,7 @@ import (
 
 	"github.com/rmamba/image"
 	"github.com/rmamba/image/color"
+	"github.com/rmamba/image/metadata"
 )
 
 func diff(m0, m1 image.Image) error {
@@ -387,6 +388,43 @@ func TestMetadataWriting(t *testing.T) {
 
 }
 
+func TestEXIFByteOrder(t *testing.T) {
+	ctx := context.Background()
+	src := &metadata.EXIF{Artist: "someone"}
+	for _, bigEndian := range []bool{true, false} {
+		raw, err := src.Encode(ctx, bigEndian)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		// Decoded EXIF data is written in the byte order it was read
+		// in.
+		m := &Metadata{}
+		m.SetRawEXIF(raw)
+		if _, err := m.EXIF(ctx); err != nil {
+			t.Fatalf("EXIF: %v", err)
+		}
+		_, sm, err := extendedEncodeDecode(image.NewGray(image.Rect(0, 0, 1, 1)), m)
+		if err != nil {
+			t.Fatalf("big endian %v: %v", bigEndian, err)
+		}
+		x, err := sm.(*Metadata).EXIF(ctx)
+		if err != nil {
+			t.Fatalf("big endian %v: EXIF: %v", bigEndian, err)
+		}
+		if x.LittleEndian == bigEndian {
+			t.Errorf("big endian %v: got little endian EXIF data %v", bigEndian, x.LittleEndian)
+		}
+	}
+}
+
+func TestEncodeExtendedLimitOptions(t *testing.T) {
+	// Core options that don't apply to encoding are ignored.
+	var buf bytes.Buffer
+	if err := EncodeExtended(context.Background(), &buf, image.NewGray(image.Rect(0, 0, 1, 1)), image.LimitOptions{}); err != nil {
+		t.Errorf("EncodeExtended with LimitOptions: %v", err)
+	}
+}
+
 func TestMetadataRoundTrip(t *testing.T) {
 	// The filenames variable is declared in reader_test.go.
 	names := filenames